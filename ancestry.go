@@ -0,0 +1,66 @@
+package gocrawl
+
+import (
+	"net/url"
+	"sync"
+)
+
+// ancestry holds the parent of each accepted URL, keyed by the normalized
+// URL, so that the chain of referrers can be walked back to the seed. It is
+// shared by the crawler and the URL contexts, so it is safe for concurrent use.
+type ancestry struct {
+	mu      sync.RWMutex
+	parents map[string]*url.URL
+}
+
+func newAncestry() *ancestry {
+	return &ancestry{parents: make(map[string]*url.URL)}
+}
+
+// Set the parent of the normalized URL, nil for a seed or an URL enqueued
+// without source (which starts a fresh chain).
+func (a *ancestry) set(u, parent *url.URL) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.parents[u.String()] = parent
+}
+
+// Return the parent of the normalized URL, and whether the URL is known.
+func (a *ancestry) parent(u *url.URL) (*url.URL, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	p, ok := a.parents[u.String()]
+	return p, ok
+}
+
+// Return the ancestors of the normalized URL, from the seed to its parent,
+// and whether the URL is known.
+func (a *ancestry) chain(u *url.URL) ([]*url.URL, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	key := u.String()
+	p, ok := a.parents[key]
+	if !ok {
+		return nil, false
+	}
+	var res []*url.URL
+	seen := map[string]bool{key: true}
+	for p != nil {
+		// A cycle is only possible if a visited URL is enqueued again from
+		// one of its descendants, stop there.
+		key = p.String()
+		if seen[key] {
+			break
+		}
+		seen[key] = true
+		res = append(res, p)
+		p = a.parents[key]
+	}
+
+	// Reverse, so that the seed comes first
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res, true
+}
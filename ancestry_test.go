@@ -0,0 +1,50 @@
+package gocrawl
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestAncestryChain(t *testing.T) {
+	mustParse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("failed to parse URL %s", s)
+		}
+		return u
+	}
+	seed, a, b, c := mustParse("http://host/"), mustParse("http://host/a"), mustParse("http://host/b"), mustParse("http://host/c")
+
+	anc := newAncestry()
+	anc.set(seed, nil)
+	anc.set(a, seed)
+	anc.set(b, a)
+
+	cases := []struct {
+		u    *url.URL
+		want []*url.URL
+		ok   bool
+	}{
+		{seed, nil, true},
+		{a, []*url.URL{seed}, true},
+		{b, []*url.URL{seed, a}, true},
+		{c, nil, false},
+	}
+	for i, cc := range cases {
+		got, ok := anc.chain(cc.u)
+		if ok != cc.ok || !reflect.DeepEqual(got, cc.want) {
+			t.Errorf("%d: want %v, %t, got %v, %t", i, cc.want, cc.ok, got, ok)
+		}
+	}
+
+	// A re-enqueued URL starts a fresh chain, a cycle stops the walk
+	anc.set(a, nil)
+	if got, _ := anc.chain(b); !reflect.DeepEqual(got, []*url.URL{a}) {
+		t.Errorf("fresh chain: want [%v], got %v", a, got)
+	}
+	anc.set(a, b)
+	if got, _ := anc.chain(b); !reflect.DeepEqual(got, []*url.URL{a}) {
+		t.Errorf("cycle: want [%v], got %v", a, got)
+	}
+}
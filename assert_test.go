@@ -37,8 +37,47 @@ func assertCallCount(spy *spyExtender, nm string, key extensionMethodKey, i int,
 	}
 }
 
+func assertEventCount(spy *spyExtender, nm string, event string, fields map[string]interface{}, i int, t *testing.T) {
+	cnt := spy.getEventCount(event, fields)
+	if cnt != i {
+		t.Errorf("FAIL %s - expected %d %s events with fields %v, got %d.", nm, i, event, fields, cnt)
+	}
+}
+
 func assertPanic(nm string, t *testing.T) {
 	if e := recover(); e == nil {
 		t.Errorf("FAIL %s - expected a panic.", nm)
 	}
 }
+
+func assertVisitOrder(spy *spyExtender, nm string, paths []string, t *testing.T) {
+	spy.m.RLock()
+	defer spy.m.RUnlock()
+
+	calls := spy.calledWith[eMKVisit]
+	if len(calls) != len(paths) {
+		t.Errorf("FAIL %s - expected %d visits, got %d.", nm, len(paths), len(calls))
+		return
+	}
+	for i, args := range calls {
+		if p := args[0].(*URLContext).normalizedURL.Path; p != paths[i] {
+			t.Errorf("FAIL %s - expected visit #%d to be %s, got %s.", nm, i, paths[i], p)
+		}
+	}
+}
+
+func assertVisitURLOrder(spy *spyExtender, nm string, urls []string, t *testing.T) {
+	spy.m.RLock()
+	defer spy.m.RUnlock()
+
+	calls := spy.calledWith[eMKVisit]
+	if len(calls) != len(urls) {
+		t.Errorf("FAIL %s - expected %d visits, got %d.", nm, len(urls), len(calls))
+		return
+	}
+	for i, args := range calls {
+		if u := args[0].(*URLContext).normalizedURL.String(); u != urls[i] {
+			t.Errorf("FAIL %s - expected visit #%d to be %s, got %s.", nm, i, urls[i], u)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package gocrawl
+
+import (
+	"net/http"
+)
+
+// AuthExtender is an optional interface that an Extender can implement to
+// answer the authentication challenges. Authenticate is called when the
+// fetch of an URL returns a 401 (Unauthorized) or 407 (Proxy Authentication
+// Required) status code, with this response (its body is closed if the URL
+// is requested again). If it returns true, the URL is requested again
+// with the returned request, after the crawl delay, and the challenge is
+// only notified as an error if this retry fails too. The request is
+// typically a clone of res.Request with the credentials applied (e.g. via
+// SetBasicAuth or an Authorization header for other schemes), it is
+// available via the URLContext's AuthRequest method for custom Fetch
+// implementations. An URL is retried at most once per crawl. It is called
+// from the worker's goroutine.
+type AuthExtender interface {
+	Authenticate(ctx *URLContext, res *http.Response) (*http.Request, bool)
+}
+
+// AuthRequest returns the request with the credentials returned by the
+// Authenticate method of the AuthExtender, if the URL is requested again
+// after an authentication challenge, nil otherwise. The DefaultExtender's
+// Fetch sends it instead of a new request (with the method and the
+// user-agent of the fetch).
+func (uc *URLContext) AuthRequest() *http.Request {
+	return uc.authRequest
+}
+
+// Indicates if the URL should be requested again with the credentials of
+// the AuthExtender, after the authentication challenge of the response.
+func (w *worker) authenticate(ctx *URLContext, res *http.Response) bool {
+	if w.authExt == nil || ctx.authRequest != nil {
+		return false
+	}
+	if res.StatusCode != http.StatusUnauthorized && res.StatusCode != http.StatusProxyAuthRequired {
+		return false
+	}
+	// At most one authenticated retry per URL and per crawl
+	if w.authRetries.inc(ctx.normalizedURL) > 1 {
+		return false
+	}
+
+	var req *http.Request
+	var ok bool
+	callExtender(w.opts, ctx, "Authenticate", w.notifyError, func() {
+		req, ok = w.authExt.Authenticate(ctx, res)
+	})
+	if !ok || req == nil {
+		return false
+	}
+	w.logFunc(LogTrace, "authentication challenge (%s), requesting again: %s", res.Status, ctx.url)
+	ctx.authRequest = req
+	return true
+}
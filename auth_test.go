@@ -0,0 +1,110 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type authExtender struct {
+	DefaultExtender
+	mu        sync.Mutex
+	challenge map[string]int
+	errors    map[string]CrawlErrorKind
+	visited   map[string]int
+}
+
+func (x *authExtender) Authenticate(ctx *URLContext, res *http.Response) (*http.Request, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.challenge[ctx.url.Path]++
+	if ctx.url.Path == "/nocreds.html" {
+		return nil, false
+	}
+	req := res.Request.Clone(res.Request.Context())
+	if ctx.url.Path == "/wrong.html" {
+		req.SetBasicAuth("user", "wrong")
+	} else {
+		req.SetBasicAuth("user", "secret")
+	}
+	return req, true
+}
+
+func (x *authExtender) Error(err *CrawlError) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err.Ctx != nil {
+		x.errors[err.Ctx.url.Path] = err.Kind
+	}
+}
+
+func (x *authExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visited[ctx.url.Path] = ctx.FetchInfo().StatusCode
+}
+
+func TestAuthExtender(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/private.html">p</a><a href="/wrong.html">w</a><a href="/nocreds.html">n</a>`)
+		default:
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/">home</a>`)
+		}
+	}))
+	defer srv.Close()
+
+	ext := &authExtender{
+		challenge: make(map[string]int),
+		errors:    make(map[string]CrawlErrorKind),
+		visited:   make(map[string]int),
+	}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 10 * time.Millisecond
+	opts.LogFlags = LogNone
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run(srv.URL + "/"); err != nil {
+		t.Fatalf("run failed with %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+	// The valid credentials are accepted on the retry
+	if ext.visited["/private.html"] != http.StatusOK || requests["/private.html"] != 2 {
+		t.Errorf("want /private.html visited after 2 requests, got %d and %d", ext.visited["/private.html"], requests["/private.html"])
+	}
+	if _, ok := ext.errors["/private.html"]; ok {
+		t.Errorf("want no error for /private.html, got %v", ext.errors["/private.html"])
+	}
+	// The invalid credentials are retried once, then notified
+	if ext.visited["/wrong.html"] != http.StatusUnauthorized || requests["/wrong.html"] != 2 || ext.challenge["/wrong.html"] != 1 {
+		t.Errorf("want /wrong.html requested twice and challenged once, got %d and %d", requests["/wrong.html"], ext.challenge["/wrong.html"])
+	}
+	if ext.errors["/wrong.html"] != CekHttpStatusCode {
+		t.Errorf("want a status code error for /wrong.html, got %v", ext.errors)
+	}
+	// No credentials, no retry
+	if requests["/nocreds.html"] != 1 || ext.errors["/nocreds.html"] != CekHttpStatusCode {
+		t.Errorf("want /nocreds.html requested once with an error, got %d and %v", requests["/nocreds.html"], ext.errors)
+	}
+}
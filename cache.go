@@ -0,0 +1,214 @@
+package gocrawl
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// The response cache of a run, with the ResponseCacheBytes option: the
+// responses of the GET requests are kept by normalized URL, in a LRU list
+// bounded by the total size of their bodies and headers, so that the URLs
+// requested again during the crawl (e.g. revisited, or allowed again by the
+// Filter) are served without calling the Fetch extender method. A response
+// followed from a redirection is kept under the URL requested. It is not an
+// HTTP cache, the freshness of the responses is not checked. It is shared by
+// all workers.
+type responseCache struct {
+	mu      sync.Mutex
+	max     int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    int
+}
+
+// A response of the cache.
+type cachedResponse struct {
+	key        string
+	status     string
+	statusCode int
+	proto      string
+	header     http.Header
+	body       []byte
+}
+
+func newResponseCache(max int64) *responseCache {
+	return &responseCache{max: max, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Indicates if a response with the status code is cached, i.e. the
+// heuristically cacheable status codes of RFC 9110, except the redirections
+// that are followed by the HTTP client.
+func isCacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// Return the maximum size of a response body, a quarter of the cache.
+func (c *responseCache) maxBodySize() int64 {
+	return c.max / 4
+}
+
+// Return the cached response of the key, or nil if there is none.
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	c.hits++
+	return el.Value.(*cachedResponse)
+}
+
+// Add the response to the cache, evicting the least recently used ones if
+// the cache is full.
+func (c *responseCache) add(cr *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[cr.key]; ok {
+		c.size -= el.Value.(*cachedResponse).size()
+		c.lru.Remove(el)
+	}
+	c.entries[cr.key] = c.lru.PushFront(cr)
+	c.size += cr.size()
+	for c.size > c.max {
+		el := c.lru.Back()
+		old := c.lru.Remove(el).(*cachedResponse)
+		delete(c.entries, old.key)
+		c.size -= old.size()
+	}
+}
+
+// Return the summary of the cache, i.e. ", 12 response cache hits".
+func (c *responseCache) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf(", %d response cache hits", c.hits)
+}
+
+// Return the size of the response, its body and headers.
+func (cr *cachedResponse) size() int64 {
+	n := int64(len(cr.key) + len(cr.body))
+	for k, vs := range cr.header {
+		for _, v := range vs {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+// Return a new response from the cached one, for a GET request or, without
+// its body, for a HEAD request.
+func (cr *cachedResponse) response(req *http.Request) *http.Response {
+	res := &http.Response{
+		Status:        cr.status,
+		StatusCode:    cr.statusCode,
+		Proto:         cr.proto,
+		Header:        cr.header.Clone(),
+		ContentLength: int64(len(cr.body)),
+		Request:       req,
+	}
+	body := cr.body
+	if req.Method == "HEAD" {
+		body = nil
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return res
+}
+
+// The cachingBody keeps a copy of the response body as it is read, and adds
+// the response to the cache once the body is read entirely, unless it is
+// larger than the maximum size.
+type cachingBody struct {
+	io.ReadCloser
+	cache *responseCache
+	res   *cachedResponse
+	buf   bytes.Buffer
+	over  bool
+}
+
+// Wrap the body of the response of the key, so that it is added to the
+// cache once read.
+func (c *responseCache) wrap(key string, res *http.Response) io.ReadCloser {
+	cr := &cachedResponse{
+		key:        key,
+		status:     res.Status,
+		statusCode: res.StatusCode,
+		proto:      res.Proto,
+		header:     res.Header.Clone(),
+	}
+	return &cachingBody{ReadCloser: res.Body, cache: c, res: cr}
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.res == nil {
+		return n, err
+	}
+	if !b.over {
+		if int64(b.buf.Len()+n) > b.cache.maxBodySize() {
+			b.over = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		if !b.over {
+			b.res.body = b.buf.Bytes()
+			b.cache.add(b.res)
+		}
+		b.res = nil
+	}
+	return n, err
+}
+
+// Indicates if the response of the URL is kept in the response cache. The
+// robots.txt, the range probes and the rechecks are always requested.
+func (w *worker) cachesResponse(ctx *URLContext, res *http.Response, headRequest bool) bool {
+	return w.cache != nil && !headRequest && res.Body != nil && !ctx.IsRobotsURL() &&
+		!ctx.skipsBody() && isCacheableStatus(res.StatusCode)
+}
+
+// Return the response of the URL from the response cache, or nil if it is
+// not cached. The Fetch extender method is not called, and the crawl delay
+// is not applied, for a cached response.
+func (w *worker) cachedResponse(ctx *URLContext, agent string, headRequest bool) *http.Response {
+	if w.cache == nil || ctx.IsRobotsURL() || ctx.skipsBody() {
+		return nil
+	}
+	cr := w.cache.get(ctx.normalizedURL.String())
+	if cr == nil {
+		return nil
+	}
+	method := "GET"
+	if headRequest {
+		method = "HEAD"
+	}
+	req, e := http.NewRequest(method, ctx.url.String(), nil)
+	if e != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", agent)
+	res := cr.response(req)
+
+	ctx.fetchInfo = &FetchInfo{ctx, 0, res.StatusCode, headRequest, 0, nil, agent, -1, true}
+	if !headRequest {
+		ctx.fetchInfo.Size = res.ContentLength
+	}
+	w.stats.addCacheHit()
+	w.logFunc(LogTrace, "response cache hit for %s (head: %v): %s", ctx.url, headRequest, res.Status)
+	return res
+}
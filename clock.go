@@ -0,0 +1,49 @@
+package gocrawl
+
+import "time"
+
+// Clock is the clock of the crawler and its workers, for the crawl delays,
+// the WorkerIdleTTL, the crawl windows, the RevisitAfter and MaxDuration
+// options and the other time-based policies, so that the tests can control
+// the time. It is the real clock unless the Clock option is set. It
+// is used concurrently by the workers, so it must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the timer of a Clock, like a *time.Timer. The channel returned by
+// C receives the time of the clock when the timer fires.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// The real clock, from the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                 { return time.Now() }
+func (realClock) Sleep(d time.Duration)          { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// Return the clock of the options, the real clock if none is set.
+func (opts *Options) getClock() Clock {
+	if opts.Clock != nil {
+		return opts.Clock
+	}
+	return realClock{}
+}
+
+// Return a channel that receives the time after the delay on the clock, like
+// time.After.
+func after(c Clock, d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
@@ -0,0 +1,131 @@
+package gocrawl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// The fake clock of the tests. Its timers fire when the clock is set past
+// their deadline, or, in auto mode, right away, the clock advancing to their
+// deadline, so that the delays are applied in no time.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	auto   bool
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c        *fakeClock
+	ch       chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func newFakeClock(now time.Time, auto bool) *fakeClock {
+	return &fakeClock{now: now, auto: auto}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// Set the clock, which fires the timers whose deadline is reached.
+func (c *fakeClock) set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	c.fire()
+}
+
+// Fire the active timers whose deadline is reached, the lock must be held.
+func (c *fakeClock) fire() {
+	for _, t := range c.timers {
+		if t.active && !t.deadline.After(c.now) {
+			t.active = false
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.deadline, t.active = t.c.now.Add(d), true
+	if t.c.auto && t.deadline.After(t.c.now) {
+		t.c.now = t.deadline
+	}
+	t.c.fire()
+	return active
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	c := newFakeClock(start, false)
+	t1, t2 := c.NewTimer(time.Minute), c.NewTimer(time.Hour)
+	select {
+	case <-c.NewTimer(0).C():
+	default:
+		t.Errorf("want a timer without delay fired right away")
+	}
+	c.set(start.Add(time.Minute))
+	select {
+	case now := <-t1.C():
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Errorf("want the timer fired at %s, got %s", start.Add(time.Minute), now)
+		}
+	default:
+		t.Errorf("want the timer fired at its deadline")
+	}
+	if !t2.Stop() || t2.Stop() {
+		t.Errorf("want the timer stopped once")
+	}
+	c.set(start.Add(2 * time.Hour))
+	select {
+	case <-t2.C():
+		t.Errorf("want the stopped timer not fired")
+	default:
+	}
+
+	auto := newFakeClock(start, true)
+	auto.Sleep(time.Second)
+	tm := auto.NewTimer(time.Minute)
+	<-tm.C()
+	tm.Reset(time.Minute)
+	<-tm.C()
+	if got, want := auto.Now(), start.Add(2*time.Minute+time.Second); !got.Equal(want) {
+		t.Errorf("want the auto clock at %s, got %s", want, got)
+	}
+}
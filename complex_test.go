@@ -1,47 +1,114 @@
 package gocrawl
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/PuerkitoBio/purell"
 )
 
 func testNoCrawlDelay(t *testing.T, tc *testCase, buf bool) {
-	const MaxTime = 15 * time.Millisecond // Give some buffer when running with -race
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start, true)
 
 	ff := newFileFetcher()
 	spy := newSpy(ff, buf)
 	opts := NewOptions(spy)
 	opts.SameHostOnly = true
 	opts.CrawlDelay = 0
+	opts.WorkerIdleTTL = 0
+	opts.Clock = clock
 	c := NewCrawlerWithOptions(opts)
 
-	start := time.Now()
 	c.Run([]string{
 		"http://hosta/page1.html",
 		"http://hosta/page4.html",
 	})
 
-	elps := time.Now().Sub(start)
-	assertTrue(elps <= MaxTime, "expected elapsed time to be at most %v, got %v", MaxTime, elps)
+	elps := clock.Now().Sub(start)
+	assertTrue(elps == 0, "expected no delay, got %v", elps)
 	assertCallCount(spy, tc.name, eMKVisit, 5, t)
 	assertCallCount(spy, tc.name, eMKFilter, 13, t)
 }
 
 func testNoExtender(t *testing.T, tc *testCase, buf bool) {
-	defer assertPanic(tc.name, t)
-
 	c := NewCrawler(nil)
 	c.Options.CrawlDelay = DefaultTestCrawlDelay
 	c.Options.LogFlags = LogError | LogTrace
 
-	c.Run(nil)
+	err := c.Run(nil)
+	oe, ok := err.(*OptionsError)
+	assertTrue(ok, "expected an *OptionsError, got %v", err)
+	if ok {
+		assertTrue(len(oe.Problems) == 1 && oe.Problems[0] == "Extender is nil", "expected nil Extender problem, got %v", oe.Problems)
+	}
 }
 
 func testCrawlDelay(t *testing.T, tc *testCase, buf bool) {
+	var last time.Time
+	var since []time.Duration
+	cnt := 0
+	clock := newFakeClock(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true)
+
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, agent string, head bool) (*http.Response, error) {
+		since = append(since, clock.Now().Sub(last))
+		last = clock.Now()
+		return ff.Fetch(ctx, agent, head)
+	})
+	spy.setExtensionMethod(eMKComputeDelay, func(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+		// Crawl delay always grows
+		cnt++
+		return time.Duration(int(di.OptsDelay) * cnt)
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.HeadBeforeGet = true
+	opts.WorkerIdleTTL = 0
+	opts.LogFlags = LogAll
+	opts.Clock = clock
+	c := NewCrawlerWithOptions(opts)
+	last = clock.Now()
+
+	c.Run("http://hosta/page1.html")
+
+	assertCallCount(spy, tc.name, eMKFetch, 7, t)
+	assertCallCount(spy, tc.name, eMKComputeDelay, 7, t)
+	// The delay computed for a fetch applies before the next one
+	for i, d := range since {
+		want := DefaultTestCrawlDelay * time.Duration(i)
+		assertTrue(d == want, "expected a delay of %v for fetch #%d, got %v.", want, i, d)
+	}
+}
+
+func testCrawlDelayRealTime(t *testing.T, tc *testCase, buf bool) {
+	if testing.Short() {
+		t.Logf("%s skipped in short mode", tc.name)
+		return
+	}
+
 	var last time.Time
 	var since []time.Duration
 	cnt := 0
@@ -98,6 +165,46 @@ func testUserAgent(t *testing.T, tc *testCase, buf bool) {
 	c.Run(srv.URL + "/bidon")
 }
 
+func testUserAgentFunc(t *testing.T, tc *testCase, buf bool) {
+	var mu sync.Mutex
+	agents := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.URL.Path] = r.UserAgent()
+		mu.Unlock()
+		if r.URL.Path == "/page1.html" {
+			fmt.Fprint(w, `<a href="/page2.html">2</a>`)
+		}
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	fetched := make(map[string]string)
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched[ctx.url.Path] = ctx.FetchInfo().UserAgent
+	})
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 10 * time.Millisecond
+	opts.LogFlags = LogAll
+	opts.UserAgentFunc = func(ctx *URLContext) string {
+		return "shard-" + strings.TrimSuffix(path.Base(ctx.url.Path), ".html")
+	}
+	NewCrawlerWithOptions(opts).Run(srv.URL + "/page1.html")
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The robots.txt is still requested with the baseline user-agent, not
+	// the one of the func
+	assertTrue(agents["/robots.txt"] == opts.UserAgent && opts.UserAgent != "shard-robots", "expected user-agent %s for robots.txt, got %s", opts.UserAgent, agents["/robots.txt"])
+	for _, p := range []string{"page1", "page2"} {
+		want := "shard-" + p
+		assertTrue(agents["/"+p+".html"] == want, "expected user-agent %s for %s, got %s", want, p, agents["/"+p+".html"])
+		assertTrue(fetched["/"+p+".html"] == want, "expected FetchInfo user-agent %s for %s, got %s", want, p, fetched["/"+p+".html"])
+	}
+}
+
 func testRunTwiceSameInstance(t *testing.T, tc *testCase, buf bool) {
 	ff := newFileFetcher()
 	spy := newSpy(ff, buf)
@@ -175,6 +282,7 @@ func testEnqueueNewURL(t *testing.T, tc *testCase, buf bool) {
 		// Accept only non-visited Page1s
 		return !isVisited && strings.HasSuffix(strings.ToLower(ctx.url.Path), "page1.html")
 	})
+	var c *Crawler
 	enqueued := false
 	spy.setExtensionMethod(eMKEnqueued, func(ctx *URLContext) {
 		// Add hostc's Page1 to crawl
@@ -183,7 +291,9 @@ func testEnqueueNewURL(t *testing.T, tc *testCase, buf bool) {
 			if err != nil {
 				panic(err)
 			}
-			spy.EnqueueChan <- newU
+			if err := c.Enqueue(EnqueueItem{URL: newU}); err != nil {
+				t.Errorf("enqueue failed with %v", err)
+			}
 			enqueued = true
 		}
 	})
@@ -192,7 +302,7 @@ func testEnqueueNewURL(t *testing.T, tc *testCase, buf bool) {
 	opts.CrawlDelay = DefaultTestCrawlDelay
 	opts.LogFlags = LogAll
 	opts.SameHostOnly = false
-	c := NewCrawlerWithOptions(opts)
+	c = NewCrawlerWithOptions(opts)
 
 	c.Run("http://hostb/page1.html")
 
@@ -200,6 +310,66 @@ func testEnqueueNewURL(t *testing.T, tc *testCase, buf bool) {
 	assertCallCount(spy, tc.name, eMKEnqueued, 4, t) // robots.txt * 2, both Page1s
 }
 
+func testEnqueue(t *testing.T, tc *testCase, buf bool) {
+	mustParse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			panic(err)
+		}
+		return u
+	}
+
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		// Accept only non-visited Page1s
+		return !isVisited && strings.HasSuffix(ctx.url.Path, "page1.html")
+	})
+	head := true
+	var c *Crawler
+	var states []interface{}
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		states = append(states, ctx.State)
+		if ctx.url.Host == "hosta" {
+			// Enqueue from another goroutine, while the crawler runs
+			done := make(chan error)
+			go func() {
+				done <- c.Enqueue(
+					EnqueueItem{URL: mustParse("http://hostb/page1.html"), State: "b", HeadBeforeGet: &head},
+					EnqueueItem{URL: mustParse("http://hostc/page1.html"), State: "c", Priority: 1},
+				)
+			}()
+			if err := <-done; err != nil {
+				t.Errorf("enqueue failed with %v", err)
+			}
+		}
+		return nil, false
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.SameHostOnly = false
+	c = NewCrawlerWithOptions(opts)
+
+	err := c.Enqueue(EnqueueItem{URL: mustParse("http://hostb/page1.html")})
+	assertTrue(err == ErrNotRunning, "expected ErrNotRunning before the crawl, got %v", err)
+	c.Run("http://hosta/page1.html")
+	err = c.Enqueue(EnqueueItem{URL: mustParse("http://hostb/page1.html")})
+	assertTrue(err == ErrNotRunning, "expected ErrNotRunning after the crawl, got %v", err)
+	err = c.Enqueue(EnqueueItem{})
+	assertTrue(err != nil, "expected an error for a nil URL")
+
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+	assertCallCount(spy, tc.name, eMKEnqueued, 6, t) // robots.txt * 3, Page1s * 3
+	assertCallCount(spy, tc.name, eMKRequestGet, 1, t)
+	if assertTrue(len(states) == 3, "expected 3 states, got %d", len(states)) {
+		assertTrue(states[0] == nil, "expected no state for the seed, got %v", states[0])
+		got := map[interface{}]bool{states[1]: true, states[2]: true}
+		assertTrue(got["b"] && got["c"], "expected states b and c, got %v", states[1:])
+	}
+}
+
 func testEnqueueNewURLOnError(t *testing.T, tc *testCase, buf bool) {
 	ff := newFileFetcher()
 	spy := newSpy(ff, buf)
@@ -235,4 +405,2660 @@ func testEnqueueNewURLOnError(t *testing.T, tc *testCase, buf bool) {
 	assertCallCount(spy, tc.name, eMKEnqueued, 3, t) // Twice and robots.txt
 }
 
-// TODO : Test to assert low CPU usage during long crawl delay waits? (issue #12)
+// Extender implementing the FilterURL method.
+type filterURLExtender struct {
+	*spyExtender
+}
+
+func (x *filterURLExtender) FilterURL(ctx *URLContext, isVisited bool) FilterResult {
+	res := FilterResult{Allow: !isVisited}
+	switch ctx.normalizedURL.Path {
+	case "/page2.html":
+		head := true
+		res.HeadBeforeGet = &head
+	case "/page3.html":
+		delay := 17 * time.Millisecond
+		res.DelayOverride = &delay
+		res.Priority = 3
+	}
+	return res
+}
+
+func testFilterURL(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	x := &filterURLExtender{newSpy(ff, buf)}
+	var prio int
+	x.setExtensionMethod(eMKEnqueued, func(ctx *URLContext) {
+		if ctx.normalizedURL.Path == "/page3.html" {
+			prio = ctx.Priority()
+		}
+	})
+
+	opts := NewOptions(x)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	c.Run("http://hosta/page1.html")
+
+	assertCallCount(x.spyExtender, tc.name, eMKFilter, 0, t)
+	assertCallCount(x.spyExtender, tc.name, eMKVisit, 3, t)
+	n := x.getCalledWithCount(eMKFetch, ignore, ignore, true)
+	assertTrue(n == 1, "expected 1 HEAD request, got %d", n)
+	assertTrue(prio == 3, "expected priority 3 for page3, got %d", prio)
+	assertIsInLog(tc.name, x.b, "using crawl-delay override: 17ms\n", t)
+}
+
+func testAdaptiveDelay(t *testing.T, tc *testCase, buf bool) {
+	const fetchDelay = 20 * time.Millisecond
+
+	var delays []time.Duration
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, agent string, head bool) (*http.Response, error) {
+		// Deliberately slow fetcher
+		time.Sleep(fetchDelay)
+		return ff.Fetch(ctx, agent, head)
+	})
+	spy.setExtensionMethod(eMKComputeDelay, func(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+		d := ff.ComputeDelay(host, di, lastFetch)
+		delays = append(delays, d)
+		return d
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = time.Millisecond
+	opts.AdaptiveDelay = &AdaptiveDelay{Multiplier: 2, MaxDelay: time.Second}
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	c.Run("http://hosta/page1.html")
+
+	assertCallCount(spy, tc.name, eMKComputeDelay, 4, t)
+	if assertTrue(len(delays) == 4, "expected 4 delays, got %d", len(delays)) {
+		// No fetch yet for the first delay
+		assertTrue(delays[0] == opts.CrawlDelay, "expected first delay to be %v, got %v", opts.CrawlDelay, delays[0])
+		for i, d := range delays[1:] {
+			assertTrue(d >= 2*fetchDelay, "expected delay #%d to be at least %v, got %v", i+1, 2*fetchDelay, d)
+		}
+	}
+}
+
+func testAdaptiveDelayBounds(t *testing.T, tc *testCase, buf bool) {
+	ad := &AdaptiveDelay{Multiplier: 3, MinDelay: 50 * time.Millisecond, MaxDelay: time.Second}
+	di := &DelayInfo{OptsDelay: 10 * time.Millisecond}
+
+	d := ad.Compute(di)
+	assertTrue(d == ad.MinDelay, "expected min delay %v, got %v", ad.MinDelay, d)
+
+	for i := 0; i < 10; i++ {
+		di.RecentFetches = append(di.RecentFetches, &FetchInfo{Duration: time.Duration(i+1) * 10 * time.Millisecond, StatusCode: 200})
+	}
+	d = ad.Compute(di)
+	assertTrue(d == 270*time.Millisecond, "expected delay 270ms, got %v", d)
+
+	// Half the fetches failed, the delay increases by half
+	for i := 0; i < 5; i++ {
+		di.RecentFetches[i].StatusCode = 503
+	}
+	d = ad.Compute(di)
+	assertTrue(d == 405*time.Millisecond, "expected delay 405ms, got %v", d)
+
+	di.RobotsDelay = 2 * time.Second
+	d = ad.Compute(di)
+	assertTrue(d == di.RobotsDelay, "expected robots delay %v, got %v", di.RobotsDelay, d)
+}
+
+func testMaxRequestsPerSecond(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	opts := NewOptions(spy)
+	opts.SameHostOnly = false
+	opts.CrawlDelay = 0
+	opts.MaxRequestsPerSecond = 50
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	start := time.Now()
+	c.Run([]string{"http://hosta/page1.html", "http://hostb/page1.html"})
+	elapsed := time.Now().Sub(start)
+
+	// Requests are spaced by 20ms, whatever the host
+	n := spy.getCallCount(eMKFetch)
+	min := time.Duration(n-1) * 20 * time.Millisecond
+	assertTrue(n > 2, "expected more than 2 fetches, got %d", n)
+	assertTrue(elapsed >= min, "expected crawl to last at least %v for %d fetches, got %v", min, n, elapsed)
+}
+
+// countingLimiter is a RateLimiter that counts the calls to Wait.
+type countingLimiter struct {
+	n int32
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	atomic.AddInt32(&l.n, 1)
+	return ctx.Err()
+}
+
+func testSharedRateLimiter(t *testing.T, tc *testCase, buf bool) {
+	lim := new(countingLimiter)
+	fetches := 0
+	for _, seed := range []string{"http://hosta/page1.html", "http://hostb/page1.html"} {
+		spy := newSpy(newFileFetcher(), buf)
+		opts := NewOptions(spy)
+		opts.CrawlDelay = 0
+		opts.RateLimiter = lim
+		opts.LogFlags = LogAll
+		c := NewCrawlerWithOptions(opts)
+		c.Run(seed)
+		fetches += spy.getCallCount(eMKFetch)
+	}
+	// Robots.txt requests wait for the limiter too
+	assertTrue(fetches > 2, "expected more than 2 fetches, got %d", fetches)
+	assertTrue(int(atomic.LoadInt32(&lim.n)) == fetches, "expected %d calls to Wait, got %d", fetches, lim.n)
+}
+
+func testHostFailureThreshold(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, agent string, head bool) (*http.Response, error) {
+		if ctx.IsRobotsURL() {
+			return ff.Fetch(ctx, agent, head)
+		}
+		return nil, errors.New("host is unreachable")
+	})
+	kinds := make(map[CrawlErrorKind]int)
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		kinds[err.Kind]++
+	})
+
+	ext := &hostExtender{spyExtender: spy}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.HostFailureThreshold = 2
+	opts.HostCooldown = time.Hour
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{
+		"http://hosta/page1.html",
+		"http://hosta/page2.html",
+		"http://hosta/page3.html",
+		"http://hosta/page4.html",
+		"http://hosta/page5.html",
+	})
+
+	// Robots.txt and the 2 failed fetches, then the remaining URLs are skipped
+	assertCallCount(spy, tc.name, eMKFetch, 3, t)
+	assertTrue(kinds[CekFetch] == 2, "expected 2 fetch errors, got %d", kinds[CekFetch])
+	assertTrue(kinds[CekSkippedHostDown] == 3, "expected 3 skipped URLs, got %d", kinds[CekSkippedHostDown])
+	assertIsInLog(tc.name, spy.b, "host hosta is down after 2 consecutive failures", t)
+	stopped := strings.Join(ext.stopped, ",")
+	assertTrue(stopped == "hosta host down 0", "expected hosta to stop as down, got %s", stopped)
+}
+
+func testRobotsFor(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	spy.setExtensionMethod(eMKRequestRobots, func(ctx *URLContext, agent string) ([]byte, bool) {
+		return []byte("User-agent: *\nDisallow: /page2"), false
+	})
+	var rules []string
+	spy.setExtensionMethod(eMKDisallowed, func(ctx *URLContext) {
+		rules = append(rules, ctx.RobotsRule())
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	_, ok := c.RobotsFor("robota")
+	assertTrue(!ok, "expected no robots.txt group before the crawl")
+	c.Run("http://robota/page1.html")
+
+	assertCallCount(spy, tc.name, eMKDisallowed, 1, t)
+	if assertTrue(len(rules) == 1, "expected 1 disallowed rule, got %d", len(rules)) {
+		assertTrue(rules[0] == "Disallow: /page2", "expected rule 'Disallow: /page2', got %q", rules[0])
+	}
+	if g, ok := c.RobotsFor("robota"); assertTrue(ok, "expected robots.txt group for robota") {
+		assertTrue(!g.Test("/page2.html"), "expected /page2.html to be disallowed")
+		assertTrue(g.Test("/page1.html"), "expected /page1.html to be allowed")
+	}
+	allowed, ok := c.RobotsAllowed("robota", "/page2.html")
+	assertTrue(ok && !allowed, "expected /page2.html to be disallowed, got %v (known: %v)", allowed, ok)
+	_, ok = c.RobotsFor("hosta")
+	assertTrue(!ok, "expected no robots.txt group for hosta")
+	allowed, ok = c.RobotsAllowed("hosta", "/page2.html")
+	assertTrue(!ok && allowed, "expected hosta to be allowed and unknown, got %v (known: %v)", allowed, ok)
+}
+
+// Returns a server with a root page that links to n pages of the target (or
+// of the server itself if empty).
+func newLinksServer(n int, target string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/" {
+			fmt.Fprint(w, "<html><body></body></html>")
+			return
+		}
+		fmt.Fprint(w, "<html><body>")
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(w, `<a href="%s/p/%d">%d</a>`, target, i, i)
+		}
+		fmt.Fprint(w, "</body></html>")
+	}))
+	return srv
+}
+
+func testMaxPendingDropNewest(t *testing.T, tc *testCase, buf bool) {
+	srv := newLinksServer(2000, "")
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	dropped := 0
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		if err.Kind == CekDroppedURL {
+			dropped++
+		}
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.MaxVisits = 5
+	opts.MaxPendingPerHost = 50
+	opts.PendingPolicy = DropNewest
+	opts.LogFlags = LogError | LogInfo
+	c := NewCrawlerWithOptions(opts)
+	c.Run(srv.URL + "/")
+
+	// The seed, robots.txt and the 50 first harvested URLs
+	assertCallCount(spy, tc.name, eMKEnqueued, 52, t)
+	assertTrue(dropped == 1950, "expected 1950 dropped URLs, got %d", dropped)
+}
+
+func testMaxPendingDropOldest(t *testing.T, tc *testCase, buf bool) {
+	srv := newLinksServer(200, "")
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var mu sync.Mutex
+	var visited []string
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		visited = append(visited, ctx.URL().Path)
+		mu.Unlock()
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.MaxPendingPerHost = 10
+	opts.PendingPolicy = DropOldest
+	opts.LogFlags = LogError | LogInfo
+	c := NewCrawlerWithOptions(opts)
+	c.Run(srv.URL + "/")
+
+	// The root page and the 10 last harvested URLs
+	assertCallCount(spy, tc.name, eMKVisited, 11, t)
+	assertCallCount(spy, tc.name, eMKError, 190, t)
+	for _, p := range visited {
+		var i int
+		if _, err := fmt.Sscanf(p, "/p/%d", &i); err == nil {
+			assertTrue(i >= 190, "expected only the last 10 URLs to be visited, got %s", p)
+		}
+	}
+}
+
+func testMaxPendingBlockOnFull(t *testing.T, tc *testCase, buf bool) {
+	dst := newLinksServer(0, "")
+	defer dst.Close()
+	src := newLinksServer(100, dst.URL)
+	defer src.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	dstHost := strings.TrimPrefix(dst.URL, "http://")
+	var pending, max int32
+	spy.setExtensionMethod(eMKEnqueued, func(ctx *URLContext) {
+		if ctx.URL().Host == dstHost && !ctx.IsRobotsURL() {
+			if n := atomic.AddInt32(&pending, 1); n > atomic.LoadInt32(&max) {
+				atomic.StoreInt32(&max, n)
+			}
+		}
+	})
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		if ctx.URL().Host == dstHost {
+			atomic.AddInt32(&pending, -1)
+		}
+		return nil, true
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = false
+	opts.CrawlDelay = 0
+	opts.MaxPendingPerHost = 10
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run(src.URL + "/")
+
+	// All URLs are eventually visited, without exceeding the limit
+	assertCallCount(spy, tc.name, eMKVisit, 101, t)
+	assertCallCount(spy, tc.name, eMKError, 0, t)
+	assertTrue(max <= 10, "expected at most 10 pending URLs, got %d", max)
+	assertIsInLog(tc.name, spy.b, "url(s) blocked on full queues, pausing worker for host "+strings.TrimPrefix(src.URL, "http://"), t)
+}
+
+func testMaxDuration(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var endErr error
+	spy.setExtensionMethod(eMKEnd, func(err error) {
+		endErr = err
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 100 * time.Millisecond
+	opts.MaxDuration = 150 * time.Millisecond
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	start := time.Now()
+	err := c.Run("http://hosta/page1.html")
+	elps := time.Now().Sub(start)
+
+	assertTrue(err == ErrMaxDuration, "expected error %v, got %v", ErrMaxDuration, err)
+	assertTrue(endErr == ErrMaxDuration, "expected End error %v, got %v", ErrMaxDuration, endErr)
+	assertTrue(elps < 300*time.Millisecond, "expected the crawl to stop after about 150ms, got %v", elps)
+	assertIsInLog(tc.name, spy.b, "maximum duration reached, sending STOP signals...", t)
+}
+
+func testMaxTotalBytes(t *testing.T, tc *testCase, buf bool) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Each page is 1000 bytes, linking to the next page
+		var i int
+		fmt.Sscanf(r.URL.Path, "/p/%d", &i)
+		page := fmt.Sprintf(`<html><body><a href="/p/%d">next</a>`, i+1)
+		fmt.Fprint(w, page+strings.Repeat(" ", 1000-len(page)))
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.MaxTotalBytes = 2500
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	err := c.Run(srv.URL + "/p/0")
+
+	// The third page exceeds the budget, but is still visited
+	assertTrue(err == ErrMaxTotalBytes, "expected error %v, got %v", ErrMaxTotalBytes, err)
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+	assertTrue(c.BytesRead() == 3000, "expected 3000 bytes read, got %d", c.BytesRead())
+}
+
+func testLogEvents(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.LogEventsOnly = true
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{
+		"http://hosta/page1.html",
+		"http://hosta/page4.html",
+		"http://hosta/unknown.html",
+	})
+
+	assertEventCount(spy, tc.name, EventWorkerStart, map[string]interface{}{"host": "hosta", "worker": 1}, 1, t)
+	assertEventCount(spy, tc.name, EventWorkerStop, map[string]interface{}{"host": "hosta", "worker": 1}, 1, t)
+	assertEventCount(spy, tc.name, EventEnqueue, map[string]interface{}{"url": "http://hosta/page1.html", "host": "hosta"}, 1, t)
+	assertEventCount(spy, tc.name, EventEnqueue, map[string]interface{}{"url": "http://hosta/robots.txt"}, 1, t)
+	assertEventCount(spy, tc.name, EventFetch, map[string]interface{}{"url": "http://hosta/page1.html", "status": 200, "duration": ignore, "head": false}, 1, t)
+	assertEventCount(spy, tc.name, EventDelay, map[string]interface{}{"url": "http://hosta/page1.html", "duration": DefaultTestCrawlDelay}, 1, t)
+	assertEventCount(spy, tc.name, EventVisit, map[string]interface{}{"host": "hosta"}, spy.getCallCount(eMKVisit), t)
+	assertEventCount(spy, tc.name, EventError, map[string]interface{}{"url": "http://hosta/unknown.html", "kind": "Fetch"}, 1, t)
+	assertTrue(spy.b.Len() == 0, "expected no text log, got %q", spy.b.String())
+}
+
+func testRecoverPanics(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		if ctx.url.Path == "/page2.html" {
+			panic("visit failed")
+		}
+		return ff.Visit(ctx, res, doc)
+	})
+	var perr *ExtenderPanic
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		if err.Kind == CekExtenderPanic {
+			perr, _ = err.Err.(*ExtenderPanic)
+		}
+		// Must not recurse
+		panic("error failed")
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{
+		"http://hosta/page1.html",
+		"http://hosta/page4.html",
+	})
+
+	// The remaining pages are still visited
+	assertCallCount(spy, tc.name, eMKVisit, 5, t)
+	assertCallCount(spy, tc.name, eMKVisited, 4, t)
+	assertCallCount(spy, tc.name, eMKError, 1, t)
+	if assertTrue(perr != nil, "expected an ExtenderPanic error") {
+		assertTrue(perr.Method == "Visit", "expected the Visit method, got %s", perr.Method)
+		assertTrue(perr.Value == "visit failed", "expected the panic value, got %v", perr.Value)
+		assertTrue(len(perr.Stack) > 0, "expected the stack trace")
+	}
+	assertIsInLog(tc.name, spy.b, "ERROR panic in Error dropped: error failed", t)
+}
+
+func testLinkInfo(t *testing.T, tc *testCase, buf bool) {
+	long := strings.Repeat("é", MaxLinkTextLen)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/" {
+			fmt.Fprint(w, "<html><body></body></html>")
+			return
+		}
+		fmt.Fprint(w, `<html><body>
+			<a href="#top">top</a>
+			<a href="/a" rel="NoFollow external">  Some
+				<b>anchor</b>   text </a>
+			<a href="/b">`+long+`</a>
+			<a href="/a">again</a>
+		</body></html>`)
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var mu sync.Mutex
+	infos := make(map[string]*LinkInfo)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := infos[ctx.url.Path]; !ok {
+			infos[ctx.url.Path] = ctx.LinkInfo()
+		}
+		return !isVisited
+	})
+	var visitInfo *LinkInfo
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		if ctx.url.Path == "/a" {
+			visitInfo = ctx.LinkInfo()
+		}
+		return nil, true
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run(srv.URL + "/")
+
+	assertTrue(infos["/"] == nil, "expected no link info for the seed")
+	if a := infos["/a"]; assertTrue(a != nil, "expected link info for /a") {
+		assertTrue(a.Text == "Some anchor text", "expected the collapsed anchor text, got %q", a.Text)
+		assertTrue(len(a.Rel) == 2 && a.Rel[0] == "nofollow" && a.Rel[1] == "external", "expected the rel tokens, got %v", a.Rel)
+		assertTrue(a.NoFollow, "expected nofollow")
+		assertTrue(a.Tag == "a", "expected tag a, got %s", a.Tag)
+		assertTrue(a.Index == 1, "expected index 1, got %d", a.Index)
+		assertTrue(visitInfo == a, "expected the same link info in Visit")
+	}
+	if b := infos["/b"]; assertTrue(b != nil, "expected link info for /b") {
+		assertTrue(len(b.Text) == MaxLinkTextLen, "expected the anchor text to be truncated to %d bytes, got %d", MaxLinkTextLen, len(b.Text))
+		assertTrue(!b.NoFollow && len(b.Rel) == 0, "expected no rel tokens, got %v", b.Rel)
+		assertTrue(b.Index == 2, "expected index 2, got %d", b.Index)
+	}
+}
+
+func testTrackAncestry(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var mu sync.Mutex
+	ancestries := make(map[string][]*url.URL)
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		ancestries[ctx.url.Path] = ctx.Ancestry()
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.TrackAncestry = true
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run("http://hosta/page1.html")
+
+	toStrings := func(urls []*url.URL) string {
+		var res []string
+		for _, u := range urls {
+			res = append(res, u.String())
+		}
+		return strings.Join(res, " ")
+	}
+	assertCallCount(spy, tc.name, eMKVisited, 3, t)
+	assertTrue(len(ancestries["/page1.html"]) == 0, "expected no ancestry for the seed, got %v", ancestries["/page1.html"])
+	got := toStrings(ancestries["/page2.html"])
+	assertTrue(got == "http://hosta/page1.html", "expected the seed as ancestry of page2, got %s", got)
+
+	u, _ := url.Parse("http://hosta/page3.html")
+	got = toStrings(c.PathTo(u))
+	assertTrue(got == "http://hosta/page1.html http://hosta/page3.html", "expected the path to page3, got %s", got)
+	u, _ = url.Parse("http://hosta/page4.html")
+	assertTrue(c.PathTo(u) == nil, "expected no path to page4, got %v", c.PathTo(u))
+}
+
+func testAttemptsAndFetchInfo(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		// Allow the URLs re-enqueued on error
+		return !isVisited || ctx.State == "Error"
+	})
+	var attempts []int
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		if err.Kind != CekFetch || err.Ctx.url.Path != "/page6.html" {
+			return
+		}
+		attempts = append(attempts, err.Ctx.Attempts())
+		if fi := err.Ctx.FetchInfo(); assertTrue(fi != nil, "expected the fetch info of the failed fetch") {
+			assertTrue(fi.StatusCode == 0 && fi.Size == 0, "expected an empty fetch info, got %+v", fi)
+		}
+		if err.Ctx.Attempts() < 3 {
+			// copy the URL first, otherwise creates a race
+			u := *err.Ctx.URL()
+			spy.EnqueueChan <- map[*url.URL]interface{}{&u: "Error"}
+		}
+	})
+	var size int64
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		if fi := ctx.FetchInfo(); assertTrue(fi != nil, "expected the fetch info of the visited URL") {
+			assertTrue(fi.StatusCode == 200 && !fi.IsHeadRequest, "expected a 200 GET, got %+v", fi)
+			if ctx.url.Path == "/pageunlinked.html" {
+				size = fi.Size
+			}
+		}
+		assertTrue(ctx.Attempts() == 1, "expected 1 attempt, got %d", ctx.Attempts())
+	})
+
+	opts := NewOptions(spy)
+	opts.LogFlags = LogAll
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.SameHostOnly = false
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{
+		"http://hosta/page6.html", // Does not exist, generate an error
+		"http://hostb/pageunlinked.html",
+	})
+
+	assertTrue(len(attempts) == 3 && attempts[0] == 1 && attempts[1] == 2 && attempts[2] == 3, "expected attempts 1, 2 and 3, got %v", attempts)
+	if fi, err := os.Stat("./testdata/hostb/pageunlinked.html"); err == nil {
+		assertTrue(size == fi.Size(), "expected a size of %d, got %d", fi.Size(), size)
+	}
+}
+
+// Extender implementing the Edge method.
+type edgeExtender struct {
+	*spyExtender
+	edges map[string]bool
+}
+
+func (x *edgeExtender) Edge(from, to *URLContext, followed bool) {
+	x.edges[from.url.Path+" -> "+to.url.Path] = followed
+}
+
+func testRecordEdges(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &edgeExtender{spy, make(map[string]bool)}
+
+	opts := NewOptions(ext)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.RecordEdges = true
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run("http://hosta/page1.html")
+
+	// The links to hostb are ignored on the same host policy
+	want := map[string]bool{
+		"/page1.html -> /page2.html": true,
+		"/page1.html -> /page3.html": true,
+		"/page2.html -> /page1.html": false,
+		"/page2.html -> /page3.html": false,
+		"/page3.html -> /page1.html": false,
+	}
+	assertTrue(len(ext.edges) == len(want), "expected %d streamed edges, got %d", len(want), len(ext.edges))
+	for k, v := range want {
+		f, ok := ext.edges[k]
+		assertTrue(ok && f == v, "expected streamed edge %s with followed=%t, got %t, %t", k, v, ok, f)
+	}
+
+	edges := c.Edges()
+	assertTrue(len(edges) == len(want), "expected %d recorded edges, got %d", len(want), len(edges))
+	for _, e := range edges {
+		k := e.From.Path + " -> " + e.To.Path
+		f, ok := want[k]
+		assertTrue(ok && f == e.Followed, "unexpected recorded edge %s with followed=%t", k, e.Followed)
+	}
+}
+
+func testFuncExtender(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	visited := 0
+	fe := &FuncExtender{
+		FetchFn: ff.Fetch,
+		VisitFn: func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+			visited++
+			return nil, true
+		},
+		LogFn: func(logFlags LogFlags, msgLevel LogFlags, msg string) {},
+	}
+	opts := NewOptions(fe)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.SameHostOnly = true
+	c := NewCrawlerWithOptions(opts)
+	assertTrue(fe.EnqueueChan == nil, "expected EnqueueChan to be nil")
+
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+
+	assertTrue(visited == 5, "expected 5 visits, got %d", visited)
+	assertTrue(fe.EnqueueChan != nil, "expected EnqueueChan to be non-nil")
+}
+
+type hostExtender struct {
+	*spyExtender
+	m       sync.Mutex
+	started []string
+	stopped []string
+}
+
+func (x *hostExtender) HostStarted(host string) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.started = append(x.started, host)
+}
+
+func (x *hostExtender) HostStopped(host string, reason HostStopReason, pending int) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.stopped = append(x.stopped, fmt.Sprintf("%s %s %d", host, reason, pending))
+}
+
+func testHostLifecycle(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &hostExtender{spyExtender: spy}
+	var c *Crawler
+
+	// Only the seeds and the hosta links are followed
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		return !isVisited && (ctx.url.Host == "hosta" || ctx.sourceURL == nil)
+	})
+	// Once the hostb worker is reaped, enqueue a hostb URL to start it again
+	visits := 0
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		if ctx.url.Host == "hosta" {
+			if visits++; visits == 3 {
+				u, _ := url.Parse("http://hostb/pageunlinked.html")
+				c.Enqueue(EnqueueItem{URL: u})
+			}
+		}
+	})
+
+	opts := NewOptions(ext)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.WorkerIdleTTL = 50 * time.Millisecond
+	opts.LogFlags = LogAll
+	c = NewCrawlerWithOptions(opts)
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html", "http://hostb/page2.html"})
+
+	assertCallCount(spy, tc.name, eMKVisit, 7, t)
+	started := strings.Join(ext.started, ",")
+	assertTrue(started == "hosta,hostb,hostb", "expected hosta,hostb,hostb to be started, got %s", started)
+	stopped := strings.Join(ext.stopped, ",")
+	assertTrue(stopped == "hostb idle 0,hostb idle 0,hosta crawl end 0", "expected hostb to stop twice on idle, then hosta on crawl end, got %s", stopped)
+	assertIsInLog(tc.name, spy.b, "host hostb stopped (idle), 0 pending URLs\n", t)
+}
+
+func testProgress(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.SameHostOnly = true
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	assertTrue(c.VisitedCount() == 0 && c.EnqueuedCount() == 0 && c.InFlight() == 0, "expected zero counters before Run")
+	assertTrue(len(c.Hosts()) == 0 && c.QueueLen("hosta") == 0, "expected no host before Run")
+
+	// Sample the progress during the crawl
+	end, done := make(chan struct{}), make(chan struct{})
+	spy.setExtensionMethod(eMKEnd, func(err error) { close(end) })
+	var samples, decreases, maxQueue int
+	go func() {
+		defer close(done)
+		last := 0
+		for {
+			select {
+			case <-time.After(5 * time.Millisecond):
+			case <-end:
+				return
+			}
+			v := c.VisitedCount()
+			if v < last {
+				decreases++
+			}
+			last = v
+			if n := c.QueueLen("hosta"); n > maxQueue {
+				maxQueue = n
+			}
+			c.EnqueuedCount()
+			c.InFlight()
+			c.Hosts()
+			samples++
+		}
+	}()
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+	<-done
+
+	assertTrue(samples > 0, "expected samples during the crawl")
+	assertTrue(decreases == 0, "expected visited count to never decrease, got %d decreases", decreases)
+	assertTrue(maxQueue > 0, "expected URLs waiting in the hosta queue during the crawl")
+	assertTrue(c.VisitedCount() == 5, "expected 5 visits, got %d", c.VisitedCount())
+	assertTrue(c.EnqueuedCount() == 5, "expected 5 enqueued URLs, got %d", c.EnqueuedCount())
+	assertTrue(c.InFlight() == 0, "expected no URL in flight, got %d", c.InFlight())
+	hosts := c.Hosts()
+	assertTrue(len(hosts) == 1 && hosts[0] == "hosta", "expected hosta as only host, got %v", hosts)
+	assertTrue(c.QueueLen("hosta") == 0, "expected empty hosta queue, got %d", c.QueueLen("hosta"))
+}
+
+func testStopDuringDelay(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 5 * time.Second
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	// Stop while the worker waits for the crawl delay following the robots.txt
+	var stopped time.Time
+	spy.setExtensionMethod(eMKFetchedRobots, func(ctx *URLContext, res *http.Response) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			stopped = time.Now()
+			c.Stop()
+		}()
+	})
+	start := time.Now()
+	err := c.Run("http://hosta/page1.html")
+	elapsed := time.Since(stopped)
+
+	assertTrue(err == ErrInterrupted, "expected ErrInterrupted, got %v", err)
+	assertTrue(elapsed < 500*time.Millisecond, "expected Run to return within 500ms of Stop, got %v", elapsed)
+	assertTrue(time.Since(start) < time.Second, "expected the crawl delay to be interrupted, Run took %v", time.Since(start))
+	assertCallCount(spy, tc.name, eMKVisit, 0, t)
+	assertIsInLog(tc.name, spy.b, "stop signal received during crawl delay.", t)
+}
+
+func testPerHost(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+
+	var m sync.Mutex
+	agents, heads, delays := make(map[string]string), make(map[string]int), make(map[string]time.Duration)
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+		m.Lock()
+		agents[ctx.url.Host] = userAgent
+		if headRequest {
+			heads[ctx.url.Host]++
+		}
+		m.Unlock()
+		return ff.Fetch(ctx, userAgent, headRequest)
+	})
+	spy.setExtensionMethod(eMKComputeDelay, func(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+		m.Lock()
+		delays[host] = di.OptsDelay
+		m.Unlock()
+		return di.OptsDelay
+	})
+
+	delay, head := 10*time.Millisecond, true
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.PerHost = map[string]HostOptions{
+		"hosta": {UserAgent: "agent-a", CrawlDelay: &delay, MaxVisitsPerHost: 2},
+		"hostb": {HeadBeforeGet: &head},
+	}
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{"http://hosta/page1.html", "http://hostb/page1.html"})
+
+	assertTrue(agents["hosta"] == "agent-a", "expected agent-a user agent for hosta, got %s", agents["hosta"])
+	assertTrue(agents["hostb"] == opts.UserAgent, "expected default user agent for hostb, got %s", agents["hostb"])
+	assertTrue(delays["hosta"] == delay, "expected %v options delay for hosta, got %v", delay, delays["hosta"])
+	assertTrue(delays["hostb"] == DefaultTestCrawlDelay, "expected %v options delay for hostb, got %v", DefaultTestCrawlDelay, delays["hostb"])
+	assertTrue(heads["hosta"] == 0, "expected no HEAD request for hosta, got %d", heads["hosta"])
+	assertTrue(heads["hostb"] == 3, "expected 3 HEAD requests for hostb, got %d", heads["hostb"])
+	// 2 visits for hosta (page1 and page2 or page3), 2 for hostb (page1 and page2)
+	assertCallCount(spy, tc.name, eMKVisit, 4, t)
+	assertIsInLog(tc.name, spy.b, "ignored on host max visits policy: http://hosta/page3.html", t)
+}
+
+func testRevisitAfter(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	opts := NewOptions(nil)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = 10 * time.Millisecond
+	opts.RevisitAfter = 300 * time.Millisecond
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	seeds := []string{"http://hosta/page1.html", "http://hosta/page4.html"}
+
+	run := func() (*spyExtender, int) {
+		spy := newSpy(ff, buf)
+		stale := 0
+		spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+			if !isVisited && !ctx.VisitedAt().IsZero() {
+				stale++
+			}
+			return !isVisited
+		})
+		opts.Extender = spy
+		c.Run(seeds)
+		return spy, stale
+	}
+
+	// First run visits all pages
+	spy, stale := run()
+	assertCallCount(spy, tc.name, eMKVisit, 5, t)
+	assertTrue(stale == 0, "expected no stale visit on first run, got %d", stale)
+
+	// Second run, the pages are still fresh
+	spy, _ = run()
+	assertCallCount(spy, tc.name, eMKVisit, 0, t)
+
+	// Third run, once the visits are stale
+	time.Sleep(opts.RevisitAfter)
+	spy, stale = run()
+	assertCallCount(spy, tc.name, eMKVisit, 5, t)
+	assertTrue(stale == 5, "expected 5 stale visits on third run, got %d", stale)
+}
+
+// A frontier that fails to push the page3.html URLs and fails the first pop
+// of each host.
+type flakyFrontier struct {
+	*MemoryFrontier
+	mu     sync.Mutex
+	popped map[string]bool
+}
+
+func (f *flakyFrontier) Push(ctx *URLContext) error {
+	if ctx.url.Path == "/page3.html" {
+		return errors.New("push failed")
+	}
+	b, err := json.Marshal(ctx)
+	if err != nil {
+		return err
+	}
+	cp := new(URLContext)
+	if err := json.Unmarshal(b, cp); err != nil {
+		return err
+	}
+	return f.MemoryFrontier.Push(cp)
+}
+
+func (f *flakyFrontier) Pop(host string) (*URLContext, bool, error) {
+	f.mu.Lock()
+	failed := f.popped[host]
+	f.popped[host] = true
+	f.mu.Unlock()
+	if !failed {
+		return nil, false, errors.New("pop failed")
+	}
+	return f.MemoryFrontier.Pop(host)
+}
+
+func testFrontier(t *testing.T, tc *testCase, buf bool) {
+	defer func(d time.Duration) { frontierRetryDelay = d }(frontierRetryDelay)
+	frontierRetryDelay = 10 * time.Millisecond
+
+	spy := newSpy(newFileFetcher(), buf)
+	var m sync.Mutex
+	pushes, pops := 0, 0
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		m.Lock()
+		defer m.Unlock()
+		if err.Kind != CekFrontier {
+			t.Errorf("unexpected error kind %s: %s", err.Kind, err)
+		} else if err.Ctx != nil {
+			pushes++
+		} else {
+			pops++
+		}
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.TrackAncestry = true
+	opts.Frontier = &flakyFrontier{NewMemoryFrontier(OrderBFS), sync.Mutex{}, make(map[string]bool)}
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+
+	// page3.html is filtered again each time it fails to be pushed
+	assertCallCount(spy, tc.name, eMKVisit, 4, t)
+	assertTrue(pushes == 2, "expected 2 push errors, got %d", pushes)
+	assertTrue(pops == 1, "expected 1 pop error, got %d", pops)
+	assertIsInLog(tc.name, spy.b, "ERROR pushing http://hosta/page3.html to the frontier: push failed", t)
+	assertIsNotInLog(tc.name, spy.b, "popped: http://hosta/page3.html", t)
+}
+
+func testDrain(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	seeds := S{"http://hosta/page1.html": "a", "http://hosta/page4.html": "b"}
+	run := func(seeds interface{}, maxVisits int) (*Crawler, map[string]bool, map[string]bool) {
+		spy := newSpy(ff, buf)
+		var m sync.Mutex
+		enqueued, visited := make(map[string]bool), make(map[string]bool)
+		spy.setExtensionMethod(eMKEnqueued, func(ctx *URLContext) {
+			if !ctx.IsRobotsURL() {
+				m.Lock()
+				enqueued[ctx.normalizedURL.String()] = true
+				m.Unlock()
+			}
+		})
+		spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+			m.Lock()
+			visited[ctx.normalizedURL.String()] = true
+			m.Unlock()
+		})
+		opts := NewOptions(spy)
+		opts.SameHostOnly = true
+		opts.CrawlDelay = DefaultTestCrawlDelay
+		opts.MaxVisits = maxVisits
+		opts.LogFlags = LogAll
+		c := NewCrawlerWithOptions(opts)
+		c.Run(seeds)
+		return c, enqueued, visited
+	}
+
+	c, enqueued, visited := run(seeds, 2)
+	drained := c.Drain()
+	assertTrue(len(visited) == 2, "expected 2 visits, got %v", visited)
+	assertTrue(len(drained)+len(visited) == len(enqueued), "expected %d drained URLs, got %d", len(enqueued)-len(visited), len(drained))
+	for _, ctx := range drained {
+		u := ctx.normalizedURL.String()
+		assertTrue(enqueued[u] && !visited[u], "unexpected drained URL %s", u)
+		if st, ok := seeds[u]; ok {
+			assertTrue(ctx.State == st, "expected state %v for %s, got %v", st, u, ctx.State)
+		} else {
+			assertTrue(ctx.sourceURL != nil, "expected a source URL for %s", u)
+		}
+	}
+	assertTrue(c.Drain() == nil, "expected nothing to drain twice")
+
+	// The drained URLs are visited by the next run
+	c, _, revisited := run(drained, 0)
+	for _, ctx := range drained {
+		assertTrue(revisited[ctx.normalizedURL.String()], "expected %s to be visited", ctx.normalizedURL)
+	}
+	assertTrue(c.Drain() == nil, "expected nothing to drain from a completed crawl")
+}
+
+// Extender requesting page2.html with a HEAD request only.
+type headOnlyExtender struct {
+	*spyExtender
+}
+
+func (x *headOnlyExtender) FilterURL(ctx *URLContext, isVisited bool) FilterResult {
+	res := FilterResult{Allow: !isVisited}
+	if ctx.normalizedURL.Path == "/page2.html" {
+		headOnly := true
+		res.HeadOnly = &headOnly
+	}
+	return res
+}
+
+func testHeadOnly(t *testing.T, tc *testCase, buf bool) {
+	x := &headOnlyExtender{newSpy(newFileFetcher(), buf)}
+	opts := NewOptions(x)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	// page4.html is enqueued as HEAD-only, so page5.html is never found
+	x.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		if ctx.normalizedURL.Path == "/page1.html" {
+			u, _ := url.Parse("http://hosta/page4.html")
+			if err := c.Enqueue(EnqueueItem{URL: u, HeadOnly: true}); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	c.Run("http://hosta/page1.html")
+
+	assertCallCount(x.spyExtender, tc.name, eMKVisit, 2, t)
+	assertCallCount(x.spyExtender, tc.name, eMKVisited, 4, t)
+	assertCallCount(x.spyExtender, tc.name, eMKRequestGet, 0, t)
+	n := x.getCalledWithCount(eMKFetch, ignore, ignore, true)
+	assertTrue(n == 2, "expected 2 HEAD requests, got %d", n)
+	n = x.getCalledWithCount(eMKFetch, ignore, ignore, false)
+	assertTrue(n == 3, "expected 3 GET requests (robots.txt, page1 and page3), got %d", n)
+	assertIsNotInLog(tc.name, x.b, "http://hosta/page5.html", t)
+}
+
+func testHeadGate(t *testing.T, tc *testCase, buf bool) {
+	// The HEAD responses announce various content types and sizes
+	mux := http.NewServeMux()
+	serve := func(path, ct string, size int, body string) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if ct != "" {
+				w.Header().Set("Content-Type", ct)
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(size))
+			if r.Method == "GET" {
+				fmt.Fprint(w, body)
+			}
+		})
+	}
+	links := `<a href="/a.zip">zip</a><a href="/big.html">big</a><a href="/page.txt">txt</a><a href="/noct">none</a>`
+	serve("/index.html", "text/html; charset=utf-8", len(links), links)
+	serve("/a.zip", "application/zip", 100, "")
+	serve("/big.html", "text/html", 1000, "")
+	serve("/page.txt", "text/plain", 4, "text")
+	serve("/noct", "", 4, "none")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var m sync.Mutex
+	gets := make(map[string]bool)
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+		if !headRequest {
+			m.Lock()
+			gets[ctx.url.Path] = true
+			m.Unlock()
+		}
+		return spy.Extender.Fetch(ctx, userAgent, headRequest)
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.HeadBeforeGet = true
+	opts.MaxBodySize = 500
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run(srv.URL + "/index.html")
+
+	for path, get := range map[string]bool{"/robots.txt": true, "/index.html": true, "/a.zip": false, "/big.html": false, "/page.txt": true, "/noct": true} {
+		assertTrue(gets[path] == get, "expected GET %v for %s", get, path)
+	}
+	n := spy.getCalledWithCount(eMKFetch, ignore, ignore, true)
+	assertTrue(n == 5, "expected 5 HEAD requests, got %d", n)
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+	// The URLs with a skipped GET are still visited
+	assertCallCount(spy, tc.name, eMKVisited, 5, t)
+	assertCallCount(spy, tc.name, eMKRequestGet, 4, t)
+	assertIsInLog(tc.name, spy.b, "ignored on body size policy (1000 bytes): "+srv.URL+"/big.html", t)
+	assertIsInLog(tc.name, spy.b, "GET skipped on HEAD filter policy: "+srv.URL+"/a.zip", t)
+}
+
+func testContentEncoding(t *testing.T, tc *testCase, buf bool) {
+	page := []byte(`<a href="http://other/1">1</a><a href="http://other/2">2</a><a href="http://other/3">3</a>`)
+	mux := http.NewServeMux()
+	serve := func(path, enc string, body []byte) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if enc != "" {
+				w.Header().Set("Content-Encoding", enc)
+			}
+			w.Write(body)
+		})
+	}
+	serve("/plain.html", "", page)
+	serve("/gzip.html", "gzip", gzipBytes(page))
+	serve("/deflate.html", "deflate", zlibBytes(page))
+	serve("/double.html", "gzip", gzipBytes(gzipBytes(page)))
+	serve("/corrupt.html", "gzip", page)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+		// Setting the Accept-Encoding header disables the transport's decompression
+		req, _ := http.NewRequest("GET", ctx.url.String(), nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		return HttpClient.Do(req)
+	})
+	var m sync.Mutex
+	links, kinds := make(map[string]int), make(map[string]CrawlErrorKind)
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		m.Lock()
+		defer m.Unlock()
+		if doc != nil {
+			links[ctx.url.Path] = doc.Find("a").Length()
+		}
+		assertTrue(res.Header.Get("Content-Encoding") == "", "expected no Content-Encoding for %s", ctx.url)
+		return nil, false
+	})
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		m.Lock()
+		defer m.Unlock()
+		kinds[err.Ctx.url.Path] = err.Kind
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	paths := []string{"/plain.html", "/gzip.html", "/deflate.html", "/double.html", "/corrupt.html"}
+	seeds := make([]string, len(paths))
+	for i, p := range paths {
+		seeds[i] = srv.URL + p
+	}
+	c.Run(seeds)
+
+	for _, p := range paths[:3] {
+		assertTrue(links[p] == 3, "expected 3 links for %s, got %d", p, links[p])
+	}
+	for _, p := range paths[3:] {
+		assertTrue(kinds[p] == CekReadBody, "expected a ReadBody error for %s, got %v", p, kinds[p])
+	}
+	assertIsInLog(tc.name, spy.b, "ERROR reading body "+srv.URL+"/double.html: "+ErrDoubleEncoded.Error(), t)
+}
+
+func testTransport(t *testing.T, tc *testCase, buf bool) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page1.html" {
+			fmt.Fprint(w, `<a href="/page2.html">2</a><a href="/page3.html">3</a>`)
+		}
+	}))
+	var conns int32
+	srv.Config.ConnState = func(c net.Conn, st http.ConnState) {
+		if st == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// The robots.txt and the 3 pages are fetched sequentially
+	for _, c := range []struct {
+		opts *TransportOptions
+		want int32
+	}{
+		{&TransportOptions{MaxIdleConnsPerHost: 1}, 1},
+		{&TransportOptions{DisableKeepAlives: true}, 4},
+	} {
+		atomic.StoreInt32(&conns, 0)
+		spy := newSpy(new(DefaultExtender), buf)
+		opts := NewOptions(spy)
+		opts.CrawlDelay = DefaultTestCrawlDelay
+		opts.LogFlags = LogAll
+		opts.Transport = c.opts
+		NewCrawlerWithOptions(opts).Run(srv.URL + "/page1.html")
+
+		assertCallCount(spy, tc.name, eMKVisit, 3, t)
+		n := atomic.LoadInt32(&conns)
+		assertTrue(n == c.want, "expected %d connection(s) with %+v, got %d", c.want, *c.opts, n)
+	}
+}
+
+func testHostRewrite(t *testing.T, tc *testCase, buf bool) {
+	var mu sync.Mutex
+	var hosts []string
+	robots := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		if r.URL.Path == "/robots.txt" {
+			robots++
+		}
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+		case "/page1.html":
+			fmt.Fprint(w, `<a href="/page2.html">2</a><a href="/private/page3.html">3</a>`)
+		}
+	}))
+	defer srv.Close()
+
+	var dials int32
+	spy := newSpy(new(DefaultExtender), buf)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.HostRewrite = map[string]string{"hosta:80": srv.Listener.Addr().String()}
+	opts.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		assertTrue(addr == srv.Listener.Addr().String(), "expected the rewritten address, got %s", addr)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	NewCrawlerWithOptions(opts).Run("http://hosta/page1.html")
+
+	assertCallCount(spy, tc.name, eMKVisit, 2, t)
+	assertCallCount(spy, tc.name, eMKDisallowed, 1, t)
+	mu.Lock()
+	defer mu.Unlock()
+	assertTrue(robots == 1, "expected the robots.txt to be requested once, got %d", robots)
+	for _, h := range hosts {
+		assertTrue(h == "hosta", "expected the Host header hosta, got %s", h)
+	}
+	assertTrue(atomic.LoadInt32(&dials) > 0, "expected DialContext to be called")
+}
+
+func testFollowHreflang(t *testing.T, tc *testCase, buf bool) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/en/" {
+			fmt.Fprint(w, "<html><body></body></html>")
+			return
+		}
+		fmt.Fprint(w, `<html><head>
+			<base href="/en/">
+			<link rel="alternate" hreflang="en" href="/en/">
+			<link rel="alternate" hreflang="fr" href="/fr/">
+			<link rel="Alternate" hreflang="de" href="/de/">
+			<link rel="alternate" hreflang="x-default" href="/">
+			<link rel="alternate" hreflang="es" href="http://other.example/es/">
+			<link rel="stylesheet" hreflang="en" href="style.css">
+		</head><body><a href="page.html">page</a></body></html>`)
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var mu sync.Mutex
+	infos := make(map[string]*LinkInfo)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := infos[ctx.url.Path]; !ok {
+			infos[ctx.url.Path] = ctx.LinkInfo()
+		}
+		if li := ctx.LinkInfo(); li != nil && li.Hreflang == "de" {
+			return false
+		}
+		return !isVisited
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	opts.FollowHreflang = true
+	NewCrawlerWithOptions(opts).Run(srv.URL + "/en/")
+
+	// /en/, /en/page.html, /fr/ and /
+	assertCallCount(spy, tc.name, eMKVisit, 4, t)
+	assertTrue(infos["/en/style.css"] == nil, "expected the stylesheet link to be ignored")
+	// The cross-host alternate is filtered, then ignored on the same host policy
+	if es := infos["/es/"]; assertTrue(es != nil, "expected link info for /es/") {
+		assertTrue(es.Hreflang == "es", "expected es, got %q", es.Hreflang)
+	}
+	if p := infos["/en/page.html"]; assertTrue(p != nil, "expected link info for the anchor") {
+		assertTrue(p.Index == 0 && p.Hreflang == "", "expected the anchor first without hreflang, got %+v", p)
+	}
+	if fr := infos["/fr/"]; assertTrue(fr != nil, "expected link info for /fr/") {
+		assertTrue(fr.Hreflang == "fr" && fr.Tag == "link" && fr.Index == 2, "expected the fr alternate link, got %+v", fr)
+	}
+	if def := infos["/"]; assertTrue(def != nil, "expected link info for x-default") {
+		assertTrue(def.Hreflang == "x-default", "expected x-default, got %q", def.Hreflang)
+	}
+	if de := infos["/de/"]; assertTrue(de != nil, "expected link info for /de/") {
+		assertTrue(de.Hreflang == "de", "expected de, got %q", de.Hreflang)
+	}
+}
+
+func testFollowGetForms(t *testing.T, tc *testCase, buf bool) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/search" {
+			fmt.Fprint(w, "<html><body></body></html>")
+			return
+		}
+		fmt.Fprint(w, `<html><body>
+			<form action="/results" method="Get">
+				<input type="hidden" name="lang" value="en">
+				<input type="hidden" name="sid" value="a b">
+				<input type="hidden" name="off" value="1" disabled>
+				<input type="hidden" value="unnamed">
+				<input type="text" name="q" value="query">
+				<select name="sort"><option value="name">Name</option><option value="date" selected>Date</option></select>
+				<select name="tag" multiple><option selected>  Go  </option><option selected value="web">Web</option></select>
+			</form>
+			<form action="/post" method="post"><input type="hidden" name="a" value="1"></form>
+			<form><input type="text" name="q"></form>
+			<form action="/other?old=1"></form>
+		</body></html>`)
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var mu sync.Mutex
+	var filtered []string
+	infos := make(map[string]*LinkInfo)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		u := ctx.NormalizedURL().RequestURI()
+		filtered = append(filtered, u)
+		infos[u] = ctx.LinkInfo()
+		return !isVisited
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	opts.FollowGetForms = true
+	opts.GetFormDefaults = true
+	opts.StripQueryParams = []string{"lang"}
+	NewCrawlerWithOptions(opts).Run(srv.URL + "/search")
+
+	// /search, /results and /other, the empty action is the visited /search
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/search", "/results?sid=a+b&sort=date&tag=Go&tag=web", "/search", "/other"}
+	assertTrue(reflect.DeepEqual(filtered, want), "expected filtered URLs %v, got %v", want, filtered)
+	if r := infos[want[1]]; assertTrue(r != nil, "expected link info for /results") {
+		assertTrue(r.Tag == "form" && r.Index == 0 && r.Text == "", "expected the first form, got %+v", r)
+	}
+	if o := infos["/other"]; assertTrue(o != nil, "expected link info for /other") {
+		assertTrue(o.Index == 2, "expected the third GET form, got %d", o.Index)
+	}
+}
+
+func testMaxPaginationDepth(t *testing.T, tc *testCase, buf bool) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/page/%d", &n); err != nil || n < 1 {
+			if r.URL.Path == "/list" {
+				fmt.Fprint(w, `<html><body><a href="/page/1">archives</a></body></html>`)
+			}
+			return
+		}
+		// The next page is a link of the head, except for page 2 that has an anchor
+		next := fmt.Sprintf(`<link rel="next" href="/page/%d">`, n+1)
+		if n == 2 {
+			next = fmt.Sprintf(`<a rel="next" href="/page/%d">next</a>`, n+1)
+		}
+		fmt.Fprintf(w, `<html><head><link rel="prev" href="/page/%d">%s</head>
+			<body><a href="/article/%d">article</a></body></html>`, n-1, next, n)
+	}))
+	defer srv.Close()
+
+	// With the default options, the pagination links of the head are not
+	// harvested: only /list, /page/1 and /article/1 are visited
+	def := newSpy(new(DefaultExtender), buf)
+	defOpts := NewOptions(def)
+	defOpts.CrawlDelay = 0
+	defOpts.LogFlags = LogAll
+	NewCrawlerWithOptions(defOpts).Run(srv.URL + "/list")
+	assertCallCount(def, tc.name, eMKVisit, 3, t)
+	assertIsNotInLog(tc.name, def.b, "/page/2", t)
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var mu sync.Mutex
+	depths := make(map[string]int)
+	infos := make(map[string]*LinkInfo)
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		depths[ctx.url.Path] = ctx.PaginationDepth()
+		infos[ctx.url.Path] = ctx.LinkInfo()
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	opts.MaxPaginationDepth = 2
+	NewCrawlerWithOptions(opts).Run(srv.URL + "/list")
+
+	// /list, /page/0 (the prev of page 1) to 3 and /article/1 to 3
+	assertCallCount(spy, tc.name, eMKVisit, 8, t)
+	assertIsInLog(tc.name, spy.b, "ignore on pagination depth policy: "+srv.URL+"/page/4\n", t)
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"/list": 0, "/page/1": 0, "/page/2": 1, "/page/3": 2, "/page/0": 0, "/article/3": 0}
+	for p, d := range want {
+		got, ok := depths[p]
+		assertTrue(ok && got == d, "expected %s visited with pagination depth %d, got %d (visited: %v)", p, d, got, ok)
+	}
+	assertTrue(infos["/page/1"] != nil && !infos["/page/1"].Pagination, "expected /page/1 not to be a pagination link")
+	if li := infos["/page/3"]; assertTrue(li != nil, "expected link info for /page/3") {
+		assertTrue(li.Pagination && li.Tag == "a", "expected the pagination anchor, got %+v", li)
+	}
+	if li := infos["/page/2"]; assertTrue(li != nil, "expected link info for /page/2") {
+		assertTrue(li.Pagination && li.Tag == "link", "expected the pagination link, got %+v", li)
+	}
+}
+
+func testHarvestImages(t *testing.T, tc *testCase, buf bool) {
+	var mu sync.Mutex
+	methods := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods[r.URL.Path] = r.Method
+		mu.Unlock()
+		if r.URL.Path != "/" {
+			if strings.HasPrefix(r.URL.Path, "/img/") {
+				w.Header().Set("Content-Type", "image/png")
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><base href="/img/"></head><body>
+			<img src="a.png" srcset="a.png 1x, /img/b.png 2x, c.png foo">
+			<picture>
+				<source srcset="http://%[1]s/img/d.png 480w,  //%[1]s/img/e.png   1080w" media="(min-width: 600px)">
+				<img src="f.png">
+			</picture>
+			<img srcset="">
+			<a href="/page">page</a>
+		</body></html>`, r.Host)
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	infos := make(map[string]*LinkInfo)
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		infos[ctx.url.Path] = ctx.LinkInfo()
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	opts.HarvestImages = true
+	NewCrawlerWithOptions(opts).Run(srv.URL + "/")
+
+	// The page and the link are visited, the images are only checked
+	assertCallCount(spy, tc.name, eMKVisit, 2, t)
+	assertCallCount(spy, tc.name, eMKVisited, 7, t)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range []string{"/img/a.png", "/img/b.png", "/img/d.png", "/img/e.png", "/img/f.png"} {
+		assertTrue(methods[p] == "HEAD", "expected %s requested with HEAD, got %q", p, methods[p])
+		if li := infos[p]; assertTrue(li != nil, "expected link info for %s", p) {
+			assertTrue(li.Asset == AssetImage, "expected an image asset for %s, got %q", p, li.Asset)
+		}
+	}
+	_, ok := methods["/img/c.png"]
+	assertTrue(!ok, "expected the candidate with an invalid descriptor to be skipped")
+	if li := infos["/page"]; assertTrue(li != nil, "expected link info for /page") {
+		assertTrue(li.Asset == "" && li.Index == 0, "expected the anchor first and not an asset, got %+v", li)
+	}
+	if li := infos["/img/d.png"]; assertTrue(li != nil, "expected link info for /img/d.png") {
+		assertTrue(li.Tag == "source" && li.Index == 3, "expected the fourth link from a source tag, got %+v", li)
+	}
+}
+
+func testProtocolRelative(t *testing.T, tc *testCase, buf bool) {
+	for _, scheme := range []string{"http", "https"} {
+		spy := newSpy(newFileFetcher(), buf)
+		var mu sync.Mutex
+		var visited []string
+		spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			visited = append(visited, ctx.NormalizedURL().String())
+		})
+
+		opts := NewOptions(spy)
+		opts.SameHostOnly = true
+		opts.CrawlDelay = DefaultTestCrawlDelay
+		opts.LogFlags = LogAll
+		// Keep the scheme in the normalized URLs
+		opts.URLNormalizationFlags = purell.FlagsUsuallySafeGreedy | purell.FlagRemoveFragment
+		NewCrawlerWithOptions(opts).Run([]string{scheme + "://hostg/page1.html", "//hostg/page1.html"})
+
+		assertCallCount(spy, tc.name, eMKVisit, 4, t)
+		assertIsInLog(tc.name, spy.b, "ERROR "+ErrProtocolRelative.Error()+": //hostg/page1.html\n", t)
+		assertIsInLog(tc.name, spy.b, "ignore on same host policy: "+scheme+"://hosta/page1.html\n", t)
+		mu.Lock()
+		sort.Strings(visited)
+		want := []string{scheme + "://hostg/page1.html", scheme + "://hostg/page2.html", scheme + "://hostg/page3.html", scheme + "://hostg/sub/page4.html"}
+		assertTrue(reflect.DeepEqual(visited, want), "expected %v visited, got %v", want, visited)
+		mu.Unlock()
+	}
+}
+
+func testRegisterVisitor(t *testing.T, tc *testCase, buf bool) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><body><a href="/data.json">json</a><a href="/doc.pdf">pdf</a><a href="/notes.txt">txt</a></body></html>`)
+		case "/data.json":
+			w.Header().Set("Content-Type", "application/ld+json")
+			fmt.Fprint(w, `{"next": "/more.json"}`)
+		case "/more.json":
+			w.Header().Set("Content-Type", "application/ld+json")
+			fmt.Fprint(w, `{}`)
+		case "/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			fmt.Fprint(w, "%PDF-1.4")
+		case "/notes.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "notes")
+		}
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), buf)
+	var mu sync.Mutex
+	var extVisits []string
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		extVisits = append(extVisits, ctx.url.Path)
+		return nil, true
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	opts.VisitWorkers = 2
+	c := NewCrawlerWithOptions(opts)
+
+	type visit struct {
+		body string
+		doc  bool
+	}
+	visits := make(map[string]visit)
+	record := func(ctx *URLContext, body []byte, doc *goquery.Document) {
+		mu.Lock()
+		defer mu.Unlock()
+		visits[ctx.url.Path] = visit{string(body), doc != nil}
+	}
+	c.RegisterVisitor("text/html", func(ctx *URLContext, res *http.Response, body []byte, doc *goquery.Document) (interface{}, bool) {
+		record(ctx, body, doc)
+		return nil, true
+	})
+	c.RegisterVisitor("application/*+json", func(ctx *URLContext, res *http.Response, body []byte, doc *goquery.Document) (interface{}, bool) {
+		record(ctx, body, doc)
+		var v struct{ Next string }
+		json.Unmarshal(body, &v)
+		if v.Next == "" {
+			return nil, false
+		}
+		return srv.URL + v.Next, false
+	})
+	c.RegisterVisitor("application/pdf", func(ctx *URLContext, res *http.Response, body []byte, doc *goquery.Document) (interface{}, bool) {
+		record(ctx, body, doc)
+		b, _ := ioutil.ReadAll(res.Body)
+		assertTrue(string(b) == string(body), "expected the body to be readable, got %q", b)
+		return nil, false
+	})
+	c.Run(srv.URL + "/")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assertTrue(reflect.DeepEqual(extVisits, []string{"/notes.txt"}), "expected only the text to be visited by the extender, got %v", extVisits)
+	assertCallCount(spy, tc.name, eMKVisited, 5, t)
+	want := map[string]visit{
+		"/":          {`<html><body><a href="/data.json">json</a><a href="/doc.pdf">pdf</a><a href="/notes.txt">txt</a></body></html>`, true},
+		"/data.json": {`{"next": "/more.json"}`, false},
+		"/more.json": {`{}`, false},
+		"/doc.pdf":   {"%PDF-1.4", false},
+	}
+	assertTrue(reflect.DeepEqual(visits, want), "expected visits %v, got %v", want, visits)
+}
+
+func testDelayState(t *testing.T, tc *testCase, buf bool) {
+	const delay = 200 * time.Millisecond
+	var mu sync.Mutex
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	newCrawler := func() *Crawler {
+		opts := NewOptions(newSpy(new(DefaultExtender), buf))
+		opts.CrawlDelay = delay
+		opts.LogFlags = LogAll
+		return NewCrawlerWithOptions(opts)
+	}
+	// Return the gap between the first request of the run and the previous
+	// request.
+	run := func(c *Crawler) time.Duration {
+		mu.Lock()
+		n := len(times)
+		mu.Unlock()
+		c.Run(srv.URL + "/page")
+		mu.Lock()
+		defer mu.Unlock()
+		if !assertTrue(n > 0 && len(times) > n, "expected requests, got %d then %d", n, len(times)) {
+			return 0
+		}
+		return times[n].Sub(times[n-1])
+	}
+
+	// The second run waits for the delay of the last fetch of the first run
+	c := newCrawler()
+	c.Run(srv.URL + "/page")
+	state := c.DelayState()
+	_, ok := state[u.Host]
+	assertTrue(ok && len(state) == 1, "expected the delay state of %s, got %v", u.Host, state)
+	gap := run(c)
+	assertTrue(gap >= delay-10*time.Millisecond, "expected a gap of at least %v between the runs, got %v", delay, gap)
+
+	// A new crawler waits for the delay of the imported state
+	c = newCrawler()
+	c.SetDelayState(map[string]time.Time{u.Host: time.Now()})
+	gap = run(c)
+	assertTrue(gap >= delay-10*time.Millisecond, "expected a gap of at least %v with the imported state, got %v", delay, gap)
+
+	// An old fetch does not delay the run
+	c = newCrawler()
+	c.SetDelayState(map[string]time.Time{u.Host: time.Now().Add(-time.Hour)})
+	start := time.Now()
+	c.Run(srv.URL + "/page")
+	mu.Lock()
+	first := times[len(times)-2] // robots.txt and the page
+	mu.Unlock()
+	assertTrue(first.Sub(start) < delay, "expected no wait for an old fetch, got %v", first.Sub(start))
+}
+
+func testIsVisited(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+
+	// Safe to query while running
+	var during bool
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		if ctx.url.Path == "/page1.html" {
+			during = c.IsVisited("http://hosta/page1.html") && len(c.VisitedURLs()) > 0
+		}
+	})
+	c.Run("http://hosta/page1.html")
+
+	assertTrue(during, "expected the URL to be visited during the crawl")
+	for _, u := range []string{
+		"http://hosta/page1.html",
+		"http://hosta/page1.html#section",
+		"http://hosta/page1.html/",
+		"HTTP://HOSTA/page1.html",
+		"http://www.hosta/page1.html",
+		"http://hosta/page2.html",
+	} {
+		assertTrue(c.IsVisited(u), "expected %s to be visited", u)
+	}
+	for _, u := range []string{"http://hosta/page9.html", "http://hostb/page1.html", "/page1.html", "://"} {
+		assertTrue(!c.IsVisited(u), "expected %s not to be visited", u)
+	}
+
+	want := []string{
+		"http://hosta/page1.html",
+		"http://hosta/page2.html",
+		"http://hosta/page3.html",
+	}
+	got := c.VisitedURLs()
+	assertTrue(reflect.DeepEqual(got, want), "expected visited URLs %v, got %v", want, got)
+
+	n := 0
+	c.EachVisited(func(u string, at time.Time) bool {
+		assertTrue(!at.IsZero(), "expected the time %s was visited", u)
+		n++
+		return n < 2
+	})
+	assertTrue(n == 2, "expected the iteration to stop after 2 URLs, got %d", n)
+}
+
+func testImportVisited(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	c.Run("http://hosta/page1.html")
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+
+	var exported bytes.Buffer
+	assertTrue(c.ExportVisited(&exported) == nil, "expected the visited URLs to be exported")
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	assertTrue(len(lines) == 3 && strings.HasPrefix(lines[0], "http://hosta/page1.html\t"), "expected 3 sorted URLs, got %q", lines)
+
+	// Round-trip, the times are kept
+	spy = newSpy(newFileFetcher(), buf)
+	opts = NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c = NewCrawlerWithOptions(opts)
+	assertTrue(c.ImportVisited(bytes.NewReader(exported.Bytes())) == nil, "expected the visited URLs to be imported")
+	var reexported bytes.Buffer
+	c.ExportVisited(&reexported)
+	assertTrue(reexported.String() == exported.String(), "expected %q, got %q", exported.String(), reexported.String())
+
+	// The second run only visits the new URLs, the imported ones are visited
+	var seen []string
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		if isVisited {
+			seen = append(seen, ctx.normalizedURL.String())
+		}
+		return !isVisited
+	})
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+	assertCallCount(spy, tc.name, eMKVisit, 2, t)
+	assertTrue(len(seen) > 0 && seen[0] == "http://hosta/page1.html", "expected the seed page1 to be visited, got %v", seen)
+	assertTrue(len(c.VisitedURLs()) == 5, "expected 5 visited URLs, got %v", c.VisitedURLs())
+}
+
+func testSeedItems(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var mu sync.Mutex
+	type seen struct {
+		seed  bool
+		state interface{}
+	}
+	filtered := make(map[string]seen)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := filtered[ctx.url.String()]; !ok {
+			filtered[ctx.url.String()] = seen{ctx.IsSeed(), ctx.State}
+		}
+		return !isVisited
+	})
+	var headOnly []string
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.HeadOnly {
+			headOnly = append(headOnly, ctx.url.String())
+		}
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = false
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	p1, _ := url.Parse("http://hosta/page1.html")
+	b2, _ := url.Parse("http://hostb/page2.html")
+	c.Run([]EnqueueItem{
+		{URL: p1, State: "category-a", Priority: 2},
+		{URL: b2, State: "category-b", HeadOnly: true},
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]seen{
+		"http://hosta/page1.html": {true, "category-a"},
+		"http://hostb/page2.html": {true, "category-b"},
+		"http://hosta/page2.html": {false, nil},
+	}
+	for u, s := range want {
+		got, ok := filtered[u]
+		assertTrue(ok && got == s, "expected %s filtered with %+v, got %+v", u, s, got)
+	}
+	assertTrue(reflect.DeepEqual(headOnly, []string{"http://hostb/page2.html"}), "expected the HeadOnly seed, got %v", headOnly)
+
+	// The legacy forms are seeds too
+	filtered = make(map[string]seen)
+	mu.Unlock()
+	c.Run(S{"http://hosta/page1.html": "legacy"})
+	mu.Lock()
+	got := filtered["http://hosta/page1.html"]
+	assertTrue(got.seed && got.state == "legacy", "expected the legacy seed with its state, got %+v", got)
+}
+
+func testSeedProvider(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var mu sync.Mutex
+	var errs []*CrawlError
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err.Kind == CekSeedProvider {
+			errs = append(errs, err)
+		}
+	})
+	seeds := make(map[string]bool)
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		seeds[ctx.url.String()] = ctx.IsSeed()
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	err := c.Run(FileSeedProvider(strings.NewReader(`# seeds
+http://hosta/page1.html
+
+http://[bad
+http://hostb/page2.html
+`)))
+	assertTrue(err == nil, "expected no error, got %v", err)
+
+	mu.Lock()
+	assertTrue(seeds["http://hosta/page1.html"] && seeds["http://hostb/page2.html"], "expected the provided seeds to be visited as seeds, got %v", seeds)
+	seed, ok := seeds["http://hosta/page2.html"]
+	assertTrue(ok && !seed, "expected the harvested URL to be visited as a non-seed, got %v", seeds)
+	assertTrue(len(errs) == 1, "expected a single seed provider error, got %v", errs)
+	errs, seeds = nil, make(map[string]bool)
+	mu.Unlock()
+
+	// The crawl stops on the first error if requested
+	opts.AbortOnSeedError = true
+	err = c.Run(FileSeedProvider(strings.NewReader("http://[bad\nhttp://hosta/page1.html\n")))
+	assertTrue(err != nil && err != ErrInterrupted, "expected the seed provider error, got %v", err)
+	mu.Lock()
+	defer mu.Unlock()
+	assertTrue(len(seeds) == 0, "expected no visit, got %v", seeds)
+	assertTrue(len(errs) == 1, "expected a single seed provider error, got %v", errs)
+}
+
+func testMaxErrors(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var mu sync.Mutex
+	errs := 0
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err.Kind == CekFetch {
+			errs++
+		}
+	})
+
+	ext := &hostExtender{spyExtender: spy}
+	opts := NewOptions(ext)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.MaxErrors = 3
+	c := NewCrawlerWithOptions(opts)
+	var seeds []string
+	for i := 1; i <= 10; i++ {
+		seeds = append(seeds, fmt.Sprintf("http://hosta/unknown%d.html", i))
+	}
+	err := c.Run(seeds)
+	assertTrue(errors.Is(err, ErrMaxErrors), "expected ErrMaxErrors, got %v", err)
+	assertTrue(strings.Contains(err.Error(), "3 errors"), "expected the error count in %q", err)
+	mu.Lock()
+	assertTrue(errs >= 3 && errs < 10, "expected the crawl to stop after 3 errors, got %d errors", errs)
+	errs = 0
+	mu.Unlock()
+	ext.m.Lock()
+	assertTrue(len(ext.stopped) == 1 && strings.HasPrefix(ext.stopped[0], "hosta errors "), "expected hosta to stop on errors, got %v", ext.stopped)
+	ext.m.Unlock()
+
+	// A successful fetch resets the consecutive errors
+	opts.MaxErrors = 0
+	opts.MaxConsecutiveErrors = 3
+	err = c.Run([]string{
+		"http://hosta/unknown1.html",
+		"http://hosta/unknown2.html",
+		"http://hosta/page1.html",
+		"http://hosta/unknown3.html",
+		"http://hosta/unknown4.html",
+		"http://hosta/unknown5.html",
+		"http://hosta/unknown6.html",
+	})
+	assertTrue(errors.Is(err, ErrMaxErrors), "expected ErrMaxErrors, got %v", err)
+	assertTrue(strings.Contains(err.Error(), "3 consecutive errors"), "expected the consecutive error count in %q", err)
+	mu.Lock()
+	defer mu.Unlock()
+	assertTrue(errs >= 5, "expected the crawl to stop after 3 consecutive errors, got %d errors", errs)
+}
+
+func testMutateOptionsDuringRun(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var c *Crawler
+	started := make(chan struct{})
+	var once sync.Once
+	var setErr error
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		once.Do(func() {
+			setErr = c.SetOptions(NewOptions(spy))
+			close(started)
+		})
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c = NewCrawlerWithOptions(opts)
+
+	// Mutate the caller's Options while the crawler runs and is queried, the
+	// race detector must not report a race with the workers.
+	done := make(chan struct{})
+	mutated := make(chan struct{})
+	go func() {
+		defer close(mutated)
+		select {
+		case <-started:
+		case <-done:
+			return
+		}
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			opts.UserAgent = fmt.Sprintf("agent-%d", i)
+			opts.CrawlDelay = time.Duration(i)
+			opts.SameHostOnly = i%2 == 0
+			opts.AllowedSchemes = []string{"http"}
+			opts.PerHost = map[string]HostOptions{"hosta": {UserAgent: "a"}}
+			c.IsVisited("http://hosta/page1.html")
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := c.Run("http://hosta/page1.html")
+	close(done)
+	<-mutated
+	assertTrue(err == nil, "expected no error, got %v", err)
+	assertTrue(setErr != nil, "expected SetOptions to fail while running")
+	assertTrue(c.Options == opts, "expected the caller's Options to be kept by the run")
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+
+	// Between runs, the Options can be replaced
+	other := NewOptions(spy)
+	assertTrue(c.SetOptions(other) == nil, "expected SetOptions to succeed between runs")
+	assertTrue(c.Options == other, "expected the new Options to be set")
+	assertTrue(c.SetOptions(nil) != nil, "expected SetOptions to fail with nil Options")
+}
+
+type visitedInfoExtender struct {
+	*spyExtender
+	m     sync.Mutex
+	infos map[string][]*VisitInfo
+}
+
+func (x *visitedInfoExtender) VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.infos[ctx.url.String()] = append(x.infos[ctx.url.String()], info)
+}
+
+func testVisitedInfo(t *testing.T, tc *testCase, buf bool) {
+	ff := newFileFetcher()
+	spy := newSpy(ff, buf)
+	ext := &visitedInfoExtender{spyExtender: spy, infos: make(map[string][]*VisitInfo)}
+
+	// Only the seeds are followed, page5 gets a 500 response
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		return !isVisited && ctx.sourceURL == nil
+	})
+	spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, agent string, head bool) (*http.Response, error) {
+		if ctx.url.Path == "/page5.html" {
+			return &http.Response{
+				Status:     "500 Internal Server Error",
+				StatusCode: 500,
+				Header:     http.Header{"Retry-After": {"10"}},
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+				Request:    &http.Request{URL: ctx.url},
+			}, nil
+		}
+		return ff.Fetch(ctx, agent, head)
+	})
+
+	opts := NewOptions(ext)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	u := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	c.Run([]EnqueueItem{
+		{URL: u("http://hosta/page1.html")},
+		{URL: u("http://hosta/page4.html"), HeadOnly: true},
+		{URL: u("http://hosta/page5.html")},
+		{URL: u("http://hosta/unknown.html")},
+	})
+
+	// The Visited extender method is replaced by VisitedInfo
+	assertCallCount(spy, tc.name, eMKVisited, 0, t)
+	ext.m.Lock()
+	defer ext.m.Unlock()
+	assertTrue(len(ext.infos) == 4, "expected 4 URLs notified, got %d", len(ext.infos))
+	for u, infos := range ext.infos {
+		assertTrue(len(infos) == 1, "expected %s notified once, got %d", u, len(infos))
+	}
+	if infos := ext.infos["http://hosta/page1.html"]; len(infos) == 1 {
+		info := infos[0]
+		assertTrue(info.Outcome == VisitDone && info.StatusCode == 200 && info.FindLinks, "expected page1 visited with its links, got %+v", info)
+		assertTrue(info.Size > 0 && info.Harvested == 3, "expected the size and the 3 harvested links of page1, got %+v", info)
+	}
+	if infos := ext.infos["http://hosta/page4.html"]; len(infos) == 1 {
+		info := infos[0]
+		assertTrue(info.Outcome == VisitHeadOnly && info.StatusCode == 200 && info.Size == 0, "expected page4 head only, got %+v", info)
+	}
+	if infos := ext.infos["http://hosta/page5.html"]; len(infos) == 1 {
+		info := infos[0]
+		assertTrue(info.Outcome == VisitStatusError && info.StatusCode == 500, "expected the status error of page5, got %+v", info)
+		assertTrue(info.Header.Get("Retry-After") == "10", "expected the header of page5, got %v", info.Header)
+	}
+	if infos := ext.infos["http://hosta/unknown.html"]; len(infos) == 1 {
+		info := infos[0]
+		assertTrue(info.Outcome == VisitFetchError && info.Err != nil && info.StatusCode == 0 && info.Header == nil, "expected the fetch error of unknown.html, got %+v", info)
+	}
+}
+
+type hostCompleteExtender struct {
+	*spyExtender
+	m     sync.Mutex
+	stats map[string][]HostStats
+	order []string
+}
+
+func (x *hostCompleteExtender) HostComplete(host string, stats HostStats) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.stats[host] = append(x.stats[host], stats)
+	x.order = append(x.order, host)
+}
+
+func (x *hostCompleteExtender) End(err error) {
+	x.m.Lock()
+	x.order = append(x.order, "end")
+	x.m.Unlock()
+	x.spyExtender.End(err)
+}
+
+func testHostComplete(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &hostCompleteExtender{spyExtender: spy, stats: make(map[string][]HostStats)}
+	var c *Crawler
+
+	// Only the seeds and the links to the same host are followed
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		return !isVisited && (ctx.sourceURL == nil || ctx.url.Host == ctx.sourceURL.Host)
+	})
+
+	opts := NewOptions(ext)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.WorkerIdleTTL = 50 * time.Millisecond
+	opts.LogFlags = LogAll
+	opts.HostTags = map[string]map[string]string{"hosta": {"customer": "acme"}}
+	c = NewCrawlerWithOptions(opts)
+
+	// Once hostb is complete, enqueue a hostb URL to start it again
+	var once sync.Once
+	go func() {
+		for {
+			ext.m.Lock()
+			n := len(ext.stats["hostb"])
+			ext.m.Unlock()
+			if n > 0 {
+				once.Do(func() {
+					u, _ := url.Parse("http://hostb/pageunlinked.html")
+					c.Enqueue(EnqueueItem{URL: u})
+				})
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html", "http://hostb/page2.html"})
+
+	ext.m.Lock()
+	defer ext.m.Unlock()
+	order := strings.Join(ext.order, ",")
+	assertTrue(order == "hostb,hostb,hosta,end", "expected hostb to complete twice, then hosta before End, got %s", order)
+	if st := ext.stats["hostb"]; len(st) == 2 {
+		assertTrue(st[0].Visits == 2 && st[0].Errors[CekFetch] == 1, "expected 2 visits and 1 fetch error for hostb, got %+v", st[0])
+		assertTrue(st[0].Fetches == 4 && st[0].Bytes > 0 && st[0].FetchTime > 0, "expected the 4 fetches of hostb, got %+v", st[0])
+		assertTrue(st[0].AvgDelay == DefaultTestCrawlDelay && st[0].Robots == RobotsFetched, "expected the delay and robots.txt of hostb, got %+v", st[0])
+		assertTrue(st[1].Visits == 1 && len(st[1].Errors) == 0 && st[1].Fetches == 2, "expected the stats of the second batch of hostb only, got %+v", st[1])
+		assertTrue(st[0].Tags == nil, "expected no tags for hostb, got %v", st[0].Tags)
+	}
+	if st := ext.stats["hosta"]; len(st) == 1 {
+		assertTrue(st[0].Visits == 5 && st[0].Fetches == 6, "expected 5 visits for hosta, got %+v", st[0])
+		assertTrue(st[0].Tags["customer"] == "acme", "expected the tags of hosta, got %v", st[0].Tags)
+	}
+
+	// A host is not complete when the crawl is stopped
+	ext.order, ext.stats = nil, make(map[string][]HostStats)
+	ext.m.Unlock()
+	opts.MaxVisits = 2
+	opts.IgnoreRobots = true
+	c.Run("http://hosta/page1.html")
+	ext.m.Lock()
+	assertTrue(strings.Join(ext.order, ",") == "end", "expected no host complete when stopped, got %v", ext.order)
+}
+
+func testRewriteURL(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	var mu sync.Mutex
+	orig := make(map[string]string)
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		orig[ctx.URL().String()] = ctx.OriginalURL().String()
+		mu.Unlock()
+	})
+
+	opts := NewOptions(spy)
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.RewriteURL = func(u *url.URL, src *URLContext) *url.URL {
+		switch u.String() {
+		case "http://hostb/page1.html":
+			// Rewritten to the same host, so it is allowed
+			u.Host, u.Path = "hosta", "/page4.html"
+		case "http://hosta/page3.html":
+			return nil
+		case "http://hosta/page5.html":
+			// Rewritten to another host, so it is ignored
+			u.Host = "hostc"
+		}
+		return u
+	}
+	c := NewCrawlerWithOptions(opts)
+	c.Run("http://hosta/page1.html")
+
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+	assertCallCount(spy, tc.name, eMKError, 0, t)
+	assertIsInLog(tc.name, spy.b, "ignore on rewrite policy: http://hosta/page3.html\n", t)
+	assertIsInLog(tc.name, spy.b, "ignore on same host policy: http://hostc/page5.html\n", t)
+	mu.Lock()
+	defer mu.Unlock()
+	assertTrue(orig["http://hosta/page4.html"] == "http://hostb/page1.html", "expected the original URL of page4 to be hostb, got %v", orig)
+	assertTrue(orig["http://hosta/page1.html"] == "http://hosta/page1.html", "expected the seed not to be rewritten, got %v", orig)
+}
+
+type disallowedReasonExtender struct {
+	*spyExtender
+	m          sync.Mutex
+	reasons    map[string]DisallowedKind
+	details    map[string]string
+	disallowed int
+	visited    int
+	harvested  int
+}
+
+func (x *disallowedReasonExtender) DisallowedReason(ctx *URLContext, reason DisallowedKind, detail string) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.disallowed++
+	x.reasons[ctx.normalizedURL.String()] = reason
+	x.details[ctx.normalizedURL.String()] = detail
+}
+
+func (x *disallowedReasonExtender) VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.visited++
+	x.harvested += info.Harvested
+}
+
+func testDisallowedReason(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &disallowedReasonExtender{
+		spyExtender: spy,
+		reasons:     make(map[string]DisallowedKind),
+		details:     make(map[string]string),
+	}
+	var filtered int
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		if isVisited {
+			ext.m.Lock()
+			filtered++
+			ext.m.Unlock()
+		}
+		return !isVisited
+	})
+
+	opts := NewOptions(ext)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.ExcludePatterns = []*regexp.Regexp{regexp.MustCompile(`/page3\.html$`)}
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	seeds := []string{"http://hosta/page1.html", "http://robotb/page1.html"}
+	c.Run(seeds)
+
+	// The Disallowed extender method is replaced by DisallowedReason
+	assertCallCount(spy, tc.name, eMKDisallowed, 0, t)
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+
+	ext.m.Lock()
+	defer ext.m.Unlock()
+	assertTrue(ext.reasons["http://robotb/page2.html"] == DisRobots, "expected robotb/page2 to be disallowed by robots.txt, got %v", ext.reasons)
+	assertTrue(ext.details["http://robotb/page2.html"] == "Disallow: /page2.html", "expected the robots.txt rule, got %q", ext.details["http://robotb/page2.html"])
+	assertTrue(ext.reasons["http://hosta/page3.html"] == DisPattern, "expected hosta/page3 to be disallowed by pattern, got %v", ext.reasons)
+	assertTrue(ext.reasons["http://hostb/page1.html"] == DisHostPolicy, "expected hostb/page1 to be disallowed by host policy, got %v", ext.reasons)
+	assertTrue(ext.details["http://hostb/page1.html"] == "hostb", "expected the host detail, got %q", ext.details["http://hostb/page1.html"])
+
+	// Each seed and harvested URL is either visited, filtered out or disallowed
+	total := len(seeds) + ext.harvested
+	got := ext.visited + filtered + ext.disallowed
+	assertTrue(total == got, "expected %d URLs accounted for, got %d (%d visited, %d filtered, %d disallowed)", total, got, ext.visited, filtered, ext.disallowed)
+}
+
+func testCrawlerTraps(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &disallowedReasonExtender{
+		spyExtender: spy,
+		reasons:     make(map[string]DisallowedKind),
+		details:     make(map[string]string),
+	}
+
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.MaxURLLength = 100
+	opts.MaxPathSegments = 6
+	opts.MaxRepeatedSegments = 3
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	// Each page links to itself with a deeper path, the guards end the branches
+	c.Run([]string{"http://hosth/loop/page.html", "http://hosth/cycle/page.html", "http://hosth/long/page.html"})
+
+	assertCallCount(spy, tc.name, eMKVisit, 9, t)
+	assertIsInLog(tc.name, spy.b, "summary: 9 visited, 9 enqueued, 3 disallowed (trap: 3)", t)
+	counts := c.DisallowedCounts()
+	assertTrue(counts[DisTrap] == 3 && len(counts) == 1, "expected 3 trap rejections, got %v", counts)
+
+	ext.m.Lock()
+	defer ext.m.Unlock()
+	want := map[string]string{
+		"http://hosth/loop/loop/loop/loop/page.html": `path segment "loop" repeated 4 times`,
+		"http://hosth/cycle/a/b/a/b/a/b/page.html":   "8 path segments",
+		"http://hosth/long/calendar-2020-01-01-to-2020-12-31/calendar-2020-01-01-to-2020-12-31/calendar-2020-01-01-to-2020-12-31/page.html": "URL length 129",
+	}
+	for u, detail := range want {
+		assertTrue(ext.reasons[u] == DisTrap, "expected %s to be disallowed as a trap, got %v", u, ext.reasons)
+		assertTrue(ext.details[u] == detail, "expected detail %q for %s, got %q", detail, u, ext.details[u])
+	}
+}
+
+func testSamePathPrefixOnly(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &disallowedReasonExtender{
+		spyExtender: spy,
+		reasons:     make(map[string]DisallowedKind),
+		details:     make(map[string]string),
+	}
+
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.SamePathPrefixOnly = true
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	// The seed's directory is /subdir/, page2 links to /page3.html
+	c.Run("http://hostd/subdir/page1.html")
+
+	assertCallCount(spy, tc.name, eMKVisit, 2, t)
+	assertIsInLog(tc.name, spy.b, "summary: 2 visited, 2 enqueued, 1 disallowed (scope: 1)", t)
+	ext.m.Lock()
+	u := "http://hostd/page3.html"
+	assertTrue(ext.reasons[u] == DisScope && ext.details[u] == "/page3.html", "expected %s to be out of scope, got %v %v", u, ext.reasons, ext.details)
+	ext.m.Unlock()
+
+	// An explicit prefix, on the seed's host only
+	spy = newSpy(newFileFetcher(), buf)
+	ext.spyExtender = spy
+	opts = NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.ScopePrefixes = []string{"http://hostd/"}
+	opts.LogFlags = LogAll
+	c = NewCrawlerWithOptions(opts)
+	c.Run("http://hostd/subdir/page1.html")
+
+	assertCallCount(spy, tc.name, eMKVisit, 3, t)
+	counts := c.DisallowedCounts()
+	assertTrue(counts[DisScope] == 1 && len(counts) == 1, "expected 1 scope rejection, got %v", counts)
+}
+
+type duplicateExtender struct {
+	*spyExtender
+	m     sync.Mutex
+	calls int
+	first map[string]*url.URL
+}
+
+func (x *duplicateExtender) Duplicate(ctx *URLContext, firstSource *url.URL) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.calls++
+	x.first[ctx.normalizedURL.String()] = firstSource
+}
+
+func testTrackDuplicates(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &duplicateExtender{spyExtender: spy, first: make(map[string]*url.URL)}
+	var visitedFilters int
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		if isVisited {
+			ext.m.Lock()
+			visitedFilters++
+			ext.m.Unlock()
+		}
+		return !isVisited
+	})
+
+	opts := NewOptions(ext)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	opts.TrackDuplicates = true
+	opts.TrackDuplicateSources = true
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+
+	assertCallCount(spy, tc.name, eMKVisit, 5, t)
+	counts, sources := c.DuplicateCounts(), c.DuplicateSources()
+	var total, nsources int
+	for u, n := range counts {
+		total += n
+		nsources += len(sources[u])
+	}
+	ext.m.Lock()
+	defer ext.m.Unlock()
+	// Each URL found again in the visited set is counted and notified
+	assertTrue(total > 0 && total == visitedFilters, "expected %d duplicates, got %d: %v", visitedFilters, total, counts)
+	assertTrue(ext.calls == total, "expected %d Duplicate calls, got %d", total, ext.calls)
+	assertTrue(nsources == total, "expected %d duplicate sources, got %d: %v", total, nsources, sources)
+	// page1 is a seed, page2 is first found on page1
+	assertTrue(ext.first["http://hosta/page2.html"] != nil && ext.first["http://hosta/page2.html"].String() == "http://hosta/page1.html",
+		"expected page1 as the first source of page2, got %v", ext.first["http://hosta/page2.html"])
+	if src, ok := ext.first["http://hosta/page1.html"]; ok {
+		assertTrue(src == nil, "expected no first source for the seed page1, got %v", src)
+	}
+	assertIsInLog(tc.name, spy.b, fmt.Sprintf(", %d duplicates (ratio ", total), t)
+
+	// Only the counts without the TrackDuplicateSources option
+	opts.TrackDuplicateSources = false
+	c = NewCrawlerWithOptions(opts)
+	c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+	assertTrue(len(c.DuplicateCounts()) > 0 && c.DuplicateSources() == nil, "expected counts without sources, got %v", c.DuplicateSources())
+}
+
+func testLogHostFilter(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogDelay | LogQueue
+	opts.LogHostFilter = func(host string) bool {
+		return host == "hosta"
+	}
+	c := NewCrawlerWithOptions(opts)
+	c.Run([]string{"http://hosta/page1.html", "http://hostb/page1.html"})
+
+	assertCallCount(spy, tc.name, eMKVisit, 5, t)
+	assertIsInLog(tc.name, spy.b, "crawl delay for hosta: ", t)
+	assertIsInLog(tc.name, spy.b, "for host hosta, queue length ", t)
+	// Nothing is logged for the filtered host
+	assertIsNotInLog(tc.name, spy.b, "hostb", t)
+}
+
+type dryRunExtender struct {
+	*spyExtender
+	m     sync.Mutex
+	would []string
+}
+
+func (x *dryRunExtender) WouldFetch(ctx *URLContext) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.would = append(x.would, ctx.url.String())
+}
+
+func testDryRun(t *testing.T, tc *testCase, buf bool) {
+	for _, skipRobots := range []bool{false, true} {
+		spy := newSpy(newFileFetcher(), buf)
+		ext := &dryRunExtender{spyExtender: spy}
+		opts := NewOptions(ext)
+		opts.CrawlDelay = DefaultTestCrawlDelay
+		opts.DryRun = true
+		opts.DryRunSkipRobots = skipRobots
+		opts.LogFlags = LogAll
+		c := NewCrawlerWithOptions(opts)
+		c.Run([]string{
+			"http://hosta/page1.html",
+			"http://hosta/page4.html",
+			"http://robota/page1.html",
+		})
+
+		// Only the robots.txt are fetched, and nothing is visited
+		spy.m.RLock()
+		for _, args := range spy.calledWith[eMKFetch] {
+			ctx := args[0].(*URLContext)
+			assertTrue(ctx.IsRobotsURL(), "expected only robots.txt fetches, got %s", ctx.url)
+		}
+		spy.m.RUnlock()
+		assertCallCount(spy, tc.name, eMKVisit, 0, t)
+		assertCallCount(spy, tc.name, eMKVisited, 0, t)
+		sort.Strings(ext.would)
+		if skipRobots {
+			assertCallCount(spy, tc.name, eMKFetch, 0, t)
+			assertTrue(len(ext.would) == 3, "expected 3 URLs that would be fetched, got %v", ext.would)
+			assertIsInLog(tc.name, spy.b, "summary: dry run, 3 visited", t)
+		} else {
+			// The robots.txt of robota disallows its page
+			assertCallCount(spy, tc.name, eMKFetch, 2, t)
+			assertTrue(strings.Join(ext.would, ",") == "http://hosta/page1.html,http://hosta/page4.html",
+				"expected the pages of hosta only, got %v", ext.would)
+			assertIsInLog(tc.name, spy.b, "summary: dry run, 2 visited", t)
+		}
+	}
+}
+
+func testVisitErrorPages(t *testing.T, tc *testCase, buf bool) {
+	bodies := map[string]string{
+		"/missing.html": `<html><body>Not found, see <a href="page2.html">page 2</a></body></html>`,
+		"/error.html":   `<html><body>Internal error, back to <a href="page1.html">page 1</a></body></html>`,
+	}
+	statuses := map[string]int{"/missing.html": 404, "/error.html": 500}
+
+	for _, visitErrors := range []bool{false, true} {
+		ff := newFileFetcher()
+		spy := newSpy(ff, buf)
+		spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, agent string, head bool) (*http.Response, error) {
+			code, ok := statuses[ctx.url.Path]
+			if !ok {
+				return ff.Fetch(ctx, agent, head)
+			}
+			req, _ := http.NewRequest("GET", ctx.url.String(), nil)
+			return &http.Response{
+				Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+				StatusCode: code,
+				Header:     http.Header{"Content-Type": {"text/html"}, "X-Request-Id": {ctx.url.Path}},
+				Body:       ioutil.NopCloser(strings.NewReader(bodies[ctx.url.Path])),
+				Request:    req,
+			}, nil
+		})
+		errs := make(map[string]*CrawlError)
+		spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+			if err.Kind == CekHttpStatusCode {
+				errs[err.Ctx.url.Path] = err
+			}
+		})
+
+		opts := NewOptions(spy)
+		opts.CrawlDelay = DefaultTestCrawlDelay
+		opts.VisitErrorPages = visitErrors
+		opts.LogFlags = LogAll
+		NewCrawlerWithOptions(opts).Run("http://hostj/page1.html")
+
+		// The errors carry the status code and the response
+		assertTrue(len(errs) == 2, "expected 2 status code errors, got %v", errs)
+		for p, code := range statuses {
+			err := errs[p]
+			if err == nil {
+				t.Errorf("expected a status code error for %s", p)
+				continue
+			}
+			assertTrue(err.StatusCode == code, "expected status code %d for %s, got %d", code, p, err.StatusCode)
+			assertTrue(err.Response != nil && err.Response.Header.Get("X-Request-Id") == p, "expected the response of %s, got %v", p, err.Response)
+			src := err.Ctx.SourceURL()
+			assertTrue(src != nil && src.String() == "http://hostj/page1.html", "expected the source of %s, got %v", p, src)
+		}
+
+		if visitErrors {
+			// The error pages are visited, and page2 is found on the 404 page
+			assertCallCount(spy, tc.name, eMKVisit, 4, t)
+			assertCallCount(spy, tc.name, eMKVisited, 4, t)
+			assertCallCount(spy, tc.name, eMKFetch, 5, t)
+		} else {
+			// Only page1 is visited, the error pages are still notified via
+			// Visited
+			assertCallCount(spy, tc.name, eMKVisit, 1, t)
+			assertCallCount(spy, tc.name, eMKVisited, 3, t)
+			assertCallCount(spy, tc.name, eMKFetch, 4, t)
+		}
+	}
+}
+
+func testURLValues(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		assertTrue(ctx.Value("tag") == nil, "expected no tag for %s before the Filter", ctx.url)
+		ctx.SetValue("tag", "filtered")
+		return !isVisited
+	})
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		assertTrue(ctx.Value("tag") == "filtered", "expected the tag of the Filter for %s, got %v", ctx.url, ctx.Value("tag"))
+		ctx.Values()["title"] = doc.Find("h1").Text()
+		return nil, true
+	})
+	var mu sync.Mutex
+	titles := make(map[string]interface{})
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		assertTrue(ctx.Value("tag") == "filtered", "expected the tag of the Filter for %s, got %v", ctx.url, ctx.Value("tag"))
+		assertTrue(ctx.State == nil, "expected no state for %s, got %v", ctx.url, ctx.State)
+		titles[ctx.url.Path] = ctx.Value("title")
+	})
+
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = DefaultTestCrawlDelay
+	opts.LogFlags = LogAll
+	NewCrawlerWithOptions(opts).Run("http://hosta/page1.html")
+
+	assertCallCount(spy, tc.name, eMKVisited, 3, t)
+	for p, title := range map[string]string{"/page1.html": "Page 1 Title", "/page2.html": "Page 2 Title", "/page3.html": "Page 3 Title"} {
+		assertTrue(titles[p] == title, "expected the title %q of the Visit for %s, got %v", title, p, titles[p])
+	}
+}
+
+func testMaxQueryVariantsPerPath(t *testing.T, tc *testCase, buf bool) {
+	spy := newSpy(newFileFetcher(), buf)
+	ext := &disallowedReasonExtender{
+		spyExtender: spy,
+		reasons:     make(map[string]DisallowedKind),
+		details:     make(map[string]string),
+	}
+
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.MaxQueryVariantsPerPath = 2
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	// The products pages link to a new variant each
+	c.Run("http://hostm/page1.html")
+
+	assertCallCount(spy, tc.name, eMKVisit, 4, t)
+	assertIsInLog(tc.name, spy.b, "summary: 4 visited, 4 enqueued, 4 disallowed (query variants: 4), top query variants: http://hostm/products 6 (4 rejected)\n", t)
+	counts := c.DisallowedCounts()
+	assertTrue(counts[DisQueryVariants] == 4 && len(counts) == 1, "expected 4 query variants rejections, got %v", counts)
+
+	ext.m.Lock()
+	defer ext.m.Unlock()
+	for _, q := range []string{"color=green", "color=red&size=m", "color=blue&sort=price"} {
+		u := "http://hostm/products?" + q
+		assertTrue(ext.reasons[u] == DisQueryVariants, "expected %s to be disallowed on query variants, got %v", u, ext.reasons)
+		assertTrue(ext.details[u] == q, "expected detail %q for %s, got %q", q, u, ext.details[u])
+	}
+}
+
+// Assert the ordering contract of the notifications: the Enqueued call of a
+// URL happens before its Visited call, which happens before the Enqueued
+// calls of the URLs harvested from it.
+func assertCallbacksOrder(spy *spyExtender, name string, t *testing.T) {
+	enqueued := make(map[string]int)
+	for _, c := range spy.getSequence(eMKEnqueued) {
+		if _, ok := enqueued[c.url]; !ok {
+			enqueued[c.url] = c.seq
+		}
+	}
+	visited := make(map[string]int)
+	for _, c := range spy.getSequence(eMKVisited) {
+		seq, ok := enqueued[c.url]
+		assertTrue(ok && seq < c.seq, "%s: expected %s to be enqueued before visited", name, c.url)
+		visited[c.url] = c.seq
+	}
+	for _, c := range spy.getSequence(eMKEnqueued) {
+		if c.source == "" {
+			continue
+		}
+		seq, ok := visited[c.source]
+		assertTrue(ok && seq < c.seq, "%s: expected %s to be visited before %s is enqueued", name, c.source, c.url)
+	}
+}
+
+func testCallbacksOrder(t *testing.T, tc *testCase, buf bool) {
+	seeds := []string{"http://hosta/page1.html", "http://hostb/page1.html", "http://hostd/subdir/page1.html"}
+
+	// Concurrent notifications, ordered per URL
+	spy := newSpy(newFileFetcher(), buf)
+	spy.setSequenced()
+	opts := NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	NewCrawlerWithOptions(opts).Run(seeds)
+
+	assertCallCount(spy, tc.name, eMKVisited, 8, t)
+	assertCallbacksOrder(spy, tc.name, t)
+
+	// Serialized notifications
+	spy = newSpy(newFileFetcher(), buf)
+	spy.setSequenced()
+	var active, overlaps int32
+	serialized := func() {
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&active, -1)
+	}
+	spy.setExtensionMethod(eMKEnqueued, func(ctx *URLContext) { serialized() })
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) { serialized() })
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) { serialized() })
+	var atEnd int
+	spy.setExtensionMethod(eMKEnd, func(err error) {
+		atEnd = spy.getCallCount(eMKVisited)
+	})
+	opts = NewOptions(spy)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = 0
+	opts.OrderedCallbacks = true
+	opts.LogFlags = LogAll
+	NewCrawlerWithOptions(opts).Run(seeds)
+
+	assertCallCount(spy, tc.name, eMKVisited, 8, t)
+	assertTrue(atomic.LoadInt32(&overlaps) == 0, "expected serialized notifications, got %d overlaps", overlaps)
+	assertTrue(atEnd == 8, "expected the notifications to complete before End, got %d Visited calls", atEnd)
+	assertCallbacksOrder(spy, tc.name, t)
+}
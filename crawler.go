@@ -2,39 +2,165 @@
 package gocrawl
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"reflect"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	robotstxt "github.com/temoto/robotstxt.go"
 )
 
 // Communication from worker to the master crawler, about the crawling of a URL
 type workerResponse struct {
-	ctx           *URLContext
-	visited       bool
-	harvestedURLs interface{}
-	host          string
-	idleDeath     bool
+	ctx       *URLContext
+	visited   bool
+	harvested []harvestedLink
+	host      string
+	idleDeath bool
+
+	// Set when the worker is parked or resumes, see the
+	// RobotsUnavailableRetries option. No URL is processed.
+	parked  bool
+	resumed bool
 }
 
 // Crawler is the web crawler that processes URLs and manages the workers.
+//
+// Several crawlers may run concurrently in the same process: each run owns
+// its HTTP client (a copy of the HTTPClient option, or of the package's
+// HttpClient, with its own transport if it is an *http.Transport), its
+// robots.txt data, its visited URLs, its frontier (a Frontier set in the
+// Options must not be shared by several running crawlers) and its workers,
+// and the package-level state is never modified by a run. The Extender of a
+// crawler must not be shared with another running crawler, since its
+// EnqueueChan field is set by Run. The methods of a Crawler (e.g. Enqueue and
+// Stop) are safe for concurrent use, but a Crawler runs one crawl at a time.
 type Crawler struct {
 	// Options configures the Crawler, refer to the Options type for documentation.
+	// Run crawls with a clone of the Options taken when it starts, so that
+	// modifying them during a run has no effect until the next run.
 	Options *Options
 
 	// Internal fields
 	logFunc         func(LogFlags, string, ...interface{})
+	eventFunc       func(LogFlags, string, map[string]interface{})
+	filterExt       FilterExtender
+	edgeExt         EdgeExtender
+	hostExt         HostExtender
+	visitedExt      VisitedExtender
+	hostCompExt     HostCompleteExtender
+	disExt          DisallowedExtender
+	robotsExt       RobotsInfoExtender
+	authExt         AuthExtender
+	dupExt          DuplicateExtender
+	dryRunExt       DryRunExtender
+	beforeFetchExt  BeforeFetchExtender
+	visitCtxExt     VisitCtxExtender
 	push            chan *workerResponse
 	enqueue         chan interface{}
+	seeds           chan *seedResult
+	visitJobs       chan *visitJob
 	stop            chan struct{}
+	stopCtx         context.Context
+	limiter         RateLimiter
+	throttle        *byteThrottle
+	bytesRead       *int64
+	drained         *int32
+	robots          *robotsGroups
+	ancestry        *ancestry
+	dups            *duplicates
+	callbacks       *dispatcher
+	queries         *queryVariants
+	scope           *crawlScope
+	harvestScope    goquery.Matcher
+	events          *eventStream
+	attempts        *fetchAttempts
+	authRetries     *fetchAttempts
+	warc            *warcWriter
+	cache           *responseCache
+	har             *harRecorder
+	report          *reporter
+	frontier        Frontier
+	client          *http.Client
+	visitors        []*visitor
 	wg              *sync.WaitGroup
 	pushPopRefCount int
+	progress        *progress
+	inFlight        *inFlight
+	lastFetches     *lastFetches
+	errBudget       *errorBudget
 	visits          int
 
+	// URLs enqueued via the Enqueue method, protected by enqMu as it may be
+	// called from any goroutine. The signal channel notifies the crawler that
+	// URLs are pending. The inRun flag is set for the whole Run, while it
+	// crawls with a clone of the Options.
+	enqMu      sync.Mutex
+	running    bool
+	inRun      bool
+	enqPending []*URLContext
+	enqSignal  chan struct{}
+
+	// Options of the current run, a clone of the Options, guarded by optsMu
+	// as they may be read from any goroutine (see opts)
+	optsMu  sync.RWMutex
+	runOpts *Options
+
 	// keep lookups in maps, O(1) access time vs O(n) for slice. The empty struct value
 	// is of no use, but this is the smallest type possible - it uses no memory at all.
-	visited map[string]struct{}
-	hosts   map[string]struct{}
-	workers map[string]*worker
+	// The visited map is only modified by the crawler's goroutine, with visitedMu
+	// locked so that it can be queried from any goroutine.
+	visitedMu sync.RWMutex
+	visited   map[string]time.Time
+	imported  bool
+	hosts     map[string]struct{}
+	workers   map[string]*worker
+
+	// The number of contexts of each normalized URL accepted and not
+	// processed yet, i.e. waiting in the queues or being fetched. It is only
+	// used by the crawler's goroutine.
+	pending map[string]int
+
+	// Hosts whose worker is parked, it does not hold a slot of
+	// MaxConcurrentHosts meanwhile.
+	parked map[string]struct{}
+
+	// URLs of the hosts waiting for a worker slot when MaxConcurrentHosts
+	// is reached, and the order in which the hosts get a slot.
+	waiting      map[string][]*URLContext
+	waitingHosts []string
+
+	// URLs blocked on full queues with the BlockOnFull policy, in order,
+	// and their count by host, shared with the workers
+	blocked      []*blockedURLs
+	blockedHosts *blockedHosts
+
+	// Turns of the Deterministic mode: the worker of the current turn, if
+	// any, the host of the last turn, the signal of the end of a turn and
+	// the responses and enqueued values received during the turn.
+	turn        *worker
+	lastTurn    string
+	turnDone    chan struct{}
+	turnPush    []*workerResponse
+	turnEnqueue []interface{}
+
+	// Edges of the crawl graph when RecordEdges is set, and the index of
+	// each edge by its from and to URLs to deduplicate them.
+	edges     []Edge
+	edgeIndex map[[2]string]int
+}
+
+// URLs blocked on full queues, and the paused worker that harvested them.
+type blockedURLs struct {
+	src  *worker
+	ctxs []*URLContext
 }
 
 // NewCrawlerWithOptions returns a Crawler initialized with the
@@ -42,6 +168,7 @@ type Crawler struct {
 func NewCrawlerWithOptions(opts *Options) *Crawler {
 	ret := new(Crawler)
 	ret.Options = opts
+	ret.progress = newProgress()
 	return ret
 }
 
@@ -54,26 +181,206 @@ func NewCrawler(ext Extender) *Crawler {
 	return NewCrawlerWithOptions(NewOptions(ext))
 }
 
+// SetOptions replaces the Options of the Crawler. It returns an error if the
+// crawler is running or if the Options are nil. The Options are validated
+// when Run is called.
+func (c *Crawler) SetOptions(opts *Options) error {
+	if opts == nil {
+		return errors.New("nil options")
+	}
+	c.enqMu.Lock()
+	defer c.enqMu.Unlock()
+	if c.inRun {
+		return errors.New("cannot set the options while the crawler is running")
+	}
+	c.Options = opts
+	return nil
+}
+
+// Return the Options of the current run, i.e. the clone of the Options taken
+// when it started, or the Options outside of a run.
+func (c *Crawler) opts() *Options {
+	c.optsMu.RLock()
+	defer c.optsMu.RUnlock()
+	if c.runOpts != nil {
+		return c.runOpts
+	}
+	return c.Options
+}
+
 // Run starts the crawling process, based on the given seeds and the current
 // Options settings. Execution stops either when MaxVisits is reached (if specified)
 // or when no more URLs need visiting. If an error occurs, it is returned (if
-// MaxVisits is reached, the error ErrMaxVisits is returned).
+// MaxVisits is reached, the error ErrMaxVisits is returned). If the Options are
+// invalid (see Options.Validate), an *OptionsError is returned and no extender
+// method is called. The seeds may also be a SeedProvider, whose seeds are
+// pulled as the workers have capacity during the crawl.
+//
+// The seeds are best passed as an EnqueueItem or a []EnqueueItem, to attach
+// their State and per-URL settings. The legacy forms are normalized as
+// items: a string, []string, *url.URL or []*url.URL as items with only the
+// URL, and a map[string]interface{}, map[*url.URL]interface{}, S or U as
+// items with the URL and its State. Their other settings have the zero value
+// of the EnqueueItem, i.e. the HeadBeforeGet of the Options (or of the
+// PerHost override) and a zero Priority. A *URLContext or []*URLContext
+// (e.g. from Drain) is used as is.
 func (c *Crawler) Run(seeds interface{}) error {
+	// Crawl with a clone of the Options, so that the caller's Options may be
+	// modified without racing with the workers.
+	c.enqMu.Lock()
+	opts := c.Options.Clone()
+	c.optsMu.Lock()
+	c.runOpts = opts
+	c.optsMu.Unlock()
+	c.inRun = true
+	if opts.EmitEvents && c.events == nil {
+		c.events = newEventStream(opts.EventBuffer)
+	}
+	c.enqMu.Unlock()
+	defer func() {
+		c.enqMu.Lock()
+		c.optsMu.Lock()
+		c.runOpts = nil
+		c.optsMu.Unlock()
+		c.inRun = false
+		c.enqMu.Unlock()
+	}()
+
+	// Invalid options fail before anything else, the Extender may be nil
+	if err := c.opts().Validate(); err != nil {
+		c.endEvents(err)
+		return err
+	}
+
 	// Helper log function, takes care of filtering based on level
-	c.logFunc = getLogFunc(c.Options.Extender, c.Options.LogFlags, -1)
+	c.logFunc = getLogFunc(c.opts(), -1, "")
+	c.eventFunc = getEventFunc(c.opts(), -1)
+	for _, warn := range c.opts().warnings() {
+		c.logFunc(LogError, "WARNING: %s", warn)
+	}
 
-	seeds = c.Options.Extender.Start(seeds)
+	// Use the richer FilterURL method if the extender implements it
+	c.filterExt, _ = c.opts().Extender.(FilterExtender)
+	c.edgeExt, _ = c.opts().Extender.(EdgeExtender)
+	c.hostExt, _ = c.opts().Extender.(HostExtender)
+	c.visitedExt, _ = c.opts().Extender.(VisitedExtender)
+	c.hostCompExt, _ = c.opts().Extender.(HostCompleteExtender)
+	c.disExt, _ = c.opts().Extender.(DisallowedExtender)
+	c.robotsExt, _ = c.opts().Extender.(RobotsInfoExtender)
+	c.authExt, _ = c.opts().Extender.(AuthExtender)
+	c.dupExt, _ = c.opts().Extender.(DuplicateExtender)
+	c.dryRunExt, _ = c.opts().Extender.(DryRunExtender)
+	c.beforeFetchExt, _ = c.opts().Extender.(BeforeFetchExtender)
+	c.visitCtxExt, _ = c.opts().Extender.(VisitCtxExtender)
+
+	seeds = c.opts().Extender.Start(seeds)
+	sp, _ := seeds.(SeedProvider)
+	if sp != nil {
+		// The seeds are pulled from the provider during the crawl
+		seeds = nil
+	}
 	ctxs := c.toURLContexts(seeds, nil)
+	for _, ctx := range ctxs {
+		ctx.seed = true
+	}
 	c.init(ctxs)
+	c.seeds = nil
+	if sp != nil {
+		c.seeds = make(chan *seedResult)
+		go runSeedProvider(sp, c.seeds, c.stop)
+	}
 
 	// Start with the seeds, and loop till death
-	c.enqueueUrls(ctxs)
+	c.enqueueUrls(ctxs, nil, nil)
 	err := c.collectUrls()
 
-	c.Options.Extender.End(err)
+	// Write the HAR entries not flushed yet
+	if c.har != nil {
+		if e := c.har.flush(false); e != nil {
+			c.notifyError(newCrawlError(nil, e, CekWriteHAR))
+			c.logFunc(LogError, "ERROR writing HAR: %s", e)
+		}
+	}
+	// Complete the crawl report
+	if e := c.report.close(); e != nil {
+		c.notifyError(newCrawlError(nil, e, CekWriteReport))
+		c.logFunc(LogError, "ERROR writing report: %s", e)
+	}
+	// Complete the notifications before the End
+	c.callbacks.close()
+	c.opts().Extender.End(err)
+	c.endEvents(err)
 	return err
 }
 
+// RobotsFor returns the robots.txt group that applies to the host (the
+// normalized form of the URL's host), and whether the robots.txt of the host
+// has been processed. A host without robots.txt policies gets a group that
+// allows all URLs. It is safe to call it during the crawl, e.g. from an
+// extender method. The Test method of the group does not apply the RFC 9309
+// precedence of the crawler to the rules with an Allow or a wildcard, so it
+// may disagree with the crawler's decisions: use RobotsAllowed to test a
+// path.
+func (c *Crawler) RobotsFor(host string) (*robotstxt.Group, bool) {
+	if c.robots == nil {
+		return nil, false
+	}
+	return c.robots.get(host)
+}
+
+// RobotsAllowed indicates if the path is allowed by the robots.txt of the
+// host (the normalized form of the URL's host), with the same rules as the
+// crawler, and whether the robots.txt of the host has been processed. The
+// paths of a host whose robots.txt has not been processed are allowed. It is
+// safe to call it during the crawl, e.g. from an extender method.
+func (c *Crawler) RobotsAllowed(host, path string) (bool, bool) {
+	if c.robots == nil {
+		return true, false
+	}
+	return c.robots.allowed(host, path)
+}
+
+// FlushHAR writes the requests and responses recorded since the last flush
+// as a HAR document to the Options' HARWriter, if set. It is safe to call it
+// during the crawl, the remaining entries are written when the crawl ends.
+func (c *Crawler) FlushHAR() error {
+	if c.har == nil {
+		return nil
+	}
+	return c.har.flush(true)
+}
+
+// BytesRead returns the number of bytes read from the response bodies since
+// the start of the crawl, or of the last crawl if it is done. It is safe to
+// call it during the crawl.
+func (c *Crawler) BytesRead() int64 {
+	if c.bytesRead == nil {
+		return 0
+	}
+	return atomic.LoadInt64(c.bytesRead)
+}
+
+// PathTo returns the chain of URLs in normalized form that led to the URL,
+// from the seed to the URL itself, if the Options' TrackAncestry is set. It
+// returns nil if the URL was not enqueued during the last crawl. It is
+// meant to be called once Run has returned.
+func (c *Crawler) PathTo(u *url.URL) []*url.URL {
+	if c.ancestry == nil || u == nil {
+		return nil
+	}
+	// Copy the URL, as the normalization alters it
+	cp := *u
+	nu, err := normalizeURL(&cp, c.opts())
+	if err != nil {
+		return nil
+	}
+	res, ok := c.ancestry.chain(nu)
+	if !ok {
+		return nil
+	}
+	return append(res, nu)
+}
+
 // Initialize the Crawler's internal fields before a crawling execution.
 func (c *Crawler) init(ctxs []*URLContext) {
 	// Initialize the internal hosts map
@@ -89,28 +396,155 @@ func (c *Crawler) init(ctxs []*URLContext) {
 	l := len(ctxs)
 	c.logFunc(LogTrace, "init() - seeds length: %d", l)
 	c.logFunc(LogTrace, "init() - host count: %d", hostCount)
-	c.logFunc(LogInfo, "robot user-agent: %s", c.Options.RobotUserAgent)
+	c.logFunc(LogInfo, "robot user-agent: %s", c.opts().RobotUserAgent)
+	if c.opts().ignoresRobots() {
+		c.logFunc(LogInfo, "robots.txt handling is disabled, all URLs are allowed")
+	}
+	if c.opts().DryRun {
+		c.logFunc(LogInfo, "dry run, the pages are not fetched")
+	}
 
 	// Create a shiny new WaitGroup
 	c.wg = new(sync.WaitGroup)
 
 	// Initialize the visits fields
-	c.visited = make(map[string]struct{}, l)
+	c.visitedMu.Lock()
+	if c.visited == nil || (c.opts().RevisitAfter <= 0 && !c.imported) {
+		// The visited URLs are kept across runs only if they can be revisited,
+		// or if they were imported for this run
+		c.visited = make(map[string]time.Time, l)
+	}
+	c.imported = false
+	c.visitedMu.Unlock()
+	c.pending = make(map[string]int, l)
 	c.pushPopRefCount, c.visits = 0, 0
+	if c.progress == nil {
+		c.progress = newProgress()
+	}
+	c.progress.reset()
+	c.inFlight = newInFlight()
+	// The last fetches are kept across runs
+	if c.lastFetches == nil {
+		c.lastFetches = newLastFetches()
+	}
 
 	// Create the workers map and the push channel (the channel used by workers
 	// to communicate back to the crawler)
 	c.stop = make(chan struct{})
-	if c.Options.SameHostOnly {
+	if c.opts().SameHostOnly {
 		c.workers, c.push = make(map[string]*worker, hostCount),
 			make(chan *workerResponse, hostCount)
 	} else {
-		c.workers, c.push = make(map[string]*worker, c.Options.HostBufferFactor*hostCount),
-			make(chan *workerResponse, c.Options.HostBufferFactor*hostCount)
+		c.workers, c.push = make(map[string]*worker, c.opts().HostBufferFactor*hostCount),
+			make(chan *workerResponse, c.opts().HostBufferFactor*hostCount)
+	}
+	c.waiting, c.waitingHosts = make(map[string][]*URLContext), nil
+	c.parked = make(map[string]struct{})
+	c.blocked, c.blockedHosts = nil, newBlockedHosts()
+	c.turn, c.lastTurn, c.turnDone = nil, "", nil
+	c.turnPush, c.turnEnqueue = nil, nil
+	if c.opts().Deterministic {
+		c.turnDone = make(chan struct{}, 1)
+	}
+	c.edges, c.edgeIndex = nil, make(map[[2]string]int)
+	c.robots = newRobotsGroups()
+	c.attempts = newFetchAttempts()
+	c.authRetries = newFetchAttempts()
+	c.warc = nil
+	if c.opts().WARCWriter != nil {
+		c.warc = newWARCWriter(c.opts().WARCWriter, c.opts().WARCGzip)
+	}
+	c.ancestry = nil
+	if c.opts().TrackAncestry {
+		c.ancestry = newAncestry()
+	}
+	c.cache = nil
+	if c.opts().ResponseCacheBytes > 0 {
+		c.cache = newResponseCache(c.opts().ResponseCacheBytes)
+	}
+	c.callbacks = nil
+	if c.opts().OrderedCallbacks {
+		c.callbacks = newDispatcher()
+	}
+	c.queries = nil
+	if c.opts().MaxQueryVariantsPerPath > 0 {
+		c.queries = newQueryVariants(c.opts().MaxQueryVariantsPerPath)
+	}
+	c.dups = nil
+	if c.opts().TrackDuplicates {
+		c.dups = newDuplicates(c.opts().TrackDuplicateSources)
+	}
+	c.scope = newCrawlScope(c.opts())
+	c.harvestScope, _ = compileHarvestSelector(c.opts().HarvestSelector)
+	if c.scope != nil {
+		for _, ctx := range ctxs {
+			c.scope.addSeed(ctx)
+		}
+	}
+	c.har = nil
+	if c.opts().HARWriter != nil {
+		c.har = newHARRecorder(c.opts().HARWriter, c.opts().HARMaxBodySize)
+	}
+	c.report = nil
+	if c.opts().ReportWriter != nil {
+		c.report = newReporter(c.opts().ReportWriter, c.opts().ReportFormat)
+	}
+	c.client = newHTTPClient(c.opts(), c.har)
+	c.frontier = c.opts().Frontier
+	if c.frontier == nil {
+		c.frontier = NewMemoryFrontier(c.opts().Ordering)
+	}
+
+	// Set the global rate limiter, if requested, and the context that cancels
+	// its waits when the crawler stops.
+	c.limiter, c.stopCtx = c.opts().RateLimiter, nil
+	if c.limiter == nil && c.opts().MaxRequestsPerSecond > 0 {
+		c.limiter = NewRateLimiter(c.opts().MaxRequestsPerSecond)
+	}
+	if c.limiter != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func(stop <-chan struct{}) {
+			<-stop
+			cancel()
+		}(c.stop)
+		c.stopCtx = ctx
+	}
+
+	// Set the global bandwidth throttle, if requested
+	c.throttle = nil
+	if c.opts().MaxBytesPerSecond > 0 {
+		c.throttle = newByteThrottle(c.opts().MaxBytesPerSecond)
+	}
+
+	// Count the bytes read, shared by all workers
+	c.bytesRead = new(int64)
+	c.drained = new(int32)
+
+	// Count the fetch errors, if requested
+	c.errBudget = nil
+	if c.opts().MaxErrors > 0 || c.opts().MaxConsecutiveErrors > 0 {
+		c.errBudget = new(errorBudget)
+	}
+
+	// Start the visitor pool, if requested
+	c.visitJobs = nil
+	if n := c.opts().VisitWorkers; n > 0 && !c.opts().Deterministic {
+		c.visitJobs = make(chan *visitJob)
+		c.wg.Add(n)
+		for i := 0; i < n; i++ {
+			go runVisitor(c.visitJobs, c.stop, c.wg)
+		}
+		c.logFunc(LogInfo, "%d visitors launched", n)
 	}
+
 	// Create and pass the enqueue channel
-	c.enqueue = make(chan interface{}, c.Options.EnqueueChanBuffer)
+	c.enqueue = make(chan interface{}, c.opts().EnqueueChanBuffer)
 	c.setExtenderEnqueueChan()
+
+	// Accept the URLs enqueued via the Enqueue method
+	c.enqMu.Lock()
+	c.running, c.enqPending, c.enqSignal = true, nil, make(chan struct{}, 1)
+	c.enqMu.Unlock()
 }
 
 // Set the Enqueue channel on the extender, based on the naming convention.
@@ -126,7 +560,7 @@ func (c *Crawler) setExtenderEnqueueChan() {
 	// Using reflection, check if the extender has a `EnqueueChan` field
 	// of type `chan<- interface{}`. If it does, set it to the crawler's
 	// enqueue channel.
-	v := reflect.ValueOf(c.Options.Extender)
+	v := reflect.ValueOf(c.opts().Extender)
 	el := v.Elem()
 	if el.Kind() != reflect.Struct {
 		c.logFunc(LogInfo, "extender is not a struct, cannot set the enqueue channel")
@@ -153,34 +587,186 @@ func (c *Crawler) setExtenderEnqueueChan() {
 
 // Launch a new worker goroutine for a given host.
 func (c *Crawler) launchWorker(ctx *URLContext) *worker {
-	// Initialize index and channels
+	// Initialize index, queue and channels
 	i := len(c.workers) + 1
-	pop := newPopChannel()
+	host := ctx.normalizedURL.Host
+	pop := newHostQueue(host, c.frontier)
+	pop.ancestry = c.ancestry
+
+	// Resolve the options of the host
+	ho := c.opts().hostOptions(host)
+	userAgent, robotUserAgent, crawlDelay := c.opts().UserAgent, c.opts().RobotUserAgent, c.opts().CrawlDelay
+	if ho.UserAgent != "" {
+		userAgent = ho.UserAgent
+	}
+	if ho.RobotUserAgent != "" {
+		robotUserAgent = ho.RobotUserAgent
+	}
+	if ho.CrawlDelay != nil {
+		crawlDelay = *ho.CrawlDelay
+	}
+	robotsStatus := RobotsNotFetched
+	if c.opts().ignoresRobots() {
+		robotsStatus = RobotsIgnored
+	}
 
 	// Create the worker
 	w := &worker{
-		host:    ctx.normalizedURL.Host,
-		index:   i,
-		push:    c.push,
-		pop:     pop,
-		stop:    c.stop,
-		enqueue: c.enqueue,
-		wg:      c.wg,
-		logFunc: getLogFunc(c.Options.Extender, c.Options.LogFlags, i),
-		opts:    c.Options,
+		host:           host,
+		index:          i,
+		push:           c.push,
+		pop:            pop,
+		stop:           c.stop,
+		retire:         make(chan struct{}),
+		enqueue:        c.enqueue,
+		visits:         c.visitJobs,
+		limiter:        c.limiter,
+		stopCtx:        c.stopCtx,
+		throttle:       c.throttle,
+		bytesRead:      c.bytesRead,
+		robots:         c.robots,
+		attempts:       c.attempts,
+		warc:           c.warc,
+		inFlight:       c.inFlight,
+		lastFetches:    c.lastFetches,
+		errBudget:      c.errBudget,
+		client:         c.client,
+		visitors:       c.visitors,
+		hostExt:        c.hostExt,
+		visitedExt:     c.visitedExt,
+		hostCompExt:    c.hostCompExt,
+		disExt:         c.disExt,
+		robotsExt:      c.robotsExt,
+		authExt:        c.authExt,
+		authRetries:    c.authRetries,
+		dryRunExt:      c.dryRunExt,
+		beforeFetchExt: c.beforeFetchExt,
+		visitCtxExt:    c.visitCtxExt,
+		harvestScope:   c.harvestScope,
+		cache:          c.cache,
+		report:         c.report,
+		callbacks:      c.callbacks,
+		stats:          newHostStats(robotsStatus, c.opts().hostTags(host)),
+		blockedHosts:   c.blockedHosts,
+		drained:        c.drained,
+		progress:       c.progress,
+		events:         c.events,
+		userAgent:      userAgent,
+		robotUserAgent: robotUserAgent,
+		crawlDelay:     crawlDelay,
+		maxVisits:      ho.MaxVisitsPerHost,
+		windows:        c.opts().crawlWindows(host),
+		tags:           c.opts().hostTags(host),
+		wg:             c.wg,
+		logFunc:        getLogFunc(c.opts(), i, host),
+		eventFunc:      getEventFunc(c.opts(), i),
+		opts:           c.opts(),
+		clock:          c.opts().getClock(),
+	}
+	if c.turnDone != nil {
+		w.turn, w.turnDone = make(chan struct{}, 1), c.turnDone
+	} else if n := c.opts().FetchersPerHost; n > 1 {
+		w.fetchers = make(chan struct{}, n)
+	}
+
+	// Wait for the remaining of the crawl delay of the host's last fetch, if
+	// it was fetched recently (i.e. in the previous run).
+	if t, ok := c.lastFetches.get(host); ok {
+		if age := w.clock.Now().Sub(t); crawlDelay-age > 0 {
+			w.startDelay(crawlDelay - age)
+			c.logFunc(LogTrace, "host %s fetched %v ago, waiting %v", host, age, crawlDelay-age)
+		}
 	}
 
 	// Increment wait group count
 	c.wg.Add(1)
 
+	// Notify the host start before any other extender call for this host
+	if c.hostExt != nil {
+		c.hostExt.HostStarted(w.host)
+	}
+	c.logFunc(LogTrace, "host %s started", w.host)
+	c.events.emit(CrawlEvent{Kind: CevHostStarted, Host: w.host, Tags: w.tags})
+
 	// Launch worker
 	go w.run()
 	c.logFunc(LogInfo, "worker %d launched for host %s", i, w.host)
+	c.eventFunc(LogInfo, EventWorkerStart, withTags(map[string]interface{}{"host": w.host, "worker": i}, w.tags))
 	c.workers[w.host] = w
+	c.progress.setQueue(w.host, pop)
 
 	return w
 }
 
+// Launch a new worker for the host of the URL context, and return it along
+// with the robots.txt URL to push first in line, unless robots.txt handling
+// is disabled.
+func (c *Crawler) startHost(ctx *URLContext) (*worker, []*URLContext) {
+	w := c.launchWorker(ctx)
+	if c.opts().ignoresRobots() {
+		return w, nil
+	}
+	// Automatically enqueue the robots.txt URL as first in line
+	robCtx, e := ctx.getRobotsURLCtx(w.tags)
+	if e != nil {
+		c.notifyError(newCrawlError(ctx, e, CekParseRobots))
+		c.logFunc(LogError, "ERROR parsing robots.txt from %s: %s", ctx.normalizedURL, e)
+		return w, nil
+	}
+	c.logFunc(LogEnqueued, "enqueue: %s", robCtx.url)
+	c.eventFunc(LogEnqueued, EventEnqueue, urlFields(robCtx))
+	c.enqueued(robCtx)
+	return w, []*URLContext{robCtx}
+}
+
+// Indicates if a new worker can be launched, based on the MaxConcurrentHosts
+// option.
+func (c *Crawler) hasWorkerSlot() bool {
+	return c.opts().MaxConcurrentHosts <= 0 || len(c.workers)-len(c.parked) < c.opts().MaxConcurrentHosts
+}
+
+// Launch the workers for the hosts waiting for a slot, as long as there are
+// slots available.
+func (c *Crawler) launchWaitingHosts() {
+	for len(c.waitingHosts) > 0 && c.hasWorkerSlot() {
+		host := c.waitingHosts[0]
+		c.waitingHosts = c.waitingHosts[1:]
+		ctxs := c.waiting[host]
+		delete(c.waiting, host)
+		c.progress.setWaiting(host, 0)
+
+		w, batch := c.startHost(ctxs[0])
+		w.queued += len(ctxs)
+		c.pushURLs(w, append(batch, ctxs...))
+	}
+}
+
+// Retire the worker if it has no more URLs to process and other hosts are
+// waiting for its slot.
+func (c *Crawler) retireIdleWorker(host string) {
+	w, ok := c.workers[host]
+	if !ok || w.queued > 0 || len(c.waitingHosts) == 0 {
+		return
+	}
+	close(w.retire)
+	delete(c.workers, host)
+	c.logFunc(LogInfo, "worker for host %s retired to free a slot", host)
+	c.launchWaitingHosts()
+}
+
+// Indicates if the URL was already visited, based on the RevisitAfter option.
+// An URL still pending is visited, whatever the RevisitAfter option. The
+// time of the previous visit, if any, is set on the URL context.
+func (c *Crawler) isVisited(ctx *URLContext) bool {
+	key := ctx.normalizedURL.String()
+	at, ok := c.visited[key]
+	if !ok {
+		return false
+	}
+	ctx.visitedAt = at
+	return c.pending[key] > 0 || c.opts().RevisitAfter <= 0 || c.opts().getClock().Now().Sub(at) < c.opts().RevisitAfter
+}
+
 // Check if the specified URL is from the same host as its source URL, or if
 // nil, from the same host as one of the seed URLs.
 func (c *Crawler) isSameHost(ctx *URLContext) bool {
@@ -194,93 +780,534 @@ func (c *Crawler) isSameHost(ctx *URLContext) bool {
 	return ok
 }
 
+// Call the FilterURL extender method if available, applying the per-URL
+// overrides of the result, otherwise call the Filter extender method.
+func (c *Crawler) filterURL(ctx *URLContext, isVisited bool) bool {
+	var res FilterResult
+
+	// A panic in the filter rejects the URL
+	if c.filterExt == nil {
+		callExtender(c.opts(), ctx, "Filter", c.notifyError, func() {
+			res.Allow = c.opts().Extender.Filter(ctx, isVisited)
+		})
+		return res.Allow
+	}
+	callExtender(c.opts(), ctx, "FilterURL", c.notifyError, func() {
+		res = c.filterExt.FilterURL(ctx, isVisited)
+	})
+	if res.Allow {
+		if res.HeadBeforeGet != nil {
+			ctx.HeadBeforeGet = *res.HeadBeforeGet
+		}
+		if res.HeadOnly != nil {
+			ctx.HeadOnly = *res.HeadOnly
+		}
+		if res.FetchMode != nil {
+			ctx.FetchMode = *res.FetchMode
+		}
+		if res.Priority != 0 {
+			ctx.priority = res.Priority
+		}
+		if res.DelayOverride != nil {
+			ctx.delayOverride = res.DelayOverride
+		}
+	}
+	return res.Allow
+}
+
+// Check the URL against the include and exclude patterns, and return the
+// reason why it is rejected, or an empty string if it is accepted.
+func (c *Crawler) patternPolicy(u *url.URL) string {
+	s := u.String()
+	for i, rx := range c.opts().ExcludePatterns {
+		if rx.MatchString(s) {
+			return fmt.Sprintf("excluded by pattern %d", i)
+		}
+	}
+	if len(c.opts().IncludePatterns) == 0 {
+		return ""
+	}
+	for _, rx := range c.opts().IncludePatterns {
+		if rx.MatchString(s) {
+			return ""
+		}
+	}
+	return "not included by any pattern"
+}
+
 // Enqueue the URLs returned from the worker, as long as it complies with the
-// selection policies.
-func (c *Crawler) enqueueUrls(ctxs []*URLContext) (cnt int) {
+// selection policies. If the URLs were harvested from a page, from is its
+// URL context and src is its worker.
+func (c *Crawler) enqueueUrls(ctxs []*URLContext, from *URLContext, src *worker) (cnt int) {
+	// The URLs are pushed to the workers' queues once all URLs are processed, so
+	// that the ordering policy applies to the whole batch.
+	batches := make(map[*worker][]*URLContext)
+	// URLs blocked on full queues, with the BlockOnFull policy
+	var overflow []*URLContext
+	// Links of the crawl graph, if requested
+	var edges []*pendingEdge
+	trackEdges := from != nil && (c.edgeExt != nil || c.opts().RecordEdges)
+
 	for _, ctx := range ctxs {
-		var isVisited, enqueue bool
+		var enqueue bool
+		var edge *pendingEdge
+
+		ctx.ancestry = c.ancestry
 
 		// Cannot directly enqueue a robots.txt URL, since it is managed as a special case
 		// in the worker (doesn't return a response to crawler).
 		if ctx.IsRobotsURL() {
 			continue
 		}
+		// Drop URLs with a disallowed scheme before they reach the Filter.
+		if ctx.normalizedURL.IsAbs() && !isAllowedScheme(c.opts().AllowedSchemes, ctx.normalizedURL.Scheme) {
+			if ctx.sourceURL == nil {
+				// A seed or an explicitly enqueued URL, warn the caller.
+				c.logFunc(LogError, "WARNING disallowed scheme: %s", ctx.normalizedURL)
+			} else {
+				c.logFunc(LogIgnored, "ignore on scheme policy: %s", ctx.normalizedURL)
+			}
+			c.disallowed(ctx, DisScheme, ctx.normalizedURL.Scheme)
+			continue
+		}
+		if trackEdges && ctx.normalizedURL.IsAbs() && (!c.opts().SameHostOnly || c.isSameHost(ctx)) {
+			edge = &pendingEdge{ctx, false}
+			edges = append(edges, edge)
+		}
+		// Apply the include and exclude patterns before the Filter.
+		if reason := c.patternPolicy(ctx.normalizedURL); reason != "" {
+			c.logFunc(LogIgnored, "ignore on pattern policy: %s", ctx.normalizedURL)
+			c.logFunc(LogTrace, "%s: %s", reason, ctx.normalizedURL)
+			c.disallowed(ctx, DisPattern, reason)
+			continue
+		}
+		// Keep the URLs under the path prefixes of the crawl scope.
+		if c.scope != nil && ctx.normalizedURL.IsAbs() && !c.scope.contains(ctx.normalizedURL) {
+			c.logFunc(LogIgnored, "ignore on scope policy: %s", ctx.normalizedURL)
+			c.disallowed(ctx, DisScope, ctx.normalizedURL.EscapedPath())
+			continue
+		}
+		// Reject the URLs that look like crawler traps.
+		if reason := trapPolicy(c.opts(), ctx.normalizedURL); reason != "" {
+			c.logFunc(LogIgnored, "ignore on trap policy (%s): %s", reason, ctx.normalizedURL)
+			c.disallowed(ctx, DisTrap, reason)
+			continue
+		}
+		// Reject the new query strings of the paths with too many variants.
+		if c.queries != nil && !c.queries.allows(ctx.normalizedURL) {
+			c.logFunc(LogIgnored, "ignore on query variants policy: %s", ctx.normalizedURL)
+			c.disallowed(ctx, DisQueryVariants, ctx.normalizedURL.RawQuery)
+			continue
+		}
+		// Stop following the next pages beyond the pagination depth.
+		if max := c.opts().MaxPaginationDepth; max > 0 && ctx.paginationDepth > max {
+			c.logFunc(LogIgnored, "ignore on pagination depth policy: %s", ctx.normalizedURL)
+			c.disallowed(ctx, DisMaxDepth, strconv.Itoa(ctx.paginationDepth))
+			continue
+		}
 		// Check if it has been visited before, using the normalized URL
-		_, isVisited = c.visited[ctx.normalizedURL.String()]
+		isVisited := c.isVisited(ctx)
+		if isVisited && c.dups != nil {
+			c.duplicate(ctx)
+		}
 
 		// Filter the URL
-		if enqueue = c.Options.Extender.Filter(ctx, isVisited); !enqueue {
+		if enqueue = c.filterURL(ctx, isVisited); !enqueue {
 			// Filter said NOT to use this url, so continue with next
 			c.logFunc(LogIgnored, "ignore on filter policy: %s", ctx.normalizedURL)
+			c.report.filtered(ctx)
 			continue
 		}
+		c.markRecheck(ctx, isVisited)
 
-		// Even if filter said to use the URL, it still MUST be absolute, http(s)-prefixed,
-		// and comply with the same host policy if requested.
+		// A harvested URL already waiting in a queue is not enqueued again,
+		// even if the Filter allows the visited URLs, only the URLs enqueued
+		// explicitly are (e.g. to retry an URL on error).
+		if ctx.sourceURL != nil && c.pending[ctx.normalizedURL.String()] > 0 {
+			c.logFunc(LogIgnored, "ignore on duplicate policy, already pending: %s", ctx.normalizedURL)
+			c.disallowed(ctx, DisDuplicate, "")
+			continue
+		}
+
+		// Even if filter said to use the URL, it still MUST be absolute, and comply
+		// with the same host policy if requested.
 		if !ctx.normalizedURL.IsAbs() {
 			// Only absolute URLs are processed, so ignore
 			c.logFunc(LogIgnored, "ignore on absolute policy: %s", ctx.normalizedURL)
+			c.disallowed(ctx, DisNotAbsolute, "")
 
-		} else if !strings.HasPrefix(ctx.normalizedURL.Scheme, "http") {
-			c.logFunc(LogIgnored, "ignore on scheme policy: %s", ctx.normalizedURL)
-
-		} else if c.Options.SameHostOnly && !c.isSameHost(ctx) {
+		} else if c.opts().SameHostOnly && !c.isSameHost(ctx) {
 			// Only allow URLs coming from the same host
 			c.logFunc(LogIgnored, "ignore on same host policy: %s", ctx.normalizedURL)
+			c.disallowed(ctx, DisHostPolicy, ctx.normalizedURL.Host)
 
 		} else {
 			// All is good, visit this URL (robots.txt verification is done by worker)
 
-			// Possible caveat: if the normalization changes the host, it is possible
-			// that the robots.txt fetched for this host would differ from the one for
-			// the unnormalized host. However, this should be rare, and is a weird
-			// behaviour from the host (i.e. why would site.com differ in its rules
-			// from www.site.com) and can be fixed by using a different normalization
-			// flag. So this is an acceptable behaviour for gocrawl.
-
-			// Launch worker if required, based on the host of the normalized URL
-			w, ok := c.workers[ctx.normalizedURL.Host]
-			if !ok {
-				// No worker exists for this host, launch a new one
-				w = c.launchWorker(ctx)
-				// Automatically enqueue the robots.txt URL as first in line
-				if robCtx, e := ctx.getRobotsURLCtx(); e != nil {
-					c.Options.Extender.Error(newCrawlError(ctx, e, CekParseRobots))
-					c.logFunc(LogError, "ERROR parsing robots.txt from %s: %s", ctx.normalizedURL, e)
-				} else {
-					c.logFunc(LogEnqueued, "enqueue: %s", robCtx.url)
-					c.Options.Extender.Enqueued(robCtx)
-					w.pop.stack(robCtx)
+			// Apply the pending URLs policy if the host's queue is full
+			if host := ctx.normalizedURL.Host; c.isHostFull(host) {
+				switch c.opts().PendingPolicy {
+				case DropNewest:
+					c.dropURL(ctx)
+					continue
+				case DropOldest:
+					if !c.dropOldestURL(host, batches) {
+						c.dropURL(ctx)
+						continue
+					}
+				case BlockOnFull:
+					if src != nil {
+						overflow = append(overflow, ctx)
+						c.blockedHosts.add(host, 1)
+						if edge != nil {
+							edge.followed = true
+						}
+						continue
+					}
 				}
 			}
 
 			cnt++
-			c.logFunc(LogEnqueued, "enqueue: %s", ctx.url)
-			c.Options.Extender.Enqueued(ctx)
-			w.pop.stack(ctx)
-			c.pushPopRefCount++
-
-			// Once it is stacked, it WILL be visited eventually, so add it to the visited slice
-			// (unless denied by robots.txt, but this is out of our hands, for all we
-			// care, it is visited).
-			if !isVisited {
-				// The visited map works with the normalized URL
-				c.visited[ctx.normalizedURL.String()] = struct{}{}
+			c.acceptURL(ctx, batches)
+			if edge != nil {
+				edge.followed = true
 			}
 		}
 	}
+	if len(edges) > 0 {
+		c.addEdges(from, edges)
+	}
+
+	for w, batch := range batches {
+		c.pushURLs(w, batch)
+	}
+	if len(overflow) > 0 {
+		// Pause the source worker until its URLs can be accepted
+		c.logFunc(LogTrace, "%d url(s) blocked on full queues, pausing worker for host %s", len(overflow), src.host)
+		c.blocked = append(c.blocked, &blockedURLs{src, overflow})
+		src.pop.pause(true)
+	}
 	return
 }
 
+// A link harvested from a page, and whether it is followed.
+type pendingEdge struct {
+	to       *URLContext
+	followed bool
+}
+
+// Notify the links harvested from the page to the EdgeExtender, and record
+// them if requested. The identical links of the page are merged.
+func (c *Crawler) addEdges(from *URLContext, edges []*pendingEdge) {
+	seen := make(map[string]*pendingEdge, len(edges))
+	var uniq []*pendingEdge
+	for _, e := range edges {
+		key := e.to.normalizedURL.String()
+		if prev, ok := seen[key]; ok {
+			prev.followed = prev.followed || e.followed
+			continue
+		}
+		seen[key] = e
+		uniq = append(uniq, e)
+	}
+
+	fromKey := from.normalizedURL.String()
+	for _, e := range uniq {
+		if c.edgeExt != nil {
+			callExtender(c.opts(), e.to, "Edge", c.notifyError, func() {
+				c.edgeExt.Edge(from, e.to, e.followed)
+			})
+		}
+		if c.opts().RecordEdges {
+			key := [2]string{fromKey, e.to.normalizedURL.String()}
+			if i, ok := c.edgeIndex[key]; ok {
+				c.edges[i].Followed = c.edges[i].Followed || e.followed
+			} else {
+				c.edgeIndex[key] = len(c.edges)
+				c.edges = append(c.edges, Edge{from.normalizedURL, e.to.normalizedURL, e.followed})
+			}
+		}
+	}
+}
+
+// Edges returns the links harvested from the visited pages during the last
+// crawl, if the Options' RecordEdges is set. The identical links are
+// deduplicated. It is meant to be called once Run has returned.
+func (c *Crawler) Edges() []Edge {
+	return c.edges
+}
+
+// Indicates if the queue of the host is full, based on the MaxPendingPerHost
+// option.
+func (c *Crawler) isHostFull(host string) bool {
+	max := c.opts().MaxPendingPerHost
+	if max <= 0 {
+		return false
+	}
+	if w, ok := c.workers[host]; ok {
+		return w.queued >= max
+	}
+	return len(c.waiting[host]) >= max
+}
+
+// Notify that the URL is dropped because the queue of its host is full.
+func (c *Crawler) dropURL(ctx *URLContext) {
+	c.notifyError(newCrawlErrorMessage(ctx, "host queue is full", CekDroppedURL))
+	c.logFunc(LogIgnored, "ignore on pending policy: %s", ctx.normalizedURL)
+	c.disallowed(ctx, DisPending, "host queue is full")
+}
+
+// Count the URL rejected by a policy of the crawler, and notify it to the
+// DisallowedExtender, if implemented.
+func (c *Crawler) disallowed(ctx *URLContext, kind DisallowedKind, detail string) {
+	c.progress.addDisallowed(kind)
+	c.report.disallowed(ctx, kind, detail)
+	if c.disExt != nil {
+		c.callbacks.call(func() {
+			c.disExt.DisallowedReason(ctx, kind, detail)
+		})
+	}
+}
+
+// Drop the oldest URL waiting in the queue of the host, including the batch
+// not yet pushed. Returns false if there is none (i.e. the URLs of the host are
+// being processed).
+func (c *Crawler) dropOldestURL(host string, batches map[*worker][]*URLContext) bool {
+	var old *URLContext
+
+	if w, ok := c.workers[host]; ok {
+		if ctx, ok, err := w.pop.dropOldest(); ok {
+			old = ctx
+		} else {
+			if err != nil {
+				c.notifyError(newCrawlError(nil, err, CekFrontier))
+			}
+			// The URLs of the batch are more recent than those of the queue
+			for i, ctx := range batches[w] {
+				if !ctx.IsRobotsURL() {
+					old = ctx
+					batches[w] = append(batches[w][:i], batches[w][i+1:]...)
+					break
+				}
+			}
+		}
+		if old != nil {
+			w.queued--
+		}
+	} else if q := c.waiting[host]; len(q) > 0 {
+		old = q[0]
+		c.waiting[host] = q[1:]
+		c.progress.setWaiting(host, len(q)-1)
+	}
+	if old == nil {
+		return false
+	}
+
+	// The URL may be enqueued again
+	c.pushPopRefCount--
+	c.progress.add(0, 0, -1)
+	c.setVisited(old.normalizedURL.String(), false)
+	c.setPending(old, false)
+	c.dropURL(old)
+	return true
+}
+
+// Push the URLs to the queue of the worker. The URLs that could not be
+// pushed to the Frontier are forgotten, so that they may be enqueued again.
+func (c *Crawler) pushURLs(w *worker, ctxs []*URLContext) {
+	failed, err := w.pop.push(ctxs...)
+	for _, ctx := range failed {
+		w.queued--
+		c.pushPopRefCount--
+		c.progress.add(0, 0, -1)
+		c.setVisited(ctx.normalizedURL.String(), false)
+		c.setPending(ctx, false)
+		c.notifyError(newCrawlError(ctx, err, CekFrontier))
+		c.logFunc(LogError, "ERROR pushing %s to the frontier: %s", ctx.normalizedURL, err)
+	}
+	if n := len(ctxs) - len(failed); n > 0 && c.opts().LogFlags&LogQueue != 0 && c.opts().logsHost(w.host) {
+		c.logFunc(LogQueue, "enqueued %d url(s) for host %s, queue length %d", n, w.host, w.pop.len())
+	}
+}
+
+// Accept the blocked URLs that fit in their hosts' queues, and resume the
+// workers that have no more blocked URLs. If no worker can make progress to
+// free some room, the oldest blocked URLs are accepted regardless of the
+// MaxPendingPerHost option, to avoid a deadlock (e.g. when a worker harvests
+// URLs for its own full queue).
+func (c *Crawler) releaseBlocked() {
+	for len(c.blocked) > 0 {
+		batches := make(map[*worker][]*URLContext)
+		remaining := c.blocked[:0]
+		for _, b := range c.blocked {
+			kept := b.ctxs[:0]
+			for _, ctx := range b.ctxs {
+				if c.isHostFull(ctx.normalizedURL.Host) {
+					kept = append(kept, ctx)
+				} else {
+					c.blockedHosts.add(ctx.normalizedURL.Host, -1)
+					c.acceptURL(ctx, batches)
+				}
+			}
+			if b.ctxs = kept; len(kept) > 0 {
+				remaining = append(remaining, b)
+			}
+		}
+		c.blocked = remaining
+
+		if len(c.blocked) > 0 && !c.canProgress() {
+			b := c.blocked[0]
+			c.logFunc(LogTrace, "no progress possible, accepting %d blocked url(s) from host %s", len(b.ctxs), b.src.host)
+			for _, ctx := range b.ctxs {
+				c.blockedHosts.add(ctx.normalizedURL.Host, -1)
+				c.acceptURL(ctx, batches)
+			}
+			c.blocked = c.blocked[1:]
+		}
+
+		for w, batch := range batches {
+			c.pushURLs(w, batch)
+		}
+		c.resumeWorkers()
+		if len(batches) == 0 {
+			// No URL accepted, wait for some progress
+			return
+		}
+	}
+}
+
+// Resume the paused workers that have no more blocked URLs.
+func (c *Crawler) resumeWorkers() {
+	for _, w := range c.workers {
+		if !w.pop.isPaused() {
+			continue
+		}
+		blocked := false
+		for _, b := range c.blocked {
+			if b.src == w {
+				blocked = true
+				break
+			}
+		}
+		if !blocked {
+			c.logFunc(LogTrace, "resuming worker for host %s", w.host)
+			w.pop.pause(false)
+		}
+	}
+}
+
+// Indicates if a worker can make progress, that is, if a worker has URLs to
+// process. The URLs in the queue of a paused worker do not count, only the
+// ones being processed.
+func (c *Crawler) canProgress() bool {
+	for _, w := range c.workers {
+		n := w.queued
+		if w.pop.isPaused() {
+			n -= w.pop.len()
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept the URL in its host's queue, launching the worker of the host if
+// required. The URL is added to the batch of its worker, or buffered if
+// there is no worker slot available.
+func (c *Crawler) acceptURL(ctx *URLContext, batches map[*worker][]*URLContext) {
+	// Possible caveat: if the normalization changes the host, it is possible
+	// that the robots.txt fetched for this host would differ from the one for
+	// the unnormalized host. However, this should be rare, and is a weird
+	// behaviour from the host (i.e. why would site.com differ in its rules
+	// from www.site.com) and can be fixed by using a different normalization
+	// flag. So this is an acceptable behaviour for gocrawl.
+
+	// Launch worker if required, based on the host of the normalized URL
+	host := ctx.normalizedURL.Host
+	w, ok := c.workers[host]
+	if !ok && c.hasWorkerSlot() {
+		// No worker exists for this host, launch a new one
+		var robots []*URLContext
+		w, robots = c.startHost(ctx)
+		batches[w] = append(batches[w], robots...)
+	}
+
+	c.logFunc(LogEnqueued, "enqueue: %s", ctx.url)
+	c.eventFunc(LogEnqueued, EventEnqueue, urlFields(ctx))
+	c.enqueued(ctx)
+	if c.queries != nil {
+		c.queries.add(ctx.normalizedURL)
+	}
+	if w != nil {
+		w.queued++
+		batches[w] = append(batches[w], ctx)
+	} else {
+		// No slot available, buffer the URL until its host gets a worker
+		if _, ok := c.waiting[host]; !ok {
+			c.logFunc(LogInfo, "host %s waiting for a worker slot", host)
+			c.waitingHosts = append(c.waitingHosts, host)
+		}
+		c.waiting[host] = append(c.waiting[host], ctx)
+		c.progress.setWaiting(host, len(c.waiting[host]))
+	}
+	c.pushPopRefCount++
+	c.progress.add(0, 1, 1)
+
+	// Once it is queued, it WILL be visited eventually, so add it to the visited slice
+	// (unless denied by robots.txt, but this is out of our hands, for all we
+	// care, it is visited). The visited map works with the normalized URL.
+	c.setVisited(ctx.normalizedURL.String(), true)
+	c.setPending(ctx, true)
+	if c.ancestry != nil {
+		c.ancestry.set(ctx.normalizedURL, ctx.normalizedSourceURL)
+	}
+	if c.dups != nil {
+		c.dups.setFirst(ctx)
+	}
+}
+
 // This is the main loop of the crawler, waiting for responses from the workers
 // and processing these responses.
 func (c *Crawler) collectUrls() error {
 	defer func() {
+		c.endEnqueue(true)
 		c.logFunc(LogInfo, "waiting for goroutines to complete...")
 		c.wg.Wait()
+		if c.client != nil && c.opts().clonesTransport() {
+			// The client of the run is not reused, nor its transport
+			c.client.CloseIdleConnections()
+		}
+		if c.throttle != nil {
+			n, bps := c.throttle.throughput()
+			c.logFunc(LogInfo, "read %d bytes, throughput: %.0f bytes/s", n, bps)
+		}
+		summary := c.progress.summary()
+		if c.opts().DryRun {
+			summary = "dry run, " + summary
+		}
+		if c.dups != nil {
+			_, enqueued, _ := c.progress.counters()
+			summary += c.dups.summary(enqueued)
+		}
+		if c.cache != nil {
+			summary += c.cache.summary()
+		}
+		if c.queries != nil {
+			summary += c.queries.summary()
+		}
+		c.logFunc(LogInfo, "summary: %s", summary)
 		c.logFunc(LogInfo, "crawler done.")
 	}()
 
+	// Stop the crawl once the maximum duration is reached, if requested
+	var deadline <-chan time.Time
+	if c.opts().MaxDuration > 0 {
+		t := c.opts().getClock().NewTimer(c.opts().MaxDuration)
+		defer t.Stop()
+		deadline = t.C()
+	}
+
 	for {
 		// By checking this after each channel reception, there is a bug if the worker
 		// wants to reenqueue following an error or a redirection. The pushPopRefCount
@@ -289,45 +1316,226 @@ func (c *Crawler) collectUrls() error {
 		// state.
 		//
 		// Check if refcount is zero - MUST be before the select statement, so that if
-		// no valid seeds are enqueued, the crawler stops.
-		if c.pushPopRefCount == 0 && len(c.enqueue) == 0 {
+		// no valid seeds are enqueued, the crawler stops. The blocked URLs are released
+		// first, as they are not part of the refcount.
+		if len(c.blocked) > 0 {
+			c.releaseBlocked()
+		}
+		if c.pushPopRefCount == 0 && len(c.enqueue) == 0 && c.seeds == nil && c.endEnqueue(false) {
+			// The hosts are complete, as there are no more URLs
+			atomic.StoreInt32(c.drained, 1)
 			c.logFunc(LogInfo, "sending STOP signals...")
 			close(c.stop)
 			return nil
 		}
+		if c.turnDone != nil && c.turn == nil {
+			c.nextTurn()
+		}
 
 		select {
 		case res := <-c.push:
-			// Received a response, check if it contains URLs to enqueue
-			if res.visited {
-				c.visits++
-				if c.Options.MaxVisits > 0 && c.visits >= c.Options.MaxVisits {
-					// Limit reached, request workers to stop
-					c.logFunc(LogInfo, "sending STOP signals...")
-					close(c.stop)
-					return ErrMaxVisits
-				}
+			// Received a response, check if it contains URLs to enqueue, once the
+			// turn is done in Deterministic mode
+			if c.turn != nil {
+				c.turnPush = append(c.turnPush, res)
+			} else if err := c.processResponse(res); err != nil {
+				return err
 			}
-			if res.idleDeath {
-				// The worker timed out from its Idle TTL delay, remove from active workers
-				delete(c.workers, res.host)
-				c.logFunc(LogInfo, "worker for host %s cleared on idle policy", res.host)
+
+		case enq := <-c.enqueue:
+			// Received a command to enqueue a URL, proceed once the turn is done
+			// in Deterministic mode
+			if c.turn != nil {
+				c.turnEnqueue = append(c.turnEnqueue, enq)
 			} else {
-				c.enqueueUrls(c.toURLContexts(res.harvestedURLs, res.ctx.url))
-				c.pushPopRefCount--
+				c.processEnqueue(enq)
 			}
 
-		case enq := <-c.enqueue:
-			// Received a command to enqueue a URL, proceed
-			ctxs := c.toURLContexts(enq, nil)
-			c.logFunc(LogTrace, "receive url(s) to enqueue %v", toStringArrayContextURL(ctxs))
-			c.enqueueUrls(ctxs)
+		case <-c.enqSignalChan():
+			// URLs were enqueued via the Enqueue method, process them the same way
+			c.processEnqueuePending()
+
+		case <-c.turnDone:
+			// The turn is done, process its results in a stable order
+			if err := c.endTurn(); err != nil {
+				return err
+			}
+
+		case sr, ok := <-c.seedsChan():
+			// Pulled a seed from the SeedProvider, the workers have capacity
+			if !ok {
+				c.logFunc(LogInfo, "seed provider exhausted")
+				c.seeds = nil
+			} else if err := c.enqueueSeed(sr); err != nil {
+				c.logFunc(LogInfo, "seed provider failed, sending STOP signals...")
+				close(c.stop)
+				return err
+			}
+
+		case <-deadline:
+			// Limit reached, request workers to stop
+			c.logFunc(LogInfo, "maximum duration reached, sending STOP signals...")
+			close(c.stop)
+			return ErrMaxDuration
+
 		case <-c.stop:
 			return ErrInterrupted
 		}
 	}
 }
 
+// Process the response of a worker, enqueuing its harvested URLs. It returns
+// the error that stops the crawl, if a limit is reached.
+func (c *Crawler) processResponse(res *workerResponse) error {
+	if res.visited && !c.countsVisit(res.ctx) {
+		// A soft visit, it does not count against the MaxVisits budget
+		c.progress.add(1, 0, 0)
+		c.progress.addUnchanged()
+	} else if res.visited {
+		c.visits++
+		c.progress.add(1, 0, 0)
+		if c.opts().MaxVisits > 0 && c.visits >= c.opts().MaxVisits {
+			// Limit reached, request workers to stop
+			c.logFunc(LogInfo, "sending STOP signals...")
+			close(c.stop)
+			return ErrMaxVisits
+		}
+	}
+	if max := c.opts().MaxTotalBytes; max > 0 && c.BytesRead() > max {
+		// Budget exceeded, request workers to stop
+		c.logFunc(LogInfo, "maximum total bytes exceeded, sending STOP signals...")
+		close(c.stop)
+		return ErrMaxTotalBytes
+	}
+	if c.errBudget != nil {
+		if err := c.errBudget.exceeded(c.opts().MaxErrors, c.opts().MaxConsecutiveErrors); err != nil {
+			// Too many errors, request workers to stop
+			c.logFunc(LogInfo, "maximum number of errors reached, sending STOP signals...")
+			close(c.stop)
+			return err
+		}
+	}
+	if res.parked || res.resumed {
+		// The parked worker frees its slot until it resumes, even if the
+		// maximum number of workers is then exceeded
+		if res.parked {
+			c.parked[res.host] = struct{}{}
+			c.logFunc(LogTrace, "host %s parked, its slot is free", res.host)
+			c.launchWaitingHosts()
+		} else {
+			delete(c.parked, res.host)
+		}
+	} else if res.idleDeath {
+		// The worker timed out from its Idle TTL delay, remove from active workers
+		if _, ok := c.workers[res.host]; ok {
+			delete(c.workers, res.host)
+			c.logFunc(LogInfo, "worker for host %s cleared on idle policy", res.host)
+			c.launchWaitingHosts()
+		}
+	} else {
+		w := c.workers[res.host]
+		if w != nil {
+			w.queued--
+		}
+		if res.ctx != nil {
+			c.setPending(res.ctx, false)
+		}
+		c.enqueueUrls(c.harvestedContexts(res), res.ctx, w)
+		c.pushPopRefCount--
+		c.progress.add(0, 0, -1)
+		c.retireIdleWorker(res.host)
+	}
+	return nil
+}
+
+// Process a value received on the enqueue channel.
+func (c *Crawler) processEnqueue(enq interface{}) {
+	ctxs := c.toURLContexts(enq, nil)
+	c.logFunc(LogTrace, "receive url(s) to enqueue %v", toStringArrayContextURL(ctxs))
+	c.enqueueUrls(ctxs, nil, nil)
+}
+
+// Process the URLs enqueued via the Enqueue method.
+func (c *Crawler) processEnqueuePending() {
+	c.enqMu.Lock()
+	ctxs := c.enqPending
+	c.enqPending = nil
+	c.enqMu.Unlock()
+	c.logFunc(LogTrace, "receive url(s) to enqueue %v", toStringArrayContextURL(ctxs))
+	c.enqueueUrls(ctxs, nil, nil)
+}
+
+// Stop accepting URLs via the Enqueue method, unless some are pending and
+// force is false. Returns true if the Enqueue method is stopped.
+func (c *Crawler) endEnqueue(force bool) bool {
+	c.enqMu.Lock()
+	defer c.enqMu.Unlock()
+	if !force && len(c.enqPending) > 0 {
+		return false
+	}
+	c.running = false
+	return true
+}
+
+// Enqueue enqueues the URLs in the running crawler. The URLs are processed as
+// if they had been harvested from a visit: they go through the Filter and, if
+// allowed, the Enqueued extender methods. It is safe to call it from any
+// goroutine, including from the extender methods, it never blocks. It returns
+// ErrNotRunning if the crawler is not running, or an error if an URL is
+// invalid, in which case no URL is enqueued. The URLs dropped by the
+// RewriteURL option are skipped.
+func (c *Crawler) Enqueue(items ...EnqueueItem) error {
+	ctxs := make([]*URLContext, 0, len(items))
+	for _, it := range items {
+		ctx, err := c.itemToURLContext(it, nil)
+		if err == errRewriteDropped {
+			// Silently dropped by the RewriteURL option
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ctxs = append(ctxs, ctx)
+	}
+
+	c.enqMu.Lock()
+	defer c.enqMu.Unlock()
+	if !c.running {
+		return ErrNotRunning
+	}
+	c.enqPending = append(c.enqPending, ctxs...)
+	select {
+	case c.enqSignal <- struct{}{}:
+	default:
+		// The crawler is already notified
+	}
+	return nil
+}
+
+// Notify that the URL is enqueued to the extender.
+func (c *Crawler) enqueued(ctx *URLContext) {
+	if !ctx.IsRobotsURL() {
+		c.events.emitURL(CevEnqueued, ctx)
+	}
+	c.callbacks.call(func() {
+		callExtender(c.opts(), ctx, "Enqueued", c.notifyError, func() {
+			c.opts().Extender.Enqueued(ctx)
+		})
+	})
+}
+
+// Notify the error to the extender, and send its structured log event.
+func (c *Crawler) notifyError(err *CrawlError) {
+	c.eventFunc(LogError, EventError, errorFields(err))
+	c.events.emitError(err)
+	c.callbacks.call(func() {
+		if c.opts().RecoverPanics {
+			defer dropErrorPanic(c.logFunc)
+		}
+		c.opts().Extender.Error(err)
+	})
+}
+
 // Stop terminates the crawler.
 func (c *Crawler) Stop() {
 	defer func() {
@@ -0,0 +1,145 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The extender of a crawler of the concurrent crawls test, which records its
+// crawl delays and the user agents of its fetches.
+type tenantExtender struct {
+	Extender
+	mu      sync.Mutex
+	delays  map[time.Duration]bool
+	agents  map[string]bool
+	visited []string
+}
+
+func newTenantExtender(ext Extender) *tenantExtender {
+	return &tenantExtender{Extender: ext, delays: make(map[time.Duration]bool), agents: make(map[string]bool)}
+}
+
+func (x *tenantExtender) ComputeDelay(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+	x.mu.Lock()
+	x.delays[di.OptsDelay] = true
+	x.mu.Unlock()
+	return x.Extender.ComputeDelay(host, di, lastFetch)
+}
+
+func (x *tenantExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.agents[ctx.FetchInfo().UserAgent] = true
+	x.visited = append(x.visited, ctx.URL().Path)
+}
+
+func (x *tenantExtender) Log(logFlags LogFlags, msgLevel LogFlags, msg string) {}
+
+func TestConcurrentCrawlers(t *testing.T) {
+	type request struct {
+		path string
+		at   time.Time
+	}
+	var mu sync.Mutex
+	requests := make(map[string][]request)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agent := r.Header.Get("User-Agent")
+		requests[agent] = append(requests[agent], request{r.URL.Path, time.Now()})
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/robots.txt":
+			http.NotFound(w, r)
+		case strings.HasSuffix(r.URL.Path, "/a"):
+			http.Redirect(w, r, "c", http.StatusFound)
+		case strings.HasSuffix(r.URL.Path, "/"):
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="a">a</a><a href="b">b</a><a href="c">c</a>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="./">index</a>`)
+		}
+	}))
+	defer srv.Close()
+	template := *HttpClient
+
+	// The crawlers of the server, with their own user agent and delay, and
+	// the template client that follows the redirections for one of them
+	follow := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return nil }}
+	cases := []struct {
+		ext     *tenantExtender
+		client  *http.Client
+		seed    string
+		delay   time.Duration
+		visited []string
+	}{
+		{newTenantExtender(new(DefaultExtender)), nil, srv.URL + "/t0/", 0, []string{"/t0/", "/t0/b", "/t0/c"}},
+		{newTenantExtender(new(DefaultExtender)), follow, srv.URL + "/t1/", 15 * time.Millisecond, []string{"/t1/", "/t1/a", "/t1/b", "/t1/c"}},
+		{newTenantExtender(new(DefaultExtender)), nil, srv.URL + "/t2/", 30 * time.Millisecond, []string{"/t2/", "/t2/b", "/t2/c"}},
+		{newTenantExtender(newFileFetcher()), nil, "http://hosta/page1.html", 0, []string{"/page1.html", "/page2.html", "/page3.html"}},
+		{newTenantExtender(newFileFetcher()), nil, "http://hosta/page1.html", 20 * time.Millisecond, []string{"/page1.html", "/page2.html", "/page3.html"}},
+	}
+	var wg sync.WaitGroup
+	for i, c := range cases {
+		opts := NewOptions(c.ext)
+		opts.UserAgent = fmt.Sprintf("tenant-%d", i)
+		opts.CrawlDelay = c.delay
+		opts.HTTPClient = c.client
+		opts.LogFlags = LogNone
+		wg.Add(1)
+		go func(i int, opts *Options) {
+			defer wg.Done()
+			if err := NewCrawlerWithOptions(opts).Run(cases[i].seed); err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+		}(i, opts)
+	}
+	wg.Wait()
+
+	for i, c := range cases {
+		agent := fmt.Sprintf("tenant-%d", i)
+		if !reflect.DeepEqual(c.ext.agents, map[string]bool{agent: true}) {
+			t.Errorf("%d: want the user agent %s only, got %v", i, agent, c.ext.agents)
+		}
+		if !reflect.DeepEqual(c.ext.delays, map[time.Duration]bool{c.delay: true}) {
+			t.Errorf("%d: want the crawl delay %v only, got %v", i, c.delay, c.ext.delays)
+		}
+		sort.Strings(c.ext.visited)
+		if !reflect.DeepEqual(c.ext.visited, c.visited) {
+			t.Errorf("%d: want the pages %v visited, got %v", i, c.visited, c.ext.visited)
+		}
+		if strings.HasPrefix(c.seed, "http://hosta") {
+			// The file fetcher does not request the server
+			continue
+		}
+		// The requests of the server are those of the crawler, spaced by its
+		// delay
+		u, _ := url.Parse(c.seed)
+		reqs := requests[agent]
+		for j, r := range reqs {
+			if r.path != "/robots.txt" && !strings.HasPrefix(r.path, u.Path) {
+				t.Errorf("%d: want the requests of %s only, got %s", i, u.Path, r.path)
+			}
+			if j > 0 && r.at.Sub(reqs[j-1].at) < c.delay {
+				t.Errorf("%d: want the requests spaced by %v, got %v", i, c.delay, r.at.Sub(reqs[j-1].at))
+			}
+		}
+	}
+
+	// The template of the clients is not modified
+	if HttpClient.Transport != template.Transport || HttpClient.Jar != template.Jar || HttpClient.Timeout != template.Timeout {
+		t.Errorf("want the HttpClient unchanged, got %+v", HttpClient)
+	}
+	req := httptest.NewRequest("GET", srv.URL+"/t0/c", nil)
+	if err := HttpClient.CheckRedirect(req, []*http.Request{req}); err != ErrEnqueueRedirect {
+		t.Errorf("want the redirection policy of the HttpClient unchanged, got %v", err)
+	}
+}
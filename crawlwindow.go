@@ -0,0 +1,126 @@
+package gocrawl
+
+import "time"
+
+// The maximum delay between two checks of the crawl windows of a parked
+// host, so that the changes of the clock (e.g. the daylight saving time
+// transitions) are noticed.
+const crawlWindowPoll = time.Minute
+
+// CrawlWindow is a time-of-day range during which a host may be crawled,
+// per the CrawlWindows option. Start and End are the durations since
+// midnight in the Location, i.e. 1*time.Hour for 01:00, and End is
+// excluded. A window whose End is before its Start spans midnight, e.g.
+// from 22:00 to 02:00.
+type CrawlWindow struct {
+	Start time.Duration
+	End   time.Duration
+
+	// Location is the timezone of the window, time.Local if nil.
+	Location *time.Location
+}
+
+func (cw CrawlWindow) location() *time.Location {
+	if cw.Location == nil {
+		return time.Local
+	}
+	return cw.Location
+}
+
+// Indicates if the window is valid, i.e. its Start is in [0, 24h), its End
+// in (0, 24h], and they differ.
+func (cw CrawlWindow) valid() bool {
+	return cw.Start >= 0 && cw.Start < 24*time.Hour && cw.End > 0 && cw.End <= 24*time.Hour &&
+		cw.Start != cw.End
+}
+
+// Indicates if t is in the window.
+func (cw CrawlWindow) contains(t time.Time) bool {
+	t = t.In(cw.location())
+	h, m, s := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+	if cw.Start < cw.End {
+		return tod >= cw.Start && tod < cw.End
+	}
+	return tod >= cw.Start || tod < cw.End
+}
+
+// Return the next opening of the window after t.
+func (cw CrawlWindow) next(t time.Time) time.Time {
+	t = t.In(cw.location())
+	y, mo, d := t.Date()
+	for i := 0; ; i++ {
+		// Built from the wall clock, so that the days of the daylight saving
+		// time transitions are handled
+		open := time.Date(y, mo, d+i, 0, 0, 0, int(cw.Start), cw.location())
+		if open.After(t) {
+			return open
+		}
+	}
+}
+
+// Return the crawl windows of the host, based on the CrawlWindows option,
+// matched like the PerHost overrides.
+func (opts *Options) crawlWindows(host string) []CrawlWindow {
+	if cws, ok := opts.CrawlWindows[host]; ok {
+		return cws
+	}
+	var match string
+	for pattern := range opts.CrawlWindows {
+		if matchesHostPattern(host, pattern) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	return opts.CrawlWindows[match]
+}
+
+// Indicates if the host may be crawled at t, per its crawl windows, and if
+// not, returns the next opening of its windows.
+func inCrawlWindows(cws []CrawlWindow, t time.Time) (bool, time.Time) {
+	if len(cws) == 0 {
+		return true, time.Time{}
+	}
+	var open time.Time
+	for _, cw := range cws {
+		if cw.contains(t) {
+			return true, time.Time{}
+		}
+		if next := cw.next(t); open.IsZero() || next.Before(open) {
+			open = next
+		}
+	}
+	return false, open
+}
+
+// Park the host until its crawl windows open, if it is outside of them:
+// its queue is kept but nothing is fetched, and the worker does not hold a
+// slot of MaxConcurrentHosts meanwhile. The windows are checked again at
+// least every crawlWindowPoll. Returns false if the stop signal is received.
+func (w *worker) waitCrawlWindow() bool {
+	now := w.clock.Now()
+	ok, open := inCrawlWindows(w.windows, now)
+	if ok {
+		return true
+	}
+	w.logFunc(LogTrace, "host %s outside of its crawl windows, parked until %s", w.host, open.Format(time.RFC3339))
+	w.stats.addParked(open.Sub(now))
+	w.sendParked(true)
+	defer w.sendParked(false)
+
+	for !ok {
+		d := open.Sub(now)
+		if d > crawlWindowPoll {
+			d = crawlWindowPoll
+		}
+		select {
+		case <-after(w.clock, d):
+		case <-w.stop:
+			return false
+		}
+		now = w.clock.Now()
+		ok, open = inCrawlWindows(w.windows, now)
+	}
+	w.logFunc(LogTrace, "host %s crawl window open, resumed", w.host)
+	return true
+}
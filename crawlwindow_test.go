@@ -0,0 +1,125 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestCrawlWindowContains(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	offPeak := CrawlWindow{Start: time.Hour, End: 5 * time.Hour, Location: paris}
+	overnight := CrawlWindow{Start: 22 * time.Hour, End: 2 * time.Hour, Location: time.UTC}
+	cases := []struct {
+		cw   CrawlWindow
+		t    time.Time
+		in   bool
+		next time.Time
+	}{
+		{offPeak, time.Date(2024, 3, 1, 0, 30, 0, 0, paris), false, time.Date(2024, 3, 1, 1, 0, 0, 0, paris)},
+		{offPeak, time.Date(2024, 3, 1, 1, 0, 0, 0, paris), true, time.Date(2024, 3, 2, 1, 0, 0, 0, paris)},
+		{offPeak, time.Date(2024, 3, 1, 5, 0, 0, 0, paris), false, time.Date(2024, 3, 2, 1, 0, 0, 0, paris)},
+		// 01:00 in Paris is midnight in UTC, in the winter
+		{offPeak, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true, time.Date(2024, 3, 2, 1, 0, 0, 0, paris)},
+		// The daylight saving time starts on 2024-03-31 at 02:00 in Paris
+		{offPeak, time.Date(2024, 3, 30, 12, 0, 0, 0, paris), false, time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{overnight, time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC), true, time.Date(2024, 3, 2, 22, 0, 0, 0, time.UTC)},
+		{overnight, time.Date(2024, 3, 1, 1, 59, 0, 0, time.UTC), true, time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)},
+		{overnight, time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), false, time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)},
+	}
+	for i, c := range cases {
+		if in := c.cw.contains(c.t); in != c.in {
+			t.Errorf("%d: want in window %v for %s, got %v", i, c.in, c.t, in)
+		}
+		if next := c.cw.next(c.t); !next.Equal(c.next) {
+			t.Errorf("%d: want next opening %s for %s, got %s", i, c.next, c.t, next)
+		}
+	}
+
+	// The earliest opening of several windows
+	cws := []CrawlWindow{overnight, {Start: 12 * time.Hour, End: 13 * time.Hour, Location: time.UTC}}
+	if ok, open := inCrawlWindows(cws, time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC)); ok || !open.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("want the host parked until 12:00, got %v %s", ok, open)
+	}
+	if ok, _ := inCrawlWindows(nil, time.Now()); !ok {
+		t.Errorf("want a host without windows always crawled")
+	}
+}
+
+func TestCrawlWindowsParking(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	windowed, other := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer windowed.Close()
+	defer other.Close()
+	host := windowed.Listener.Addr().String()
+
+	var mu sync.Mutex
+	var visits []string
+	spy := newSpy(new(DefaultExtender), true)
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		visits = append(visits, ctx.url.Host)
+		return nil, false
+	})
+	ext := &hostCompleteExtender{spyExtender: spy, stats: make(map[string][]HostStats)}
+	clock := newFakeClock(time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC), false)
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.MaxConcurrentHosts = 1
+	opts.CrawlWindows = map[string][]CrawlWindow{
+		host: {{Start: time.Hour, End: 5 * time.Hour, Location: time.UTC}},
+	}
+	opts.LogFlags = LogAll
+	opts.Clock = clock
+	done := make(chan error, 1)
+	go func() {
+		done <- NewCrawlerWithOptions(opts).Run([]string{windowed.URL + "/page", other.URL + "/page"})
+	}()
+
+	// The other host gets the slot of the parked host, and the crawl waits
+	// for the window of the parked host
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("want the crawl to wait for the crawl window, it ended with %v", err)
+	default:
+	}
+	mu.Lock()
+	if len(visits) != 1 || visits[0] != other.Listener.Addr().String() {
+		t.Errorf("want only the other host visited before the window, got %v", visits)
+	}
+	mu.Unlock()
+	// The robots.txt and the page of the other host
+	if n := spy.getCallCount(eMKFetch); n != 2 {
+		t.Errorf("want only the other host fetched before the window, got %d fetches", n)
+	}
+
+	clock.set(time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the crawl to end once the window opens")
+	}
+	if len(visits) != 2 || visits[1] != host {
+		t.Errorf("want the page of the parked host visited last, got %v", visits)
+	}
+	if st := ext.stats[host]; len(st) != 1 || st[0].Parked != 1 || st[0].ParkedTime != 30*time.Minute {
+		t.Errorf("want the host parked once for 30m, got %+v", st)
+	}
+	assertIsInLog("CrawlWindowsParking", spy.b, fmt.Sprintf("host %s outside of its crawl windows, parked until 2024-03-01T01:00:00Z", host), t)
+	assertIsInLog("CrawlWindowsParking", spy.b, fmt.Sprintf("host %s crawl window open, resumed", host), t)
+}
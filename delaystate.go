@@ -0,0 +1,64 @@
+package gocrawl
+
+import (
+	"sync"
+	"time"
+)
+
+// The time of the last successful fetch of each host, when its crawl delay
+// started. It is kept across runs of the crawler, so that the first fetch of
+// a host in a run respects the crawl delay of the last fetch of the previous
+// run.
+type lastFetches struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+func newLastFetches() *lastFetches {
+	return &lastFetches{times: make(map[string]time.Time)}
+}
+
+// Record the last fetch of the host.
+func (l *lastFetches) set(host string, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.times[host] = t
+}
+
+// Return the last fetch of the host, if any.
+func (l *lastFetches) get(host string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.times[host]
+	return t, ok
+}
+
+// DelayState returns the time of the last successful fetch of each host,
+// keyed by normalized host, as recorded by the previous runs of the crawler
+// or set by SetDelayState. It can be saved so that the crawler of a new
+// process respects the crawl delays of the hosts it fetched recently.
+func (c *Crawler) DelayState() map[string]time.Time {
+	res := make(map[string]time.Time)
+	if c.lastFetches == nil {
+		return res
+	}
+	c.lastFetches.mu.Lock()
+	defer c.lastFetches.mu.Unlock()
+	for host, t := range c.lastFetches.times {
+		res[host] = t
+	}
+	return res
+}
+
+// SetDelayState replaces the time of the last fetch of each host, e.g. with
+// the state returned by DelayState in a previous process. The first fetch of
+// a host in the next run waits for the remaining of its crawl delay (the
+// CrawlDelay option, or its PerHost override) since this time. It must be
+// called before Run.
+func (c *Crawler) SetDelayState(state map[string]time.Time) {
+	l := newLastFetches()
+	for host, t := range state {
+		l.times[host] = t
+	}
+	c.lastFetches = l
+}
@@ -0,0 +1,76 @@
+package gocrawl
+
+import (
+	"sort"
+)
+
+// Grant the next turn of the Deterministic mode to the worker of the first
+// host, in the order of the host names, that follows the host of the last
+// turn and has URLs to process. The hosts are serviced round-robin.
+func (c *Crawler) nextTurn() {
+	hosts := make([]string, 0, len(c.workers))
+	for host, w := range c.workers {
+		if w.pop.len() > 0 && !w.pop.isPaused() {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+	sort.Strings(hosts)
+	i := sort.SearchStrings(hosts, c.lastTurn)
+	if i < len(hosts) && hosts[i] == c.lastTurn {
+		i++
+	}
+	if i == len(hosts) {
+		i = 0
+	}
+	c.lastTurn, c.turn = hosts[i], c.workers[hosts[i]]
+	c.logFunc(LogTrace, "turn of host %s", c.lastTurn)
+	c.turn.turn <- struct{}{}
+}
+
+// End the current turn of the Deterministic mode. The results of the turn
+// are processed in a stable order: the response of the worker, then the
+// URLs sent on the enqueue channel and finally those enqueued via the
+// Enqueue method. It returns the error that stops the crawl, if a limit is
+// reached.
+func (c *Crawler) endTurn() error {
+	c.turn = nil
+
+	// The results were sent before the end of the turn, the buffered ones are
+	// still in the channels.
+	for len(c.push) > 0 {
+		c.turnPush = append(c.turnPush, <-c.push)
+	}
+	for len(c.enqueue) > 0 {
+		c.turnEnqueue = append(c.turnEnqueue, <-c.enqueue)
+	}
+	pushes, enqs := c.turnPush, c.turnEnqueue
+	c.turnPush, c.turnEnqueue = nil, nil
+
+	for _, res := range pushes {
+		if err := c.processResponse(res); err != nil {
+			return err
+		}
+	}
+	for _, enq := range enqs {
+		c.processEnqueue(enq)
+	}
+	select {
+	case <-c.enqSignal:
+		c.processEnqueuePending()
+	default:
+		// Nothing enqueued via the Enqueue method
+	}
+	return nil
+}
+
+// Return the channel that signals the URLs enqueued via the Enqueue method,
+// or nil during a turn of the Deterministic mode.
+func (c *Crawler) enqSignalChan() <-chan struct{} {
+	if c.turn != nil {
+		return nil
+	}
+	return c.enqSignal
+}
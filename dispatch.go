@@ -0,0 +1,85 @@
+package gocrawl
+
+import "sync"
+
+// The callbacks dispatcher runs the notification calls of the extender
+// (Enqueued, Visited, VisitedInfo, Error, Disallowed and DisallowedReason)
+// one at a time on its own goroutine, in the order they are made, with the
+// OrderedCallbacks option. The calls are queued without blocking the
+// callers, so that an extender method may enqueue URLs or stop the crawler.
+// A nil dispatcher runs the calls directly, on the caller's goroutine.
+type dispatcher struct {
+	mu      sync.Mutex
+	queue   []func()
+	closed  bool
+	stopped bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go d.run()
+	return d
+}
+
+// Queue the call, or run it directly if the dispatcher is nil or stopped.
+func (d *dispatcher) call(f func()) {
+	if d == nil {
+		f()
+		return
+	}
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		f()
+		return
+	}
+	d.queue = append(d.queue, f)
+	d.mu.Unlock()
+	d.signal()
+}
+
+// Run the queued calls until the dispatcher is closed and its queue is
+// empty.
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for {
+		d.mu.Lock()
+		q := d.queue
+		d.queue = nil
+		if len(q) == 0 && d.closed {
+			d.stopped = true
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+		if len(q) == 0 {
+			<-d.wake
+			continue
+		}
+		for _, f := range q {
+			f()
+		}
+	}
+}
+
+func (d *dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close the dispatcher, and wait for the queued calls to complete, including
+// those made meanwhile. The calls made afterwards run directly.
+func (d *dispatcher) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.signal()
+	<-d.done
+}
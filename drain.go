@@ -0,0 +1,112 @@
+package gocrawl
+
+import (
+	"sort"
+	"sync"
+)
+
+// The URLs popped by the workers and not processed yet, keyed by their
+// normalized form. An URL stays in flight if the crawl is stopped before its
+// response is sent to the crawler, unless it was visited.
+type inFlight struct {
+	mu   sync.Mutex
+	ctxs map[string]*URLContext
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{ctxs: make(map[string]*URLContext)}
+}
+
+// Add the popped URL.
+func (f *inFlight) add(ctx *URLContext) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ctxs[ctx.normalizedURL.String()] = ctx
+}
+
+// Remove the processed URL.
+func (f *inFlight) remove(ctx *URLContext) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.ctxs, ctx.normalizedURL.String())
+}
+
+// Remove and return the URLs in flight, sorted by normalized URL.
+func (f *inFlight) take() []*URLContext {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.ctxs))
+	for k := range f.ctxs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]*URLContext, len(keys))
+	for i, k := range keys {
+		res[i] = f.ctxs[k]
+	}
+	f.ctxs = make(map[string]*URLContext)
+	return res
+}
+
+// Drain returns the URLs that were not processed when the last crawl ended,
+// i.e. on MaxVisits or Stop, so that they can be passed as the seeds of the
+// next run. These are the URLs popped by the workers but neither processed
+// nor visited, followed by the URLs waiting in the hosts' queues (popped from
+// the Frontier), for a worker slot, on full queues and in the Enqueue method.
+// The robots.txt URLs are excluded. The URLs keep their normalized and source
+// URLs and their State. The links harvested by the visits whose response was
+// not processed by the crawler (e.g. the visit that reached MaxVisits) were
+// never enqueued, so they are not part of it.
+//
+// It must be called once Run has returned, and before the next run. It
+// returns nil while the crawler is running, or if the crawl completed.
+// The URLs are removed from the crawler, so a second call returns nil.
+func (c *Crawler) Drain() []*URLContext {
+	c.enqMu.Lock()
+	running := c.running
+	c.enqMu.Unlock()
+	if running || c.inFlight == nil {
+		return nil
+	}
+
+	res := c.inFlight.take()
+
+	// The URLs of the hosts' queues, in host order
+	hosts := make([]string, 0, len(c.workers))
+	for host := range c.workers {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		for {
+			ctx, ok, err := c.frontier.Pop(host)
+			if err != nil {
+				c.notifyError(newCrawlError(nil, err, CekFrontier))
+				c.logFunc(LogError, "ERROR draining the frontier of host %s: %s", host, err)
+				break
+			}
+			if !ok {
+				break
+			}
+			res = append(res, ctx)
+		}
+	}
+
+	for _, host := range c.waitingHosts {
+		res = append(res, c.waiting[host]...)
+	}
+	for _, b := range c.blocked {
+		res = append(res, b.ctxs...)
+	}
+	c.waiting, c.waitingHosts, c.blocked = nil, nil, nil
+
+	c.enqMu.Lock()
+	res = append(res, c.enqPending...)
+	c.enqPending = nil
+	c.enqMu.Unlock()
+
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,17 @@
+package gocrawl
+
+// Report the URL that would have been fetched with the DryRun option, and
+// mark it as visited so that the crawl advances. No request is made, so no
+// link is harvested.
+func (w *worker) wouldFetch(ctx *URLContext) {
+	w.mu.Lock()
+	w.visitCount++
+	w.mu.Unlock()
+	w.logFunc(LogTrace, "dry run, would fetch %s", ctx.url)
+	if w.dryRunExt != nil {
+		callExtender(w.opts, ctx, "WouldFetch", w.notifyError, func() {
+			w.dryRunExt.WouldFetch(ctx)
+		})
+	}
+	w.sendResponse(ctx, true, nil, false)
+}
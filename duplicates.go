@@ -0,0 +1,128 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// DuplicateExtender is an optional interface that an Extender can implement
+// to be notified of the URLs found again once in the visited set, when the
+// Options' TrackDuplicates is set. Duplicate is called before the Filter,
+// with the normalized source URL of the first occurrence of the URL if it
+// is known (with the TrackDuplicateSources or the TrackAncestry option), nil
+// otherwise or for a seed. It is called from the crawler's goroutine.
+type DuplicateExtender interface {
+	Duplicate(ctx *URLContext, firstSource *url.URL)
+}
+
+// duplicates counts the URLs found again once in the visited set, keyed by
+// normalized URL, along with their sources if requested. It is updated by
+// the crawler's goroutine and may be read concurrently.
+type duplicates struct {
+	mu     sync.RWMutex
+	counts map[string]int
+	total  int
+
+	// The source of the first occurrence of each URL, and the sources of its
+	// duplicates, only with the TrackDuplicateSources option
+	first   map[string]*url.URL
+	sources map[string][]string
+}
+
+func newDuplicates(sources bool) *duplicates {
+	d := &duplicates{counts: make(map[string]int)}
+	if sources {
+		d.first, d.sources = make(map[string]*url.URL), make(map[string][]string)
+	}
+	return d
+}
+
+// Record the source of the first occurrence of the URL, if the sources are
+// tracked.
+func (d *duplicates) setFirst(ctx *URLContext) {
+	if d.first == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.first[ctx.normalizedURL.String()] = ctx.normalizedSourceURL
+}
+
+// Count a duplicate of the URL, and return the source of its first
+// occurrence, if known.
+func (d *duplicates) add(ctx *URLContext) *url.URL {
+	key := ctx.normalizedURL.String()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counts[key]++
+	d.total++
+	if d.sources == nil {
+		return nil
+	}
+	if src := ctx.normalizedSourceURL; src != nil {
+		d.sources[key] = append(d.sources[key], src.String())
+	}
+	return d.first[key]
+}
+
+// Return the summary of the duplicates, relative to the number of URLs
+// enqueued (i.e. ", 5 duplicates (ratio 0.25)").
+func (d *duplicates) summary(enqueued int) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var ratio float64
+	if n := d.total + enqueued; n > 0 {
+		ratio = float64(d.total) / float64(n)
+	}
+	return fmt.Sprintf(", %d duplicates (ratio %.2f)", d.total, ratio)
+}
+
+// Count a duplicate of the URL and notify it to the DuplicateExtender.
+func (c *Crawler) duplicate(ctx *URLContext) {
+	first := c.dups.add(ctx)
+	if first == nil && c.ancestry != nil {
+		first, _ = c.ancestry.parent(ctx.normalizedURL)
+	}
+	if c.dupExt != nil {
+		callExtender(c.opts(), ctx, "Duplicate", c.notifyError, func() {
+			c.dupExt.Duplicate(ctx, first)
+		})
+	}
+}
+
+// DuplicateCounts returns the number of times each URL was found again once
+// in the visited set, keyed by normalized URL, if the Options'
+// TrackDuplicates is set (nil otherwise). It is safe to call it during the
+// crawl, the counts of the last crawl are kept until the next run.
+func (c *Crawler) DuplicateCounts() map[string]int {
+	d := c.dups
+	if d == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	res := make(map[string]int, len(d.counts))
+	for u, n := range d.counts {
+		res[u] = n
+	}
+	return res
+}
+
+// DuplicateSources returns the normalized source URLs of the duplicates of
+// each URL, in the order they were found, keyed by normalized URL, if the
+// Options' TrackDuplicateSources is set (nil otherwise). The duplicate seeds
+// and enqueued URLs have no source. It is safe to call it during the crawl.
+func (c *Crawler) DuplicateSources() map[string][]string {
+	d := c.dups
+	if d == nil || d.sources == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	res := make(map[string][]string, len(d.sources))
+	for u, srcs := range d.sources {
+		res[u] = append([]string(nil), srcs...)
+	}
+	return res
+}
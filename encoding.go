@@ -0,0 +1,120 @@
+package gocrawl
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Decompress the body of the response according to its Content-Encoding
+// header, if the transport did not (e.g. when the Accept-Encoding header of
+// the request was set explicitly). The gzip and deflate encodings are
+// supported, the header is removed once the body is wrapped. An invalid
+// stream is reported as an error when reading the body. An error is
+// returned if an encoding is not supported, in which case the body is
+// unchanged.
+func decodeBody(res *http.Response) error {
+	ce := res.Header.Get("Content-Encoding")
+	if ce == "" || res.Body == nil {
+		return nil
+	}
+	var encs []string
+	for _, enc := range strings.Split(ce, ",") {
+		switch enc = strings.ToLower(strings.TrimSpace(enc)); enc {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate":
+			encs = append(encs, enc)
+		default:
+			return fmt.Errorf("unsupported Content-Encoding %s", enc)
+		}
+	}
+	res.Body = &decodedBody{body: res.Body, encs: encs}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return nil
+}
+
+// The decodedBody decompresses a body. The decoders are created on the first
+// read, the encodings being undone in the reverse order of their listing in
+// the Content-Encoding header.
+type decodedBody struct {
+	body    io.ReadCloser
+	encs    []string
+	r       io.Reader
+	closers []io.Closer
+	err     error
+}
+
+// Read reads the decompressed body.
+func (db *decodedBody) Read(p []byte) (int, error) {
+	if db.r == nil && db.err == nil {
+		db.err = db.init()
+	}
+	if db.err != nil {
+		return 0, db.err
+	}
+	return db.r.Read(p)
+}
+
+// Close closes the decoders and the body.
+func (db *decodedBody) Close() error {
+	for _, c := range db.closers {
+		c.Close()
+	}
+	return db.body.Close()
+}
+
+// Create the decoders.
+func (db *decodedBody) init() error {
+	var r io.Reader = db.body
+	for i := len(db.encs) - 1; i >= 0; i-- {
+		if db.encs[i] == "deflate" {
+			// The deflate encoding should be zlib-wrapped, but some servers
+			// send raw deflate data.
+			br := bufio.NewReader(r)
+			if isZlibHeader(br) {
+				zr, err := zlib.NewReader(br)
+				if err != nil {
+					return err
+				}
+				r = zr
+				db.closers = append(db.closers, zr)
+			} else {
+				fr := flate.NewReader(br)
+				r = fr
+				db.closers = append(db.closers, fr)
+			}
+			continue
+		}
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = gr
+		db.closers = append(db.closers, gr)
+	}
+
+	// Detect a body compressed twice
+	br := bufio.NewReader(r)
+	if b, _ := br.Peek(2); len(b) == 2 && b[0] == 0x1f && b[1] == 0x8b {
+		return ErrDoubleEncoded
+	}
+	db.r = br
+	return nil
+}
+
+// Indicates if the reader starts with a zlib header.
+func isZlibHeader(br *bufio.Reader) bool {
+	b, err := br.Peek(2)
+	if err != nil {
+		return false
+	}
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
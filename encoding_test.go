@@ -0,0 +1,87 @@
+package gocrawl
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zlibBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func flateBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	plain := []byte("<html><a href=\"/a\">a</a></html>")
+	cases := []struct {
+		enc     string
+		body    []byte
+		wantErr bool
+		readErr bool
+	}{
+		{"", plain, false, false},
+		{"identity", plain, false, false},
+		{"gzip", gzipBytes(plain), false, false},
+		{"x-gzip", gzipBytes(plain), false, false},
+		{"deflate", zlibBytes(plain), false, false},
+		{"deflate", flateBytes(plain), false, false},
+		{"deflate, gzip", gzipBytes(zlibBytes(plain)), false, false},
+		{"gzip", gzipBytes(gzipBytes(plain)), false, true},
+		{"gzip", plain, false, true},
+		{"br", plain, true, false},
+	}
+	for i, c := range cases {
+		res := &http.Response{
+			Header:        http.Header{"Content-Length": {"1"}},
+			Body:          ioutil.NopCloser(bytes.NewReader(c.body)),
+			ContentLength: int64(len(c.body)),
+		}
+		if c.enc != "" {
+			res.Header.Set("Content-Encoding", c.enc)
+		}
+		err := decodeBody(res)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%d: %s: want error %v, got %v", i, c.enc, c.wantErr, err)
+			continue
+		}
+		b, err := io.ReadAll(res.Body)
+		if c.wantErr {
+			if !bytes.Equal(b, c.body) {
+				t.Errorf("%d: %s: want the body unchanged", i, c.enc)
+			}
+			continue
+		}
+		if (err != nil) != c.readErr {
+			t.Errorf("%d: %s: want read error %v, got %v", i, c.enc, c.readErr, err)
+		} else if err == nil && !bytes.Equal(b, plain) {
+			t.Errorf("%d: %s: want %q, got %q", i, c.enc, plain, b)
+		}
+		if c.enc != "" && c.enc != "identity" && (res.Header.Get("Content-Encoding") != "" || res.ContentLength != -1) {
+			t.Errorf("%d: %s: want the encoding headers reset, got %v", i, c.enc, res.Header)
+		}
+	}
+}
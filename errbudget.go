@@ -0,0 +1,67 @@
+package gocrawl
+
+import (
+	"fmt"
+	"sync"
+)
+
+// The count of the fetch errors of a run, shared by the workers, for the
+// MaxErrors and MaxConsecutiveErrors options.
+type errorBudget struct {
+	mu          sync.Mutex
+	total       int
+	consecutive int
+	last        *CrawlError
+	stopped     bool
+}
+
+// Count the error if it is a fetch error.
+func (b *errorBudget) add(err *CrawlError) {
+	if err.Kind != CekFetch && err.Kind != CekHttpStatusCode {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total++
+	b.consecutive++
+	b.last = err
+}
+
+// Record a successful fetch, which resets the consecutive errors.
+func (b *errorBudget) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutive = 0
+}
+
+// Return the error that stops the crawl if one of the maximums is reached,
+// nil otherwise. A maximum of zero means no maximum. The budget is then
+// marked as having stopped the crawl.
+func (b *errorBudget) exceeded(maxTotal, maxConsecutive int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	switch {
+	case maxTotal > 0 && b.total >= maxTotal:
+		err = fmt.Errorf("%w: %d errors, last: %s", ErrMaxErrors, b.total, b.lastError())
+	case maxConsecutive > 0 && b.consecutive >= maxConsecutive:
+		err = fmt.Errorf("%w: %d consecutive errors, last: %s", ErrMaxErrors, b.consecutive, b.lastError())
+	}
+	b.stopped = b.stopped || err != nil
+	return err
+}
+
+// Indicates if the budget stopped the crawl.
+func (b *errorBudget) hasStopped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stopped
+}
+
+// Describe the last error, with its URL.
+func (b *errorBudget) lastError() string {
+	if b.last.Ctx == nil {
+		return b.last.Error()
+	}
+	return fmt.Sprintf("%s (%s)", b.last.Error(), b.last.Ctx.url)
+}
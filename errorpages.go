@@ -0,0 +1,34 @@
+package gocrawl
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Visit the HTML body of the response with a non-2xx status code, per the
+// VisitErrorPages option, and return the harvested links. The URL is still
+// notified with the VisitStatusError outcome, and is not counted as visited.
+func (w *worker) visitErrorPage(ctx *URLContext, res *http.Response) []harvestedLink {
+	var harvested interface{}
+	var links map[*url.URL]*LinkInfo
+	var doLinks bool
+
+	doc := w.loadDocument(ctx, res)
+	if w.opts.RespectMetaRobots {
+		ctx.robots = getRobotsDirectives(res, doc, w.robotUserAgent)
+	}
+	if !callExtender(w.opts, ctx, "Visit", w.notifyError, func() {
+		harvested, doLinks = w.opts.Extender.Visit(ctx, res, doc)
+	}) {
+		w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitPanicked})
+		return nil
+	}
+	if ctx.robots.noFollow {
+		harvested, doLinks = nil, false
+	}
+	if doLinks && doc != nil {
+		harvested, links = w.processLinks(doc)
+	}
+	w.notifyVisited(ctx, res, harvested, &VisitInfo{Outcome: VisitStatusError, FindLinks: doLinks && doc != nil})
+	return w.harvestedLinks(ctx, harvested, links)
+}
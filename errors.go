@@ -2,6 +2,10 @@ package gocrawl
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
 )
 
 var (
@@ -10,13 +14,58 @@ var (
 	// enqueue the redirect-to URL.
 	ErrEnqueueRedirect = errors.New("redirection not followed")
 
+	// ErrRedirectPolicy is wrapped by the errors returned when a redirection
+	// violates the redirection policy of the HttpClient (e.g. too many
+	// redirects for a robots.txt URL). A custom CheckRedirect function can
+	// wrap it so that the error is notified with the CekRedirectPolicy kind.
+	ErrRedirectPolicy = errors.New("redirection policy violated")
+
+	// ErrRedirectLoop is wrapped by the errors notified when a redirection
+	// closes a loop (e.g. /a to /b to /a, or an URL that redirects to
+	// itself), with the CekRedirectLoop kind. The error describes the chain
+	// of redirections.
+	ErrRedirectLoop = errors.New("redirect loop")
+
+	// ErrVetoed is wrapped by the errors returned by the DefaultExtender's
+	// Fetch when the BeforeFetch hook vetoes the request, so that the error
+	// is notified with the CekVetoed kind. A custom Fetch can wrap it too.
+	ErrVetoed = errors.New("request vetoed")
+
 	// ErrMaxVisits is returned when the maximum number of visits, as specified by the
 	// Options field MaxVisits, is reached.
 	ErrMaxVisits = errors.New("the maximum number of visits is reached")
 
+	// ErrMaxDuration is returned when the maximum duration of the crawl, as
+	// specified by the Options field MaxDuration, is reached.
+	ErrMaxDuration = errors.New("the maximum duration is reached")
+
+	// ErrMaxTotalBytes is returned when the maximum number of bytes read, as
+	// specified by the Options field MaxTotalBytes, is exceeded.
+	ErrMaxTotalBytes = errors.New("the maximum number of bytes is exceeded")
+
+	// ErrMaxErrors is wrapped by the error returned when the maximum number
+	// of fetch errors, as specified by the Options fields MaxErrors and
+	// MaxConsecutiveErrors, is reached. The error also describes the last
+	// fetch error.
+	ErrMaxErrors = errors.New("the maximum number of errors is reached")
+
 	// ErrInterrupted is returned when the crawler is manually stopped
 	// (via a call to Stop).
 	ErrInterrupted = errors.New("interrupted")
+
+	// ErrNotRunning is returned when URLs are enqueued via Crawler.Enqueue
+	// while the crawler is not running.
+	ErrNotRunning = errors.New("the crawler is not running")
+
+	// ErrDoubleEncoded is the read error of a decompressed response body that
+	// is still gzip-encoded, i.e. if the server compressed the body twice but
+	// announced a single Content-Encoding.
+	ErrDoubleEncoded = errors.New("body is still gzip-encoded once decoded")
+
+	// ErrProtocolRelative is returned when a protocol-relative URL (i.e.
+	// "//host/path") is enqueued without a source URL to take the scheme
+	// from, e.g. as a seed.
+	ErrProtocolRelative = errors.New("protocol-relative URL without a source URL")
 )
 
 // CrawlErrorKind indicated the kind of crawling error.
@@ -32,6 +81,19 @@ const (
 	CekParseURL
 	CekProcessLinks
 	CekParseRedirectURL
+	CekSkippedHostDown
+	CekDroppedURL
+	CekExtenderPanic
+	CekFetchRobots
+	CekRedirectPolicy
+	CekWriteWARC
+	CekFrontier
+	CekSeedProvider
+	CekWriteHAR
+	CekRedirectLoop
+	CekWriteReport
+	CekVetoed
+	CekVisitTimeout
 )
 
 var (
@@ -44,6 +106,19 @@ var (
 		CekParseURL:         "ParseURL",
 		CekProcessLinks:     "ProcessLinks",
 		CekParseRedirectURL: "ParseRedirectURL",
+		CekSkippedHostDown:  "SkippedHostDown",
+		CekDroppedURL:       "DroppedURL",
+		CekExtenderPanic:    "ExtenderPanic",
+		CekFetchRobots:      "FetchRobots",
+		CekRedirectPolicy:   "RedirectPolicy",
+		CekWriteWARC:        "WriteWARC",
+		CekFrontier:         "Frontier",
+		CekSeedProvider:     "SeedProvider",
+		CekWriteHAR:         "WriteHAR",
+		CekRedirectLoop:     "RedirectLoop",
+		CekWriteReport:      "WriteReport",
+		CekVetoed:           "Vetoed",
+		CekVisitTimeout:     "VisitTimeout",
 	}
 )
 
@@ -63,6 +138,13 @@ type CrawlError struct {
 	// The error kind.
 	Kind CrawlErrorKind
 
+	// The status code of the response, for the CekHttpStatusCode kind.
+	StatusCode int
+
+	// The response, for the CekHttpStatusCode kind, i.e. to get its headers.
+	// Its body must not be read, it is processed by the crawler.
+	Response *http.Response
+
 	msg string
 }
 
@@ -74,12 +156,95 @@ func (ce CrawlError) Error() string {
 	return ce.msg
 }
 
+// Unwrap returns the underlying error, if any, so that the CrawlError
+// works with errors.Is and errors.As.
+func (ce CrawlError) Unwrap() error {
+	return ce.Err
+}
+
 // Create a new CrawlError based on a source error.
 func newCrawlError(ctx *URLContext, e error, kind CrawlErrorKind) *CrawlError {
-	return &CrawlError{ctx, e, kind, ""}
+	return &CrawlError{ctx, e, kind, 0, nil, ""}
 }
 
 // Create a new CrawlError with the specified message.
 func newCrawlErrorMessage(ctx *URLContext, msg string, kind CrawlErrorKind) *CrawlError {
-	return &CrawlError{ctx, nil, kind, msg}
+	return &CrawlError{ctx, nil, kind, 0, nil, msg}
+}
+
+// Create a new CrawlError of the CekHttpStatusCode kind for the response.
+func newStatusCodeError(ctx *URLContext, res *http.Response) *CrawlError {
+	return &CrawlError{ctx, nil, CekHttpStatusCode, res.StatusCode, res, res.Status}
+}
+
+// ExtenderPanic is the underlying error of a CrawlError of kind
+// CekExtenderPanic. It holds the value and the stack trace of a panic
+// recovered in an extender method.
+type ExtenderPanic struct {
+	// The name of the extender method that panicked.
+	Method string
+
+	// The value passed to panic.
+	Value interface{}
+
+	// The stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+// Error implements of the error interface for ExtenderPanic.
+func (ep *ExtenderPanic) Error() string {
+	return fmt.Sprintf("panic in %s: %v", ep.Method, ep.Value)
+}
+
+// The error of a request vetoed by the BeforeFetch hook, which wraps the
+// error of the hook and matches ErrVetoed.
+type vetoError struct {
+	err error
+}
+
+func (ve *vetoError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrVetoed, ve.err)
+}
+
+func (ve *vetoError) Unwrap() error {
+	return ve.err
+}
+
+func (ve *vetoError) Is(target error) bool {
+	return target == ErrVetoed
+}
+
+// Call the extender method f, recovering from a panic if the RecoverPanics
+// option is set, in which case the panic is notified via notify. Returns
+// false if the method panicked.
+func callExtender(opts *Options, ctx *URLContext, method string, notify func(*CrawlError), f func()) (ok bool) {
+	if opts.RecoverPanics {
+		defer func() {
+			if v := recover(); v != nil {
+				ok = false
+				notify(newCrawlError(ctx, &ExtenderPanic{method, v, debug.Stack()}, CekExtenderPanic))
+			}
+		}()
+	}
+	f()
+	return true
+}
+
+// Recover from a panic in the Error extender method, which cannot be notified,
+// so it is logged and dropped.
+func dropErrorPanic(logFunc func(LogFlags, string, ...interface{})) {
+	if v := recover(); v != nil {
+		logFunc(LogError, "ERROR panic in Error dropped: %v", v)
+	}
+}
+
+// OptionsError is returned by Options.Validate and Crawler.Run when the
+// Options are invalid. It lists all the problems found.
+type OptionsError struct {
+	Problems []string
+}
+
+// Error implements of the error interface for OptionsError.
+func (oe *OptionsError) Error() string {
+	return "invalid options: " + strings.Join(oe.Problems, "; ")
 }
@@ -0,0 +1,184 @@
+package gocrawl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CrawlEventKind indicates what happened in a CrawlEvent.
+type CrawlEventKind uint8
+
+// The various kinds of crawl events.
+const (
+	// CevEnqueued means the URL was enqueued (robots.txt URLs excluded).
+	CevEnqueued CrawlEventKind = iota
+
+	// CevFetchStart means the request of the URL is about to be sent.
+	CevFetchStart
+
+	// CevFetchDone means the fetch of the URL is done, with the Status of
+	// the response (zero if it failed, the Err is then set) and the
+	// Duration of the fetch.
+	CevFetchDone
+
+	// CevVisited means the URL was visited.
+	CevVisited
+
+	// CevError means an error occurred, with its ErrKind and its Err.
+	CevError
+
+	// CevHostStarted means a worker started crawling the Host.
+	CevHostStarted
+
+	// CevHostFinished means the worker of the Host stopped.
+	CevHostFinished
+
+	// CevCrawlEnd is the last event of a run, with the number of events
+	// Dropped and the error returned by Run, if any.
+	CevCrawlEnd
+)
+
+var lookupCrawlEventKind = [...]string{
+	CevEnqueued:     "enqueued",
+	CevFetchStart:   "fetch start",
+	CevFetchDone:    "fetch done",
+	CevVisited:      "visited",
+	CevError:        "error",
+	CevHostStarted:  "host started",
+	CevHostFinished: "host finished",
+	CevCrawlEnd:     "crawl end",
+}
+
+func (k CrawlEventKind) String() string {
+	return lookupCrawlEventKind[k]
+}
+
+// CrawlEvent is an event of the crawl, sent on the channel returned by the
+// Crawler's Events method. Only the fields relevant to its Kind are set. The
+// Tags are those of the URL (see the URLContext's Tags method), or of the
+// Host for the host events, and must not be modified.
+type CrawlEvent struct {
+	Kind     CrawlEventKind
+	Time     time.Time
+	URL      string
+	Host     string
+	Status   int
+	Duration time.Duration
+	ErrKind  CrawlErrorKind
+	Err      error
+	Dropped  int64
+	Tags     map[string]string
+}
+
+// The event stream of a run. The events are dropped when its buffer is
+// full, so that the crawl never waits for the consumer.
+type eventStream struct {
+	ch      chan CrawlEvent
+	dropped int64
+}
+
+func newEventStream(buffer int) *eventStream {
+	if buffer < 1 {
+		// Room for the crawl end event
+		buffer = 1
+	}
+	return &eventStream{ch: make(chan CrawlEvent, buffer)}
+}
+
+// Send the event, or drop it if the buffer is full. It is a no-op on a nil
+// stream.
+func (s *eventStream) emit(ev CrawlEvent) {
+	if s == nil {
+		return
+	}
+	ev.Time = time.Now()
+	select {
+	case s.ch <- ev:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Send the crawl end event, dropping the oldest events to make room for it
+// if needed, and close the channel. Must be called once nothing else sends
+// events.
+func (s *eventStream) end(err error) {
+	ev := CrawlEvent{Kind: CevCrawlEnd, Time: time.Now(), Err: err}
+	for {
+		ev.Dropped = atomic.LoadInt64(&s.dropped)
+		select {
+		case s.ch <- ev:
+			close(s.ch)
+			return
+		default:
+			select {
+			case <-s.ch:
+				atomic.AddInt64(&s.dropped, 1)
+			default:
+				// Read by the consumer meanwhile
+			}
+		}
+	}
+}
+
+// Send the event of the URL.
+func (s *eventStream) emitURL(kind CrawlEventKind, ctx *URLContext) {
+	if s != nil {
+		s.emit(urlEvent(kind, ctx))
+	}
+}
+
+// Send the event of the fetch done for the URL.
+func (s *eventStream) emitFetch(ctx *URLContext, status int, d time.Duration, err error) {
+	if s != nil {
+		ev := urlEvent(CevFetchDone, ctx)
+		ev.Status, ev.Duration, ev.Err = status, d, err
+		s.emit(ev)
+	}
+}
+
+// Send the event of the crawl error.
+func (s *eventStream) emitError(err *CrawlError) {
+	if s != nil {
+		ev := urlEvent(CevError, err.Ctx)
+		ev.ErrKind, ev.Err = err.Kind, err
+		s.emit(ev)
+	}
+}
+
+// Return the event for the URL.
+func urlEvent(kind CrawlEventKind, ctx *URLContext) CrawlEvent {
+	if ctx == nil || ctx.url == nil {
+		return CrawlEvent{Kind: kind}
+	}
+	return CrawlEvent{Kind: kind, URL: ctx.url.String(), Host: ctx.normalizedURL.Host, Tags: ctx.tags}
+}
+
+// Events returns the channel of the events of the crawl, if the Options'
+// EmitEvents is set, nil otherwise. It is meant to be called before Run, to
+// receive all the events of the run, or during the run. The channel is
+// closed when Run returns, right after the CevCrawlEnd event, and a new
+// channel is returned for the next run. The events are dropped if the
+// channel's buffer (see the EventBuffer option) is full.
+func (c *Crawler) Events() <-chan CrawlEvent {
+	c.enqMu.Lock()
+	defer c.enqMu.Unlock()
+	if c.events == nil {
+		if !c.opts().EmitEvents {
+			return nil
+		}
+		c.events = newEventStream(c.opts().EventBuffer)
+	}
+	return c.events.ch
+}
+
+// End the event stream of the run, if any, with the error returned by Run.
+func (c *Crawler) endEvents(err error) {
+	c.enqMu.Lock()
+	s := c.events
+	c.events = nil
+	c.enqMu.Unlock()
+	if s != nil {
+		s.end(err)
+	}
+}
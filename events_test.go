@@ -0,0 +1,116 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/missing">c</a>`)
+		case "/a", "/b":
+			fmt.Fprint(w, "ok")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	opts := NewOptions(new(DefaultExtender))
+	opts.CrawlDelay = 0
+	opts.EmitEvents = true
+	c := NewCrawlerWithOptions(opts)
+	events := c.Events()
+	done := make(chan []CrawlEvent)
+	go func() {
+		var evs []CrawlEvent
+		for ev := range events {
+			evs = append(evs, ev)
+		}
+		done <- evs
+	}()
+	if err := c.Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	var evs []CrawlEvent
+	select {
+	case evs = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("want the events channel to be closed when Run returns")
+	}
+	counts := make(map[CrawlEventKind]int)
+	statuses := make(map[string]int)
+	for _, ev := range evs {
+		counts[ev.Kind]++
+		if ev.Kind == CevFetchDone {
+			statuses[ev.URL] = ev.Status
+		}
+		if ev.Kind == CevError && ev.ErrKind != CekHttpStatusCode {
+			t.Errorf("want a status code error, got %v", ev.ErrKind)
+		}
+	}
+	want := map[CrawlEventKind]int{
+		CevEnqueued:     4,
+		CevFetchStart:   5, // with the robots.txt
+		CevFetchDone:    5,
+		CevVisited:      3,
+		CevError:        1,
+		CevHostStarted:  1,
+		CevHostFinished: 1,
+		CevCrawlEnd:     1,
+	}
+	for k, n := range want {
+		if counts[k] != n {
+			t.Errorf("want %d %s events, got %d", n, k, counts[k])
+		}
+	}
+	if st := statuses[srv.URL+"/missing"]; st != http.StatusNotFound {
+		t.Errorf("want a 404 fetch, got %d", st)
+	}
+	if last := evs[len(evs)-1]; last.Kind != CevCrawlEnd || last.Dropped != 0 {
+		t.Errorf("want a crawl end event without drops last, got %+v", last)
+	}
+
+	// A new channel for the next run
+	if c.Events() == events {
+		t.Error("want a new events channel for the next run")
+	}
+}
+
+func TestEventsDropped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	opts := NewOptions(new(DefaultExtender))
+	opts.CrawlDelay = 0
+	opts.EmitEvents = true
+	opts.EventBuffer = 1
+	c := NewCrawlerWithOptions(opts)
+	events := c.Events()
+	// Nobody reads the events during the run, it must not block
+	if err := c.Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	var evs []CrawlEvent
+	for ev := range events {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 1 || evs[0].Kind != CevCrawlEnd || evs[0].Dropped == 0 {
+		t.Errorf("want only the crawl end event with dropped events, got %+v", evs)
+	}
+
+	// No events without the option
+	opts.EmitEvents = false
+	if ch := NewCrawlerWithOptions(opts).Events(); ch != nil {
+		t.Error("want no events channel without the EmitEvents option")
+	}
+}
@@ -1,7 +1,10 @@
 package gocrawl_test
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"regexp"
 	"time"
 
@@ -57,3 +60,38 @@ func ExampleCrawl() {
 
 	// xOutput: voluntarily fail to see log output
 }
+
+// Extender that writes the crawl graph as a DOT file, as the links are
+// harvested.
+type DOTExtender struct {
+	gocrawl.DefaultExtender
+	w io.Writer
+}
+
+// Edge is called for each link harvested from a visited page.
+func (x *DOTExtender) Edge(from, to *gocrawl.URLContext, followed bool) {
+	style := "solid"
+	if !followed {
+		style = "dashed"
+	}
+	fmt.Fprintf(x.w, "\t%q -> %q [style=%s];\n", from.NormalizedURL(), to.NormalizedURL(), style)
+}
+
+func ExampleEdgeExtender() {
+	f, err := os.Create("crawl.dot")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "digraph crawl {")
+	opts := gocrawl.NewOptions(&DOTExtender{w: f})
+	opts.RobotUserAgent = "Example"
+	opts.UserAgent = "Mozilla/5.0 (compatible; Example/1.0; +http://example.com)"
+	opts.CrawlDelay = 1 * time.Second
+	opts.MaxVisits = 2
+
+	c := gocrawl.NewCrawlerWithOptions(opts)
+	c.Run("https://duckduckgo.com/")
+	fmt.Fprintln(f, "}")
+}
@@ -1,30 +1,583 @@
 package gocrawl
 
 import (
-	"errors"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
 // DelayInfo contains the delay configuration: the Options delay, the
-// Robots.txt delay, and the last delay used.
+// Robots.txt delay, and the last delay used. It also holds the information
+// of the most recent fetches of the host (the oldest first), the adaptive
+// delay configuration from the Options, if any, and the policy used to
+// combine the Options and robots.txt delays.
 type DelayInfo struct {
-	OptsDelay   time.Duration
-	RobotsDelay time.Duration
-	LastDelay   time.Duration
+	OptsDelay         time.Duration
+	RobotsDelay       time.Duration
+	LastDelay         time.Duration
+	RecentFetches     []*FetchInfo
+	AdaptiveDelay     *AdaptiveDelay
+	RobotsDelayPolicy RobotsDelayPolicy
+}
+
+// Delay returns the combination of the Options and robots.txt delays, based
+// on the RobotsDelayPolicy.
+func (di *DelayInfo) Delay() time.Duration {
+	switch di.RobotsDelayPolicy {
+	case RobotsDelayUseRobots:
+		if di.RobotsDelay > 0 {
+			return di.RobotsDelay
+		}
+	case RobotsDelayUseMax:
+		if di.RobotsDelay > di.OptsDelay {
+			return di.RobotsDelay
+		}
+	}
+	return di.OptsDelay
+}
+
+// AdaptiveDelay configures the built-in adaptive crawl delay, that slows
+// down the crawling of a host when its responses get slower or fail.
+type AdaptiveDelay struct {
+	// MinDelay and MaxDelay are the bounds of the computed delay. A zero
+	// MaxDelay means no upper bound.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// Multiplier is applied to the 90th percentile of the recent fetch
+	// durations. Defaults to DefaultAdaptiveMultiplier if zero.
+	Multiplier float64
+
+	// Window is the number of recent fetches considered. Defaults to
+	// DefaultAdaptiveWindow if zero.
+	Window int
+}
+
+// Default values of the AdaptiveDelay configuration.
+const (
+	DefaultAdaptiveMultiplier float64 = 2
+	DefaultAdaptiveWindow     int     = 10
+)
+
+// Compute returns the adaptive delay for the delay information. It is the
+// greatest of the Options delay and the 90th percentile of the recent fetch
+// durations multiplied by the Multiplier, increased by the proportion of
+// failed recent fetches (a fetch error, a 429 or a 5xx status code), and
+// bounded by MinDelay and MaxDelay. The robots.txt delay is used as a floor,
+// unless the RobotsDelayPolicy is RobotsDelayUseOptions.
+func (ad *AdaptiveDelay) Compute(di *DelayInfo) time.Duration {
+	k := ad.Multiplier
+	if k == 0 {
+		k = DefaultAdaptiveMultiplier
+	}
+
+	var durs []time.Duration
+	var errs int
+	for _, fi := range di.RecentFetches {
+		durs = append(durs, fi.Duration)
+		if fi.StatusCode == 0 || fi.StatusCode == http.StatusTooManyRequests || fi.StatusCode >= 500 {
+			errs++
+		}
+	}
+
+	d := di.OptsDelay
+	if len(durs) > 0 {
+		sort.Slice(durs, func(i, j int) bool { return durs[i] < durs[j] })
+		p90 := durs[(len(durs)*9+9)/10-1]
+		if lat := time.Duration(float64(p90) * k); lat > d {
+			d = lat
+		}
+		d += time.Duration(float64(d) * float64(errs) / float64(len(durs)))
+	}
+
+	if d < ad.MinDelay {
+		d = ad.MinDelay
+	}
+	if ad.MaxDelay > 0 && d > ad.MaxDelay {
+		d = ad.MaxDelay
+	}
+	if d < di.RobotsDelay && di.RobotsDelayPolicy != RobotsDelayUseOptions {
+		d = di.RobotsDelay
+	}
+	return d
 }
 
 // FetchInfo contains the fetch information: the duration of the fetch,
 // the returned status code, whether or not it was a HEAD request,
-// and whether or not it was a robots.txt request.
+// and whether or not it was a robots.txt request. The size is the number of
+// bytes read from the response body, it is complete once the body has been
+// read (i.e. when the URL is visited). The timings of the phases of the
+// fetch are set if the Options' TraceFetch is set, nil otherwise. The
+// user-agent is the one the URL was requested with. The total size is the
+// size of the resource per the response to a range probe (see
+// FetchRangeProbe), -1 if it is unknown or if the URL is not probed. Cached
+// indicates that the response was served from the response cache (see the
+// Options' ResponseCacheBytes), without a request.
 type FetchInfo struct {
 	Ctx           *URLContext
 	Duration      time.Duration
 	StatusCode    int
 	IsHeadRequest bool
+	Size          int64
+	Timings       *FetchTimings
+	UserAgent     string
+	TotalSize     int64
+	Cached        bool
+}
+
+// FilterResult is the filtering decision returned by the FilterURL method
+// of a FilterExtender. Besides allowing or not the URL, it can override
+// some settings for this specific URL.
+type FilterResult struct {
+	// Allow indicates if the URL should be enqueued.
+	Allow bool
+
+	// HeadBeforeGet, if not nil, overrides the URLContext's HeadBeforeGet
+	// field (which is otherwise initialized from the Options).
+	HeadBeforeGet *bool
+
+	// HeadOnly, if not nil, overrides the URLContext's HeadOnly field.
+	HeadOnly *bool
+
+	// FetchMode, if not nil, overrides the URLContext's FetchMode field.
+	FetchMode *FetchMode
+
+	// Priority is the priority of the URL within its host's queue. If
+	// it is zero, the URL keeps its current priority (e.g. the one set
+	// via Crawler.Enqueue).
+	Priority int
+
+	// DelayOverride, if not nil, is the crawl delay used after fetching
+	// this URL, instead of the one returned by the ComputeDelay extender
+	// method. Like the computed delay, it is floored by the robots.txt
+	// crawl delay (capped by MaxRobotsDelay, and unless the
+	// RobotsDelayPolicy is RobotsDelayUseOptions) and jittered per the
+	// DelayJitter option.
+	DelayOverride *time.Duration
+}
+
+// FilterExtender is an optional interface that an Extender can implement
+// to return a FilterResult instead of a simple bool. If the Extender
+// implements it, FilterURL is called instead of Filter.
+type FilterExtender interface {
+	FilterURL(*URLContext, bool) FilterResult
+}
+
+// EdgeExtender is an optional interface that an Extender can implement to
+// receive the links harvested from the visited pages, as edges of the crawl
+// graph. Edge is called for each link that complies with the scheme and same
+// host policies, whether or not it is followed (i.e. enqueued). The
+// identical links of a page are reported once.
+type EdgeExtender interface {
+	Edge(from, to *URLContext, followed bool)
+}
+
+// HostStopReason indicates why the worker of a host stopped.
+type HostStopReason uint8
+
+// The various host stop reasons.
+const (
+	// HostStopIdle means the worker was idle for the WorkerIdleTTL delay.
+	HostStopIdle HostStopReason = iota
+
+	// HostStopRetired means the worker was retired to free its slot for a
+	// waiting host, based on the MaxConcurrentHosts option.
+	HostStopRetired
+
+	// HostStopCrawlEnd means the crawl ended, because there were no more
+	// URLs to process, a limit was reached or the crawler was stopped.
+	HostStopCrawlEnd
+
+	// HostStopErrors means the crawl was stopped by the error budget, based
+	// on the MaxErrors and MaxConsecutiveErrors options.
+	HostStopErrors
+
+	// HostStopHostDown means the worker stopped, idle or at the end of the
+	// crawl, while the host was considered down by the circuit breaker,
+	// based on the HostFailureThreshold option: its last URLs were skipped.
+	HostStopHostDown
+)
+
+var lookupHostStopReason = [...]string{
+	HostStopIdle:     "idle",
+	HostStopRetired:  "retired",
+	HostStopCrawlEnd: "crawl end",
+	HostStopErrors:   "errors",
+	HostStopHostDown: "host down",
+}
+
+func (r HostStopReason) String() string {
+	return lookupHostStopReason[r]
+}
+
+// HostExtender is an optional interface that an Extender can implement to
+// observe the lifecycle of the host workers. HostStarted is called when a
+// worker is launched for a host, including when a URL arrives for a host
+// whose worker was stopped. HostStopped is called when the worker stops,
+// with the number of URLs still waiting in its queue, which are not
+// processed (it is normally 0, except at the end of the crawl). HostStopped
+// is called from the worker's goroutine, so it may be called concurrently.
+type HostExtender interface {
+	HostStarted(host string)
+	HostStopped(host string, reason HostStopReason, pending int)
+}
+
+// RobotsStatus indicates how the robots.txt of a host was handled.
+type RobotsStatus uint8
+
+// The various robots.txt statuses.
+const (
+	// RobotsNotFetched means the robots.txt was not processed, e.g. because
+	// its URL is invalid.
+	RobotsNotFetched RobotsStatus = iota
+
+	// RobotsFetched means the robots.txt was fetched (or returned by the
+	// RequestRobots extender method) and parsed.
+	RobotsFetched
+
+	// RobotsFailed means the robots.txt could not be fetched or parsed, the
+	// RobotsErrorPolicy applies.
+	RobotsFailed
+
+	// RobotsIgnored means the robots.txt handling is disabled by the
+	// IgnoreRobots or DryRunSkipRobots option.
+	RobotsIgnored
+)
+
+var lookupRobotsStatus = [...]string{
+	RobotsNotFetched: "not fetched",
+	RobotsFetched:    "fetched",
+	RobotsFailed:     "failed",
+	RobotsIgnored:    "ignored",
+}
+
+func (s RobotsStatus) String() string {
+	return lookupRobotsStatus[s]
+}
+
+// HostStats holds the statistics of a host, for the URLs processed by a
+// worker of the host.
+type HostStats struct {
+	// Visits is the number of URLs visited (with a 2xx response).
+	Visits int
+
+	// Errors is the number of errors notified for the host, by kind.
+	Errors map[CrawlErrorKind]int
+
+	// Bytes is the number of bytes read from the response bodies.
+	Bytes int64
+
+	// Fetches is the number of requests, including the robots.txt and the
+	// HEAD requests, and FetchTime their total duration.
+	Fetches   int
+	FetchTime time.Duration
+
+	// AvgDelay is the average crawl delay applied after the fetches.
+	AvgDelay time.Duration
+
+	// Robots is the status of the robots.txt of the host.
+	Robots RobotsStatus
+
+	// Parked is the number of times the host was parked after a 503
+	// response to its robots.txt request, per the RobotsUnavailableRetries
+	// option, or outside of its CrawlWindows, and ParkedTime the total delay
+	// it was parked for.
+	Parked     int
+	ParkedTime time.Duration
+
+	// CacheHits is the number of requests served from the response cache,
+	// per the ResponseCacheBytes option. They are not counted in Fetches.
+	CacheHits int
+
+	// HeadFallbacks is the number of HEAD requests rejected by the host
+	// with a 405 or 501 status code and followed by a GET request. The HEAD
+	// requests of the host are skipped afterwards, so it is normally 0 or 1.
+	HeadFallbacks int
+
+	// Tags are the tags of the host, per the HostTags option, without those
+	// attached to its URLs. The map is shared and must not be modified.
+	Tags map[string]string
+}
+
+// HostCompleteExtender is an optional interface that an Extender can
+// implement to get the statistics of each host once it is complete, i.e.
+// when its worker stops with an empty queue and none of its URLs blocked on
+// a full queue (see BlockOnFull): on idle, when retired, or when the crawl
+// ends because there are no more URLs to process (not when it is stopped by
+// a limit or by Stop). If a worker is launched again for the host,
+// HostComplete is called again at its next completion, with the statistics
+// of this worker only. It is called from the worker's goroutine, so it may be
+// called concurrently, and before the End extender method.
+type HostCompleteExtender interface {
+	HostComplete(host string, stats HostStats)
+}
+
+// DisallowedKind indicates why a URL was rejected by the crawler.
+type DisallowedKind uint8
+
+// The various kinds of rejections.
+const (
+	// DisRobots means the URL is disallowed by the robots.txt of its host,
+	// the detail is the matched rule (e.g. "Disallow: /private/").
+	DisRobots DisallowedKind = iota
+
+	// DisRobotsError means the robots.txt of the host could not be fetched
+	// and the RobotsErrorPolicy disallows all its URLs.
+	DisRobotsError
+
+	// DisScheme means the scheme of the URL is not in the AllowedSchemes,
+	// the detail is the scheme.
+	DisScheme
+
+	// DisPattern means the URL is rejected by the IncludePatterns or the
+	// ExcludePatterns, the detail tells which.
+	DisPattern
+
+	// DisMaxDepth means the URL is beyond the MaxPaginationDepth, the
+	// detail is its pagination depth.
+	DisMaxDepth
+
+	// DisNotAbsolute means the URL is not absolute.
+	DisNotAbsolute
+
+	// DisHostPolicy means the URL is rejected by the SameHostOnly policy,
+	// the detail is its host.
+	DisHostPolicy
+
+	// DisPending means the URL is dropped by the PendingPolicy because the
+	// queue of its host is full.
+	DisPending
+
+	// DisHostMaxVisits means the maximum number of visits of the host,
+	// per the PerHost options, is reached.
+	DisHostMaxVisits
+
+	// DisTrap means the URL looks like a crawler trap per the MaxURLLength,
+	// MaxPathSegments or MaxRepeatedSegments options, the detail tells which.
+	DisTrap
+
+	// DisScope means the URL is not under the path prefixes of the
+	// ScopePrefixes or SamePathPrefixOnly options, the detail is its path.
+	DisScope
+
+	// DisQueryVariants means the path of the URL already has the maximum
+	// number of query strings per the MaxQueryVariantsPerPath option, the
+	// detail is the query string of the URL.
+	DisQueryVariants
+
+	// DisDuplicate means the URL was harvested while it is still waiting in
+	// the queue of its host, or being fetched, and the Filter allowed it
+	// again as a visited URL. Only the URLs enqueued explicitly (e.g. via
+	// the EnqueueChan) may be pending more than once.
+	DisDuplicate
+)
+
+var lookupDisallowedKind = [...]string{
+	DisRobots:        "robots",
+	DisRobotsError:   "robots error",
+	DisScheme:        "scheme",
+	DisPattern:       "pattern",
+	DisMaxDepth:      "max depth",
+	DisNotAbsolute:   "not absolute",
+	DisHostPolicy:    "host policy",
+	DisPending:       "pending",
+	DisHostMaxVisits: "host max visits",
+	DisTrap:          "trap",
+	DisScope:         "scope",
+	DisQueryVariants: "query variants",
+	DisDuplicate:     "duplicate",
+}
+
+func (k DisallowedKind) String() string {
+	return lookupDisallowedKind[k]
+}
+
+// DisallowedExtender is an optional interface that an Extender can implement
+// to know why the URLs are rejected by the crawler. When it is implemented,
+// DisallowedReason is called instead of the Disallowed extender method, for
+// the robots.txt rejections and for all other rejections of the crawler's
+// policies, so that each harvested or enqueued URL is either filtered out by
+// the Filter extender method, dropped by the RewriteURL option, notified via
+// DisallowedReason, or fetched. It is called from the crawler's goroutine or
+// the worker's, so it may be called concurrently.
+type DisallowedExtender interface {
+	DisallowedReason(ctx *URLContext, reason DisallowedKind, detail string)
+}
+
+// VisitOutcome indicates how the processing of a fetched URL ended.
+type VisitOutcome uint8
+
+// The various visit outcomes.
+const (
+	// VisitDone means the URL was visited by the Visit extender method or
+	// a registered visitor.
+	VisitDone VisitOutcome = iota
+
+	// VisitHeadOnly means the URL got a 2xx response to its HEAD request,
+	// and no GET request, as it is HeadOnly or the GET was skipped.
+	VisitHeadOnly
+
+	// VisitGetSkipped means the GET request was skipped after a HEAD
+	// request whose status code is not 2xx.
+	VisitGetSkipped
+
+	// VisitStatusError means the status code of the response is not 2xx.
+	VisitStatusError
+
+	// VisitFetchError means the Fetch extender method returned an error.
+	VisitFetchError
+
+	// VisitRedirected means the redirection of the URL was enqueued, as
+	// requested by returning ErrEnqueueRedirect from Fetch.
+	VisitRedirected
+
+	// VisitPanicked means the visit panicked, with RecoverPanics set.
+	VisitPanicked
+
+	// VisitRangeProbe means the range probe of the URL succeeded (see
+	// FetchRangeProbe), it is not visited.
+	VisitRangeProbe
+
+	// VisitRechecked means the visited URL was rechecked with a GET request
+	// whose body is not read, as its server rejected the HEAD request (see
+	// RecheckGet).
+	VisitRechecked
+
+	// VisitRedirectLoop means the redirection of the URL was not followed,
+	// as it closes a redirect loop (see URLContext.IsRedirectLoop).
+	VisitRedirectLoop
+
+	// VisitNotModified means the response is a 304 (Not Modified), with the
+	// CountOnlyChangedVisits option, it is not visited.
+	VisitNotModified
+
+	// VisitTimedOut means the parsing and visit of the URL did not complete
+	// within the VisitTimeout, its links are not harvested.
+	VisitTimedOut
+)
+
+var lookupVisitOutcome = [...]string{
+	VisitDone:         "done",
+	VisitHeadOnly:     "head only",
+	VisitGetSkipped:   "get skipped",
+	VisitStatusError:  "status error",
+	VisitFetchError:   "fetch error",
+	VisitRedirected:   "redirected",
+	VisitPanicked:     "panicked",
+	VisitRangeProbe:   "range probe",
+	VisitRechecked:    "rechecked",
+	VisitRedirectLoop: "redirect loop",
+	VisitNotModified:  "not modified",
+	VisitTimedOut:     "timed out",
+}
+
+func (o VisitOutcome) String() string {
+	return lookupVisitOutcome[o]
+}
+
+// VisitInfo describes the outcome of the processing of a fetched URL.
+type VisitInfo struct {
+	// Outcome indicates how the processing ended.
+	Outcome VisitOutcome
+
+	// StatusCode is the status code of the last response, 0 if the fetch
+	// failed.
+	StatusCode int
+
+	// Header is a copy of the header of the last response, nil if the
+	// fetch failed.
+	Header http.Header
+
+	// Size is the number of bytes read from the response body.
+	Size int64
+
+	// Harvested is the number of harvested URLs (or values, for the maps
+	// and slices of URLs).
+	Harvested int
+
+	// FindLinks indicates if the links of the document were processed by
+	// the crawler, as requested by the visit.
+	FindLinks bool
+
+	// Err is the error returned by the Fetch extender method, if any.
+	Err error
+}
+
+// VisitedExtender is an optional interface that an Extender can implement to
+// get the outcome of each fetched URL. When it is implemented, VisitedInfo
+// is called instead of the Visited extender method, exactly once for each
+// URL for which the Fetch extender method was called (robots.txt URLs
+// excepted), whatever the outcome: after the visit, and also when the fetch
+// failed, the status code is not 2xx, or the GET request was skipped. The
+// URLs that are not fetched (e.g. disallowed by robots.txt, skipped because
+// the host is down, or left when the crawler stops) are not reported.
+// VisitedInfo is called from the worker's goroutine, or from the visitor's
+// with the VisitWorkers option, so it may be called concurrently, unless the
+// OrderedCallbacks option serializes the calls (see Extender.Enqueued).
+type VisitedExtender interface {
+	VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo)
+}
+
+// DryRunExtender is an optional interface that an Extender can implement to
+// get the URLs that would have been fetched with the DryRun option, once
+// they passed the filters and the robots.txt policies. It is called from
+// the worker's goroutine, so it may be called concurrently for distinct
+// hosts.
+type DryRunExtender interface {
+	WouldFetch(ctx *URLContext)
+}
+
+// BeforeFetchExtender is an optional interface that an Extender can
+// implement to inspect the requests just before they are sent by the
+// DefaultExtender's Fetch, e.g. to sign them or to veto them based on
+// dynamic rules. BeforeFetch is called for the HEAD, GET and robots.txt
+// requests (see the URLContext's IsRobotsURL), once the request is fully
+// built, with its user-agent and the other headers of the fetch. The
+// returned request is sent instead of req, it may be req itself, modified
+// or not. If it returns an error, the request is not sent, and the error is
+// notified via the Error extender method with the CekVetoed kind (it wraps
+// ErrVetoed and the returned error), without counting as a failure of the
+// host. It is not called for the responses served from the response cache
+// (see ResponseCacheBytes), nor by a custom Fetch that does not call the
+// DefaultExtender's. It is called from the worker's goroutine, so it may be
+// called concurrently.
+type BeforeFetchExtender interface {
+	BeforeFetch(ctx *URLContext, req *http.Request) (*http.Request, error)
+}
+
+// VisitCtxExtender is an optional interface that an Extender can implement
+// to visit the URLs with a context, which is called instead of the Visit
+// extender method (the registered visitors still take precedence). With
+// the VisitTimeout option, the context is done once the deadline of the
+// visit expires, as the worker then abandons the visit and moves on, so a
+// long visit should return when it is done. The result of an abandoned
+// visit is dropped, and the URLContext must not be used once the context
+// is done: a visit (or a Visit extender method, which has no context) that
+// keeps running runs concurrently with the next visits of the same host, and
+// its changes to the URLContext (e.g. SetUnchanged) race with the crawler.
+// Without VisitTimeout, the context is never done. It is called from the
+// worker's goroutine (or the visitor's with the VisitWorkers option), so it
+// may be called concurrently.
+type VisitCtxExtender interface {
+	VisitCtx(c context.Context, ctx *URLContext, res *http.Response, doc *goquery.Document) (harvested interface{}, findLinks bool)
+}
+
+// Edge is a link between two pages of the crawl graph, in normalized form,
+// as recorded when the Options' RecordEdges is set.
+type Edge struct {
+	From     *url.URL
+	To       *url.URL
+	Followed bool
 }
 
 // Extender defines the extension methods required by the crawler.
@@ -47,23 +600,41 @@ type Extender interface {
 	RequestRobots(*URLContext, string) ([]byte, bool)
 	FetchedRobots(*URLContext, *http.Response)
 	Filter(*URLContext, bool) bool
+
+	// Enqueued is called once the URL is enqueued, before the Visited call
+	// of the page, and Visited after the visit, before the Enqueued calls
+	// of the URLs harvested from the page. The calls for different pages
+	// are not ordered and may be concurrent, unless the OrderedCallbacks
+	// option is set: they are then made one at a time, in this order, from
+	// a dedicated goroutine (along with Error, Disallowed and the
+	// VisitedInfo and DisallowedReason variants).
 	Enqueued(*URLContext)
 	Visit(*URLContext, *http.Response, *goquery.Document) (interface{}, bool)
 	Visited(*URLContext, interface{})
 	Disallowed(*URLContext)
 }
 
-// HttpClient is the default HTTP client used by DefaultExtender's fetch
-// requests (this is thread-safe). The client's fields can be customized
-// (i.e. for a different redirection strategy, a different Transport
-// object, ...). It should be done prior to starting the crawler.
-var HttpClient = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+// HttpClient is the default template of the HTTP client used by
+// DefaultExtender's fetch requests, when the Options' HTTPClient is not set.
+// Each run of a crawler uses its own copy of it, so it is never modified by
+// the crawlers. The client's fields can be customized (i.e. for a different
+// redirection strategy, a different Transport object, ...). It should be
+// done prior to starting the crawlers, the HTTPClient option being the way
+// to use a distinct client per crawler.
+var HttpClient = &http.Client{CheckRedirect: checkRedirect}
+
+// The redirection policy of the HttpClient, and of the HTTPClient templates
+// without a CheckRedirect.
+func checkRedirect(req *http.Request, via []*http.Request) error {
 	// For robots.txt URLs, allow up to 10 redirects, like the default http client.
 	// Rationale: the site owner explicitly tells us that this specific robots.txt
 	// should be used for this domain.
 	if isRobotsURL(req.URL) {
+		if e := checkRedirectLoop(req, via); e != nil {
+			return e
+		}
 		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
+			return fmt.Errorf("stopped after 10 redirects: %w", ErrRedirectPolicy)
 		}
 		if len(via) > 0 {
 			req.Header.Set("User-Agent", via[0].Header.Get("User-Agent"))
@@ -75,7 +646,7 @@ var HttpClient = &http.Client{CheckRedirect: func(req *http.Request, via []*http
 	// will ask the worker to enqueue the new (redirect-to) URL. Returning an error
 	// will make httpClient.Do() return a url.Error, with the URL field containing the new URL.
 	return ErrEnqueueRedirect
-}}
+}
 
 // DefaultExtender is a default working implementation of an extender. It is
 // possible to nest such a value in a custom struct so that only the
@@ -105,12 +676,13 @@ func (de *DefaultExtender) Log(logFlags LogFlags, msgLevel LogFlags, msg string)
 }
 
 // ComputeDelay returns the delay specified in the Crawler's Options, unless a
-// crawl-delay is specified in the robots.txt file, which has precedence.
+// crawl-delay is specified in the robots.txt file, which has precedence. If
+// the Options' AdaptiveDelay is set, the adaptive delay is returned instead.
 func (de *DefaultExtender) ComputeDelay(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
-	if di.RobotsDelay > 0 {
-		return di.RobotsDelay
+	if di.AdaptiveDelay != nil {
+		return di.AdaptiveDelay.Compute(di)
 	}
-	return di.OptsDelay
+	return di.Delay()
 }
 
 // Fetch requests the specified URL using the given user agent string. It uses
@@ -146,18 +718,161 @@ func (de *DefaultExtender) Fetch(ctx *URLContext, userAgent string, headRequest
 	} else {
 		reqType = "GET"
 	}
-	req, e := http.NewRequest(reqType, ctx.url.String(), nil)
-	if e != nil {
-		return nil, e
+	var req *http.Request
+	if ar := ctx.authRequest; ar != nil {
+		// Request again with the credentials of the AuthExtender
+		req = ar.Clone(ar.Context())
+		req.Method = reqType
+	} else {
+		var e error
+		if req, e = http.NewRequest(reqType, ctx.url.String(), nil); e != nil {
+			return nil, e
+		}
 	}
 	req.Header.Set("User-Agent", userAgent)
-	return HttpClient.Do(req)
+	if !headRequest && ctx.isRangeProbe() {
+		req.Header.Set("Range", rangeProbeHeader)
+	}
+	if bf := ctx.beforeFetch; bf != nil {
+		r, e := bf.BeforeFetch(ctx, req)
+		if e != nil {
+			return nil, &vetoError{e}
+		}
+		if r != nil {
+			req = r
+		}
+	}
+	if ctx.trace != nil {
+		req = ctx.trace.withTrace(req)
+	}
+	return ctx.httpClient().Do(req)
+}
+
+// BeforeFetch returns the request unchanged.
+func (de *DefaultExtender) BeforeFetch(ctx *URLContext, req *http.Request) (*http.Request, error) {
+	return req, nil
+}
+
+// Create the HTTP client of a run, a copy of the HTTPClient option (or of
+// the HttpClient) with a copy of its transport tuned with the Transport,
+// DialContext and HostRewrite options, serving the hosts of the LocalFS
+// option from their file systems and recorded by the HAR recorder, if any.
+// The transport is shared with the template, and not tuned, if it is not an
+// *http.Transport.
+func newHTTPClient(o *Options, har *harRecorder) *http.Client {
+	client := *o.httpClient()
+	if client.CheckRedirect == nil {
+		client.CheckRedirect = checkRedirect
+	}
+	var tr *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		tr = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		tr = t.Clone()
+	default:
+		client.Transport = wrapTransport(t, o, har)
+		return &client
+	}
+	if o.Transport != nil {
+		tuneTransport(tr, o.Transport)
+	}
+	if o.DialContext != nil || len(o.HostRewrite) > 0 {
+		tr.DialContext = rewriteDial(tr.DialContext, o.DialContext, o.HostRewrite)
+		if tr.DialTLSContext != nil {
+			// The TLS connections are opened by this function instead, only
+			// rewrite their address.
+			tr.DialTLSContext = rewriteDial(tr.DialTLSContext, nil, o.HostRewrite)
+		}
+	}
+
+	client.Transport = wrapTransport(tr, o, har)
+	return &client
+}
+
+// Wrap the transport to serve the hosts of the LocalFS option, and to record
+// the exchanges in the HAR recorder, if any.
+func wrapTransport(rt http.RoundTripper, o *Options, har *harRecorder) http.RoundTripper {
+	if len(o.LocalFS) > 0 {
+		rt = newFSTransport(o.LocalFS, rt)
+	}
+	if har != nil {
+		rt = har.wrap(rt)
+	}
+	return rt
+}
+
+// Apply the transport options to the transport.
+func tuneTransport(tr *http.Transport, opts *TransportOptions) {
+	if opts.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.MaxConnsPerHost > 0 {
+		tr.MaxConnsPerHost = opts.MaxConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.DisableKeepAlives {
+		tr.DisableKeepAlives = true
+	}
+	if opts.ForceAttemptHTTP2 != nil {
+		tr.ForceAttemptHTTP2 = *opts.ForceAttemptHTTP2
+		if !tr.ForceAttemptHTTP2 {
+			// A non-nil empty map disables HTTP/2, which must not be negotiated
+			// either if the transport was already configured for it.
+			tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+			if tr.TLSClientConfig != nil {
+				protos := tr.TLSClientConfig.NextProtos[:0:0]
+				for _, p := range tr.TLSClientConfig.NextProtos {
+					if p != "h2" {
+						protos = append(protos, p)
+					}
+				}
+				tr.TLSClientConfig.NextProtos = protos
+			}
+		}
+	}
+}
+
+// Return the dial function that rewrites the addresses according to the
+// rewrites before dialing with dial, or the transport's dial function if
+// dial is nil.
+func rewriteDial(trDial, dial func(context.Context, string, string) (net.Conn, error),
+	rewrites map[string]string) func(context.Context, string, string) (net.Conn, error) {
+
+	if dial == nil {
+		dial = trDial
+	}
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if to, ok := rewrites[addr]; ok {
+			addr = to
+		}
+		return dial(ctx, network, addr)
+	}
 }
 
 // RequestGet asks the worker to actually request the URL's body
-// (issue a GET), unless the status code is not 2xx.
+// (issue a GET), unless the status code is not 2xx or the Content-Type
+// of the HEAD response is neither HTML nor text. A missing Content-Type
+// is accepted.
 func (de *DefaultExtender) RequestGet(ctx *URLContext, headRes *http.Response) bool {
-	return headRes.StatusCode >= 200 && headRes.StatusCode < 300
+	return headRes.StatusCode >= 200 && headRes.StatusCode < 300 && isTextContentType(headRes.Header.Get("Content-Type"))
+}
+
+// Indicates if the Content-Type header value is HTML or text, or unknown.
+func isTextContentType(ct string) bool {
+	if ct == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return true
+	}
+	return strings.HasPrefix(mt, "text/") || mt == "application/xhtml+xml"
 }
 
 // RequestRobots asks the worker to actually request (fetch) the robots.txt.
@@ -186,3 +901,158 @@ func (de *DefaultExtender) Visited(ctx *URLContext, harvested interface{}) {}
 
 // Disallowed is a no-op.
 func (de *DefaultExtender) Disallowed(ctx *URLContext) {}
+
+// FuncExtender is an Extender implemented by optional function fields, one
+// per extender method. When a function is nil, the DefaultExtender behaviour
+// is used. It is convenient for small programs that only customize a few
+// extender methods, i.e.:
+//
+//     c := NewCrawler(&FuncExtender{
+//         VisitFn: func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+//             // Use the goquery document...
+//             return nil, true
+//         },
+//     })
+//
+// The EnqueueChan field is set to the enqueue channel by the crawler, like
+// the DefaultExtender's field.
+type FuncExtender struct {
+	EnqueueChan chan<- interface{}
+
+	StartFn         func(seeds interface{}) interface{}
+	EndFn           func(err error)
+	ErrorFn         func(err *CrawlError)
+	LogFn           func(logFlags LogFlags, msgLevel LogFlags, msg string)
+	ComputeDelayFn  func(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration
+	FetchFn         func(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error)
+	RequestGetFn    func(ctx *URLContext, headRes *http.Response) bool
+	RequestRobotsFn func(ctx *URLContext, robotAgent string) (data []byte, doRequest bool)
+	FetchedRobotsFn func(ctx *URLContext, res *http.Response)
+	FilterFn        func(ctx *URLContext, isVisited bool) bool
+	EnqueuedFn      func(ctx *URLContext)
+	VisitFn         func(ctx *URLContext, res *http.Response, doc *goquery.Document) (harvested interface{}, findLinks bool)
+	VisitedFn       func(ctx *URLContext, harvested interface{})
+	DisallowedFn    func(ctx *URLContext)
+}
+
+// The DefaultExtender used by the FuncExtender for the nil functions.
+var funcDefaultExtender = new(DefaultExtender)
+
+// Start calls StartFn, or DefaultExtender.Start if nil.
+func (fe *FuncExtender) Start(seeds interface{}) interface{} {
+	if fe.StartFn != nil {
+		return fe.StartFn(seeds)
+	}
+	return funcDefaultExtender.Start(seeds)
+}
+
+// End calls EndFn, or DefaultExtender.End if nil.
+func (fe *FuncExtender) End(err error) {
+	if fe.EndFn != nil {
+		fe.EndFn(err)
+		return
+	}
+	funcDefaultExtender.End(err)
+}
+
+// Error calls ErrorFn, or DefaultExtender.Error if nil.
+func (fe *FuncExtender) Error(err *CrawlError) {
+	if fe.ErrorFn != nil {
+		fe.ErrorFn(err)
+		return
+	}
+	funcDefaultExtender.Error(err)
+}
+
+// Log calls LogFn, or DefaultExtender.Log if nil.
+func (fe *FuncExtender) Log(logFlags LogFlags, msgLevel LogFlags, msg string) {
+	if fe.LogFn != nil {
+		fe.LogFn(logFlags, msgLevel, msg)
+		return
+	}
+	funcDefaultExtender.Log(logFlags, msgLevel, msg)
+}
+
+// ComputeDelay calls ComputeDelayFn, or DefaultExtender.ComputeDelay if nil.
+func (fe *FuncExtender) ComputeDelay(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+	if fe.ComputeDelayFn != nil {
+		return fe.ComputeDelayFn(host, di, lastFetch)
+	}
+	return funcDefaultExtender.ComputeDelay(host, di, lastFetch)
+}
+
+// Fetch calls FetchFn, or DefaultExtender.Fetch if nil.
+func (fe *FuncExtender) Fetch(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+	if fe.FetchFn != nil {
+		return fe.FetchFn(ctx, userAgent, headRequest)
+	}
+	return funcDefaultExtender.Fetch(ctx, userAgent, headRequest)
+}
+
+// RequestGet calls RequestGetFn, or DefaultExtender.RequestGet if nil.
+func (fe *FuncExtender) RequestGet(ctx *URLContext, headRes *http.Response) bool {
+	if fe.RequestGetFn != nil {
+		return fe.RequestGetFn(ctx, headRes)
+	}
+	return funcDefaultExtender.RequestGet(ctx, headRes)
+}
+
+// RequestRobots calls RequestRobotsFn, or DefaultExtender.RequestRobots if nil.
+func (fe *FuncExtender) RequestRobots(ctx *URLContext, robotAgent string) (data []byte, doRequest bool) {
+	if fe.RequestRobotsFn != nil {
+		return fe.RequestRobotsFn(ctx, robotAgent)
+	}
+	return funcDefaultExtender.RequestRobots(ctx, robotAgent)
+}
+
+// FetchedRobots calls FetchedRobotsFn, or DefaultExtender.FetchedRobots if nil.
+func (fe *FuncExtender) FetchedRobots(ctx *URLContext, res *http.Response) {
+	if fe.FetchedRobotsFn != nil {
+		fe.FetchedRobotsFn(ctx, res)
+		return
+	}
+	funcDefaultExtender.FetchedRobots(ctx, res)
+}
+
+// Filter calls FilterFn, or DefaultExtender.Filter if nil.
+func (fe *FuncExtender) Filter(ctx *URLContext, isVisited bool) bool {
+	if fe.FilterFn != nil {
+		return fe.FilterFn(ctx, isVisited)
+	}
+	return funcDefaultExtender.Filter(ctx, isVisited)
+}
+
+// Enqueued calls EnqueuedFn, or DefaultExtender.Enqueued if nil.
+func (fe *FuncExtender) Enqueued(ctx *URLContext) {
+	if fe.EnqueuedFn != nil {
+		fe.EnqueuedFn(ctx)
+		return
+	}
+	funcDefaultExtender.Enqueued(ctx)
+}
+
+// Visit calls VisitFn, or DefaultExtender.Visit if nil.
+func (fe *FuncExtender) Visit(ctx *URLContext, res *http.Response, doc *goquery.Document) (harvested interface{}, findLinks bool) {
+	if fe.VisitFn != nil {
+		return fe.VisitFn(ctx, res, doc)
+	}
+	return funcDefaultExtender.Visit(ctx, res, doc)
+}
+
+// Visited calls VisitedFn, or DefaultExtender.Visited if nil.
+func (fe *FuncExtender) Visited(ctx *URLContext, harvested interface{}) {
+	if fe.VisitedFn != nil {
+		fe.VisitedFn(ctx, harvested)
+		return
+	}
+	funcDefaultExtender.Visited(ctx, harvested)
+}
+
+// Disallowed calls DisallowedFn, or DefaultExtender.Disallowed if nil.
+func (fe *FuncExtender) Disallowed(ctx *URLContext) {
+	if fe.DisallowedFn != nil {
+		fe.DisallowedFn(ctx)
+		return
+	}
+	funcDefaultExtender.Disallowed(ctx)
+}
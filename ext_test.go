@@ -0,0 +1,267 @@
+package gocrawl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestFuncExtenderDefaults(t *testing.T) {
+	u, _ := url.Parse("http://hosta/page1.html")
+	ctx := &URLContext{url: u, normalizedURL: u}
+	fe := new(FuncExtender)
+	de := new(DefaultExtender)
+
+	if got := fe.Start("seed"); got != "seed" {
+		t.Errorf("Start: expected seed, got %v", got)
+	}
+	fe.End(nil)
+	fe.Error(newCrawlErrorMessage(ctx, "error", CekFetch))
+	fe.Log(LogNone, LogInfo, "not logged")
+	di := &DelayInfo{OptsDelay: time.Second, RobotsDelay: 2 * time.Second, RobotsDelayPolicy: RobotsDelayUseRobots}
+	if got, exp := fe.ComputeDelay("hosta", di, nil), de.ComputeDelay("hosta", di, nil); got != exp {
+		t.Errorf("ComputeDelay: expected %s, got %s", exp, got)
+	}
+	for _, code := range []int{200, 404} {
+		res := &http.Response{StatusCode: code}
+		if got, exp := fe.RequestGet(ctx, res), de.RequestGet(ctx, res); got != exp {
+			t.Errorf("RequestGet(%d): expected %v, got %v", code, exp, got)
+		}
+	}
+	if data, ok := fe.RequestRobots(ctx, "agent"); data != nil || !ok {
+		t.Errorf("RequestRobots: expected nil, true, got %v, %v", data, ok)
+	}
+	fe.FetchedRobots(ctx, nil)
+	for _, visited := range []bool{true, false} {
+		if got := fe.Filter(ctx, visited); got == visited {
+			t.Errorf("Filter(%v): expected %v, got %v", visited, !visited, got)
+		}
+	}
+	fe.Enqueued(ctx)
+	if harvested, findLinks := fe.Visit(ctx, nil, nil); harvested != nil || !findLinks {
+		t.Errorf("Visit: expected nil, true, got %v, %v", harvested, findLinks)
+	}
+	fe.Visited(ctx, nil)
+	fe.Disallowed(ctx)
+}
+
+func TestFuncExtenderFuncs(t *testing.T) {
+	u, _ := url.Parse("http://hosta/page1.html")
+	ctx := &URLContext{url: u, normalizedURL: u}
+	res := &http.Response{StatusCode: 200}
+	errFetch := errors.New("fetch")
+
+	var calls []string
+	call := func(nm string) { calls = append(calls, nm) }
+	fe := &FuncExtender{
+		StartFn: func(seeds interface{}) interface{} { call("Start"); return "other" },
+		EndFn:   func(err error) { call("End") },
+		ErrorFn: func(err *CrawlError) { call("Error") },
+		LogFn:   func(logFlags LogFlags, msgLevel LogFlags, msg string) { call("Log") },
+		ComputeDelayFn: func(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+			call("ComputeDelay")
+			return time.Minute
+		},
+		FetchFn: func(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+			call("Fetch")
+			return nil, errFetch
+		},
+		RequestGetFn: func(ctx *URLContext, headRes *http.Response) bool { call("RequestGet"); return false },
+		RequestRobotsFn: func(ctx *URLContext, robotAgent string) ([]byte, bool) {
+			call("RequestRobots")
+			return []byte("robots"), false
+		},
+		FetchedRobotsFn: func(ctx *URLContext, res *http.Response) { call("FetchedRobots") },
+		FilterFn:        func(ctx *URLContext, isVisited bool) bool { call("Filter"); return isVisited },
+		EnqueuedFn:      func(ctx *URLContext) { call("Enqueued") },
+		VisitFn: func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+			call("Visit")
+			return "harvested", false
+		},
+		VisitedFn:    func(ctx *URLContext, harvested interface{}) { call("Visited") },
+		DisallowedFn: func(ctx *URLContext) { call("Disallowed") },
+	}
+
+	if got := fe.Start("seed"); got != "other" {
+		t.Errorf("Start: expected other, got %v", got)
+	}
+	fe.End(nil)
+	fe.Error(newCrawlErrorMessage(ctx, "error", CekFetch))
+	fe.Log(LogAll, LogInfo, "msg")
+	if got := fe.ComputeDelay("hosta", &DelayInfo{}, nil); got != time.Minute {
+		t.Errorf("ComputeDelay: expected 1m, got %s", got)
+	}
+	if _, err := fe.Fetch(ctx, "agent", false); err != errFetch {
+		t.Errorf("Fetch: expected %v, got %v", errFetch, err)
+	}
+	if fe.RequestGet(ctx, res) {
+		t.Error("RequestGet: expected false")
+	}
+	if data, ok := fe.RequestRobots(ctx, "agent"); string(data) != "robots" || ok {
+		t.Errorf("RequestRobots: expected robots, false, got %s, %v", data, ok)
+	}
+	fe.FetchedRobots(ctx, res)
+	if !fe.Filter(ctx, true) {
+		t.Error("Filter: expected true")
+	}
+	fe.Enqueued(ctx)
+	if harvested, findLinks := fe.Visit(ctx, res, nil); harvested != "harvested" || findLinks {
+		t.Errorf("Visit: expected harvested, false, got %v, %v", harvested, findLinks)
+	}
+	fe.Visited(ctx, nil)
+	fe.Disallowed(ctx)
+
+	exp := []string{"Start", "End", "Error", "Log", "ComputeDelay", "Fetch", "RequestGet",
+		"RequestRobots", "FetchedRobots", "Filter", "Enqueued", "Visit", "Visited", "Disallowed"}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Errorf("expected calls %v, got %v", exp, calls)
+	}
+}
+
+func TestDefaultRequestGet(t *testing.T) {
+	de := new(DefaultExtender)
+	cases := []struct {
+		status int
+		ct     string
+		want   bool
+	}{
+		{200, "text/html; charset=utf-8", true},
+		{200, "TEXT/PLAIN", true},
+		{200, "application/xhtml+xml", true},
+		{200, "", true},
+		{200, "application/zip", false},
+		{200, "image/png", false},
+		{404, "text/html", false},
+	}
+	for i, c := range cases {
+		res := &http.Response{StatusCode: c.status, Header: http.Header{}}
+		if c.ct != "" {
+			res.Header.Set("Content-Type", c.ct)
+		}
+		if got := de.RequestGet(nil, res); got != c.want {
+			t.Errorf("%d: %d %q: want %v, got %v", i, c.status, c.ct, c.want, got)
+		}
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	h2 := false
+	client := newHTTPClient(&Options{Transport: &TransportOptions{
+		MaxIdleConnsPerHost: 7,
+		MaxConnsPerHost:     9,
+		IdleConnTimeout:     time.Second,
+		DisableKeepAlives:   true,
+		ForceAttemptHTTP2:   &h2,
+	}}, nil)
+	if client == HttpClient || client.CheckRedirect == nil {
+		t.Fatal("want a copy of the HttpClient")
+	}
+	tr := client.Transport.(*http.Transport)
+	if tr.MaxIdleConnsPerHost != 7 || tr.MaxConnsPerHost != 9 || tr.IdleConnTimeout != time.Second || !tr.DisableKeepAlives {
+		t.Errorf("want the transport options applied, got %+v", tr)
+	}
+	if tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil || len(tr.TLSNextProto) != 0 {
+		t.Error("want HTTP/2 disabled")
+	}
+	if tr == http.DefaultTransport {
+		t.Error("want a copy of the default transport")
+	}
+
+	// The zero values keep the transport's settings
+	tr = newHTTPClient(&Options{Transport: &TransportOptions{}}, nil).Transport.(*http.Transport)
+	def := http.DefaultTransport.(*http.Transport)
+	if tr.MaxIdleConnsPerHost != def.MaxIdleConnsPerHost || tr.IdleConnTimeout != def.IdleConnTimeout || tr.ForceAttemptHTTP2 != def.ForceAttemptHTTP2 {
+		t.Errorf("want the default transport settings, got %+v", tr)
+	}
+}
+
+type beforeFetchExtender struct {
+	DefaultExtender
+	mu     sync.Mutex
+	hooked []string
+	errors map[string]*CrawlError
+}
+
+var errVeto = errors.New("not allowed by the gateway")
+
+func (x *beforeFetchExtender) BeforeFetch(ctx *URLContext, req *http.Request) (*http.Request, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.hooked = append(x.hooked, fmt.Sprintf("%s %s %v", req.Method, ctx.url.Path, ctx.IsRobotsURL()))
+	if ctx.url.Path == "/vetoed.html" {
+		return nil, errVeto
+	}
+	signed := req.Clone(req.Context())
+	signed.Header.Set("X-Signature", "sig:"+req.Header.Get("User-Agent")+":"+req.Method)
+	return signed, nil
+}
+
+func (x *beforeFetchExtender) Error(err *CrawlError) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err.Ctx != nil {
+		x.errors[err.Ctx.url.Path] = err
+	}
+}
+
+func TestBeforeFetch(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, r.Header.Get("X-Signature")))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow:\n")
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/vetoed.html">v</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ext := &beforeFetchExtender{errors: make(map[string]*CrawlError)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.HeadBeforeGet = true
+	opts.UserAgent = "agent"
+	opts.LogFlags = LogNone
+	if err := NewCrawlerWithOptions(opts).Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The robots.txt, HEAD and GET requests are hooked and signed, the
+	// vetoed one is not sent
+	wantHooked := []string{"GET /robots.txt true", "HEAD / false", "GET / false", "HEAD /vetoed.html false"}
+	if !reflect.DeepEqual(ext.hooked, wantHooked) {
+		t.Errorf("want the requests %v hooked, got %v", wantHooked, ext.hooked)
+	}
+	wantSent := []string{"GET /robots.txt sig:agent:GET", "HEAD / sig:agent:HEAD", "GET / sig:agent:GET"}
+	if !reflect.DeepEqual(requests, wantSent) {
+		t.Errorf("want the signed requests %v sent, got %v", wantSent, requests)
+	}
+	err := ext.errors["/vetoed.html"]
+	if err == nil || err.Kind != CekVetoed || !errors.Is(err, ErrVetoed) || !errors.Is(err, errVeto) {
+		t.Errorf("want a vetoed error wrapping the error of the hook, got %v", err)
+	}
+	if len(ext.errors) != 1 {
+		t.Errorf("want a single error, got %v", ext.errors)
+	}
+
+	// The DefaultExtender's is a no-op
+	req := httptest.NewRequest("GET", "http://hosta/", nil)
+	if got, err := new(DefaultExtender).BeforeFetch(nil, req); got != req || err != nil {
+		t.Errorf("want the request unchanged, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,63 @@
+package gocrawl
+
+import (
+	"net/url"
+	"strings"
+)
+
+// FragmentPolicy controls how the fragments of the URLs are handled, for the
+// single-page applications that encode their routes in the fragment.
+type FragmentPolicy uint8
+
+// The various fragment policies.
+const (
+	// FragmentsStrip handles the fragments per the URLNormalizationFlags,
+	// which strip them by default, and ignores the links to a fragment of
+	// the same page.
+	FragmentsStrip FragmentPolicy = iota
+
+	// FragmentsKeep keeps the fragments in the normalized URLs, so that
+	// they are part of the visited set, and follows the links to a
+	// fragment of the same page (e.g. "#/products/42"). The fragment is not
+	// sent in the request, it is available to the Visit extender method via
+	// the URLContext's URL.
+	FragmentsKeep
+
+	// FragmentsHashbangToQuery translates the hashbang fragments (i.e.
+	// "#!/products/42") to the "_escaped_fragment_" query parameter before
+	// the URLs are fetched, and follows the hashbang links to the same page.
+	// The other fragments are handled like with FragmentsStrip.
+	FragmentsHashbangToQuery
+)
+
+// The query parameter of the translated hashbang fragments.
+const escapedFragmentParam = "_escaped_fragment_"
+
+// Translate the hashbang fragment of the URL to its _escaped_fragment_ query
+// form, in place, with the FragmentsHashbangToQuery policy. The escaped
+// fragment of the URL, if any, is replaced.
+func escapeHashbang(u *url.URL, opts *Options) {
+	if opts.FragmentPolicy != FragmentsHashbangToQuery || !strings.HasPrefix(u.Fragment, "!") {
+		return
+	}
+	var params []string
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p != "" && p != escapedFragmentParam && !strings.HasPrefix(p, escapedFragmentParam+"=") {
+			params = append(params, p)
+		}
+	}
+	params = append(params, escapedFragmentParam+"="+url.QueryEscape(u.Fragment[1:]))
+	u.RawQuery, u.Fragment = strings.Join(params, "&"), ""
+}
+
+// Indicates if the link to a fragment of the same page (e.g. "#section") is
+// followed, per the FragmentPolicy option.
+func followFragment(link string, opts *Options) bool {
+	switch opts.FragmentPolicy {
+	case FragmentsKeep:
+		return len(link) > 1
+	case FragmentsHashbangToQuery:
+		return strings.HasPrefix(link, "#!")
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestEscapeHashbang(t *testing.T) {
+	opts := NewOptions(nil)
+	opts.FragmentPolicy = FragmentsHashbangToQuery
+	cases := []struct {
+		in, out string
+	}{
+		{"http://host/#!/products/42", "http://host/?_escaped_fragment_=%2Fproducts%2F42"},
+		{"http://host/app?lang=en#!home", "http://host/app?lang=en&_escaped_fragment_=home"},
+		{"http://host/?_escaped_fragment_=old&a=1#!new", "http://host/?a=1&_escaped_fragment_=new"},
+		{"http://host/#section", "http://host/#section"},
+		{"http://host/", "http://host/"},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		escapeHashbang(u, opts)
+		if got := u.String(); got != c.out {
+			t.Errorf("%s: want %s, got %s", c.in, c.out, got)
+		}
+	}
+}
+
+func TestFragmentPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/robots.txt":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/" && r.URL.Query().Get("_escaped_fragment_") == "":
+			fmt.Fprint(w, `<a href="#/a">a</a><a href="#/b">b</a><a href="#!/c">c</a><a href="#">top</a>`)
+			fmt.Fprint(w, `<a href="/page#one">1</a><a href="/page#two">2</a><a href="/page">3</a>`)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		policy  FragmentPolicy
+		visited []string
+	}{
+		// The links differing only in fragment are deduped
+		{FragmentsStrip, []string{"/", "/page"}},
+		{FragmentsKeep, []string{"/", "/#!/c", "/#/a", "/#/b", "/page#one", "/page#two", "/page"}},
+		{FragmentsHashbangToQuery, []string{"/", "/?_escaped_fragment_=%2Fc", "/page"}},
+	}
+	for _, c := range cases {
+		var mu sync.Mutex
+		var visited []string
+		spy := newSpy(new(DefaultExtender), true)
+		spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			visited = append(visited, ctx.NormalizedURL().RequestURI()+fragmentSuffix(ctx.NormalizedURL()))
+		})
+		opts := NewOptions(spy)
+		opts.CrawlDelay = 0
+		opts.FragmentPolicy = c.policy
+		opts.LogFlags = LogAll
+		if err := NewCrawlerWithOptions(opts).Run(srv.URL + "/"); err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(visited)
+		want := append([]string(nil), c.visited...)
+		sort.Strings(want)
+		if fmt.Sprint(visited) != fmt.Sprint(want) {
+			t.Errorf("policy %d: want visited %v, got %v", c.policy, want, visited)
+		}
+	}
+}
+
+// Return the fragment of the URL with its "#", if any.
+func fragmentSuffix(u *url.URL) string {
+	if u.Fragment == "" {
+		return ""
+	}
+	return "#" + u.Fragment
+}
@@ -0,0 +1,274 @@
+package gocrawl
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Frontier holds the URLs waiting to be visited, by host. The robots.txt URLs
+// are never pushed to the Frontier, they are kept by the workers.
+//
+// A Frontier is used by a single crawler at a time: the crawler counts the
+// URLs it pushes, and waits for each of them to be popped by the worker of
+// its host before it ends. An implementation must thus return each pushed
+// URL exactly once from Pop (a URL lost or popped by another crawler blocks
+// the end of the crawl), only return the URLs pushed by the crawler, and
+// count in Len the URLs pushed and not yet popped. It may keep the URLs out
+// of the process (e.g. in a file or an external store, to crawl frontiers
+// larger than the memory), but it must not be shared by several running
+// crawlers.
+//
+// The methods of the Frontier are called concurrently by the workers and the
+// crawler, and Pop may be slow (e.g. a network call): the crawl delay of a
+// host is not started before its URL is popped. External implementations may
+// use the JSON encoding of the URLContext (see URLContext.MarshalJSON) to
+// store the URLs.
+type Frontier interface {
+	// Push adds the URL to the queue of its host (the host of its
+	// normalized URL).
+	Push(ctx *URLContext) error
+
+	// Pop removes and returns the next URL to visit for the host, or false
+	// if its queue is empty.
+	Pop(host string) (*URLContext, bool, error)
+
+	// Hosts returns the hosts with URLs in their queue.
+	Hosts() []string
+
+	// Len returns the number of URLs in the queue of the host.
+	Len(host string) int
+}
+
+// FrontierDropper is an optional interface of a Frontier, to remove the
+// oldest URL of a host when the DropOldest pending policy is used. Without
+// it, the DropOldest policy may only drop the URLs not yet pushed to the
+// Frontier, and drops the newest URL otherwise.
+type FrontierDropper interface {
+	// DropOldest removes and returns the URL of the host that was pushed
+	// first, or false if its queue is empty.
+	DropOldest(host string) (*URLContext, bool, error)
+}
+
+// MemoryFrontier is the in-memory Frontier used by default. The URLs of a
+// host are popped by order of priority (highest first), and based on the
+// Ordering for URLs with the same priority (FIFO for OrderBFS, LIFO for
+// OrderDFS).
+type MemoryFrontier struct {
+	mu    sync.Mutex
+	lifo  bool
+	seq   uint64
+	hosts map[string]*queueItems
+}
+
+// NewMemoryFrontier returns an empty in-memory Frontier using the specified
+// ordering.
+func NewMemoryFrontier(order Ordering) *MemoryFrontier {
+	return &MemoryFrontier{
+		lifo:  order == OrderDFS,
+		hosts: make(map[string]*queueItems),
+	}
+}
+
+// Push adds the URL to the queue of its host.
+func (f *MemoryFrontier) Push(ctx *URLContext) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	host := ctx.normalizedURL.Host
+	q, ok := f.hosts[host]
+	if !ok {
+		q = &queueItems{lifo: f.lifo}
+		f.hosts[host] = q
+	}
+	f.seq++
+	heap.Push(q, &queueItem{ctx, f.seq})
+	return nil
+}
+
+// Pop removes and returns the next URL of the host.
+func (f *MemoryFrontier) Pop(host string) (*URLContext, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, ok := f.hosts[host]
+	if !ok {
+		return nil, false, nil
+	}
+	ctx := heap.Pop(q).(*queueItem).ctx
+	if q.Len() == 0 {
+		delete(f.hosts, host)
+	}
+	return ctx, true, nil
+}
+
+// DropOldest removes and returns the URL of the host that was pushed first.
+func (f *MemoryFrontier) DropOldest(host string) (*URLContext, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, ok := f.hosts[host]
+	if !ok {
+		return nil, false, nil
+	}
+	oldest := 0
+	for i, it := range q.items {
+		if it.seq < q.items[oldest].seq {
+			oldest = i
+		}
+	}
+	ctx := heap.Remove(q, oldest).(*queueItem).ctx
+	if q.Len() == 0 {
+		delete(f.hosts, host)
+	}
+	return ctx, true, nil
+}
+
+// Hosts returns the hosts with URLs in their queue, sorted.
+func (f *MemoryFrontier) Hosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make([]string, 0, len(f.hosts))
+	for host := range f.hosts {
+		res = append(res, host)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Len returns the number of URLs in the queue of the host.
+func (f *MemoryFrontier) Len(host string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if q, ok := f.hosts[host]; ok {
+		return q.Len()
+	}
+	return 0
+}
+
+// The JSON encoding of an URLContext. The URLs are encoded as strings, the
+// delay override as a number of nanoseconds.
+type urlContextJSON struct {
+	URL                 string            `json:"url"`
+	NormalizedURL       string            `json:"normalizedURL"`
+	SourceURL           string            `json:"sourceURL,omitempty"`
+	NormalizedSourceURL string            `json:"normalizedSourceURL,omitempty"`
+	OriginalURL         string            `json:"originalURL,omitempty"`
+	HeadBeforeGet       bool              `json:"headBeforeGet,omitempty"`
+	HeadOnly            bool              `json:"headOnly,omitempty"`
+	FetchMode           FetchMode         `json:"fetchMode,omitempty"`
+	State               interface{}       `json:"state,omitempty"`
+	Priority            int               `json:"priority,omitempty"`
+	DelayOverride       *time.Duration    `json:"delayOverride,omitempty"`
+	LinkInfo            *LinkInfo         `json:"linkInfo,omitempty"`
+	PaginationDepth     int               `json:"paginationDepth,omitempty"`
+	Depth               int               `json:"depth,omitempty"`
+	Seed                bool              `json:"seed,omitempty"`
+	Recheck             bool              `json:"recheck,omitempty"`
+	VisitedAt           *time.Time        `json:"visitedAt,omitempty"`
+	Tags                map[string]string `json:"tags,omitempty"`
+	URLTags             map[string]string `json:"urlTags,omitempty"`
+}
+
+// MarshalJSON encodes the URLContext as a JSON object, so that it can be
+// stored by a Frontier. The object has the following keys, the optional ones
+// being omitted when empty:
+//
+//	url                  the URL, as a string
+//	normalizedURL        the normalized URL, as a string
+//	sourceURL            the source URL, as a string (optional)
+//	normalizedSourceURL  the normalized source URL, as a string (optional)
+//	originalURL          the URL before the RewriteURL option (optional)
+//	headBeforeGet        the HeadBeforeGet field (optional)
+//	headOnly             the HeadOnly field (optional)
+//	fetchMode            the FetchMode field, as a number (optional)
+//	state                the State field (optional)
+//	priority             the priority (optional)
+//	delayOverride        the crawl delay override, in nanoseconds (optional)
+//	linkInfo             the LinkInfo, with its fields as keys (optional)
+//	paginationDepth      the pagination depth (optional)
+//	depth                the depth (optional)
+//	seed                 whether the URL is a seed (optional)
+//	recheck              whether the URL is a recheck (optional)
+//	visitedAt            the VisitedAt time, in RFC 3339 format (optional)
+//	tags                 the tags of the URL, as an object (optional)
+//	urlTags              the tags attached to the URL, without those of its
+//	                     host, as an object (optional)
+//
+// The State must be encodable by the encoding/json package, and it is decoded
+// as a generic value (e.g. a map[string]interface{} for a struct), so the
+// extender must not expect its original type. The ancestry, fetch attempts,
+// fetch information and robots.txt rule are not encoded.
+func (uc *URLContext) MarshalJSON() ([]byte, error) {
+	v := urlContextJSON{
+		URL:             uc.url.String(),
+		NormalizedURL:   uc.normalizedURL.String(),
+		HeadBeforeGet:   uc.HeadBeforeGet,
+		HeadOnly:        uc.HeadOnly,
+		FetchMode:       uc.FetchMode,
+		State:           uc.State,
+		Priority:        uc.priority,
+		DelayOverride:   uc.delayOverride,
+		LinkInfo:        uc.linkInfo,
+		PaginationDepth: uc.paginationDepth,
+		Depth:           uc.depth,
+		Seed:            uc.seed,
+		Recheck:         uc.recheck,
+		Tags:            uc.tags,
+		URLTags:         uc.urlTags,
+	}
+	if uc.sourceURL != nil {
+		v.SourceURL = uc.sourceURL.String()
+	}
+	if uc.normalizedSourceURL != nil {
+		v.NormalizedSourceURL = uc.normalizedSourceURL.String()
+	}
+	if uc.originalURL != nil {
+		v.OriginalURL = uc.originalURL.String()
+	}
+	if !uc.visitedAt.IsZero() {
+		v.VisitedAt = &uc.visitedAt
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an URLContext encoded by MarshalJSON.
+func (uc *URLContext) UnmarshalJSON(b []byte) error {
+	var v urlContextJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var res URLContext
+	var err error
+	if res.url, err = url.Parse(v.URL); err != nil {
+		return err
+	}
+	if res.normalizedURL, err = url.Parse(v.NormalizedURL); err != nil {
+		return err
+	}
+	if v.SourceURL != "" {
+		if res.sourceURL, err = url.Parse(v.SourceURL); err != nil {
+			return err
+		}
+	}
+	if v.NormalizedSourceURL != "" {
+		if res.normalizedSourceURL, err = url.Parse(v.NormalizedSourceURL); err != nil {
+			return err
+		}
+	}
+	if v.OriginalURL != "" {
+		if res.originalURL, err = url.Parse(v.OriginalURL); err != nil {
+			return err
+		}
+	}
+	res.HeadBeforeGet, res.HeadOnly, res.State = v.HeadBeforeGet, v.HeadOnly, v.State
+	res.FetchMode = v.FetchMode
+	res.priority, res.delayOverride, res.linkInfo = v.Priority, v.DelayOverride, v.LinkInfo
+	res.paginationDepth, res.depth, res.seed, res.recheck = v.PaginationDepth, v.Depth, v.Seed, v.Recheck
+	if v.VisitedAt != nil {
+		res.visitedAt = *v.VisitedAt
+	}
+	res.tags, res.urlTags = v.Tags, v.URLTags
+	*uc = res
+	return nil
+}
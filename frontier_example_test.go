@@ -0,0 +1,150 @@
+package gocrawl_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/PuerkitoBio/gocrawl/gocrawltest"
+)
+
+// FileFrontier is a Frontier that stores the URLs of each host as JSON
+// lines in a file of its directory, in FIFO order. It ignores the
+// priorities, and is not meant to be efficient: it only shows that a
+// Frontier can store the URLs outside of the process.
+type FileFrontier struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func (f *FileFrontier) path(host string) string {
+	return filepath.Join(f.dir, url.PathEscape(host)+".jsonl")
+}
+
+func (f *FileFrontier) read(host string) ([][]byte, error) {
+	b, err := os.ReadFile(f.path(host))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var lines [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		lines = append(lines, append([]byte(nil), sc.Bytes()...))
+	}
+	return lines, sc.Err()
+}
+
+// Push appends the URL to the file of its host.
+func (f *FileFrontier) Push(ctx *gocrawl.URLContext) error {
+	b, err := json.Marshal(ctx)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path(ctx.NormalizedURL().Host), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Pop removes the first URL of the file of the host.
+func (f *FileFrontier) Pop(host string) (*gocrawl.URLContext, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines, err := f.read(host)
+	if err != nil || len(lines) == 0 {
+		return nil, false, err
+	}
+	ctx := new(gocrawl.URLContext)
+	if err := json.Unmarshal(lines[0], ctx); err != nil {
+		return nil, false, err
+	}
+	if len(lines) == 1 {
+		return ctx, true, os.Remove(f.path(host))
+	}
+	rest := append(bytes.Join(lines[1:], []byte("\n")), '\n')
+	return ctx, true, os.WriteFile(f.path(host), rest, 0600)
+}
+
+// Hosts returns the hosts that have a file.
+func (f *FileFrontier) Hosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names, _ := filepath.Glob(filepath.Join(f.dir, "*.jsonl"))
+	var hosts []string
+	for _, name := range names {
+		host, err := url.PathUnescape(filepath.Base(name[:len(name)-len(".jsonl")]))
+		if err == nil {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Len returns the number of lines of the file of the host.
+func (f *FileFrontier) Len(host string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines, _ := f.read(host)
+	return len(lines)
+}
+
+type frontierExtender struct {
+	*gocrawltest.FileFetcher
+}
+
+func (x *frontierExtender) Visited(ctx *gocrawl.URLContext, harvested interface{}) {
+	fmt.Println("visited", ctx.URL(), "from", ctx.SourceURL(), "with state", ctx.State)
+}
+
+func ExampleFrontier() {
+	dir, err := os.MkdirTemp("", "gocrawl-frontier")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	ext := &frontierExtender{gocrawltest.NewFileFetcher(fstest.MapFS{
+		"host/page1.html": {Data: []byte(`<a href="page2.html">2</a><a href="page3.html">3</a>`)},
+		"host/page2.html": {Data: []byte(`<a href="page4.html">4</a>`)},
+		"host/page3.html": {Data: []byte(`page 3`)},
+		"host/page4.html": {Data: []byte(`page 4`)},
+	})}
+	ext.SetResponse("host", "/robots.txt", gocrawltest.Response{StatusCode: http.StatusNotFound})
+
+	opts := gocrawl.NewOptions(ext)
+	opts.CrawlDelay = 10 * time.Millisecond
+	opts.LogFlags = gocrawl.LogNone
+	opts.Frontier = &FileFrontier{dir: dir}
+
+	c := gocrawl.NewCrawlerWithOptions(opts)
+	if err := c.Run(gocrawl.S{"http://host/page1.html": "seed"}); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// visited http://host/page1.html from <nil> with state seed
+	// visited http://host/page2.html from http://host/page1.html with state <nil>
+	// visited http://host/page3.html from http://host/page1.html with state <nil>
+	// visited http://host/page4.html from http://host/page2.html with state <nil>
+}
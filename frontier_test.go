@@ -0,0 +1,65 @@
+package gocrawl
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryFrontier(t *testing.T) {
+	f := NewMemoryFrontier(OrderBFS)
+	for _, s := range []string{"http://b/1", "http://a/1", "http://b/2", "http://b/3"} {
+		if err := f.Push(mustQueueCtx(t, s, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hosts := f.Hosts(); !reflect.DeepEqual(hosts, []string{"a", "b"}) {
+		t.Errorf("want hosts [a b], got %v", hosts)
+	}
+	if n := f.Len("b"); n != 3 {
+		t.Errorf("want 3 URLs for b, got %d", n)
+	}
+	if ctx, ok, _ := f.DropOldest("b"); !ok || ctx.url.Path != "/1" {
+		t.Errorf("want /1 dropped, got %v", ctx)
+	}
+	if ctx, ok, _ := f.Pop("b"); !ok || ctx.url.Path != "/2" {
+		t.Errorf("want /2 popped, got %v", ctx)
+	}
+	f.Pop("a")
+	if hosts := f.Hosts(); !reflect.DeepEqual(hosts, []string{"b"}) {
+		t.Errorf("want hosts [b], got %v", hosts)
+	}
+	if _, ok, _ := f.Pop("a"); ok {
+		t.Error("expected no URL to pop for a")
+	}
+}
+
+func TestURLContextJSON(t *testing.T) {
+	c := NewCrawler(&DefaultExtender{})
+	src, _ := c.stringToURLContext("http://localhost/src", nil)
+	ctx, _ := c.stringToURLContext("http://LOCALHOST/p1", src)
+	d := time.Second
+	ctx.HeadBeforeGet, ctx.HeadOnly, ctx.State = true, true, map[string]interface{}{"depth": 2.0}
+	ctx.priority, ctx.delayOverride, ctx.paginationDepth, ctx.seed = 3, &d, 2, true
+	ctx.linkInfo = &LinkInfo{Text: "p1", Rel: []string{"nofollow"}, NoFollow: true, Tag: "a", Index: 1, Pagination: true}
+	ctx.visitedAt = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	b, err := json.Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(URLContext)
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ctx) {
+		t.Errorf("want %+v, got %+v (%s)", ctx, got, b)
+	}
+
+	// Optional keys are omitted
+	b, _ = json.Marshal(src)
+	if want := `{"url":"http://localhost/src","normalizedURL":"http://localhost/src"}`; string(b) != want {
+		t.Errorf("want %s, got %s", want, b)
+	}
+}
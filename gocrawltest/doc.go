@@ -0,0 +1,5 @@
+// Package gocrawltest provides helpers to unit-test gocrawl extenders without
+// hitting the network: a FileFetcher that serves a file system as fake hosts,
+// and a SpyExtender that counts and overrides the calls to the extender
+// methods.
+package gocrawltest
@@ -0,0 +1,94 @@
+package gocrawltest
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/PuerkitoBio/gocrawl/internal/testfs"
+)
+
+// Response configures the fake response of a path served by the FileFetcher.
+type Response struct {
+	// StatusCode overrides the status code of the response. If 0, the status
+	// code is 200 if the file exists, 404 otherwise.
+	StatusCode int
+
+	// Header is added to the headers of the response.
+	Header http.Header
+
+	// Latency is the time to wait before returning the response.
+	Latency time.Duration
+}
+
+// FileFetcher is an extender that fetches the URLs from a file system
+// instead of the network. The URL http://host/path is served from the file
+// host/path whatever the port, where a "www." prefix is removed from the
+// host, and internationalized hosts are stored in their punycode form. A
+// missing file is returned as a 404 response along with the error.
+type FileFetcher struct {
+	*gocrawl.DefaultExtender
+
+	fsys      fs.FS
+	mu        sync.RWMutex
+	responses map[string]Response
+}
+
+// NewFileFetcher returns a FileFetcher serving the specified file system, i.e.
+// a fstest.MapFS.
+func NewFileFetcher(fsys fs.FS) *FileFetcher {
+	return &FileFetcher{
+		DefaultExtender: new(gocrawl.DefaultExtender),
+		fsys:            fsys,
+		responses:       make(map[string]Response),
+	}
+}
+
+// NewDirFetcher returns a FileFetcher serving the specified directory.
+func NewDirFetcher(dir string) *FileFetcher {
+	return NewFileFetcher(os.DirFS(dir))
+}
+
+// SetResponse configures the response of the specified host and path.
+func (f *FileFetcher) SetResponse(host, path string, res Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[testfs.Path(&url.URL{Host: host, Path: path})] = res
+}
+
+// Fetch implements the Fetch extender method, reading the response body from
+// the file system.
+func (f *FileFetcher) Fetch(ctx *gocrawl.URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+	req, err := http.NewRequest("GET", ctx.URL().String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Prepare the pseudo-request
+	req.Header.Add("User-Agent", userAgent)
+
+	f.mu.RLock()
+	cfg := f.responses[testfs.Path(req.URL)]
+	f.mu.RUnlock()
+	if cfg.Latency > 0 {
+		time.Sleep(cfg.Latency)
+	}
+
+	res, err := testfs.Response(f.fsys, req)
+	for k, v := range cfg.Header {
+		res.Header[k] = append(res.Header[k], v...)
+	}
+	if cfg.StatusCode == 0 {
+		return res, err
+	}
+	if err != nil {
+		res.Body = http.NoBody
+	}
+	res.StatusCode = cfg.StatusCode
+	res.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	return res, nil
+}
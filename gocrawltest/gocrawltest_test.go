@@ -0,0 +1,95 @@
+package gocrawltest_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/PuerkitoBio/gocrawl/gocrawltest"
+	"github.com/PuerkitoBio/goquery"
+)
+
+func newOptions(ext gocrawl.Extender) *gocrawl.Options {
+	opts := gocrawl.NewOptions(ext)
+	opts.CrawlDelay = 10 * time.Millisecond
+	opts.LogFlags = gocrawl.LogAll
+	return opts
+}
+
+// Runs the AllSameHost case of the package's table tests on the shared
+// testdata directory.
+func TestDirFetcher(t *testing.T) {
+	spy := gocrawltest.NewSpy(gocrawltest.NewDirFetcher("../testdata"))
+	opts := newOptions(spy)
+	opts.SameHostOnly = true
+	c := gocrawl.NewCrawlerWithOptions(opts)
+	if err := c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := spy.CallCount(gocrawltest.MethodVisit); n != 5 {
+		t.Errorf("expected 5 visits, got %d", n)
+	}
+	if n := spy.CallCount(gocrawltest.MethodFilter); n != 13 {
+		t.Errorf("expected 13 filters, got %d", n)
+	}
+	if n := spy.CalledWithCount(gocrawltest.MethodFetch, gocrawltest.Any, opts.UserAgent, false); n != 6 {
+		t.Errorf("expected 6 GET fetches (robots.txt and 5 pages), got %d", n)
+	}
+	if !strings.Contains(spy.Logs(), "worker 1 launched for host hosta") {
+		t.Errorf("expected worker launch in log, got %s", spy.Logs())
+	}
+}
+
+func TestMapFSFetcher(t *testing.T) {
+	ff := gocrawltest.NewFileFetcher(fstest.MapFS{
+		"host/page1.html": {Data: []byte(`<a href="page2.html">2</a><a href="gone.html">3</a><a href="page3.html">3</a>`)},
+		"host/page2.html": {Data: []byte(`page 2`)},
+		"host/page3.html": {Data: []byte(`page 3`)},
+	})
+	ff.SetResponse("host", "/page2.html", gocrawltest.Response{
+		Header:  http.Header{"X-Test": {"2"}},
+		Latency: 50 * time.Millisecond,
+	})
+	ff.SetResponse("host", "/page3.html", gocrawltest.Response{StatusCode: http.StatusServiceUnavailable})
+	ff.SetResponse("host", "/gone.html", gocrawltest.Response{StatusCode: http.StatusGone})
+
+	spy := gocrawltest.NewSpy(ff)
+	var header string
+	var latency time.Duration
+	spy.SetMethod(gocrawltest.MethodVisit, func(ctx *gocrawl.URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		if ctx.URL().Path == "/page2.html" {
+			header, latency = res.Header.Get("X-Test"), ctx.FetchInfo().Duration
+		}
+		return nil, true
+	})
+	var statuses []int
+	spy.SetMethod(gocrawltest.MethodError, func(err *gocrawl.CrawlError) {
+		if err.Kind == gocrawl.CekHttpStatusCode {
+			statuses = append(statuses, err.Ctx.FetchInfo().StatusCode)
+		}
+	})
+	c := gocrawl.NewCrawlerWithOptions(newOptions(spy))
+	if err := c.Run("http://host/page1.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := spy.CallCount(gocrawltest.MethodVisit); n != 2 {
+		t.Errorf("expected 2 visits, got %d", n)
+	}
+	if header != "2" {
+		t.Errorf("expected X-Test header 2, got %q", header)
+	}
+	if latency < 50*time.Millisecond {
+		t.Errorf("expected page2 fetch to take at least 50ms, got %s", latency)
+	}
+	if len(statuses) != 2 {
+		t.Errorf("expected 2 status code errors, got %v", statuses)
+	}
+	if n := spy.CalledWithCount(gocrawltest.MethodFetch, gocrawltest.Any, gocrawltest.Any, false); n != 5 {
+		t.Errorf("expected 5 fetches (robots.txt and 4 pages), got %d", n)
+	}
+}
@@ -0,0 +1,252 @@
+package gocrawltest
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Method identifies an extender method.
+type Method uint8
+
+const (
+	MethodStart Method = iota
+	MethodEnd
+	MethodError
+	MethodComputeDelay
+	MethodFetch
+	MethodRequestRobots
+	MethodRequestGet
+	MethodFetchedRobots
+	MethodFilter
+	MethodEnqueued
+	MethodVisit
+	MethodVisited
+	MethodDisallowed
+	methodCount
+)
+
+var methodNames = [...]string{
+	MethodStart:         "Start",
+	MethodEnd:           "End",
+	MethodError:         "Error",
+	MethodComputeDelay:  "ComputeDelay",
+	MethodFetch:         "Fetch",
+	MethodRequestRobots: "RequestRobots",
+	MethodRequestGet:    "RequestGet",
+	MethodFetchedRobots: "FetchedRobots",
+	MethodFilter:        "Filter",
+	MethodEnqueued:      "Enqueued",
+	MethodVisit:         "Visit",
+	MethodVisited:       "Visited",
+	MethodDisallowed:    "Disallowed",
+}
+
+func (m Method) String() string {
+	if int(m) < len(methodNames) {
+		return methodNames[m]
+	}
+	return "Unknown"
+}
+
+// Any can be used as argument to CalledWithCount to match any value at this
+// position.
+var Any = new(struct{})
+
+// SpyExtender wraps an extender, counting the calls to each extender method
+// and recording their arguments. The methods can be overridden with
+// SetMethod, and the log messages are kept in a buffer.
+type SpyExtender struct {
+	gocrawl.Extender
+
+	mu         sync.RWMutex
+	callCount  map[Method]int
+	methods    map[Method]interface{}
+	calledWith map[Method][][]interface{}
+	logMu      sync.Mutex
+	log        bytes.Buffer
+}
+
+// NewSpy returns a SpyExtender wrapping the specified extender.
+func NewSpy(ext gocrawl.Extender) *SpyExtender {
+	return &SpyExtender{
+		Extender:   ext,
+		callCount:  make(map[Method]int, methodCount),
+		methods:    make(map[Method]interface{}),
+		calledWith: make(map[Method][][]interface{}, methodCount),
+	}
+}
+
+// SetMethod overrides the specified method with f, which must have the same
+// signature as the extender method, i.e. func(*gocrawl.URLContext, bool) bool
+// for MethodFilter. The call is still counted.
+func (x *SpyExtender) SetMethod(m Method, f interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.methods[m] = f
+}
+
+// CallCount returns the number of calls to the specified method.
+func (x *SpyExtender) CallCount(m Method) int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.callCount[m]
+}
+
+// CalledWithCount returns the number of calls to the specified method with
+// the specified arguments, compared with reflect.DeepEqual. Any matches any
+// argument.
+func (x *SpyExtender) CalledWithCount(m Method, args ...interface{}) int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	cnt := 0
+	for _, calledArgs := range x.calledWith[m] {
+		if isCalledWith(calledArgs, args) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Logs returns the messages logged so far, one per line.
+func (x *SpyExtender) Logs() string {
+	x.logMu.Lock()
+	defer x.logMu.Unlock()
+	return x.log.String()
+}
+
+func isCalledWith(actual, compare []interface{}) bool {
+	if len(actual) != len(compare) {
+		return false
+	}
+	for i, v := range actual {
+		if compare[i] == Any {
+			continue
+		}
+		if !reflect.DeepEqual(v, compare[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Register the call and return the overriding method, if any.
+func (x *SpyExtender) register(m Method, args ...interface{}) interface{} {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.callCount[m]++
+	x.calledWith[m] = append(x.calledWith[m], args)
+	return x.methods[m]
+}
+
+// Log implements the Log extender method, keeping the messages that match
+// the log flags in the buffer returned by Logs.
+func (x *SpyExtender) Log(logFlags gocrawl.LogFlags, msgLevel gocrawl.LogFlags, msg string) {
+	if logFlags&msgLevel == msgLevel {
+		x.logMu.Lock()
+		defer x.logMu.Unlock()
+		x.log.WriteString(msg + "\n")
+	}
+}
+
+func (x *SpyExtender) Start(seeds interface{}) interface{} {
+	if f, ok := x.register(MethodStart, seeds).(func(interface{}) interface{}); ok {
+		return f(seeds)
+	}
+	return x.Extender.Start(seeds)
+}
+
+func (x *SpyExtender) End(err error) {
+	if f, ok := x.register(MethodEnd, err).(func(error)); ok {
+		f(err)
+		return
+	}
+	x.Extender.End(err)
+}
+
+func (x *SpyExtender) Error(err *gocrawl.CrawlError) {
+	if f, ok := x.register(MethodError, err).(func(*gocrawl.CrawlError)); ok {
+		f(err)
+		return
+	}
+	x.Extender.Error(err)
+}
+
+func (x *SpyExtender) ComputeDelay(host string, di *gocrawl.DelayInfo, lastFetch *gocrawl.FetchInfo) time.Duration {
+	if f, ok := x.register(MethodComputeDelay, host, di, lastFetch).(func(string, *gocrawl.DelayInfo, *gocrawl.FetchInfo) time.Duration); ok {
+		return f(host, di, lastFetch)
+	}
+	return x.Extender.ComputeDelay(host, di, lastFetch)
+}
+
+func (x *SpyExtender) Fetch(ctx *gocrawl.URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+	if f, ok := x.register(MethodFetch, ctx, userAgent, headRequest).(func(*gocrawl.URLContext, string, bool) (*http.Response, error)); ok {
+		return f(ctx, userAgent, headRequest)
+	}
+	return x.Extender.Fetch(ctx, userAgent, headRequest)
+}
+
+func (x *SpyExtender) RequestRobots(ctx *gocrawl.URLContext, robotAgent string) ([]byte, bool) {
+	if f, ok := x.register(MethodRequestRobots, ctx, robotAgent).(func(*gocrawl.URLContext, string) ([]byte, bool)); ok {
+		return f(ctx, robotAgent)
+	}
+	return x.Extender.RequestRobots(ctx, robotAgent)
+}
+
+func (x *SpyExtender) RequestGet(ctx *gocrawl.URLContext, headRes *http.Response) bool {
+	if f, ok := x.register(MethodRequestGet, ctx, headRes).(func(*gocrawl.URLContext, *http.Response) bool); ok {
+		return f(ctx, headRes)
+	}
+	return x.Extender.RequestGet(ctx, headRes)
+}
+
+func (x *SpyExtender) FetchedRobots(ctx *gocrawl.URLContext, res *http.Response) {
+	if f, ok := x.register(MethodFetchedRobots, ctx, res).(func(*gocrawl.URLContext, *http.Response)); ok {
+		f(ctx, res)
+		return
+	}
+	x.Extender.FetchedRobots(ctx, res)
+}
+
+func (x *SpyExtender) Filter(ctx *gocrawl.URLContext, isVisited bool) bool {
+	if f, ok := x.register(MethodFilter, ctx, isVisited).(func(*gocrawl.URLContext, bool) bool); ok {
+		return f(ctx, isVisited)
+	}
+	return x.Extender.Filter(ctx, isVisited)
+}
+
+func (x *SpyExtender) Enqueued(ctx *gocrawl.URLContext) {
+	if f, ok := x.register(MethodEnqueued, ctx).(func(*gocrawl.URLContext)); ok {
+		f(ctx)
+		return
+	}
+	x.Extender.Enqueued(ctx)
+}
+
+func (x *SpyExtender) Visit(ctx *gocrawl.URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+	if f, ok := x.register(MethodVisit, ctx, res, doc).(func(*gocrawl.URLContext, *http.Response, *goquery.Document) (interface{}, bool)); ok {
+		return f(ctx, res, doc)
+	}
+	return x.Extender.Visit(ctx, res, doc)
+}
+
+func (x *SpyExtender) Visited(ctx *gocrawl.URLContext, harvested interface{}) {
+	if f, ok := x.register(MethodVisited, ctx, harvested).(func(*gocrawl.URLContext, interface{})); ok {
+		f(ctx, harvested)
+		return
+	}
+	x.Extender.Visited(ctx, harvested)
+}
+
+func (x *SpyExtender) Disallowed(ctx *gocrawl.URLContext) {
+	if f, ok := x.register(MethodDisallowed, ctx).(func(*gocrawl.URLContext)); ok {
+		f(ctx)
+		return
+	}
+	x.Extender.Disallowed(ctx)
+}
@@ -0,0 +1,390 @@
+package gocrawl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The JSON encoding of a HAR 1.2 document, with the custom _robots and
+// _error fields of the entries, and the custom _truncated field of their
+// content.
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Robots          bool        `json:"_robots,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+// The timings of an entry, in milliseconds, -1 if not applicable.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harRecorder records the requests and responses of the HTTP client as HAR
+// entries, written as a HAR 1.2 document when flushed. It is shared by the
+// workers, so it is safe for concurrent use.
+type harRecorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	maxBody int64
+	entries []*harEntry
+	written bool
+}
+
+func newHARRecorder(w io.Writer, maxBody int64) *harRecorder {
+	return &harRecorder{w: w, maxBody: maxBody}
+}
+
+// Wrap the round tripper so that its requests and responses are recorded.
+func (hr *harRecorder) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &harTransport{rt, hr}
+}
+
+func (hr *harRecorder) add(e *harEntry) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.entries = append(hr.entries, e)
+}
+
+// Write the entries recorded since the last flush as a HAR document, ordered
+// by start time. If always is false, nothing is written if there is no
+// entry and a document was already written.
+func (hr *harRecorder) flush(always bool) error {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if !always && hr.written && len(hr.entries) == 0 {
+		return nil
+	}
+	entries := hr.entries
+	if entries == nil {
+		entries = []*harEntry{}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	b, err := json.Marshal(&harDocument{harLog{"1.2", harCreator{"gocrawl", "1.0.0"}, entries}})
+	if err != nil {
+		return err
+	}
+	hr.entries, hr.written = nil, true
+	_, err = hr.w.Write(append(b, '\n'))
+	return err
+}
+
+// harTransport records the round trips of its transport.
+type harTransport struct {
+	rt http.RoundTripper
+	hr *harRecorder
+}
+
+// RoundTrip executes the request with a client trace to get its timings,
+// and records the exchange once the response body is closed, or right away
+// if the request fails.
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := newFetchTrace()
+	e := &harEntry{
+		StartedDateTime: tr.start,
+		Request:         newHARRequest(req),
+		Robots:          isRobotsURL(req.URL),
+	}
+	res, err := t.rt.RoundTrip(tr.withTrace(req))
+	if err != nil {
+		e.Response = harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1}
+		e.Error = err.Error()
+		tr.setHARTimings(e, time.Now())
+		t.hr.add(e)
+		return nil, err
+	}
+	e.Response = newHARResponse(res)
+	res.Body = &harBody{ReadCloser: res.Body, length: res.ContentLength, e: e, tr: tr, hr: t.hr}
+	return res, nil
+}
+
+// Set the timings of the entry, the exchange being complete at end.
+func (tr *fetchTrace) setHARTimings(e *harEntry, end time.Time) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	ms := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return -1
+		}
+		return float64(to.Sub(from)) / float64(time.Millisecond)
+	}
+	t := harTimings{
+		DNS:     ms(tr.dnsStart, tr.dnsDone),
+		Connect: ms(tr.connectStart, tr.connectDone),
+		SSL:     ms(tr.tlsStart, tr.tlsDone),
+		Send:    ms(tr.gotConn, tr.wroteReq),
+		Wait:    ms(tr.wroteReq, tr.firstByte),
+		Receive: ms(tr.firstByte, end),
+	}
+	if t.SSL >= 0 {
+		// The TLS handshake is part of the connection time
+		t.Connect = ms(tr.connectStart, tr.tlsDone)
+	}
+	for _, d := range []*float64{&t.Send, &t.Wait, &t.Receive} {
+		// Those timings are required, not applicable is zero
+		if *d < 0 {
+			*d = 0
+		}
+	}
+	t.Blocked = ms(tr.start, tr.gotConn)
+	if t.Blocked >= 0 {
+		for _, d := range []float64{t.DNS, t.Connect} {
+			if d > 0 {
+				t.Blocked -= d
+			}
+		}
+		if t.Blocked < 0 {
+			t.Blocked = 0
+		}
+	}
+	for _, d := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if d > 0 {
+			e.Time += d
+		}
+	}
+	e.Timings = t
+	if host := tr.remoteAddr; host != "" {
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		e.ServerIPAddress = strings.Trim(host, "[]")
+	}
+}
+
+// harBody captures the response body as it is read, up to the maximum body
+// size of the recorder, and records the entry when it is closed. The body is
+// not read on close, so the entry only has the bytes read by the crawler,
+// and its content is flagged as truncated if they are not the whole body.
+type harBody struct {
+	io.ReadCloser
+	buf    bytes.Buffer
+	size   int64
+	length int64
+	eof    bool
+	closed bool
+	e      *harEntry
+	tr     *fetchTrace
+	hr     *harRecorder
+}
+
+// Read reads from the body and captures the bytes read.
+func (hb *harBody) Read(p []byte) (int, error) {
+	n, err := hb.ReadCloser.Read(p)
+	hb.capture(p[:n])
+	if err == io.EOF {
+		hb.eof = true
+	}
+	return n, err
+}
+
+func (hb *harBody) capture(p []byte) {
+	hb.size += int64(len(p))
+	if left := hb.hr.maxBody - int64(hb.buf.Len()); left > 0 {
+		if int64(len(p)) > left {
+			p = p[:left]
+		}
+		hb.buf.Write(p)
+	}
+}
+
+// Close closes the body and records the entry.
+func (hb *harBody) Close() error {
+	if hb.closed {
+		return nil
+	}
+	hb.closed = true
+	err := hb.ReadCloser.Close()
+
+	// The size of the body is only known if it was read to the end, or if
+	// its announced length was read (there is no body for a HEAD request)
+	complete := hb.eof || hb.size == hb.length || hb.ReadCloser == http.NoBody
+	c := &hb.e.Response.Content
+	c.Size = hb.size
+	hb.e.Response.BodySize = hb.size
+	if !complete {
+		hb.e.Response.BodySize = hb.length
+	}
+	if hb.hr.maxBody > 0 {
+		if hb.buf.Len() > 0 {
+			if isHARText(c.MimeType) {
+				c.Text = hb.buf.String()
+			} else {
+				c.Text, c.Encoding = base64.StdEncoding.EncodeToString(hb.buf.Bytes()), "base64"
+			}
+		}
+		c.Truncated = !complete || hb.size > int64(hb.buf.Len())
+	}
+	hb.tr.setHARTimings(hb.e, time.Now())
+	hb.hr.add(hb.e)
+	return err
+}
+
+func newHARRequest(req *http.Request) harRequest {
+	r := harRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+	}
+	if r.HTTPVersion == "" {
+		r.HTTPVersion = "HTTP/1.1"
+	}
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range q[k] {
+			r.QueryString = append(r.QueryString, harNameValue{k, v})
+		}
+	}
+	if req.ContentLength > 0 {
+		r.BodySize = int(req.ContentLength)
+	}
+	return r
+}
+
+func newHARResponse(res *http.Response) harResponse {
+	r := harResponse{
+		Status:      res.StatusCode,
+		StatusText:  http.StatusText(res.StatusCode),
+		HTTPVersion: res.Proto,
+		Cookies:     harCookies(res.Cookies()),
+		Headers:     harHeaders(res.Header),
+		Content:     harContent{MimeType: res.Header.Get("Content-Type")},
+		HeadersSize: -1,
+	}
+	// The status text follows the status code in the Status field
+	if i := strings.IndexByte(res.Status, ' '); i >= 0 {
+		r.StatusText = res.Status[i+1:]
+	}
+	if r.HTTPVersion == "" {
+		r.HTTPVersion = "HTTP/1.1"
+	}
+	if loc, err := res.Location(); err == nil {
+		// Resolve a relative Location against the request URL
+		r.RedirectURL = loc.String()
+	}
+	return r
+}
+
+// Return the headers as name-value pairs, sorted by name.
+func harHeaders(h http.Header) []harNameValue {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]harNameValue, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range h[k] {
+			res = append(res, harNameValue{k, v})
+		}
+	}
+	return res
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	res := make([]harNameValue, 0, len(cookies))
+	for _, c := range cookies {
+		res = append(res, harNameValue{c.Name, c.Value})
+	}
+	return res
+}
+
+// Indicates if the body of the media type is included as text in a HAR
+// entry, otherwise it is encoded in base64.
+func isHARText(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+xml"),
+		strings.HasSuffix(mt, "+json"),
+		mt == "application/json",
+		mt == "application/xml",
+		mt == "application/javascript":
+		return true
+	}
+	return false
+}
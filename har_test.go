@@ -0,0 +1,145 @@
+package gocrawl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHARWriter(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/redir">r</a><a href="/img.png">i</a><a href="/big">b</a></body></html>`)
+		case "/redir":
+			http.Redirect(w, r, "/page2", http.StatusFound)
+		case "/img.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, strings.Repeat("a", 200))
+		default:
+			fmt.Fprint(w, "<html><body>ok</body></html>")
+		}
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	opts := NewOptions(new(DefaultExtender))
+	opts.CrawlDelay = time.Millisecond
+	opts.LogFlags = LogNone
+	opts.HARWriter = &buf
+	opts.HARMaxBodySize = 150
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run(srv.URL + "/page"); err != nil {
+		t.Fatalf("run failed with %v", err)
+	}
+	// The entries are written once, an empty document is written if forced
+	if err := c.FlushHAR(); err != nil {
+		t.Fatal(err)
+	}
+
+	var docs []*harDocument
+	dec := json.NewDecoder(&buf)
+	for {
+		var doc harDocument
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, &doc)
+	}
+	if len(docs) != 2 || len(docs[1].Log.Entries) != 0 {
+		t.Fatalf("expected 2 documents, the last one empty, got %d", len(docs))
+	}
+	log := docs[0].Log
+	if log.Version != "1.2" || log.Creator.Name != "gocrawl" {
+		t.Errorf("expected a HAR 1.2 log by gocrawl, got %s by %s", log.Version, log.Creator.Name)
+	}
+
+	entries := make(map[string]*harEntry)
+	for i, e := range log.Entries {
+		if i > 0 && e.StartedDateTime.Before(log.Entries[i-1].StartedDateTime) {
+			t.Errorf("expected the entries ordered by start time")
+		}
+		entries[strings.TrimPrefix(e.Request.URL, srv.URL)] = e
+	}
+	if len(entries) != 6 {
+		t.Fatalf("expected 6 entries, got %d", len(entries))
+	}
+
+	if e := entries["/robots.txt"]; !e.Robots || e.Response.Status != 404 {
+		t.Errorf("expected the robots.txt entry to be tagged with a 404, got %v %d", e.Robots, e.Response.Status)
+	}
+	e := entries["/page"]
+	if e.Robots || e.Request.Method != "GET" || e.Response.Status != 200 || e.Response.StatusText != "OK" {
+		t.Errorf("expected a GET of the page with a 200 OK, got %s %d %s", e.Request.Method, e.Response.Status, e.Response.StatusText)
+	}
+	var ua string
+	for _, h := range e.Request.Headers {
+		if h.Name == "User-Agent" {
+			ua = h.Value
+		}
+	}
+	if ua != DefaultUserAgent {
+		t.Errorf("expected the user agent in the request headers, got %q", ua)
+	}
+	if c := e.Response.Content; c.MimeType != "text/html" || !strings.HasPrefix(c.Text, "<html>") || c.Encoding != "" {
+		t.Errorf("expected the text body of the page, got %+v", c)
+	}
+	if e.ServerIPAddress != "127.0.0.1" || e.Timings.Wait < 0 || e.Timings.Receive < 0 || e.Time <= 0 {
+		t.Errorf("expected the server address and timings, got %s %+v", e.ServerIPAddress, e.Timings)
+	}
+
+	if e := entries["/redir"]; e.Response.Status != 302 || e.Response.RedirectURL != srv.URL+"/page2" {
+		t.Errorf("expected the redirection to page2, got %d %s", e.Response.Status, e.Response.RedirectURL)
+	}
+	if e := entries["/page2"]; e == nil || e.Response.Status != 200 {
+		t.Errorf("expected the redirection target to be fetched")
+	}
+	if c := entries["/img.png"].Response.Content; c.Encoding != "base64" || c.Text != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("expected the binary body in base64, got %+v", c)
+	}
+	if c := entries["/big"].Response.Content; c.Size != 200 || c.Text != strings.Repeat("a", 150) || !c.Truncated {
+		t.Errorf("expected the truncated body, got %d %q %v", c.Size, c.Text, c.Truncated)
+	}
+	if c := entries["/page"].Response.Content; c.Truncated {
+		t.Errorf("expected the whole body of the page, got %+v", c)
+	}
+}
+
+func TestHARFetchError(t *testing.T) {
+	var buf bytes.Buffer
+
+	hr := newHARRecorder(&buf, DefaultHARMaxBodySize)
+	client := &http.Client{Transport: hr.wrap(http.DefaultTransport)}
+	if _, err := client.Get("http://127.0.0.1:1/page"); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if err := hr.flush(false); err != nil {
+		t.Fatal(err)
+	}
+	var doc harDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(doc.Log.Entries))
+	}
+	if e := doc.Log.Entries[0]; e.Error == "" || e.Response.Status != 0 {
+		t.Errorf("expected the error without response, got %q %d", e.Error, e.Response.Status)
+	}
+}
@@ -0,0 +1,162 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// The types accepted for the harvested URLs returned by a visit, listed in
+// the warning logged for an unsupported type.
+const harvestedTypes = "string, []string, *url.URL, []*url.URL, map[string]interface{} (or S), " +
+	"map[*url.URL]interface{} (or U), EnqueueItem, []EnqueueItem, *URLContext, []*URLContext"
+
+// harvestedLink is an URL harvested by a visit, in the internal form sent by
+// the worker to the crawler whatever the form returned by the visit: the URL
+// with its state and per-URL settings, and the metadata of its link if it
+// was harvested by the default links processing. The URL contexts returned
+// by a visit are enqueued as is, in ctx.
+type harvestedLink struct {
+	item EnqueueItem
+	info *LinkInfo
+	ctx  *URLContext
+}
+
+// harvestDoc is a document whose links are processed by the crawler, with
+// its base URL (from the base tag), and the number of its links that cannot
+// be parsed, logged once for the page along with the last parse error.
+type harvestDoc struct {
+	doc        *goquery.Document
+	baseURL    string
+	unparsable int
+	lastErr    error
+}
+
+// The characters percent-encoded in the harvested links, invalid in URLs
+// but common in the wild.
+const hrefEscapedChars = " \"<>`^{|}"
+
+// Clean the value of a link attribute, whose HTML entities are decoded by
+// the parser, before it is parsed: the leading and trailing whitespace and
+// control characters are trimmed, the tabs and newlines within are removed
+// (as browsers do), and the other control characters and the hrefEscapedChars
+// are percent-encoded, so that i.e. "page 2.html" and "page%202.html" are the
+// same URL.
+func cleanHref(s string) string {
+	s = strings.TrimFunc(s, func(r rune) bool { return r <= ' ' || r == 0x7f })
+	if strings.IndexFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f || strings.ContainsRune(hrefEscapedChars, r) }) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\t' || c == '\n' || c == '\r':
+			// Dropped
+		case c < ' ' || c == 0x7f || strings.IndexByte(hrefEscapedChars, c) >= 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Convert the harvested value returned by a visit to harvested links. The
+// strings are parsed (the parse errors are notified, and the URL dropped),
+// and an unsupported type is logged as a warning, no URL being followed.
+func (w *worker) harvestedLinks(ctx *URLContext, harvested interface{}, links map[*url.URL]*LinkInfo) []harvestedLink {
+	var res []harvestedLink
+
+	addString := func(s string, st interface{}) {
+		u, err := url.Parse(s)
+		if err != nil {
+			w.notifyError(newCrawlError(nil, err, CekParseURL))
+			w.logFunc(LogError, "ERROR parsing URL %s", s)
+			return
+		}
+		res = append(res, harvestedLink{item: EnqueueItem{URL: u, State: st}})
+	}
+	addURL := func(u *url.URL, st interface{}) {
+		res = append(res, harvestedLink{item: EnqueueItem{URL: u, State: st}, info: links[u]})
+	}
+
+	switch v := harvested.(type) {
+	case nil:
+	case string:
+		addString(v, nil)
+	case []string:
+		for _, s := range v {
+			addString(s, nil)
+		}
+	case map[string]interface{}:
+		for s, st := range v {
+			addString(s, st)
+		}
+	case S:
+		for s, st := range v {
+			addString(s, st)
+		}
+	case *url.URL:
+		addURL(v, nil)
+	case []*url.URL:
+		res = make([]harvestedLink, 0, len(v))
+		for _, u := range v {
+			addURL(u, nil)
+		}
+	case map[*url.URL]interface{}:
+		for u, st := range v {
+			addURL(u, st)
+		}
+	case U:
+		for u, st := range v {
+			addURL(u, st)
+		}
+	case EnqueueItem:
+		res = []harvestedLink{{item: v}}
+	case []EnqueueItem:
+		res = make([]harvestedLink, 0, len(v))
+		for _, it := range v {
+			res = append(res, harvestedLink{item: it})
+		}
+	case *URLContext:
+		res = []harvestedLink{{ctx: v}}
+	case []*URLContext:
+		res = make([]harvestedLink, 0, len(v))
+		for _, uc := range v {
+			res = append(res, harvestedLink{ctx: uc})
+		}
+	default:
+		w.logFunc(LogError, "WARNING unsupported type %T of the URLs harvested from %s, no URL followed (accepted types: %s)", harvested, ctx.url, harvestedTypes)
+	}
+	return res
+}
+
+// Compile the selector of the HarvestSelector option. Returns a nil matcher
+// for an empty selector, the whole page is harvested.
+func compileHarvestSelector(sel string) (goquery.Matcher, error) {
+	if sel == "" {
+		return nil, nil
+	}
+	return cascadia.Compile(sel)
+}
+
+// Return the selection of the document whose anchors and images are
+// harvested, and the scope noted in their LinkInfo, per the HarvestSelector
+// and HarvestSelectorFallback options. The selection is empty if nothing is
+// harvested.
+func (w *worker) harvestRoot(doc *goquery.Document) (*goquery.Selection, string) {
+	if w.harvestScope == nil {
+		return doc.Selection, ""
+	}
+	sel := doc.FindMatcher(w.harvestScope)
+	if sel.Length() == 0 {
+		w.logFunc(LogTrace, "no element matches the harvest selector in %s", doc.Url)
+		if w.opts.HarvestSelectorFallback == HarvestWholePage {
+			return doc.Selection, ""
+		}
+	}
+	return sel, w.opts.HarvestSelector
+}
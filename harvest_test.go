@@ -0,0 +1,153 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+type harvestExtender struct {
+	DefaultExtender
+	harvest func(ctx *URLContext) interface{}
+
+	mu      sync.Mutex
+	states  map[string]interface{}
+	methods map[string]string
+	logs    []string
+}
+
+func (x *harvestExtender) Visit(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+	if ctx.url.Path != "/" {
+		return nil, false
+	}
+	return x.harvest(ctx), false
+}
+
+func (x *harvestExtender) Filter(ctx *URLContext, isVisited bool) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.states[ctx.url.Path] = ctx.State
+	return !isVisited
+}
+
+func (x *harvestExtender) Fetch(ctx *URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+	x.mu.Lock()
+	if headRequest {
+		x.methods[ctx.url.Path] = "HEAD"
+	} else {
+		x.methods[ctx.url.Path] = "GET"
+	}
+	x.mu.Unlock()
+	return x.DefaultExtender.Fetch(ctx, userAgent, headRequest)
+}
+
+func (x *harvestExtender) Log(logFlags LogFlags, msgLevel LogFlags, msg string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.logs = append(x.logs, msg)
+}
+
+func TestVisitHarvestedTypes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>ok</body></html>")
+	}))
+	defer srv.Close()
+
+	abs := func(p string) *url.URL {
+		u, _ := url.Parse(srv.URL + p)
+		return u
+	}
+	cases := []struct {
+		nm      string
+		harvest interface{}
+		states  map[string]interface{}
+		methods map[string]string
+	}{
+		{"strings", []string{"/a", "b"}, map[string]interface{}{"/a": nil, "/b": nil}, nil},
+		{"urls", []*url.URL{abs("/a")}, map[string]interface{}{"/a": nil}, nil},
+		{"S", S{"/a": 1, "/b": "two"}, map[string]interface{}{"/a": 1, "/b": "two"}, nil},
+		{"U", map[*url.URL]interface{}{abs("/a"): 1}, map[string]interface{}{"/a": 1}, nil},
+		{"item", EnqueueItem{URL: abs("/a"), State: "a", HeadOnly: true},
+			map[string]interface{}{"/a": "a"}, map[string]string{"/a": "HEAD"}},
+		{"items", []EnqueueItem{{URL: abs("/a"), State: "a"}, {URL: abs("/b"), State: 2, HeadOnly: true}},
+			map[string]interface{}{"/a": "a", "/b": 2}, map[string]string{"/a": "GET", "/b": "HEAD"}},
+		{"unsupported", []int{1, 2}, map[string]interface{}{}, nil},
+	}
+	for _, c := range cases {
+		ext := &harvestExtender{
+			harvest: func(ctx *URLContext) interface{} { return c.harvest },
+			states:  make(map[string]interface{}),
+			methods: make(map[string]string),
+		}
+		opts := NewOptions(ext)
+		opts.CrawlDelay = time.Millisecond
+		opts.LogFlags = LogError
+		cr := NewCrawlerWithOptions(opts)
+		if err := cr.Run(srv.URL + "/"); err != nil {
+			t.Fatalf("%s: run failed with %v", c.nm, err)
+		}
+
+		delete(ext.states, "/")
+		if fmt.Sprint(ext.states) != fmt.Sprint(c.states) {
+			t.Errorf("%s: want states %v, got %v", c.nm, c.states, ext.states)
+		}
+		for p, m := range c.methods {
+			if got := ext.methods[p]; got != m {
+				t.Errorf("%s: want %s request for %s, got %q", c.nm, m, p, got)
+			}
+		}
+		warned := false
+		for _, l := range ext.logs {
+			if strings.Contains(l, "WARNING unsupported type []int") && strings.Contains(l, "[]EnqueueItem") {
+				warned = true
+			}
+		}
+		if warned != (c.nm == "unsupported") {
+			t.Errorf("%s: want warning=%v, got %v: %v", c.nm, c.nm == "unsupported", warned, ext.logs)
+		}
+	}
+}
+
+func TestCleanHref(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"page.html", "page.html"},
+		{"  /page.html\n ", "/page.html"},
+		{"\x00\tpage.html\x7f", "page.html"},
+		{"/pa\nge\r\n.html", "/page.html"},
+		{"page 2.html?q=a b", "page%202.html?q=a%20b"},
+		{"page.html?q={x}|y", "page.html?q=%7Bx%7D%7Cy"},
+		{`page.html?q="x"`, "page.html?q=%22x%22"},
+		{"page%202.html", "page%202.html"},
+		{"page.html?q=\x01", "page.html?q=%01"},
+		{"page-é.html", "page-é.html"},
+	}
+	for _, c := range cases {
+		if got := cleanHref(c.in); got != c.want {
+			t.Errorf("%q: want %q, got %q", c.in, c.want, got)
+		}
+	}
+
+	// The HTML entities of the attributes are decoded by the parser
+	node, err := html.Parse(strings.NewReader(`<a href="page.html?a=1&amp;b=2&#38;c=3&lt;">x</a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if href, _ := goquery.NewDocumentFromNode(node).Find("a").Attr("href"); cleanHref(href) != "page.html?a=1&b=2&c=3%3C" {
+		t.Errorf("want the entities decoded, got %q", href)
+	}
+}
@@ -0,0 +1,175 @@
+package gocrawl
+
+import (
+	"sync"
+	"time"
+)
+
+// The delay before popping again from the Frontier after an error.
+var frontierRetryDelay = time.Second
+
+// The host queue holds the URLs waiting to be processed by a worker. The
+// robots.txt URL is kept by the queue and always popped first, the other URLs
+// are pushed to and popped from the Frontier. While the queue is paused, no
+// URL is popped.
+type hostQueue struct {
+	host     string
+	frontier Frontier
+	ancestry *ancestry
+	mu       sync.Mutex
+	robots   []*URLContext
+	paused   bool
+	signal   chan struct{}
+}
+
+// Constructor to create and initialize a hostQueue
+func newHostQueue(host string, frontier Frontier) *hostQueue {
+	// The signal channel only needs to hold a single pending wakeup, many pushes
+	// before a pop are coalesced into this one signal.
+	return &hostQueue{
+		host:     host,
+		frontier: frontier,
+		signal:   make(chan struct{}, 1),
+	}
+}
+
+// The push function adds the specified URLs to the queue and wakes up the
+// worker if it is waiting. It returns the URLs that could not be pushed to
+// the Frontier, along with the last error.
+func (q *hostQueue) push(ctxs ...*URLContext) (failed []*URLContext, err error) {
+	for _, ctx := range ctxs {
+		if ctx.IsRobotsURL() {
+			q.mu.Lock()
+			q.robots = append(q.robots, ctx)
+			q.mu.Unlock()
+		} else if e := q.frontier.Push(ctx); e != nil {
+			failed, err = append(failed, ctx), e
+		}
+	}
+
+	if len(failed) < len(ctxs) {
+		q.wake()
+	}
+	return failed, err
+}
+
+// The wake function wakes up the worker if it is waiting. It never blocks.
+func (q *hostQueue) wake() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+		// A wakeup is already pending
+	}
+}
+
+// The pop function returns the next URL to process, or false if the queue
+// is empty or paused.
+func (q *hostQueue) pop() (*URLContext, bool, error) {
+	q.mu.Lock()
+	if q.paused {
+		q.mu.Unlock()
+		return nil, false, nil
+	}
+	if len(q.robots) > 0 {
+		ctx := q.robots[0]
+		q.robots = q.robots[1:]
+		q.mu.Unlock()
+		return ctx, true, nil
+	}
+	q.mu.Unlock()
+
+	// The Frontier may be slow, do not hold the lock
+	ctx, ok, err := q.frontier.Pop(q.host)
+	if ok && ctx.ancestry == nil {
+		// Decoded by an external Frontier
+		ctx.ancestry = q.ancestry
+	}
+	return ctx, ok, err
+}
+
+// The pause function pauses or resumes the queue. When it is resumed, the
+// worker is woken up if URLs are waiting.
+func (q *hostQueue) pause(paused bool) {
+	q.mu.Lock()
+	q.paused = paused
+	q.mu.Unlock()
+
+	if !paused && q.len() > 0 {
+		q.wake()
+	}
+}
+
+// The isPaused function indicates if the queue is paused.
+func (q *hostQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// The dropOldest function removes the URL that was pushed first (ignoring
+// the robots.txt URL) and returns it, or false if there is none or if the
+// Frontier does not implement FrontierDropper.
+func (q *hostQueue) dropOldest() (*URLContext, bool, error) {
+	if d, ok := q.frontier.(FrontierDropper); ok {
+		return d.DropOldest(q.host)
+	}
+	return nil, false, nil
+}
+
+// The wait function returns the channel that receives a value when URLs
+// have been pushed to the queue.
+func (q *hostQueue) wait() <-chan struct{} {
+	return q.signal
+}
+
+// The len function returns the number of URLs in the queue.
+func (q *hostQueue) len() int {
+	q.mu.Lock()
+	n := len(q.robots)
+	q.mu.Unlock()
+	return n + q.frontier.Len(q.host)
+}
+
+// A queued URL, with its insertion sequence number to keep the ordering
+// stable for the same priority.
+type queueItem struct {
+	ctx *URLContext
+	seq uint64
+}
+
+// The queueItems type implements heap.Interface.
+type queueItems struct {
+	items []*queueItem
+	lifo  bool
+}
+
+func (qi *queueItems) Len() int { return len(qi.items) }
+
+func (qi *queueItems) Less(i, j int) bool {
+	a, b := qi.items[i], qi.items[j]
+	if ra, rb := a.ctx.IsRobotsURL(), b.ctx.IsRobotsURL(); ra != rb {
+		return ra
+	}
+	if a.ctx.priority != b.ctx.priority {
+		return a.ctx.priority > b.ctx.priority
+	}
+	if qi.lifo {
+		return a.seq > b.seq
+	}
+	return a.seq < b.seq
+}
+
+func (qi *queueItems) Swap(i, j int) { qi.items[i], qi.items[j] = qi.items[j], qi.items[i] }
+
+func (qi *queueItems) Push(x interface{}) {
+	qi.items = append(qi.items, x.(*queueItem))
+}
+
+func (qi *queueItems) Pop() interface{} {
+	old := qi.items
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	qi.items = old[:n-1]
+	return it
+}
@@ -0,0 +1,134 @@
+package gocrawl
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func mustQueueCtx(t *testing.T, s string, prio int) *URLContext {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("failed to parse URL %s", s)
+	}
+	return &URLContext{url: u, normalizedURL: u, priority: prio}
+}
+
+func assertPopOrder(t *testing.T, q *hostQueue, want []string) {
+	for i, w := range want {
+		ctx, ok, _ := q.pop()
+		if !ok {
+			t.Fatalf("%d: expected a URL to pop", i)
+		}
+		if ctx.url.Path != w {
+			t.Errorf("%d: want %s, got %s", i, w, ctx.url.Path)
+		}
+	}
+	if _, ok, _ := q.pop(); ok {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestHostQueueOrder(t *testing.T) {
+	mk := func(s string, prio int) *URLContext {
+		return mustQueueCtx(t, s, prio)
+	}
+
+	q := newHostQueue("host", NewMemoryFrontier(OrderBFS))
+	q.push(mk("http://host/a", 0), mk("http://host/b", 1), mk("http://host/c", 0))
+	q.push(mk("http://host/robots.txt", 0), mk("http://host/d", 1))
+
+	select {
+	case <-q.wait():
+	default:
+		t.Fatal("expected a pending wakeup signal")
+	}
+	if n := q.len(); n != 5 {
+		t.Errorf("want 5 queued URLs, got %d", n)
+	}
+
+	assertPopOrder(t, q, []string{"/robots.txt", "/b", "/d", "/a", "/c"})
+}
+
+func TestHostQueueOrderDFS(t *testing.T) {
+	mk := func(s string, prio int) *URLContext {
+		return mustQueueCtx(t, s, prio)
+	}
+
+	q := newHostQueue("host", NewMemoryFrontier(OrderDFS))
+	q.push(mk("http://host/robots.txt", 0), mk("http://host/a", 0), mk("http://host/b", 1), mk("http://host/c", 0))
+	q.push(mk("http://host/d", 1), mk("http://host/e", 0))
+
+	assertPopOrder(t, q, []string{"/robots.txt", "/d", "/b", "/e", "/c", "/a"})
+}
+
+func TestHostQueuePause(t *testing.T) {
+	q := newHostQueue("host", NewMemoryFrontier(OrderBFS))
+	q.push(mustQueueCtx(t, "http://host/a", 0))
+	<-q.wait()
+
+	q.pause(true)
+	if _, ok, _ := q.pop(); ok {
+		t.Error("expected no URL to pop from a paused queue")
+	}
+	q.pause(false)
+	select {
+	case <-q.wait():
+	default:
+		t.Error("expected a wakeup when the queue is resumed")
+	}
+	assertPopOrder(t, q, []string{"/a"})
+}
+
+func TestHostQueueDropOldest(t *testing.T) {
+	q := newHostQueue("host", NewMemoryFrontier(OrderDFS))
+	q.push(
+		mustQueueCtx(t, "http://host/robots.txt", 0),
+		mustQueueCtx(t, "http://host/a", 0),
+		mustQueueCtx(t, "http://host/b", 1),
+		mustQueueCtx(t, "http://host/c", 0),
+	)
+	for _, want := range []string{"/a", "/b"} {
+		if ctx, ok, _ := q.dropOldest(); !ok || ctx.url.Path != want {
+			t.Errorf("want %s dropped, got %v", want, ctx)
+		}
+	}
+	assertPopOrder(t, q, []string{"/robots.txt", "/c"})
+	if _, ok, _ := q.dropOldest(); ok {
+		t.Error("expected no URL to drop from an empty queue")
+	}
+}
+
+type failingFrontier struct {
+	*MemoryFrontier
+}
+
+func (f failingFrontier) Push(ctx *URLContext) error {
+	if ctx.url.Path == "/b" {
+		return errors.New("push failed")
+	}
+	return f.MemoryFrontier.Push(ctx)
+}
+
+func TestHostQueueFrontierErrors(t *testing.T) {
+	q := newHostQueue("host", failingFrontier{NewMemoryFrontier(OrderBFS)})
+	failed, err := q.push(
+		mustQueueCtx(t, "http://host/a", 0),
+		mustQueueCtx(t, "http://host/b", 0),
+		mustQueueCtx(t, "http://host/robots.txt", 0),
+	)
+	if err == nil || len(failed) != 1 || failed[0].url.Path != "/b" {
+		t.Errorf("expected /b to fail, got %v (%v)", failed, err)
+	}
+	if n := q.len(); n != 2 {
+		t.Errorf("want 2 queued URLs, got %d", n)
+	}
+	assertPopOrder(t, q, []string{"/robots.txt", "/a"})
+
+	// Without FrontierDropper, nothing can be dropped
+	q = newHostQueue("host", struct{ Frontier }{NewMemoryFrontier(OrderBFS)})
+	q.push(mustQueueCtx(t, "http://host/a", 0))
+	if _, ok, _ := q.dropOldest(); ok {
+		t.Error("expected no URL to drop without a FrontierDropper")
+	}
+}
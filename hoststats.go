@@ -0,0 +1,118 @@
+package gocrawl
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The number of URLs of each host blocked on full queues with the
+// BlockOnFull policy, so that a host is not complete while some of its URLs
+// wait to be enqueued. It is updated by the crawler and read by the workers.
+type blockedHosts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newBlockedHosts() *blockedHosts {
+	return &blockedHosts{counts: make(map[string]int)}
+}
+
+// Add n blocked URLs to the host, n being negative for the released URLs.
+func (b *blockedHosts) add(host string, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.counts[host] += n; b.counts[host] <= 0 {
+		delete(b.counts, host)
+	}
+}
+
+// Indicates if some URLs of the host are blocked.
+func (b *blockedHosts) has(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts[host] > 0
+}
+
+// The statistics of a host worker, for the HostComplete extender method. The
+// errors may be notified from the visitors' goroutines.
+type hostStats struct {
+	mu      sync.Mutex
+	stats   HostStats
+	delays  time.Duration
+	ndelays int
+	bytes   int64
+}
+
+func newHostStats(robots RobotsStatus, tags map[string]string) *hostStats {
+	return &hostStats{stats: HostStats{Errors: make(map[CrawlErrorKind]int), Robots: robots, Tags: tags}}
+}
+
+// Count an error of the kind.
+func (s *hostStats) addError(kind CrawlErrorKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Errors[kind]++
+}
+
+// Count a request and its duration.
+func (s *hostStats) addFetch(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Fetches++
+	s.stats.FetchTime += d
+}
+
+// Count a request served from the response cache.
+func (s *hostStats) addCacheHit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.CacheHits++
+}
+
+// Count a crawl delay applied after a fetch.
+func (s *hostStats) addDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays += d
+	s.ndelays++
+}
+
+// Count a HEAD request rejected by the host, followed by a GET request.
+func (s *hostStats) addHeadFallback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.HeadFallbacks++
+}
+
+// Count a parking of the host and its duration.
+func (s *hostStats) addParked(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Parked++
+	s.stats.ParkedTime += d
+}
+
+// Set the status of the robots.txt.
+func (s *hostStats) setRobots(status RobotsStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Robots = status
+}
+
+// Return a copy of the statistics, with the number of visits.
+func (s *hostStats) snapshot(visits int) HostStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.Visits = visits
+	st.Bytes = atomic.LoadInt64(&s.bytes)
+	st.Errors = make(map[CrawlErrorKind]int, len(s.stats.Errors))
+	for k, n := range s.stats.Errors {
+		st.Errors[k] = n
+	}
+	if s.ndelays > 0 {
+		st.AvgDelay = s.delays / time.Duration(s.ndelays)
+	}
+	return st
+}
@@ -0,0 +1,47 @@
+// Package testfs serves the files of a file system as the responses of fake
+// hosts. It backs the FileFetcher of the gocrawltest package, and the file
+// fetcher of the tests of the gocrawl package, which cannot import
+// gocrawltest (it imports gocrawl).
+package testfs
+
+import (
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Path returns the path of the file of the URL, i.e. host/path whatever the
+// port, where a "www." prefix is removed from the host, and internationalized
+// hosts are in their punycode form.
+func Path(u *url.URL) string {
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	return path.Join(host, u.Path)
+}
+
+// Response returns the response of the request, with the file of its URL as
+// body. A missing file is returned as a 404 response along with the error.
+func Response(fsys fs.FS, req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	f, err := fsys.Open(Path(req.URL))
+	if err != nil {
+		// Treat errors as 404s - file not found
+		res.StatusCode, res.Status = http.StatusNotFound, "404 Not Found"
+		return res, err
+	}
+	res.StatusCode, res.Status = http.StatusOK, "200 OK"
+	res.Body = f
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package gocrawl
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The fsTransport serves the requests of the hosts of the LocalFS option
+// from their file systems, and sends the other requests to the next
+// transport. The hosts are matched case-insensitively.
+type fsTransport struct {
+	fss  map[string]fs.FS
+	next http.RoundTripper
+}
+
+// errFSDir is returned by readFSFile for a directory requested without its
+// trailing slash.
+var errFSDir = errors.New("directory without trailing slash")
+
+// Create the transport of the file systems, keyed by their lowercased host.
+func newFSTransport(fss map[string]fs.FS, next http.RoundTripper) *fsTransport {
+	t := &fsTransport{fss: make(map[string]fs.FS, len(fss)), next: next}
+	for host, fsys := range fss {
+		t.fss[strings.ToLower(host)] = fsys
+	}
+	return t
+}
+
+// RoundTrip serves the request from the file system of its host, if any.
+func (t *fsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fsys, ok := t.fss[strings.ToLower(req.URL.Host)]
+	if !ok {
+		fsys, ok = t.fss[strings.ToLower(req.URL.Hostname())]
+	}
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return serveFS(fsys, req)
+}
+
+// Return the response to the request, served from the file system. The
+// path of the URL is relative to the root of the file system, and the
+// directories are served by their index.html file, once redirected to their
+// path with a trailing slash, like http.FileServer does. It is a 404
+// response if the file does not exist, and an error if it cannot be read.
+func serveFS(fsys fs.FS, req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	body, name, err := readFSFile(fsys, req.URL.Path)
+	if err == errFSDir {
+		// The relative links of the index.html file are resolved against
+		// the directory
+		loc := "/" + name + "/"
+		if req.URL.RawQuery != "" {
+			loc += "?" + req.URL.RawQuery
+		}
+		body = nil
+		res.Status, res.StatusCode = "301 Moved Permanently", http.StatusMovedPermanently
+		res.Header.Set("Location", loc)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		body = []byte("404 page not found\n")
+		res.Status, res.StatusCode = "404 Not Found", http.StatusNotFound
+		res.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	} else if err != nil {
+		return nil, err
+	} else {
+		res.Status, res.StatusCode = "200 OK", http.StatusOK
+		ct := mime.TypeByExtension(path.Ext(name))
+		if ct == "" {
+			ct = http.DetectContentType(body)
+		}
+		res.Header.Set("Content-Type", ct)
+	}
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if req.Method == "HEAD" {
+		body = nil
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// Read the file of the URL path from the file system, the index.html file
+// for a directory, and return its content and name. The errFSDir error is
+// returned with the name of a directory requested without trailing slash.
+func readFSFile(fsys fs.FS, urlPath string) ([]byte, string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return nil, name, fs.ErrNotExist
+	}
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, name, err
+	}
+	if fi.IsDir() {
+		if name != "." && !strings.HasSuffix(urlPath, "/") {
+			return nil, name, errFSDir
+		}
+		name = path.Join(name, "index.html")
+	}
+	b, err := fs.ReadFile(fsys, name)
+	return b, name, err
+}
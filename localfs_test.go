@@ -0,0 +1,142 @@
+package gocrawl
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type localFSExtender struct {
+	DefaultExtender
+	mu       sync.Mutex
+	types    map[string]string
+	outcomes map[string]VisitOutcome
+}
+
+func (x *localFSExtender) Visit(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.types[ctx.URL().Path] = res.Header.Get("Content-Type")
+	return nil, true
+}
+
+func (x *localFSExtender) VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.outcomes[ctx.URL().Path] = info.Outcome
+}
+
+func TestLocalFS(t *testing.T) {
+	ext := &localFSExtender{types: make(map[string]string), outcomes: make(map[string]VisitOutcome)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.SameHostOnly = true
+	opts.LocalFS = map[string]fs.FS{"docs.local": os.DirFS("testdata/hostl")}
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run("http://docs.local/"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]VisitOutcome{
+		"/":                VisitDone,
+		"/docs/":           VisitDone,
+		"/docs/guide.html": VisitDone,
+		"/about.txt":       VisitDone,
+		"/missing.html":    VisitStatusError,
+	}
+	if len(ext.outcomes) != len(want) {
+		t.Errorf("expected %d URLs, got %v", len(want), ext.outcomes)
+	}
+	for p, o := range want {
+		if got, ok := ext.outcomes[p]; !ok || got != o {
+			t.Errorf("%s: expected outcome %s, got %s", p, o, got)
+		}
+	}
+	// Disallowed by the robots.txt of the file system
+	if _, ok := ext.outcomes["/private/secret.html"]; ok {
+		t.Errorf("expected /private/secret.html to be disallowed")
+	}
+	for p, ct := range map[string]string{
+		"/":          "text/html; charset=utf-8",
+		"/docs/":     "text/html; charset=utf-8",
+		"/about.txt": "text/plain; charset=utf-8",
+	} {
+		if got := ext.types[p]; got != ct {
+			t.Errorf("%s: expected Content-Type %q, got %q", p, ct, got)
+		}
+	}
+}
+
+func TestServeFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("<html>root</html>")},
+		"dir/index.html":   {Data: []byte("<html>dir</html>")},
+		"empty/page.html":  {Data: []byte("<html>page</html>")},
+		"style.css":        {Data: []byte("body {}")},
+		"noext":            {Data: []byte("<html><body>sniffed</body></html>")},
+		"archive/data.bin": {Data: []byte{0, 1, 2}},
+	}
+	cases := []struct {
+		method string
+		path   string
+		status int
+		ct     string
+		body   string
+	}{
+		{"GET", "/", 200, "text/html; charset=utf-8", "<html>root</html>"},
+		{"GET", "/dir", 301, "", ""},
+		{"GET", "/dir?q=1", 301, "", ""},
+		{"GET", "/dir/", 200, "text/html; charset=utf-8", "<html>dir</html>"},
+		{"GET", "/empty/", 404, "text/plain; charset=utf-8", "404 page not found\n"},
+		{"GET", "/style.css", 200, "text/css; charset=utf-8", "body {}"},
+		{"GET", "/noext", 200, "text/html; charset=utf-8", "<html><body>sniffed</body></html>"},
+		{"GET", "/../style.css", 200, "text/css; charset=utf-8", "body {}"},
+		{"GET", "/missing.html", 404, "text/plain; charset=utf-8", "404 page not found\n"},
+		{"HEAD", "/style.css", 200, "text/css; charset=utf-8", ""},
+	}
+	// The directories are redirected to their path with a trailing slash
+	locs := map[string]string{"/dir": "/dir/", "/dir?q=1": "/dir/?q=1"}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, "http://docs.local"+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := serveFS(fsys, req)
+		if err != nil {
+			t.Errorf("%s %s: %s", c.method, c.path, err)
+			continue
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != c.status || res.Header.Get("Content-Type") != c.ct || string(b) != c.body {
+			t.Errorf("%s %s: expected %d %q %q, got %d %q %q", c.method, c.path, c.status, c.ct, c.body,
+				res.StatusCode, res.Header.Get("Content-Type"), b)
+		}
+		if loc := res.Header.Get("Location"); loc != locs[c.path] {
+			t.Errorf("%s %s: expected Location %q, got %q", c.method, c.path, locs[c.path], loc)
+		}
+		if res.Request != req {
+			t.Errorf("%s %s: expected the request of the response", c.method, c.path)
+		}
+	}
+}
+
+func TestFSTransportHost(t *testing.T) {
+	fsys := fstest.MapFS{"index.html": {Data: []byte("<html>root</html>")}}
+	tr := newFSTransport(map[string]fs.FS{"Docs.Local": fsys}, nil)
+	for _, host := range []string{"docs.local", "DOCS.local", "docs.LOCAL:8080"} {
+		req, err := http.NewRequest("GET", "http://"+host+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := tr.RoundTrip(req)
+		if err != nil || res.StatusCode != 200 {
+			t.Errorf("%s: expected the file system of the host, got %v, %v", host, res, err)
+		}
+	}
+}
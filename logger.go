@@ -14,11 +14,43 @@ const (
 	LogEnqueued
 	LogIgnored
 	LogTrace
+	LogDelay
+	LogQueue
 	LogNone LogFlags = 0
-	LogAll  LogFlags = LogError | LogInfo | LogEnqueued | LogIgnored | LogTrace
+	LogAll  LogFlags = LogError | LogInfo | LogEnqueued | LogIgnored | LogTrace | LogDelay | LogQueue
 )
 
-func getLogFunc(ext Extender, verbosity LogFlags, workerIndex int) func(LogFlags, string, ...interface{}) {
+// The structured log events sent to an EventLogger.
+const (
+	EventEnqueue     = "enqueue"
+	EventFetch       = "fetch"
+	EventVisit       = "visit"
+	EventError       = "error"
+	EventDelay       = "delay"
+	EventWorkerStart = "worker_start"
+	EventWorkerStop  = "worker_stop"
+)
+
+// EventLogger is an optional interface that an Extender can implement to
+// receive structured log events, with typed fields (e.g. "url", "host",
+// "kind", "status", "duration") instead of a preformatted message. The events
+// of a tagged URL or host (see the HostTags option) have a "tags" field, the
+// map[string]string of its tags, which must not be modified. Only the events
+// of a level enabled by the Options' LogFlags are sent.
+type EventLogger interface {
+	LogEvent(level LogFlags, event string, fields map[string]interface{})
+}
+
+// Return the log function of the crawler, or of the worker of the host. The
+// worker of a host rejected by the LogHostFilter option logs nothing.
+func getLogFunc(opts *Options, workerIndex int, host string) func(LogFlags, string, ...interface{}) {
+	ext, verbosity := opts.Extender, opts.LogFlags
+	if _, ok := ext.(EventLogger); ok && opts.LogEventsOnly {
+		return func(LogFlags, string, ...interface{}) {}
+	}
+	if workerIndex > 0 && !opts.logsHost(host) {
+		return func(LogFlags, string, ...interface{}) {}
+	}
 	return func(minLevel LogFlags, format string, vals ...interface{}) {
 		if workerIndex > 0 {
 			ext.Log(verbosity, minLevel, fmt.Sprintf(fmt.Sprintf("worker %d - %s", workerIndex, format), vals...))
@@ -27,3 +59,47 @@ func getLogFunc(ext Extender, verbosity LogFlags, workerIndex int) func(LogFlags
 		}
 	}
 }
+
+func getEventFunc(opts *Options, workerIndex int) func(LogFlags, string, map[string]interface{}) {
+	el, ok := opts.Extender.(EventLogger)
+	if !ok {
+		return func(LogFlags, string, map[string]interface{}) {}
+	}
+	verbosity := opts.LogFlags
+	return func(level LogFlags, event string, fields map[string]interface{}) {
+		if verbosity&level != level {
+			return
+		}
+		if workerIndex > 0 {
+			fields["worker"] = workerIndex
+		}
+		el.LogEvent(level, event, fields)
+	}
+}
+
+// Return the fields of a structured log event for the URL.
+func urlFields(ctx *URLContext) map[string]interface{} {
+	if ctx == nil || ctx.url == nil {
+		return make(map[string]interface{})
+	}
+	return withTags(map[string]interface{}{
+		"url":  ctx.url.String(),
+		"host": ctx.normalizedURL.Host,
+	}, ctx.tags)
+}
+
+// Add the tags to the fields of a structured log event, if there are any.
+func withTags(fields map[string]interface{}, tags map[string]string) map[string]interface{} {
+	if len(tags) > 0 {
+		fields["tags"] = tags
+	}
+	return fields
+}
+
+// Return the fields of a structured log event for the crawl error.
+func errorFields(err *CrawlError) map[string]interface{} {
+	fields := urlFields(err.Ctx)
+	fields["kind"] = err.Kind.String()
+	fields["error"] = err.Error()
+	return fields
+}
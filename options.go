@@ -1,6 +1,16 @@
 package gocrawl
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/purell"
@@ -12,11 +22,172 @@ const (
 	DefaultRobotUserAgent     string                    = `Googlebot (gocrawl v0.4)`
 	DefaultEnqueueChanBuffer  int                       = 100
 	DefaultHostBufferFactor   int                       = 10
+	DefaultFetchersPerHost    int                       = 1
 	DefaultCrawlDelay         time.Duration             = 5 * time.Second
 	DefaultIdleTTL            time.Duration             = 10 * time.Second
+	DefaultHostCooldown       time.Duration             = time.Minute
+	DefaultRobotsRetries      int                       = 3
+	DefaultRobotsRetryDelay   time.Duration             = time.Second
+	DefaultMaxRobotsDelay     time.Duration             = 5 * time.Minute
+	DefaultRobotsRetryAfter   time.Duration             = 5 * time.Minute
+	DefaultMaxRobotsRetryWait time.Duration             = 15 * time.Minute
 	DefaultNormalizationFlags purell.NormalizationFlags = purell.FlagsAllGreedy
+	DefaultHARMaxBodySize     int64                     = 64 << 10
+	DefaultEventBuffer        int                       = 1000
 )
 
+// NormalizerMode controls how the Options.URLNormalizer function is combined
+// with the purell normalization.
+type NormalizerMode uint8
+
+// The various normalizer modes.
+const (
+	// NormalizerAfterPurell applies the URLNormalizer function on the
+	// URL normalized with the URLNormalizationFlags.
+	NormalizerAfterPurell NormalizerMode = iota
+
+	// NormalizerReplacePurell applies only the URLNormalizer function,
+	// the URLNormalizationFlags are ignored. The minimal canonicalization
+	// of the crawler still applies to its result, in either mode: the
+	// scheme and host are lowercased, the default port is removed and an
+	// empty path becomes "/", as these URLs key the hosts and the visited
+	// set.
+	NormalizerReplacePurell
+)
+
+// Ordering controls the order in which the URLs of a given host are crawled.
+type Ordering uint8
+
+// The various crawl orderings.
+const (
+	// OrderBFS crawls the URLs of a host breadth-first, in the order they
+	// were enqueued.
+	OrderBFS Ordering = iota
+
+	// OrderDFS crawls the URLs of a host depth-first, the most recently
+	// enqueued URLs first.
+	OrderDFS
+)
+
+// PendingPolicy controls what happens to a new URL when the queue of its
+// host is full, based on the MaxPendingPerHost option.
+type PendingPolicy uint8
+
+// The various pending URLs policies.
+const (
+	// BlockOnFull pauses the worker that harvested the URL until there is
+	// room for it in the queue of its host. Only the harvested URLs are
+	// blocked: there is no worker to pause for the seeds and the URLs sent
+	// on the EnqueueChan or via Crawler.Enqueue, so they are accepted over
+	// the MaxPendingPerHost limit.
+	BlockOnFull PendingPolicy = iota
+
+	// DropNewest drops the new URL.
+	DropNewest
+
+	// DropOldest drops the oldest URL of the queue to make room for the
+	// new URL.
+	DropOldest
+)
+
+// RobotsDelayPolicy controls how the default ComputeDelay extender method
+// combines the Options crawl delay and the robots.txt crawl delay.
+type RobotsDelayPolicy uint8
+
+// The various robots.txt crawl delay policies.
+const (
+	// RobotsDelayUseMax uses the greatest of the two delays.
+	RobotsDelayUseMax RobotsDelayPolicy = iota
+
+	// RobotsDelayUseRobots uses the robots.txt delay if there is one,
+	// the Options delay otherwise.
+	RobotsDelayUseRobots
+
+	// RobotsDelayUseOptions ignores the robots.txt delay.
+	RobotsDelayUseOptions
+)
+
+// RobotsErrorPolicy controls how the URLs of a host are handled when its
+// robots.txt cannot be fetched, because of a fetch error (e.g. a timeout) or
+// a 5xx status code. A 4xx status code always allows all URLs.
+type RobotsErrorPolicy uint8
+
+// The various robots.txt error policies.
+const (
+	// RobotsAllowOnError allows all URLs of the host.
+	RobotsAllowOnError RobotsErrorPolicy = iota
+
+	// RobotsDisallowOnError disallows all URLs of the host.
+	RobotsDisallowOnError
+
+	// RobotsRetryThenDisallow retries to fetch the robots.txt with an
+	// exponential backoff, and disallows all URLs of the host if it still
+	// cannot be fetched.
+	RobotsRetryThenDisallow
+)
+
+// HarvestFallback controls the harvest of the pages that have no element
+// matching the HarvestSelector option.
+type HarvestFallback uint8
+
+// The various harvest fallbacks.
+const (
+	// HarvestWholePage harvests the anchors of the whole page.
+	HarvestWholePage HarvestFallback = iota
+
+	// HarvestNothing harvests no anchor of the page.
+	HarvestNothing
+)
+
+// DefaultAllowedSchemes is the list of URL schemes allowed by default.
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// HostOptions overrides some of the Options for a specific host. The zero
+// value of a field (an empty string or a nil pointer) means that the global
+// option applies.
+type HostOptions struct {
+	// CrawlDelay overrides the Options' CrawlDelay, it is the OptsDelay of
+	// the DelayInfo passed to the ComputeDelay extender method.
+	CrawlDelay *time.Duration
+
+	// UserAgent overrides the Options' UserAgent.
+	UserAgent string
+
+	// RobotUserAgent overrides the Options' RobotUserAgent.
+	RobotUserAgent string
+
+	// HeadBeforeGet overrides the Options' HeadBeforeGet for the URLs of
+	// the host.
+	HeadBeforeGet *bool
+
+	// MaxVisitsPerHost is the maximum number of pages visited for the host,
+	// the URLs of the host are ignored once it is reached. Zero means no
+	// maximum.
+	MaxVisitsPerHost int
+}
+
+// TransportOptions tunes the transport of the HTTP client used by the
+// DefaultExtender's Fetch method. The zero values keep the settings of the
+// HttpClient's transport.
+type TransportOptions struct {
+	// MaxIdleConnsPerHost is the maximum number of idle (keep-alive)
+	// connections kept per host.
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost limits the total number of connections per host.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is the time after which an idle connection is
+	// closed.
+	IdleConnTimeout time.Duration
+
+	// DisableKeepAlives uses a new connection for each request.
+	DisableKeepAlives bool
+
+	// ForceAttemptHTTP2, if not nil, enables or disables HTTP/2.
+	ForceAttemptHTTP2 *bool
+}
+
 // Options contains the configuration for a Crawler to customize the
 // crawling process.
 type Options struct {
@@ -30,10 +201,48 @@ type Options struct {
 	// that site owners can configure the robots.txt accordingly.
 	RobotUserAgent string
 
+	// UserAgentFunc, if not nil, returns the user-agent used to request the
+	// URL, instead of UserAgent and its PerHost override (an empty string
+	// falls back to them). The robots.txt is still requested with UserAgent,
+	// and its policies matched with RobotUserAgent. It is called
+	// concurrently, so it must be safe for concurrent use.
+	UserAgentFunc func(ctx *URLContext) string
+
 	// MaxVisits is the maximum number of pages visited before
-	// automatically stopping the crawler.
+	// automatically stopping the crawler (see CountOnlyChangedVisits).
 	MaxVisits int
 
+	// CountOnlyChangedVisits excludes the soft visits from the MaxVisits
+	// budget, i.e. the visits that produced no new content: the HEAD-only
+	// visits (see VisitHeadOnly), the range probes and the rechecks, the
+	// 304 (Not Modified) responses, and the visits of the URLs marked as
+	// unchanged by the Visit extender method (see URLContext.SetUnchanged).
+	// A 304 response is then a soft visit, notified via the Visited extender
+	// method (with the VisitNotModified outcome), instead of a status code
+	// error. The MaxVisitsPerHost budget still counts all the 2xx visits.
+	CountOnlyChangedVisits bool
+
+	// MaxDuration is the maximum duration of the crawl, after which the
+	// crawler is automatically stopped. Zero means no maximum.
+	MaxDuration time.Duration
+
+	// MaxTotalBytes is the maximum number of bytes read from the response
+	// bodies, after which the crawler is automatically stopped. The response
+	// that exceeds it is still processed, but no other fetch is started.
+	// Zero means no maximum.
+	MaxTotalBytes int64
+
+	// MaxErrors is the maximum number of fetch errors (the CekFetch and
+	// CekHttpStatusCode kinds) after which the crawler is stopped, Run then
+	// returns an error that wraps ErrMaxErrors. The Error extender method is
+	// still called for every error. Zero means no maximum.
+	MaxErrors int
+
+	// MaxConsecutiveErrors is like MaxErrors, but for the fetch errors
+	// without a successful (2xx) fetch in between, a stronger sign of an
+	// outage. Zero means no maximum.
+	MaxConsecutiveErrors int
+
 	// EnqueueChanBuffer is the size of the buffer for the enqueue channel.
 	EnqueueChanBuffer int
 
@@ -44,37 +253,615 @@ type Options struct {
 	// initial capacity, though the map will grow as needed).
 	HostBufferFactor int
 
+	// MaxPendingPerHost is the maximum number of URLs waiting to be
+	// processed for a given host. When the queue of a host is full, the
+	// PendingPolicy is applied to new URLs. Zero means no maximum.
+	MaxPendingPerHost int
+
+	// PendingPolicy is the policy applied when the queue of a host is full.
+	// Dropped URLs are notified via the Error extender method, with the
+	// CekDroppedURL error kind. With BlockOnFull, the seeds and the URLs
+	// enqueued via the EnqueueChan or Crawler.Enqueue are never blocked.
+	PendingPolicy PendingPolicy
+
+	// MaxConcurrentHosts is the maximum number of hosts crawled at the
+	// same time (that is, the maximum number of workers). The URLs of
+	// additional hosts are buffered until a worker becomes idle and
+	// frees its slot. Zero means no maximum.
+	MaxConcurrentHosts int
+
+	// FetchersPerHost is the maximum number of fetches in flight at the same
+	// time for a given host. With more than one fetcher, the crawl delay
+	// spaces out the starts of the fetches instead of the end of a fetch
+	// and the start of the next one, i.e. it limits the rate of the requests
+	// to the host while the responses are processed concurrently, and the
+	// FetchInfo passed to ComputeDelay is the one of the most recently
+	// started fetch that completed. The robots.txt of the host is still
+	// fetched alone, before the fetches of its URLs. It is ignored in
+	// Deterministic mode. Zero or one means one fetch at a time.
+	FetchersPerHost int
+
+	// MaxRequestsPerSecond is the maximum number of requests per second
+	// made by the crawler, across all hosts. Zero means no maximum. It is
+	// ignored if RateLimiter is set.
+	MaxRequestsPerSecond float64
+
+	// RateLimiter, if set, is used to limit the rate of the requests made
+	// by the crawler, across all hosts. It may be shared by multiple
+	// crawlers.
+	RateLimiter RateLimiter
+
+	// MaxBytesPerSecond is the maximum number of bytes per second read from
+	// the response bodies, across all hosts. HEAD requests and headers are
+	// not throttled. Zero means no maximum. Note that slowly reading the body
+	// counts towards the timeout of the HTTP client, if any, so it should
+	// be sized accordingly.
+	MaxBytesPerSecond int64
+
 	// CrawlDelay is the default time to wait between requests to a given
 	// host. If a specific delay is specified in the relevant robots.txt,
-	// then this delay is used instead. Crawl delay can be customized
-	// further by implementing the ComputeDelay extender function.
+	// it is combined with this delay based on the RobotsDelayPolicy. Crawl
+	// delay can be customized further by implementing the ComputeDelay
+	// extender function.
 	CrawlDelay time.Duration
 
+	// RobotsDelayPolicy controls how the CrawlDelay and the robots.txt crawl
+	// delay are combined by the DefaultExtender's ComputeDelay method.
+	RobotsDelayPolicy RobotsDelayPolicy
+
+	// MaxRobotsDelay is the maximum robots.txt crawl delay, greater values
+	// are capped to it. Zero means no maximum.
+	MaxRobotsDelay time.Duration
+
+	// AdaptiveDelay, if set, enables the adaptive crawl delay of the
+	// DefaultExtender's ComputeDelay method, based on the recent response
+	// times and errors of the host.
+	AdaptiveDelay *AdaptiveDelay
+
+	// DelayJitter randomizes the crawl delay computed by the ComputeDelay
+	// extender method, so that requests are not sent at a fixed interval.
+	// It must be between 0 and 1: the delay d becomes a random duration
+	// between d*(1-DelayJitter) and d*(1+DelayJitter), but never less
+	// than the robots.txt crawl delay. Zero means no jitter.
+	DelayJitter float64
+
+	// DelayJitterRand returns the random numbers in [0, 1) used to apply
+	// the DelayJitter. It is called concurrently by the workers, so it must
+	// be safe for concurrent use. If it is nil, rand.Float64 is used.
+	DelayJitterRand func() float64
+
+	// HostFailureThreshold is the number of consecutive fetch failures
+	// after which a host is considered down: its queued URLs are skipped
+	// until the HostCooldown delay expires, after which a single probe
+	// request is made. A successful fetch resets the count of failures.
+	// Zero means hosts are never considered down.
+	HostFailureThreshold int
+
+	// HostCooldown is the time to wait before making a probe request to a
+	// host that is considered down.
+	HostCooldown time.Duration
+
 	// WorkerIdleTTL is the idle time-to-live allowed for a worker
 	// before it is cleared (its goroutine terminated). The crawl
 	// delay is not part of idle time, this is specifically the time
 	// when the worker is available, but there are no URLs to process.
 	WorkerIdleTTL time.Duration
 
+	// IgnoreRobots disables the robots.txt handling: the robots.txt of the
+	// hosts is never requested, all URLs are allowed and the robots.txt crawl
+	// delay is ignored. WARNING: this should only be used to crawl hosts that
+	// you own or that explicitly allow it, it is not polite otherwise.
+	IgnoreRobots bool
+
+	// DryRun exercises the filtering and the robots.txt policies without
+	// fetching the pages: each URL that would have been fetched is reported
+	// to the DryRunExtender, if the extender implements it, and is marked as
+	// visited, but the Fetch, Visit and Visited extender methods are not
+	// called for it, so no link is harvested. The robots.txt of the hosts are
+	// still requested, unless DryRunSkipRobots is set.
+	DryRun bool
+
+	// DryRunSkipRobots disables the robots.txt requests of the DryRun mode,
+	// as with IgnoreRobots: all URLs are allowed.
+	DryRunSkipRobots bool
+
+	// RobotsTTL is the time after which the robots.txt data of a host is
+	// requested again, so that the robots.txt policies changed during a long
+	// crawl are applied. If the robots.txt response has a shorter Cache-Control
+	// max-age, it is used instead. Zero means the robots.txt data is never
+	// requested again.
+	RobotsTTL time.Duration
+
+	// RobotsErrorPolicy is the policy applied when the robots.txt of a
+	// host cannot be fetched, because of a fetch error or a 5xx status code.
+	// The disallowed URLs are notified via the Disallowed extender method.
+	RobotsErrorPolicy RobotsErrorPolicy
+
+	// RobotsRetries is the number of times the robots.txt is requested again
+	// before disallowing all URLs, with the RobotsRetryThenDisallow policy.
+	RobotsRetries int
+
+	// RobotsRetryDelay is the delay before the first retry of the robots.txt
+	// request, with the RobotsRetryThenDisallow policy. It doubles with
+	// each retry.
+	RobotsRetryDelay time.Duration
+
+	// RobotsUnavailableRetries is the number of times the robots.txt of a
+	// host is requested again after a 503 (Service Unavailable) response,
+	// before the RobotsErrorPolicy applies. Until each retry, the host is
+	// parked: its queue is kept but nothing is fetched, and its worker does
+	// not hold a slot of MaxConcurrentHosts. It is parked for the delay of
+	// the Retry-After header of the response, or DefaultRobotsRetryAfter
+	// without one, capped by MaxRobotsRetryWait. Zero disables the parking,
+	// a 503 response is then handled like the other 5xx status codes.
+	RobotsUnavailableRetries int
+
+	// MaxRobotsRetryWait is the maximum delay a host is parked for after a
+	// 503 response to its robots.txt request, per the RobotsUnavailableRetries
+	// option.
+	MaxRobotsRetryWait time.Duration
+
+	// RobotsPerScheme requests the robots.txt of each scheme of a host
+	// (i.e. http://host/robots.txt and https://host/robots.txt) and applies
+	// to each URL the policies of its scheme. Otherwise the robots.txt of the
+	// scheme of the first URL of the host applies to all its schemes, i.e.
+	// when the pages redirect from http to https.
+	RobotsPerScheme bool
+
+	// VisitErrorPages also visits the HTML bodies of the responses with a
+	// non-2xx status code (i.e. the custom 404 or 500 pages that have links
+	// or diagnostics), in addition to the CekHttpStatusCode error. The Visited
+	// extender method is called for all the URLs with such a status code
+	// whether or not this option is set, so that the accounting is complete.
+	VisitErrorPages bool
+
+	// RespectMetaRobots honors the robots directives of the responses, from
+	// the X-Robots-Tag headers (for any content type) and the robots meta
+	// tags of the HTML documents: the links of a nofollow response are not
+	// harvested, and a noindex response is flagged via the URLContext's
+	// RobotsNoIndex. The directives scoped to a user-agent only apply if it
+	// matches the RobotUserAgent.
+	RespectMetaRobots bool
+
+	// VisitWorkers is the number of goroutines dedicated to visiting the
+	// fetched pages. If it is zero, the worker of the host visits the
+	// page before fetching the next URL. Otherwise, the page is loaded
+	// and handed to a visitor, and the worker proceeds with the next URL
+	// (waiting for a visitor to be available if all are busy). Visit,
+	// Visited and Error for the visit are called from the visitor, and
+	// the visits of a host may complete out of order.
+	VisitWorkers int
+
+	// VisitTimeout is the deadline of the parsing and visit of each page,
+	// i.e. of the goquery document and the Visit extender method (or the
+	// registered visitor). On expiry, the worker abandons the visit, the
+	// error is notified with the CekVisitTimeout kind, and it moves on
+	// without harvesting the page's links. A visit cannot be killed, so
+	// it keeps running until it returns, concurrently with the next
+	// visits of the same host, and its result is dropped (see the
+	// VisitCtxExtender to be notified of the expiry). With VisitWorkers,
+	// the document is parsed before the deadline starts. Zero means no
+	// deadline.
+	VisitTimeout time.Duration
+
+	// Deterministic processes one URL at a time for the whole crawl, so that
+	// the same seeds and content yield the same sequence of visits, i.e. for
+	// tests and debugging. The hosts take turns in the order of their names,
+	// each turn processing the next URL of the host's queue, and the results
+	// of a turn are enqueued before the next one starts. The crawl delays
+	// still apply, VisitWorkers and WorkerIdleTTL are ignored.
+	Deterministic bool
+
+	// OrderedCallbacks calls the notification extender methods (Enqueued,
+	// Visited, Error, Disallowed and their DisallowedReason and VisitedInfo
+	// variants) one at a time from a dedicated goroutine, in the order they
+	// are made, for the extenders that are not safe for concurrent use. The
+	// calls are asynchronous, so they may happen after the crawler moved on
+	// (e.g. a URLContext may have been enqueued again meanwhile), and they
+	// are all complete before the End extender method is called. It costs
+	// some throughput, the default is to call them concurrently from the
+	// crawler and the workers.
+	OrderedCallbacks bool
+
 	// SameHostOnly limits the URLs to enqueue only to those targeting
 	// the same hosts as the ones from the seed URLs.
 	SameHostOnly bool
 
+	// SamePathPrefixOnly limits the URLs to enqueue to those under the
+	// directory of one of the seed URLs, on the seed's host and regardless
+	// of the scheme, i.e. a seed of "http://host/docs/index.html" or
+	// "http://host/docs" limits the crawl to "http://host/docs/" and
+	// below. The paths are compared in their normalized form. It composes
+	// with SameHostOnly and ScopePrefixes, the URLs outside the scope are
+	// notified as DisScope before the Filter extender method.
+	SamePathPrefixOnly bool
+
+	// ScopePrefixes, if not empty, limits the URLs to enqueue to those under
+	// one of the absolute URL prefixes, reduced to their directory like the
+	// seeds of the SamePathPrefixOnly option (e.g. "https://host/docs/").
+	ScopePrefixes []string
+
+	// AllowedSchemes is the list of URL schemes that can be enqueued.
+	// Links with other schemes (e.g. mailto:, javascript:) are dropped
+	// as soon as they are harvested, without calling the Filter or Enqueued
+	// extender methods. If empty, DefaultAllowedSchemes is used.
+	AllowedSchemes []string
+
+	// IncludePatterns, if not empty, limits the URLs to enqueue to those
+	// whose normalized form matches at least one of the patterns. URLs
+	// that do not match are never passed to the Filter extender method.
+	IncludePatterns []*regexp.Regexp
+
+	// ExcludePatterns prevents the URLs whose normalized form matches any
+	// of the patterns from being enqueued. URLs that match are never passed
+	// to the Filter extender method. Exclusion has precedence over inclusion.
+	ExcludePatterns []*regexp.Regexp
+
+	// Ordering controls the order in which the URLs of a given host are
+	// crawled, for URLs with the same priority. It applies within a host,
+	// the hosts are crawled concurrently.
+	Ordering Ordering
+
+	// Frontier holds the URLs waiting to be visited. If nil, an in-memory
+	// MemoryFrontier using the Ordering is created for each run. Errors of
+	// the Frontier are notified with the CekFrontier kind.
+	Frontier Frontier
+
+	// AbortOnSeedError stops the crawl when the SeedProvider passed to Run
+	// returns an error other than io.EOF, Run then returns that error. By
+	// default the error is notified with the CekSeedProvider kind and the
+	// next seeds are still requested from the provider.
+	AbortOnSeedError bool
+
 	// HeadBeforeGet asks the crawler to make a HEAD request before
 	// making an eventual GET request. If set to true, the extender
 	// method RequestGet is called after the HEAD to control if the
-	// GET should be issued.
+	// GET should be issued. If a host rejects a HEAD request with a 405
+	// or 501 status code, the GET request is made right away, and the
+	// HEAD requests of the host are skipped for the rest of the crawl.
 	HeadBeforeGet bool
 
+	// MaxBodySize, if positive, skips the GET request that follows a HEAD
+	// request if the Content-Length of the HEAD response exceeds it. The
+	// RequestGet extender method is not called in that case.
+	MaxBodySize int64
+
+	// ResponseCacheBytes, if positive, keeps the responses of the GET
+	// requests in a cache of the run bounded to this size (of their bodies
+	// and headers), by normalized URL, so that the URLs requested again
+	// during the crawl (e.g. with the RevisitAfter option, or allowed again by
+	// the Filter) are served without calling the Fetch extender method (nor
+	// the BeforeFetch one) and without crawl delay. A HEAD request is served
+	// from the cached GET response, without its body. Only the GET requests
+	// are cached, so the HEAD request of a URL not yet fetched is sent,
+	// and the response of a redirection followed by the HTTP client is kept
+	// under the URL requested, not under its target. Only the responses with
+	// a heuristically cacheable status code of RFC 9110 (e.g. 200 or 404)
+	// and a body of at most a quarter of the cache are kept, the least
+	// recently used ones are evicted first. It is not an HTTP cache: the
+	// freshness of the responses is not checked, and the robots.txt, the
+	// range probes and the rechecks are always requested. Defaults to 0, no
+	// cache.
+	ResponseCacheBytes int64
+
+	// RewriteURL is an optional function to rewrite the URLs before their
+	// normalization, the same host policy and the Filter, i.e. to map a
+	// mobile host to the main one. It is called with the harvested links,
+	// the redirect targets, the seeds and the enqueued URLs, along with the
+	// URLContext of their source, if any. It receives a copy of the URL, so
+	// it may modify it and return it. The URL returned is the one fetched,
+	// the original one is available via URLContext.OriginalURL. If it
+	// returns nil, the URL is dropped. It is called concurrently from the
+	// crawler, the workers and the Enqueue callers, so it must be safe for
+	// concurrent use.
+	RewriteURL func(u *url.URL, src *URLContext) *url.URL
+
 	// URLNormalizationFlags controls the normalization of URLs.
-	// See the purell package for details.
+	// See the purell package for details. Internationalized hosts
+	// are always converted to their punycode form.
 	URLNormalizationFlags purell.NormalizationFlags
 
+	// URLNormalizer is an optional custom normalization function. Its
+	// result is the normalized URL, used for the visited check, the same
+	// host policy and the URLContext.NormalizedURL. It receives a copy of
+	// the URL, so it may modify it and return it, but it is called
+	// concurrently from the crawler and the workers, so it must otherwise
+	// be safe for concurrent use. If it returns nil, the URL it received
+	// is used.
+	URLNormalizer func(*url.URL) *url.URL
+
+	// URLNormalizerMode controls whether the URLNormalizer is applied after
+	// the URLNormalizationFlags, or instead of them.
+	URLNormalizerMode NormalizerMode
+
+	// FragmentPolicy controls how the fragments of the URLs are handled,
+	// e.g. to crawl the routes of single-page applications. The visited set,
+	// the same host policy and the deduplication of the harvested URLs use
+	// the URLs adjusted per the policy.
+	FragmentPolicy FragmentPolicy
+
+	// TrailingSlashPolicy controls how the trailing slash of the paths is
+	// normalized, after the URLNormalizationFlags and the URLNormalizer, so
+	// that the URLs that differ only by their trailing slash are visited
+	// once. The visited set, the path prefix scoping and the fetched URLs
+	// use the URLs adjusted per the policy.
+	TrailingSlashPolicy TrailingSlashPolicy
+
+	// StripQueryParams is a list of query string parameter names (case-insensitive)
+	// to remove from the URLs during normalization, i.e. session IDs or
+	// tracking parameters. The remaining parameters are sorted, so that
+	// their order does not matter for the visited check. The parameters are
+	// stripped before the URLNormalizationFlags and the URLNormalizer are
+	// applied, whatever the URLNormalizerMode, so the URLNormalizer receives
+	// the URL without them (and may add some back).
+	StripQueryParams []string
+
+	// StripQueryParamsMatching removes the query string parameters whose
+	// name matches this regular expression during normalization, in
+	// addition to those listed in StripQueryParams. Both are applied in the
+	// same pass, so the order of the two options does not matter.
+	StripQueryParamsMatching *regexp.Regexp
+
+	// FetchNormalized requests the normalized URL instead of the original
+	// one, so that the URL fetched is the same as the one used for the
+	// visited check (i.e. without the stripped query parameters). When
+	// set, URLContext.URL returns the normalized URL.
+	FetchNormalized bool
+
+	// FollowHreflang harvests the alternate links of the pages that have an
+	// hreflang attribute (i.e. <link rel="alternate" hreflang="fr"
+	// href="...">), in addition to the anchors. Their LinkInfo has the
+	// hreflang value.
+	FollowHreflang bool
+
+	// FollowGetForms harvests the actions of the forms whose method is GET
+	// (or missing), in addition to the anchors. An empty action is the URL
+	// of the page. The POST forms are ignored.
+	FollowGetForms bool
+
+	// GetFormDefaults replaces the query string of the harvested form
+	// actions by the default values of the forms, i.e. the hidden inputs and
+	// the pre-selected options. The resulting URL is normalized like any
+	// other, so StripQueryParams and StripQueryParamsMatching remove the
+	// matching form values.
+	GetFormDefaults bool
+
+	// HarvestImages harvests the images of the pages, in addition to the
+	// anchors: the src and srcset attributes of the img tags and the srcset
+	// attribute of the sources of the picture tags. Their LinkInfo has the
+	// AssetImage asset, and they are requested with HEAD only unless the
+	// Filter says otherwise.
+	HarvestImages bool
+
+	// HarvestSelector restricts the harvest of the anchors (and of the
+	// images, with HarvestImages) to the elements of the pages that match
+	// this goquery selector (i.e. "main, article"), so that the navigation
+	// bars and the footers are ignored. The pagination, hreflang and form
+	// links are still harvested from the whole page. Their LinkInfo has the
+	// selector as scope. Empty means the whole page.
+	HarvestSelector string
+
+	// HarvestSelectorFallback controls the harvest of the pages that have
+	// no element matching the HarvestSelector.
+	HarvestSelectorFallback HarvestFallback
+
+	// MaxPaginationDepth is the maximum number of consecutive rel=next links
+	// followed from a page that was not reached by such a link, the next
+	// pages beyond it are not enqueued. The pagination links of the head are
+	// only harvested when it is set. Zero means no maximum.
+	MaxPaginationDepth int
+
+	// MaxURLLength is the maximum length of the normalized URLs, the longer
+	// ones are not enqueued. Zero means no maximum.
+	MaxURLLength int
+
+	// MaxPathSegments is the maximum number of segments of the path of the
+	// normalized URLs, the URLs with more segments are not enqueued. Zero
+	// means no maximum.
+	MaxPathSegments int
+
+	// MaxRepeatedSegments is the maximum number of times a segment may
+	// appear in the path of the normalized URLs, consecutively or not (i.e.
+	// /a/a/a/ or /a/b/a/b/a/ repeat the segment a three times), the URLs
+	// with more repetitions are not enqueued. Zero means no maximum.
+	MaxRepeatedSegments int
+
+	// MaxQueryVariantsPerPath is the maximum number of distinct query strings
+	// enqueued for a normalized scheme, host and path, to guard against the
+	// combinatorial URLs of the faceted navigations (i.e. /products?color=red
+	// &size=m&sort=price). Once it is reached, the URLs of this path with
+	// another query string are not enqueued. The query strings are tracked
+	// for a bounded number of paths, the least recently used ones being
+	// forgotten. Zero means no maximum.
+	MaxQueryVariantsPerPath int
+
+	// TrackAncestry records the parent of each enqueued URL, so that the
+	// chain of referrers back to the seed is available via the URLContext's
+	// Ancestry method and the Crawler's PathTo method.
+	TrackAncestry bool
+
+	// TrackDuplicates counts the URLs found again once in the visited set,
+	// available via the Crawler's DuplicateCounts method and notified via
+	// the DuplicateExtender, if implemented. Only the counts are kept.
+	TrackDuplicates bool
+
+	// TrackDuplicateSources also records the sources of the duplicates, and
+	// of the first occurrence of each URL, with TrackDuplicates. It keeps an
+	// URL per duplicate and per enqueued URL.
+	TrackDuplicateSources bool
+
+	// RecordEdges records the links harvested from the visited pages, so
+	// that the crawl graph is available via the Crawler's Edges method. Use
+	// an EdgeExtender instead to stream the links of big crawls.
+	RecordEdges bool
+
+	// RevisitAfter is the delay after which an enqueued URL is no longer
+	// considered visited, so that the isVisited flag passed to the Filter
+	// extender method is false again. When set, the visited URLs are kept
+	// across the runs of the same Crawler. Zero means the URLs are never
+	// revisited during a run, and the visited URLs are reset for each run.
+	RevisitAfter time.Duration
+
+	// RecheckVisited rechecks the visited URLs (e.g. imported via the
+	// Crawler's ImportVisited method) instead of visiting them again: the
+	// visited URLs approved by the Filter extender method are requested with
+	// HEAD only, whatever the HeadBeforeGet setting, and notified via the
+	// Visited extender method, without GET request nor harvesting. The URLs
+	// not visited are processed normally.
+	RecheckVisited bool
+
+	// RecheckFallback is the request used to recheck a visited URL when its
+	// server rejects the HEAD request, with a 405 (Method Not Allowed) or a
+	// 501 (Not Implemented) status code, with RecheckVisited.
+	RecheckFallback RecheckFallback
+
+	// PerHost overrides some of the options for specific hosts. The keys
+	// are hosts in normalized form (i.e. "example.com"), or patterns in the
+	// form "*.example.com" that match the subdomains of example.com. An
+	// exact match has precedence over the patterns, and the longest matching
+	// pattern wins. The options are resolved when the worker of the host
+	// starts.
+	PerHost map[string]HostOptions
+
+	// CrawlWindows restricts the crawl of some hosts to time-of-day windows,
+	// e.g. the off-peak hours of a site. The keys are matched like those of
+	// PerHost. Outside of its windows, a host is parked: its queue is kept
+	// but nothing is fetched, not even its robots.txt, and its worker does
+	// not hold a slot of MaxConcurrentHosts. It resumes when one of its
+	// windows opens, the robots.txt being requested again first if it is
+	// stale per RobotsTTL. The crawl does not end while hosts are parked with
+	// URLs in their queue, unless MaxDuration is reached or Stop is called.
+	CrawlWindows map[string][]CrawlWindow
+
+	// HostTags attaches tags to the URLs of some hosts, e.g. the customer on
+	// behalf of whom a host is crawled. The keys are matched like those of
+	// PerHost. The tags of the host are merged with those attached to the
+	// URLs (see the EnqueueItem's Tags), which take precedence, and are
+	// available via the URLContext's Tags method. They are included in the
+	// structured log events (see EventLogger), the crawl events (see
+	// Crawler.Events), the crawl report and the HostStats of the host.
+	HostTags map[string]map[string]string
+
+	// WARCWriter, if set, receives the requests and responses fetched by the
+	// workers, including the robots.txt requests, as WARC 1.1 request and
+	// response records. A response record only has the bytes of the body read
+	// by the crawler, it is flagged with WARC-Truncated otherwise.
+	WARCWriter io.Writer
+
+	// WARCGzip compresses each WARC record as a separate gzip member, as
+	// expected in a .warc.gz file.
+	WARCGzip bool
+
+	// HARWriter, if set, receives the requests and responses of the HTTP
+	// client used by the DefaultExtender's Fetch method, including the
+	// robots.txt requests and the redirections not followed, as a HAR 1.2
+	// document written when the crawl ends or when Crawler.FlushHAR is
+	// called.
+	HARWriter io.Writer
+
+	// HARMaxBodySize is the maximum size of the response bodies included in
+	// the HAR entries. Only the bytes read by the crawler are included, up to
+	// this size, and the content is flagged as truncated if they are not the
+	// whole body. The text bodies are included as is, the others encoded in
+	// base64. Zero omits all bodies.
+	HARMaxBodySize int64
+
+	// ReportWriter, if set, receives the crawl report: a record per URL
+	// fetched (including the robots.txt URLs), disallowed by a policy,
+	// rejected by the Filter or skipped, with its status, source, depth,
+	// fetch duration, content type, size, error kind, number of harvested
+	// URLs and tags (see HostTags). The records are written as the URLs are
+	// processed, and the report is complete when the End extender method is
+	// called.
+	ReportWriter io.Writer
+
+	// ReportFormat is the format of the crawl report, JSON lines or CSV.
+	ReportFormat ReportFormat
+
+	// HTTPClient, if set, is the template of the HTTP client used by the
+	// DefaultExtender's Fetch method, instead of the package's HttpClient.
+	// Each run uses its own client, a copy of the template with a copy of
+	// its transport if it is an *http.Transport (or nil, for the default
+	// transport), so that the template is never modified. A nil
+	// CheckRedirect gets the redirection policy of the HttpClient.
+	HTTPClient *http.Client
+
+	// Transport, if set, tunes the transport of the HTTP client used by the
+	// DefaultExtender's Fetch method. Each run uses its own client, a copy of
+	// the HTTPClient (or of the HttpClient) with a copy of its transport,
+	// which must be an *http.Transport (or nil, for the default transport).
+	Transport *TransportOptions
+
+	// DialContext, if set, is used by the transport of the HTTP client to
+	// open the connections, including those of the robots.txt requests. It
+	// requires a copy of the HttpClient, like the Transport option.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// HostRewrite maps the "host:port" addresses dialed by the transport to
+	// other "host:port" addresses, e.g. to send the requests of a host to a
+	// staging server. The requests keep their Host header and TLS server
+	// name. It is applied before the DialContext option.
+	HostRewrite map[string]string
+
+	// LocalFS maps hosts (e.g. "docs.local", or "docs.local:8080" for a
+	// specific port, matched case-insensitively) to file systems, e.g. the
+	// output of wget or a docs build directory, so that the requests of the
+	// DefaultExtender's Fetch method to these hosts are served from their
+	// file system instead of the network: the path of the URL is relative to
+	// the root of the file system, the directories are served by their
+	// index.html file (once redirected to their path with a trailing slash,
+	// like http.FileServer), and the Content-Type is guessed from the file
+	// extension (or from the content).
+	// A missing file is a 404 response, including the robots.txt. It
+	// requires a copy of the HttpClient, like the Transport option, but it
+	// is applied whatever the HttpClient's transport.
+	LocalFS map[string]fs.FS
+
+	// TraceFetch measures the phases of the requests of the DefaultExtender's
+	// Fetch method (DNS lookup, connection, TLS handshake, time to first
+	// byte and body transfer), available via the FetchInfo's Timings.
+	TraceFetch bool
+
 	// LogFlags controls the verbosity of the logger.
 	LogFlags LogFlags
 
+	// LogHostFilter, if set, restricts the logs of the workers, and the
+	// queue logs of the crawler, to the hosts for which it returns true
+	// (the normalized host of the worker). The messages of the other hosts
+	// are dropped before they are formatted. It is called from the
+	// crawler's goroutine.
+	LogHostFilter func(host string) bool
+
+	// LogEventsOnly disables the Log extender method when the Extender
+	// implements EventLogger, so that only the structured log events are
+	// sent.
+	LogEventsOnly bool
+
+	// EmitEvents sends the events of the crawl on the channel returned by
+	// the Crawler's Events method, e.g. to display its progress without
+	// implementing the Extender hooks.
+	EmitEvents bool
+
+	// EventBuffer is the size of the buffer of the events channel. The
+	// events are dropped when it is full, and counted in the crawl end
+	// event.
+	EventBuffer int
+
+	// RecoverPanics recovers from the panics in the Filter, Enqueued,
+	// ComputeDelay, Visit and Visited extender methods. The panic is notified
+	// via the Error extender method, with the CekExtenderPanic error kind, and
+	// the crawl continues. A panic in the Error method itself is logged and
+	// dropped. Set it to false to let the panics crash the process.
+	RecoverPanics bool
+
 	// Extender is the implementation of hooks to use by the crawler.
 	Extender Extender
+
+	// Clock is the clock of the crawler and its workers, for the crawl
+	// delays, the WorkerIdleTTL, the crawl windows, the RevisitAfter and
+	// MaxDuration options and the other time-based policies, e.g. a fake clock to test an extender without waiting. The real clock
+	// is used if nil.
+	Clock Clock
 }
 
 // NewOptions creates a new set of Options with default values
@@ -86,15 +873,462 @@ func NewOptions(ext Extender) *Options {
 	return &Options{
 		DefaultUserAgent,
 		DefaultRobotUserAgent,
+		nil,
+		0,
+		false,
+		0,
+		0,
+		0,
 		0,
 		DefaultEnqueueChanBuffer,
 		DefaultHostBufferFactor,
+		0,
+		BlockOnFull,
+		0,
+		DefaultFetchersPerHost,
+		0,
+		nil,
+		0,
 		DefaultCrawlDelay,
+		RobotsDelayUseMax,
+		DefaultMaxRobotsDelay,
+		nil,
+		0,
+		nil,
+		0,
+		DefaultHostCooldown,
 		DefaultIdleTTL,
+		false,
+		false,
+		false,
+		0,
+		RobotsAllowOnError,
+		DefaultRobotsRetries,
+		DefaultRobotsRetryDelay,
+		0,
+		DefaultMaxRobotsRetryWait,
+		false,
+		false,
+		false,
+		0,
+		0,
+		false,
+		false,
 		true,
 		false,
+		nil,
+		DefaultAllowedSchemes,
+		nil,
+		nil,
+		OrderBFS,
+		nil,
+		false,
+		false,
+		0,
+		0,
+		nil,
 		DefaultNormalizationFlags,
+		nil,
+		NormalizerAfterPurell,
+		FragmentsStrip,
+		SlashKeep,
+		nil,
+		nil,
+		false,
+		false,
+		false,
+		false,
+		false,
+		"",
+		HarvestWholePage,
+		0,
+		0,
+		0,
+		0,
+		0,
+		false,
+		false,
+		false,
+		false,
+		0,
+		false,
+		RecheckNoFallback,
+		nil,
+		nil,
+		nil,
+		nil,
+		false,
+		nil,
+		DefaultHARMaxBodySize,
+		nil,
+		ReportJSONLines,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		false,
 		LogError,
+		nil,
+		false,
+		false,
+		DefaultEventBuffer,
+		true,
 		ext,
+		nil,
+	}
+}
+
+// Clone returns a deep copy of the Options: the slices, the maps and the
+// configuration structs are copied, so that they can be modified without
+// altering the original Options. The Extender, the Frontier, the
+// RateLimiter, the WARCWriter, the HARWriter, the functions and the compiled patterns are
+// shared. Crawler.Run runs with a clone of its Options.
+func (opts *Options) Clone() *Options {
+	if opts == nil {
+		return nil
+	}
+	c := *opts
+	if opts.AdaptiveDelay != nil {
+		ad := *opts.AdaptiveDelay
+		c.AdaptiveDelay = &ad
+	}
+	if opts.Transport != nil {
+		tr := *opts.Transport
+		if tr.ForceAttemptHTTP2 != nil {
+			h2 := *tr.ForceAttemptHTTP2
+			tr.ForceAttemptHTTP2 = &h2
+		}
+		c.Transport = &tr
+	}
+	c.AllowedSchemes = append([]string(nil), opts.AllowedSchemes...)
+	c.StripQueryParams = append([]string(nil), opts.StripQueryParams...)
+	c.ScopePrefixes = append([]string(nil), opts.ScopePrefixes...)
+	c.IncludePatterns = append([]*regexp.Regexp(nil), opts.IncludePatterns...)
+	c.ExcludePatterns = append([]*regexp.Regexp(nil), opts.ExcludePatterns...)
+	if opts.PerHost != nil {
+		c.PerHost = make(map[string]HostOptions, len(opts.PerHost))
+		for host, ho := range opts.PerHost {
+			if ho.CrawlDelay != nil {
+				d := *ho.CrawlDelay
+				ho.CrawlDelay = &d
+			}
+			if ho.HeadBeforeGet != nil {
+				b := *ho.HeadBeforeGet
+				ho.HeadBeforeGet = &b
+			}
+			c.PerHost[host] = ho
+		}
+	}
+	if opts.HostRewrite != nil {
+		c.HostRewrite = make(map[string]string, len(opts.HostRewrite))
+		for from, to := range opts.HostRewrite {
+			c.HostRewrite[from] = to
+		}
+	}
+	if opts.CrawlWindows != nil {
+		c.CrawlWindows = make(map[string][]CrawlWindow, len(opts.CrawlWindows))
+		for host, cws := range opts.CrawlWindows {
+			c.CrawlWindows[host] = append([]CrawlWindow(nil), cws...)
+		}
+	}
+	if opts.HostTags != nil {
+		c.HostTags = make(map[string]map[string]string, len(opts.HostTags))
+		for host, tags := range opts.HostTags {
+			c.HostTags[host] = copyTags(tags)
+		}
+	}
+	if opts.LocalFS != nil {
+		c.LocalFS = make(map[string]fs.FS, len(opts.LocalFS))
+		for host, fsys := range opts.LocalFS {
+			c.LocalFS[host] = fsys
+		}
+	}
+	return &c
+}
+
+// Validate checks the Options for invalid values, i.e. a nil Extender,
+// negative limits or durations, or unknown policies. It returns an
+// *OptionsError that lists all the problems found, or nil if the Options
+// are valid. It is called by Crawler.Run before the crawl starts.
+func (opts *Options) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
 	}
+
+	if opts.Extender == nil {
+		addf("Extender is nil")
+	}
+	for _, v := range []struct {
+		name string
+		val  int64
+	}{
+		{"MaxVisits", int64(opts.MaxVisits)},
+		{"MaxDuration", int64(opts.MaxDuration)},
+		{"MaxTotalBytes", opts.MaxTotalBytes},
+		{"MaxErrors", int64(opts.MaxErrors)},
+		{"MaxConsecutiveErrors", int64(opts.MaxConsecutiveErrors)},
+		{"EnqueueChanBuffer", int64(opts.EnqueueChanBuffer)},
+		{"HostBufferFactor", int64(opts.HostBufferFactor)},
+		{"MaxPendingPerHost", int64(opts.MaxPendingPerHost)},
+		{"MaxConcurrentHosts", int64(opts.MaxConcurrentHosts)},
+		{"FetchersPerHost", int64(opts.FetchersPerHost)},
+		{"EventBuffer", int64(opts.EventBuffer)},
+		{"MaxBytesPerSecond", opts.MaxBytesPerSecond},
+		{"CrawlDelay", int64(opts.CrawlDelay)},
+		{"MaxRobotsDelay", int64(opts.MaxRobotsDelay)},
+		{"HostFailureThreshold", int64(opts.HostFailureThreshold)},
+		{"HostCooldown", int64(opts.HostCooldown)},
+		{"WorkerIdleTTL", int64(opts.WorkerIdleTTL)},
+		{"RobotsTTL", int64(opts.RobotsTTL)},
+		{"RobotsRetries", int64(opts.RobotsRetries)},
+		{"RobotsRetryDelay", int64(opts.RobotsRetryDelay)},
+		{"RobotsUnavailableRetries", int64(opts.RobotsUnavailableRetries)},
+		{"MaxRobotsRetryWait", int64(opts.MaxRobotsRetryWait)},
+		{"VisitWorkers", int64(opts.VisitWorkers)},
+		{"VisitTimeout", int64(opts.VisitTimeout)},
+		{"MaxBodySize", opts.MaxBodySize},
+		{"ResponseCacheBytes", opts.ResponseCacheBytes},
+		{"HARMaxBodySize", opts.HARMaxBodySize},
+		{"MaxPaginationDepth", int64(opts.MaxPaginationDepth)},
+		{"MaxURLLength", int64(opts.MaxURLLength)},
+		{"MaxPathSegments", int64(opts.MaxPathSegments)},
+		{"MaxRepeatedSegments", int64(opts.MaxRepeatedSegments)},
+		{"MaxQueryVariantsPerPath", int64(opts.MaxQueryVariantsPerPath)},
+		{"RevisitAfter", int64(opts.RevisitAfter)},
+	} {
+		if v.val < 0 {
+			addf("%s is negative", v.name)
+		}
+	}
+	if opts.MaxRequestsPerSecond < 0 {
+		addf("MaxRequestsPerSecond is negative")
+	}
+	if opts.DelayJitter < 0 || opts.DelayJitter > 1 {
+		addf("DelayJitter must be between 0 and 1, got %v", opts.DelayJitter)
+	}
+	if ad := opts.AdaptiveDelay; ad != nil {
+		if ad.MinDelay < 0 || ad.MaxDelay < 0 {
+			addf("AdaptiveDelay has a negative MinDelay or MaxDelay")
+		} else if ad.MaxDelay > 0 && ad.MaxDelay < ad.MinDelay {
+			addf("AdaptiveDelay's MaxDelay is less than its MinDelay")
+		}
+		if ad.Multiplier < 0 {
+			addf("AdaptiveDelay's Multiplier is negative")
+		}
+		if ad.Window < 0 {
+			addf("AdaptiveDelay's Window is negative")
+		}
+	}
+	if tr := opts.Transport; tr != nil && (tr.MaxIdleConnsPerHost < 0 || tr.MaxConnsPerHost < 0 || tr.IdleConnTimeout < 0) {
+		addf("Transport has a negative MaxIdleConnsPerHost, MaxConnsPerHost or IdleConnTimeout")
+	}
+	for from, to := range opts.HostRewrite {
+		if _, _, err := net.SplitHostPort(from); err != nil {
+			addf("HostRewrite has an invalid address %q: %s", from, err)
+		}
+		if _, _, err := net.SplitHostPort(to); err != nil {
+			addf("HostRewrite has an invalid address %q: %s", to, err)
+		}
+	}
+	for host, fsys := range opts.LocalFS {
+		if fsys == nil {
+			addf("LocalFS has a nil file system for %q", host)
+		}
+	}
+	if opts.PendingPolicy > DropOldest {
+		addf("unknown PendingPolicy %d", opts.PendingPolicy)
+	}
+	if opts.RobotsDelayPolicy > RobotsDelayUseOptions {
+		addf("unknown RobotsDelayPolicy %d", opts.RobotsDelayPolicy)
+	}
+	if opts.RobotsErrorPolicy > RobotsRetryThenDisallow {
+		addf("unknown RobotsErrorPolicy %d", opts.RobotsErrorPolicy)
+	}
+	if opts.Ordering > OrderDFS {
+		addf("unknown Ordering %d", opts.Ordering)
+	}
+	if opts.URLNormalizerMode > NormalizerReplacePurell {
+		addf("unknown URLNormalizerMode %d", opts.URLNormalizerMode)
+	}
+	if opts.FragmentPolicy > FragmentsHashbangToQuery {
+		addf("unknown FragmentPolicy %d", opts.FragmentPolicy)
+	}
+	if opts.TrailingSlashPolicy > SlashStrip {
+		addf("unknown TrailingSlashPolicy %d", opts.TrailingSlashPolicy)
+	}
+	if opts.RecheckFallback > RecheckGet {
+		addf("unknown RecheckFallback %d", opts.RecheckFallback)
+	}
+	if opts.HarvestSelectorFallback > HarvestNothing {
+		addf("unknown HarvestSelectorFallback %d", opts.HarvestSelectorFallback)
+	}
+	if opts.ReportFormat > ReportCSV {
+		addf("unknown ReportFormat %d", opts.ReportFormat)
+	}
+	if opts.URLNormalizerMode == NormalizerReplacePurell && opts.URLNormalizer == nil {
+		addf("URLNormalizerMode is NormalizerReplacePurell but URLNormalizer is nil")
+	}
+	for i, p := range opts.ScopePrefixes {
+		if u, err := url.Parse(p); err != nil || !u.IsAbs() || u.Host == "" {
+			addf("ScopePrefixes[%d] is not an absolute URL: %q", i, p)
+		}
+	}
+	if _, err := compileHarvestSelector(opts.HarvestSelector); err != nil {
+		addf("HarvestSelector is not a valid selector: %q", opts.HarvestSelector)
+	}
+	for i, re := range opts.IncludePatterns {
+		if re == nil {
+			addf("IncludePatterns[%d] is nil", i)
+		}
+	}
+	for i, re := range opts.ExcludePatterns {
+		if re == nil {
+			addf("ExcludePatterns[%d] is nil", i)
+		}
+	}
+
+	hosts := make([]string, 0, len(opts.PerHost))
+	for host := range opts.PerHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		ho := opts.PerHost[host]
+		if ho.CrawlDelay != nil && *ho.CrawlDelay < 0 {
+			addf("PerHost[%q].CrawlDelay is negative", host)
+		}
+		if ho.MaxVisitsPerHost < 0 {
+			addf("PerHost[%q].MaxVisitsPerHost is negative", host)
+		}
+	}
+
+	hosts = hosts[:0]
+	for host := range opts.CrawlWindows {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		for i, cw := range opts.CrawlWindows[host] {
+			if !cw.valid() {
+				addf("CrawlWindows[%q][%d] is not a valid time-of-day window: %v to %v", host, i, cw.Start, cw.End)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &OptionsError{problems}
+	}
+	return nil
+}
+
+// Return the warnings about suspicious, but valid, Options.
+func (opts *Options) warnings() []string {
+	var warns []string
+	if opts.UserAgent == "" {
+		warns = append(warns, "UserAgent is empty")
+	}
+	if opts.RobotUserAgent == "" && !opts.ignoresRobots() {
+		warns = append(warns, "RobotUserAgent is empty, only the robots.txt policies for all user-agents (*) apply")
+	}
+	if opts.HostBufferFactor == 0 {
+		warns = append(warns, "HostBufferFactor is zero, the workers' responses are unbuffered")
+	}
+	if opts.RateLimiter != nil && opts.MaxRequestsPerSecond > 0 {
+		warns = append(warns, "MaxRequestsPerSecond is ignored because RateLimiter is set")
+	}
+	if opts.HostFailureThreshold > 0 && opts.HostCooldown == 0 {
+		warns = append(warns, "HostCooldown is zero, hosts considered down are probed immediately")
+	}
+	if opts.GetFormDefaults && !opts.FollowGetForms {
+		warns = append(warns, "GetFormDefaults is ignored because FollowGetForms is false")
+	}
+	if opts.HarvestSelectorFallback != HarvestWholePage && opts.HarvestSelector == "" {
+		warns = append(warns, "HarvestSelectorFallback is ignored because HarvestSelector is empty")
+	}
+	if opts.DryRunSkipRobots && !opts.DryRun {
+		warns = append(warns, "DryRunSkipRobots is ignored because DryRun is false")
+	}
+	if opts.TrackDuplicateSources && !opts.TrackDuplicates {
+		warns = append(warns, "TrackDuplicateSources is ignored because TrackDuplicates is false")
+	}
+	if opts.Deterministic && opts.VisitWorkers > 0 {
+		warns = append(warns, "VisitWorkers is ignored because Deterministic is set")
+	}
+	if opts.Deterministic && opts.FetchersPerHost > 1 {
+		warns = append(warns, "FetchersPerHost is ignored because Deterministic is set")
+	}
+	if opts.Deterministic && opts.WorkerIdleTTL > 0 && opts.WorkerIdleTTL != DefaultIdleTTL {
+		// The default is not worth a warning, it is not set on purpose
+		warns = append(warns, "WorkerIdleTTL is ignored because Deterministic is set")
+	}
+	if opts.WARCGzip && opts.WARCWriter == nil {
+		warns = append(warns, "WARCGzip is ignored because WARCWriter is nil")
+	}
+	if opts.tunesClient() && !opts.clonesTransport() {
+		warns = append(warns, "Transport, DialContext and HostRewrite are ignored because the HttpClient's Transport is not an *http.Transport")
+	}
+	return warns
+}
+
+// Indicates if the robots.txt handling is disabled, per the IgnoreRobots
+// and DryRunSkipRobots options.
+func (opts *Options) ignoresRobots() bool {
+	return opts.IgnoreRobots || opts.DryRun && opts.DryRunSkipRobots
+}
+
+// Indicates if the logs of the host are enabled, per the LogHostFilter
+// option.
+func (opts *Options) logsHost(host string) bool {
+	return opts.LogHostFilter == nil || opts.LogHostFilter(host)
+}
+
+// Indicates if the options require a copy of the HttpClient with its own
+// transport.
+func (opts *Options) tunesClient() bool {
+	return opts.Transport != nil || opts.DialContext != nil || len(opts.HostRewrite) > 0
+}
+
+// Return the template of the HTTP client of the runs, the HTTPClient option
+// or the package's HttpClient.
+func (opts *Options) httpClient() *http.Client {
+	if opts.HTTPClient != nil {
+		return opts.HTTPClient
+	}
+	return HttpClient
+}
+
+// Indicates if the transport of the HTTP client template is copied for each
+// run, i.e. if it is an *http.Transport or the default transport, so that
+// the runs do not share their connections.
+func (opts *Options) clonesTransport() bool {
+	switch opts.httpClient().Transport.(type) {
+	case nil, *http.Transport:
+		return true
+	}
+	return false
+}
+
+// Return the options overrides of the host, based on the PerHost option.
+func (opts *Options) hostOptions(host string) HostOptions {
+	if ho, ok := opts.PerHost[host]; ok {
+		return ho
+	}
+	var match string
+	for pattern := range opts.PerHost {
+		if matchesHostPattern(host, pattern) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	if match == "" {
+		return HostOptions{}
+	}
+	return opts.PerHost[match]
+}
+
+// Indicates if the host matches the pattern, in the form "*.example.com"
+// for the subdomains of example.com.
+func matchesHostPattern(host, pattern string) bool {
+	return strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])
 }
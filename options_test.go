@@ -0,0 +1,253 @@
+package gocrawl
+
+import (
+	"io/fs"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		set      func(*Options)
+		problems []string
+	}{
+		{"Defaults", func(o *Options) {}, nil},
+		{"NilExtender", func(o *Options) { o.Extender = nil }, []string{"Extender is nil"}},
+		{"MaxVisits", func(o *Options) { o.MaxVisits = -1 }, []string{"MaxVisits is negative"}},
+		{"MaxDuration", func(o *Options) { o.MaxDuration = -1 }, []string{"MaxDuration is negative"}},
+		{"MaxTotalBytes", func(o *Options) { o.MaxTotalBytes = -1 }, []string{"MaxTotalBytes is negative"}},
+		{"MaxErrors", func(o *Options) { o.MaxErrors = -1 }, []string{"MaxErrors is negative"}},
+		{"MaxConsecutiveErrors", func(o *Options) { o.MaxConsecutiveErrors = -1 }, []string{"MaxConsecutiveErrors is negative"}},
+		{"EnqueueChanBuffer", func(o *Options) { o.EnqueueChanBuffer = -1 }, []string{"EnqueueChanBuffer is negative"}},
+		{"HostBufferFactor", func(o *Options) { o.HostBufferFactor = -1 }, []string{"HostBufferFactor is negative"}},
+		{"MaxPendingPerHost", func(o *Options) { o.MaxPendingPerHost = -1 }, []string{"MaxPendingPerHost is negative"}},
+		{"MaxConcurrentHosts", func(o *Options) { o.MaxConcurrentHosts = -1 }, []string{"MaxConcurrentHosts is negative"}},
+		{"FetchersPerHost", func(o *Options) { o.FetchersPerHost = -1 }, []string{"FetchersPerHost is negative"}},
+		{"MaxRequestsPerSecond", func(o *Options) { o.MaxRequestsPerSecond = -1 }, []string{"MaxRequestsPerSecond is negative"}},
+		{"MaxBytesPerSecond", func(o *Options) { o.MaxBytesPerSecond = -1 }, []string{"MaxBytesPerSecond is negative"}},
+		{"CrawlDelay", func(o *Options) { o.CrawlDelay = -time.Second }, []string{"CrawlDelay is negative"}},
+		{"MaxRobotsDelay", func(o *Options) { o.MaxRobotsDelay = -1 }, []string{"MaxRobotsDelay is negative"}},
+		{"HostFailureThreshold", func(o *Options) { o.HostFailureThreshold = -1 }, []string{"HostFailureThreshold is negative"}},
+		{"HostCooldown", func(o *Options) { o.HostCooldown = -1 }, []string{"HostCooldown is negative"}},
+		{"WorkerIdleTTL", func(o *Options) { o.WorkerIdleTTL = -1 }, []string{"WorkerIdleTTL is negative"}},
+		{"RobotsTTL", func(o *Options) { o.RobotsTTL = -1 }, []string{"RobotsTTL is negative"}},
+		{"RobotsRetries", func(o *Options) { o.RobotsRetries = -1 }, []string{"RobotsRetries is negative"}},
+		{"RobotsRetryDelay", func(o *Options) { o.RobotsRetryDelay = -1 }, []string{"RobotsRetryDelay is negative"}},
+		{"RobotsUnavailableRetries", func(o *Options) { o.RobotsUnavailableRetries = -1 }, []string{"RobotsUnavailableRetries is negative"}},
+		{"VisitWorkers", func(o *Options) { o.VisitWorkers = -1 }, []string{"VisitWorkers is negative"}},
+		{"VisitTimeout", func(o *Options) { o.VisitTimeout = -1 }, []string{"VisitTimeout is negative"}},
+		{"RevisitAfter", func(o *Options) { o.RevisitAfter = -1 }, []string{"RevisitAfter is negative"}},
+		{"MaxBodySize", func(o *Options) { o.MaxBodySize = -1 }, []string{"MaxBodySize is negative"}},
+		{"HARMaxBodySize", func(o *Options) { o.HARMaxBodySize = -1 }, []string{"HARMaxBodySize is negative"}},
+		{"MaxQueryVariantsPerPath", func(o *Options) { o.MaxQueryVariantsPerPath = -1 }, []string{"MaxQueryVariantsPerPath is negative"}},
+		{"ResponseCacheBytes", func(o *Options) { o.ResponseCacheBytes = -1 }, []string{"ResponseCacheBytes is negative"}},
+		{"Transport", func(o *Options) { o.Transport = &TransportOptions{MaxConnsPerHost: -1} },
+			[]string{"Transport has a negative MaxIdleConnsPerHost, MaxConnsPerHost or IdleConnTimeout"}},
+		{"HostRewrite", func(o *Options) { o.HostRewrite = map[string]string{"hosta": "127.0.0.1:80"} },
+			[]string{`HostRewrite has an invalid address "hosta": address hosta: missing port in address`}},
+		{"LocalFS", func(o *Options) { o.LocalFS = map[string]fs.FS{"docs.local": nil} },
+			[]string{`LocalFS has a nil file system for "docs.local"`}},
+		{"DelayJitterNegative", func(o *Options) { o.DelayJitter = -0.5 }, []string{"DelayJitter must be between 0 and 1, got -0.5"}},
+		{"DelayJitterTooBig", func(o *Options) { o.DelayJitter = 1.5 }, []string{"DelayJitter must be between 0 and 1, got 1.5"}},
+		{"AdaptiveDelayNegative", func(o *Options) { o.AdaptiveDelay = &AdaptiveDelay{MinDelay: -1} },
+			[]string{"AdaptiveDelay has a negative MinDelay or MaxDelay"}},
+		{"AdaptiveDelayBounds", func(o *Options) { o.AdaptiveDelay = &AdaptiveDelay{MinDelay: 2, MaxDelay: 1} },
+			[]string{"AdaptiveDelay's MaxDelay is less than its MinDelay"}},
+		{"AdaptiveDelayMultiplier", func(o *Options) { o.AdaptiveDelay = &AdaptiveDelay{Multiplier: -1} },
+			[]string{"AdaptiveDelay's Multiplier is negative"}},
+		{"AdaptiveDelayWindow", func(o *Options) { o.AdaptiveDelay = &AdaptiveDelay{Window: -1} },
+			[]string{"AdaptiveDelay's Window is negative"}},
+		{"PendingPolicy", func(o *Options) { o.PendingPolicy = DropOldest + 1 }, []string{"unknown PendingPolicy 3"}},
+		{"RobotsDelayPolicy", func(o *Options) { o.RobotsDelayPolicy = RobotsDelayUseOptions + 1 }, []string{"unknown RobotsDelayPolicy 3"}},
+		{"RobotsErrorPolicy", func(o *Options) { o.RobotsErrorPolicy = RobotsRetryThenDisallow + 1 }, []string{"unknown RobotsErrorPolicy 3"}},
+		{"Ordering", func(o *Options) { o.Ordering = OrderDFS + 1 }, []string{"unknown Ordering 2"}},
+		{"URLNormalizerMode", func(o *Options) { o.URLNormalizerMode = NormalizerReplacePurell + 1 }, []string{"unknown URLNormalizerMode 2"}},
+		{"FragmentPolicy", func(o *Options) { o.FragmentPolicy = FragmentsHashbangToQuery + 1 }, []string{"unknown FragmentPolicy 3"}},
+		{"TrailingSlashPolicy", func(o *Options) { o.TrailingSlashPolicy = SlashStrip + 1 }, []string{"unknown TrailingSlashPolicy 3"}},
+		{"RecheckFallback", func(o *Options) { o.RecheckFallback = RecheckGet + 1 }, []string{"unknown RecheckFallback 3"}},
+		{"HarvestSelectorFallback", func(o *Options) { o.HarvestSelectorFallback = HarvestNothing + 1 }, []string{"unknown HarvestSelectorFallback 2"}},
+		{"ReportFormat", func(o *Options) { o.ReportFormat = ReportCSV + 1 }, []string{"unknown ReportFormat 2"}},
+		{"HarvestSelector", func(o *Options) { o.HarvestSelector = "main, [" }, []string{`HarvestSelector is not a valid selector: "main, ["`}},
+		{"URLNormalizerNil", func(o *Options) { o.URLNormalizerMode = NormalizerReplacePurell },
+			[]string{"URLNormalizerMode is NormalizerReplacePurell but URLNormalizer is nil"}},
+		{"ScopePrefixes", func(o *Options) { o.ScopePrefixes = []string{"http://host/docs/", "/docs/", ":"} },
+			[]string{`ScopePrefixes[1] is not an absolute URL: "/docs/"`, `ScopePrefixes[2] is not an absolute URL: ":"`}},
+		{"Patterns", func(o *Options) {
+			o.IncludePatterns = []*regexp.Regexp{regexp.MustCompile("a"), nil}
+			o.ExcludePatterns = []*regexp.Regexp{nil}
+		}, []string{"IncludePatterns[1] is nil", "ExcludePatterns[0] is nil"}},
+		{"PerHost", func(o *Options) {
+			d := -time.Second
+			o.PerHost = map[string]HostOptions{
+				"b.com":   {MaxVisitsPerHost: -1},
+				"a.com":   {CrawlDelay: &d},
+				"*.c.com": {MaxVisitsPerHost: 1},
+			}
+		}, []string{`PerHost["a.com"].CrawlDelay is negative`, `PerHost["b.com"].MaxVisitsPerHost is negative`}},
+		{"CrawlWindows", func(o *Options) {
+			o.CrawlWindows = map[string][]CrawlWindow{
+				"a.com":   {{Start: time.Hour, End: 5 * time.Hour}, {Start: 2 * time.Hour, End: 2 * time.Hour}},
+				"*.b.com": {{Start: 22 * time.Hour, End: 24 * time.Hour}, {Start: -time.Hour, End: time.Hour}},
+			}
+		}, []string{`CrawlWindows["*.b.com"][1] is not a valid time-of-day window: -1h0m0s to 1h0m0s`,
+			`CrawlWindows["a.com"][1] is not a valid time-of-day window: 2h0m0s to 2h0m0s`}},
+		{"Multiple", func(o *Options) {
+			o.Extender = nil
+			o.CrawlDelay = -1
+			o.DelayJitter = 2
+		}, []string{"Extender is nil", "CrawlDelay is negative", "DelayJitter must be between 0 and 1, got 2"}},
+	}
+
+	for _, c := range cases {
+		opts := NewOptions(new(DefaultExtender))
+		c.set(opts)
+		err := opts.Validate()
+		if c.problems == nil {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+			}
+			continue
+		}
+		oe, ok := err.(*OptionsError)
+		if !ok {
+			t.Errorf("%s: expected an *OptionsError, got %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(oe.Problems, c.problems) {
+			t.Errorf("%s: expected problems %v, got %v", c.name, c.problems, oe.Problems)
+		}
+	}
+}
+
+func TestOptionsWarnings(t *testing.T) {
+	cases := []struct {
+		name  string
+		set   func(*Options)
+		warns []string
+	}{
+		{"Defaults", func(o *Options) {}, nil},
+		{"UserAgent", func(o *Options) { o.UserAgent = "" }, []string{"UserAgent is empty"}},
+		{"RobotUserAgent", func(o *Options) { o.RobotUserAgent = "" },
+			[]string{"RobotUserAgent is empty, only the robots.txt policies for all user-agents (*) apply"}},
+		{"RobotUserAgentIgnoreRobots", func(o *Options) {
+			o.RobotUserAgent = ""
+			o.IgnoreRobots = true
+		}, nil},
+		{"HostBufferFactor", func(o *Options) { o.HostBufferFactor = 0 },
+			[]string{"HostBufferFactor is zero, the workers' responses are unbuffered"}},
+		{"RateLimiter", func(o *Options) {
+			o.RateLimiter = NewRateLimiter(1)
+			o.MaxRequestsPerSecond = 2
+		}, []string{"MaxRequestsPerSecond is ignored because RateLimiter is set"}},
+		{"HostCooldown", func(o *Options) {
+			o.HostFailureThreshold = 3
+			o.HostCooldown = 0
+		}, []string{"HostCooldown is zero, hosts considered down are probed immediately"}},
+		{"Deterministic", func(o *Options) {
+			o.Deterministic = true
+			o.VisitWorkers = 2
+		}, []string{"VisitWorkers is ignored because Deterministic is set"}},
+		{"DeterministicFetchers", func(o *Options) {
+			o.Deterministic = true
+			o.FetchersPerHost = 2
+		}, []string{"FetchersPerHost is ignored because Deterministic is set"}},
+		{"DeterministicIdleTTL", func(o *Options) {
+			o.Deterministic = true
+			o.WorkerIdleTTL = time.Second
+		}, []string{"WorkerIdleTTL is ignored because Deterministic is set"}},
+		{"WARCGzip", func(o *Options) { o.WARCGzip = true }, []string{"WARCGzip is ignored because WARCWriter is nil"}},
+		{"GetFormDefaults", func(o *Options) { o.GetFormDefaults = true }, []string{"GetFormDefaults is ignored because FollowGetForms is false"}},
+		{"HarvestSelectorFallback", func(o *Options) { o.HarvestSelectorFallback = HarvestNothing },
+			[]string{"HarvestSelectorFallback is ignored because HarvestSelector is empty"}},
+		{"DryRunSkipRobots", func(o *Options) { o.DryRunSkipRobots = true }, []string{"DryRunSkipRobots is ignored because DryRun is false"}},
+		{"TrackDuplicateSources", func(o *Options) { o.TrackDuplicateSources = true },
+			[]string{"TrackDuplicateSources is ignored because TrackDuplicates is false"}},
+	}
+
+	for _, c := range cases {
+		opts := NewOptions(new(DefaultExtender))
+		c.set(opts)
+		if warns := opts.warnings(); !reflect.DeepEqual(warns, c.warns) {
+			t.Errorf("%s: expected warnings %v, got %v", c.name, c.warns, warns)
+		}
+	}
+}
+
+func TestHostOptions(t *testing.T) {
+	opts := NewOptions(nil)
+	opts.PerHost = map[string]HostOptions{
+		"example.com":          {UserAgent: "exact"},
+		"*.example.com":        {UserAgent: "subdomains"},
+		"*.images.example.com": {UserAgent: "images"},
+		"other.com":            {UserAgent: "other"},
+	}
+
+	cases := map[string]string{
+		"example.com":            "exact",
+		"www.example.com":        "subdomains",
+		"a.b.example.com":        "subdomains",
+		"images.example.com":     "subdomains",
+		"cdn.images.example.com": "images",
+		"notexample.com":         "",
+		"other.com":              "other",
+		"sub.other.com":          "",
+		"unknown.org":            "",
+	}
+	for host, want := range cases {
+		if got := opts.hostOptions(host).UserAgent; got != want {
+			t.Errorf("%s: want %q, got %q", host, want, got)
+		}
+	}
+	opts.PerHost = nil
+	if ho := opts.hostOptions("example.com"); ho != (HostOptions{}) {
+		t.Errorf("want zero host options without PerHost, got %+v", ho)
+	}
+}
+
+func TestOptionsClone(t *testing.T) {
+	d := time.Second
+	h2 := true
+	opts := NewOptions(nil)
+	opts.AdaptiveDelay = &AdaptiveDelay{MinDelay: d}
+	opts.Transport = &TransportOptions{MaxConnsPerHost: 2, ForceAttemptHTTP2: &h2}
+	opts.StripQueryParams = []string{"utm_source"}
+	opts.IncludePatterns = []*regexp.Regexp{regexp.MustCompile(`^http://a/`)}
+	opts.PerHost = map[string]HostOptions{"a": {CrawlDelay: &d, UserAgent: "a"}}
+	opts.HostRewrite = map[string]string{"a:80": "b:80"}
+	opts.HostTags = map[string]map[string]string{"a": {"customer": "acme"}}
+
+	c := opts.Clone()
+	if !reflect.DeepEqual(c.AllowedSchemes, opts.AllowedSchemes) || c.AdaptiveDelay.MinDelay != d ||
+		c.Transport.MaxConnsPerHost != 2 || *c.PerHost["a"].CrawlDelay != d || c.HostRewrite["a:80"] != "b:80" {
+		t.Fatalf("want an equal clone, got %+v", c)
+	}
+
+	c.AllowedSchemes[0] = "ftp"
+	c.AdaptiveDelay.MinDelay = 0
+	c.Transport.MaxConnsPerHost = 0
+	*c.Transport.ForceAttemptHTTP2 = false
+	c.StripQueryParams[0] = "x"
+	c.IncludePatterns[0] = nil
+	*c.PerHost["a"].CrawlDelay = 0
+	c.PerHost["b"] = HostOptions{}
+	c.HostRewrite["a:80"] = "c:80"
+	c.HostTags["a"]["customer"] = "other"
+	if DefaultAllowedSchemes[0] != "http" || opts.AllowedSchemes[0] != "http" {
+		t.Errorf("want the allowed schemes unchanged, got %v", opts.AllowedSchemes)
+	}
+	if opts.AdaptiveDelay.MinDelay != d || opts.Transport.MaxConnsPerHost != 2 || !h2 {
+		t.Errorf("want the configuration structs unchanged, got %+v and %+v", opts.AdaptiveDelay, opts.Transport)
+	}
+	if opts.StripQueryParams[0] != "utm_source" || opts.IncludePatterns[0] == nil {
+		t.Errorf("want the slices unchanged, got %v and %v", opts.StripQueryParams, opts.IncludePatterns)
+	}
+	if d != time.Second || len(opts.PerHost) != 1 || opts.HostRewrite["a:80"] != "b:80" || opts.HostTags["a"]["customer"] != "acme" {
+		t.Errorf("want the maps unchanged, got %v, %v and %v", opts.PerHost, opts.HostRewrite, opts.HostTags)
+	}
+	if (*Options)(nil).Clone() != nil {
+		t.Error("want a nil clone of nil options")
+	}
+}
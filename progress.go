@@ -0,0 +1,219 @@
+package gocrawl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The progress of a crawl: the queues of the hosts and the URL counters. It
+// is updated by the crawler goroutine and may be read concurrently, i.e. by
+// the Crawler's introspection methods.
+type progress struct {
+	mu       sync.RWMutex
+	queues   map[string]*hostQueue
+	waiting  map[string]int
+	visited  int
+	enqueued int
+	inFlight int
+
+	// The soft visits, among the visited URLs, with the
+	// CountOnlyChangedVisits option
+	unchanged int
+
+	// The URLs rejected by the policies of the crawler, by kind
+	disallowed map[DisallowedKind]int
+}
+
+func newProgress() *progress {
+	return &progress{
+		queues:     make(map[string]*hostQueue),
+		waiting:    make(map[string]int),
+		disallowed: make(map[DisallowedKind]int),
+	}
+}
+
+// Reset the progress for a new crawl.
+func (p *progress) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queues = make(map[string]*hostQueue)
+	p.waiting = make(map[string]int)
+	p.disallowed = make(map[DisallowedKind]int)
+	p.visited, p.enqueued, p.inFlight, p.unchanged = 0, 0, 0, 0
+}
+
+// Set the queue of the worker of the host.
+func (p *progress) setQueue(host string, q *hostQueue) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queues[host] = q
+}
+
+// Set the number of URLs of the host waiting for a worker slot.
+func (p *progress) setWaiting(host string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n == 0 {
+		delete(p.waiting, host)
+	} else {
+		p.waiting[host] = n
+	}
+}
+
+// Add to the visited, enqueued and in-flight URL counters.
+func (p *progress) add(visited, enqueued, inFlight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.visited += visited
+	p.enqueued += enqueued
+	p.inFlight += inFlight
+}
+
+// Count a soft visit, that does not count against the MaxVisits budget.
+func (p *progress) addUnchanged() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unchanged++
+}
+
+// Count an URL rejected by a policy of the crawler. It may be called from
+// the workers' goroutines.
+func (p *progress) addDisallowed(kind DisallowedKind) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disallowed[kind]++
+}
+
+func (p *progress) disallowedCounts() map[DisallowedKind]int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make(map[DisallowedKind]int, len(p.disallowed))
+	for k, n := range p.disallowed {
+		res[k] = n
+	}
+	return res
+}
+
+// Return the summary of the crawl: the URL counters, and the rejected URLs
+// by kind (i.e. "10 visited, 12 enqueued, 3 disallowed (pattern: 1, trap: 2)").
+// The visits are split between the changed and unchanged ones if there are
+// soft visits (i.e. "10 visited (6 changed, 4 unchanged), ...").
+func (p *progress) summary() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var n int
+	var kinds []string
+	for k := range lookupDisallowedKind {
+		if c := p.disallowed[DisallowedKind(k)]; c > 0 {
+			n += c
+			kinds = append(kinds, fmt.Sprintf("%s: %d", DisallowedKind(k), c))
+		}
+	}
+	s := fmt.Sprintf("%d visited", p.visited)
+	if p.unchanged > 0 {
+		s += fmt.Sprintf(" (%d changed, %d unchanged)", p.visited-p.unchanged, p.unchanged)
+	}
+	s += fmt.Sprintf(", %d enqueued, %d disallowed", p.enqueued, n)
+	if n > 0 {
+		s += " (" + strings.Join(kinds, ", ") + ")"
+	}
+	return s
+}
+
+func (p *progress) queueLen(host string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := p.waiting[host]
+	if q, ok := p.queues[host]; ok {
+		n += q.len()
+	}
+	return n
+}
+
+func (p *progress) hosts() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	hosts := make([]string, 0, len(p.queues)+len(p.waiting))
+	for host := range p.queues {
+		hosts = append(hosts, host)
+	}
+	for host := range p.waiting {
+		if _, ok := p.queues[host]; !ok {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (p *progress) counters() (visited, enqueued, inFlight int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.visited, p.enqueued, p.inFlight
+}
+
+// QueueLen returns the number of URLs waiting to be processed for the host
+// (the normalized form of the URL's host), including the URLs waiting for a
+// worker slot. It is safe to call it during the crawl.
+func (c *Crawler) QueueLen(host string) int {
+	if c.progress == nil {
+		return 0
+	}
+	return c.progress.queueLen(host)
+}
+
+// Hosts returns the sorted list of the hosts (in normalized form) that were
+// crawled or are waiting for a worker slot since the start of the crawl, or
+// of the last crawl if it is done. It is safe to call it during the crawl.
+func (c *Crawler) Hosts() []string {
+	if c.progress == nil {
+		return nil
+	}
+	return c.progress.hosts()
+}
+
+// VisitedCount returns the number of pages visited since the start of the
+// crawl, or of the last crawl if it is done. It is safe to call it during
+// the crawl.
+func (c *Crawler) VisitedCount() int {
+	if c.progress == nil {
+		return 0
+	}
+	v, _, _ := c.progress.counters()
+	return v
+}
+
+// EnqueuedCount returns the number of URLs enqueued since the start of the
+// crawl, or of the last crawl if it is done, the robots.txt URLs excluded. It
+// is safe to call it during the crawl.
+func (c *Crawler) EnqueuedCount() int {
+	if c.progress == nil {
+		return 0
+	}
+	_, e, _ := c.progress.counters()
+	return e
+}
+
+// InFlight returns the number of enqueued URLs that are not processed yet,
+// either waiting in the queue of their host or being fetched and visited.
+// It is safe to call it during the crawl.
+func (c *Crawler) InFlight() int {
+	if c.progress == nil {
+		return 0
+	}
+	_, _, n := c.progress.counters()
+	return n
+}
+
+// DisallowedCounts returns the number of URLs rejected by the policies of the
+// crawler since the start of the crawl, or of the last crawl if it is done,
+// by kind of rejection (see DisallowedKind). It is safe to call it during the
+// crawl.
+func (c *Crawler) DisallowedCounts() map[DisallowedKind]int {
+	if c.progress == nil {
+		return nil
+	}
+	return c.progress.disallowedCounts()
+}
@@ -0,0 +1,126 @@
+package gocrawl
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// The maximum number of paths tracked by the MaxQueryVariantsPerPath guard,
+// the least recently used ones are evicted beyond, so that the guard itself
+// is bounded in memory. The variants of an evicted path are counted from
+// scratch if it is found again.
+const maxQueryVariantPaths = 10000
+
+// The number of paths listed in the summary of the crawl, by number of query
+// variants.
+const queryVariantsSummaryPaths = 5
+
+// queryVariants tracks the distinct query strings enqueued for each
+// normalized scheme, host and path, with the MaxQueryVariantsPerPath option.
+// The query strings are kept as hashes, at most max per path, for at most
+// maxQueryVariantPaths paths. It is used by the crawler's goroutine only.
+type queryVariants struct {
+	max   int
+	paths map[string]*list.Element
+	lru   *list.List
+}
+
+// The query variants of a path.
+type pathVariants struct {
+	key      string
+	queries  map[uint64]struct{}
+	rejected int
+}
+
+func newQueryVariants(max int) *queryVariants {
+	return &queryVariants{max: max, paths: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Return the key of the path of the URL, and the hash of its query string.
+func queryVariantKey(u *url.URL) (string, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(u.RawQuery))
+	return u.Scheme + "://" + u.Host + u.EscapedPath(), h.Sum64()
+}
+
+// Indicates if the URL is allowed, i.e. it has no query string, or its query
+// string is already enqueued for its path, or the path has less than max
+// variants. A rejected URL is counted for the summary.
+func (q *queryVariants) allows(u *url.URL) bool {
+	if u.RawQuery == "" {
+		return true
+	}
+	key, h := queryVariantKey(u)
+	el, ok := q.paths[key]
+	if !ok {
+		return true
+	}
+	pv := el.Value.(*pathVariants)
+	if _, ok := pv.queries[h]; ok || len(pv.queries) < q.max {
+		return true
+	}
+	pv.rejected++
+	q.lru.MoveToFront(el)
+	return false
+}
+
+// Record the query string of the enqueued URL, if any.
+func (q *queryVariants) add(u *url.URL) {
+	if u.RawQuery == "" {
+		return
+	}
+	key, h := queryVariantKey(u)
+	el, ok := q.paths[key]
+	if !ok {
+		el = q.lru.PushFront(&pathVariants{key: key, queries: make(map[uint64]struct{})})
+		q.paths[key] = el
+		if q.lru.Len() > maxQueryVariantPaths {
+			old := q.lru.Remove(q.lru.Back()).(*pathVariants)
+			delete(q.paths, old.key)
+		}
+	} else {
+		q.lru.MoveToFront(el)
+	}
+	el.Value.(*pathVariants).queries[h] = struct{}{}
+}
+
+// Return the summary of the paths with the most query variants, enqueued and
+// rejected, i.e. ", top query variants: http://host/products 40 (35
+// rejected), http://host/search 3". Empty if no path has several variants.
+func (q *queryVariants) summary() string {
+	var pvs []*pathVariants
+	for el := q.lru.Front(); el != nil; el = el.Next() {
+		if pv := el.Value.(*pathVariants); pv.count() > 1 {
+			pvs = append(pvs, pv)
+		}
+	}
+	if len(pvs) == 0 {
+		return ""
+	}
+	sort.SliceStable(pvs, func(i, j int) bool {
+		if pvs[i].count() != pvs[j].count() {
+			return pvs[i].count() > pvs[j].count()
+		}
+		return pvs[i].key < pvs[j].key
+	})
+	if len(pvs) > queryVariantsSummaryPaths {
+		pvs = pvs[:queryVariantsSummaryPaths]
+	}
+	parts := make([]string, len(pvs))
+	for i, pv := range pvs {
+		parts[i] = fmt.Sprintf("%s %d", pv.key, pv.count())
+		if pv.rejected > 0 {
+			parts[i] += fmt.Sprintf(" (%d rejected)", pv.rejected)
+		}
+	}
+	return ", top query variants: " + strings.Join(parts, ", ")
+}
+
+// Return the number of variants of the path, enqueued and rejected.
+func (pv *pathVariants) count() int {
+	return len(pv.queries) + pv.rejected
+}
@@ -0,0 +1,107 @@
+package gocrawl
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FetchMode indicates how an URL is fetched.
+type FetchMode uint8
+
+// The various fetch modes.
+const (
+	// FetchDefault fetches the URL with a GET request, preceded by a HEAD
+	// request per the HeadBeforeGet and HeadOnly settings.
+	FetchDefault FetchMode = iota
+
+	// FetchRangeProbe only checks that the URL exists, with a GET request of
+	// its first byte (a "Range: bytes=0-0" header), instead of a HEAD request
+	// that some servers handle poorly. A 206 response, a 200 response from a
+	// server that ignores the Range header or a 416 response (e.g. for an
+	// empty file) is a success: the URL is notified as visited, but it is
+	// not visited and its body is never read. The HeadBeforeGet setting is
+	// ignored, the HeadOnly setting takes precedence.
+	FetchRangeProbe
+)
+
+var lookupFetchMode = [...]string{
+	FetchDefault:    "default",
+	FetchRangeProbe: "range probe",
+}
+
+func (m FetchMode) String() string {
+	return lookupFetchMode[m]
+}
+
+// The Range header of the requests of the range probes.
+const rangeProbeHeader = "bytes=0-0"
+
+// Indicates if the URL is fetched with a range probe, not with a HEAD
+// request.
+func (uc *URLContext) isRangeProbe() bool {
+	return uc.FetchMode == FetchRangeProbe && !uc.HeadOnly
+}
+
+// Process the response of the range probe of the URL, or of the GET request
+// of its recheck (see RecheckGet). Its body is not read, it is closed by the
+// caller.
+func (w *worker) probeRange(ctx *URLContext, res *http.Response) {
+	ctx.fetchInfo.TotalSize = rangeTotalSize(res)
+	if !isProbeSuccess(ctx, res.StatusCode) {
+		w.notifyError(newStatusCodeError(ctx, res))
+		w.logFunc(LogError, "ERROR status code for %s: %s", ctx.url, res.Status)
+		w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitStatusError})
+		w.sendResponse(ctx, false, nil, false)
+		return
+	}
+	outcome := VisitRangeProbe
+	if !ctx.isRangeProbe() {
+		outcome = VisitRechecked
+	}
+
+	w.mu.Lock()
+	w.visitCount++
+	w.mu.Unlock()
+	if w.errBudget != nil {
+		w.errBudget.success()
+	}
+	w.logFunc(LogTrace, "%s of %s: %s, total size %d", outcome, ctx.url, res.Status, ctx.fetchInfo.TotalSize)
+	ctx.unchanged = true
+	w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: outcome})
+	w.eventFunc(LogInfo, EventVisit, urlFields(ctx))
+	w.events.emitURL(CevVisited, ctx)
+	w.sendResponse(ctx, true, nil, false)
+}
+
+// Indicates if the status code of the response to the range probe of the
+// URL, or to the GET request of its recheck, is a success.
+func isProbeSuccess(ctx *URLContext, status int) bool {
+	if !ctx.isRangeProbe() {
+		return status >= 200 && status < 300
+	}
+	switch status {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		return true
+	}
+	return false
+}
+
+// Return the total size of the resource per the Content-Range header of the
+// response (e.g. "bytes 0-0/1234" or "bytes */0"), or its Content-Length for
+// a 200 response. Returns -1 if it is unknown.
+func rangeTotalSize(res *http.Response) int64 {
+	if res.StatusCode == http.StatusOK {
+		return res.ContentLength
+	}
+	cr := res.Header.Get("Content-Range")
+	i := strings.LastIndexByte(cr, '/')
+	if !strings.HasPrefix(cr, "bytes ") || i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(cr[i+1:], 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,142 @@
+package gocrawl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type rangeProbeExtender struct {
+	DefaultExtender
+	mu      sync.Mutex
+	visits  int
+	visited map[string]*FetchInfo
+	infos   map[string]VisitOutcome
+}
+
+func (x *rangeProbeExtender) Visit(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visits++
+	return nil, false
+}
+
+func (x *rangeProbeExtender) VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.infos[ctx.URL().Path] = info.Outcome
+	x.visited[ctx.URL().Path] = ctx.FetchInfo()
+}
+
+func TestRangeProbe(t *testing.T) {
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Method+" "+r.URL.Path+" "+r.Header.Get("Range"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("Content-Range", "bytes 0-0/1000000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("x"))
+		case "/ignored":
+			// Ignores the Range header
+			w.Header().Set("Content-Length", "1000000")
+			w.Write([]byte(strings.Repeat("x", 1000)))
+		case "/empty":
+			w.Header().Set("Content-Range", "bytes */0")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ext := &rangeProbeExtender{visited: make(map[string]*FetchInfo), infos: make(map[string]VisitOutcome)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.HeadBeforeGet = true
+	var items []EnqueueItem
+	for _, p := range []string{"/big", "/ignored", "/empty", "/missing"} {
+		u, err := url.Parse(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, EnqueueItem{URL: u, FetchMode: FetchRangeProbe})
+	}
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run(items); err != nil {
+		t.Fatal(err)
+	}
+
+	// No HEAD request, despite the HeadBeforeGet option
+	for _, r := range ranges {
+		if !strings.HasPrefix(r, "GET ") || !strings.HasSuffix(r, " bytes=0-0") {
+			t.Errorf("expected a GET request of the first byte, got %q", r)
+		}
+	}
+	if ext.visits != 0 {
+		t.Errorf("expected no visit, got %d", ext.visits)
+	}
+	cases := []struct {
+		path    string
+		outcome VisitOutcome
+		status  int
+		total   int64
+	}{
+		{"/big", VisitRangeProbe, http.StatusPartialContent, 1000000},
+		{"/ignored", VisitRangeProbe, http.StatusOK, 1000000},
+		{"/empty", VisitRangeProbe, http.StatusRequestedRangeNotSatisfiable, 0},
+		{"/missing", VisitStatusError, http.StatusNotFound, -1},
+	}
+	for _, c := range cases {
+		if o := ext.infos[c.path]; o != c.outcome {
+			t.Errorf("%s: expected outcome %s, got %s", c.path, c.outcome, o)
+		}
+		fi := ext.visited[c.path]
+		if fi == nil {
+			t.Errorf("%s: expected fetch info", c.path)
+			continue
+		}
+		if fi.StatusCode != c.status || fi.TotalSize != c.total {
+			t.Errorf("%s: expected status %d and total size %d, got %d and %d", c.path, c.status, c.total, fi.StatusCode, fi.TotalSize)
+		}
+		// The body is not read
+		if c.outcome == VisitRangeProbe && fi.Size != 0 {
+			t.Errorf("%s: expected no body read, got %d bytes", c.path, fi.Size)
+		}
+	}
+}
+
+func TestRangeTotalSize(t *testing.T) {
+	cases := []struct {
+		status int
+		header string
+		want   int64
+	}{
+		{http.StatusPartialContent, "bytes 0-0/1234", 1234},
+		{http.StatusPartialContent, "bytes 0-0/*", -1},
+		{http.StatusPartialContent, "", -1},
+		{http.StatusRequestedRangeNotSatisfiable, "bytes */0", 0},
+		{http.StatusRequestedRangeNotSatisfiable, "items */0", -1},
+	}
+	for _, c := range cases {
+		res := &http.Response{StatusCode: c.status, Header: http.Header{}}
+		if c.header != "" {
+			res.Header.Set("Content-Range", c.header)
+		}
+		if got := rangeTotalSize(res); got != c.want {
+			t.Errorf("%d %q: expected %d, got %d", c.status, c.header, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package gocrawl
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiter limits the rate of the requests made by the crawler. Its Wait
+// method is called by the workers before each request (including robots.txt
+// and HEAD requests), and must block until the request can be made. It must
+// return an error if the context is cancelled before that, in which case the
+// request is not made. It is called concurrently by the workers, so it must be
+// safe for concurrent use. The same RateLimiter may be shared by multiple
+// Crawler instances.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NewRateLimiter returns a RateLimiter that allows at most rps requests per
+// second. The requests are spaced evenly and granted in the order in which
+// Wait is called, so that a given host cannot starve the others.
+func NewRateLimiter(rps float64) RateLimiter {
+	return &tokenBucket{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// The token bucket of the default RateLimiter. It holds a single token, that
+// is refilled every interval.
+type tokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Wait blocks until a token is available, or the context is cancelled.
+func (tb *tokenBucket) Wait(ctx context.Context) error {
+	// Reserve the next token, so that the waiters are served in order
+	tb.mu.Lock()
+	now := time.Now()
+	at := tb.next
+	if at.Before(now) {
+		at = now
+	}
+	tb.next = at.Add(tb.interval)
+	tb.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// byteThrottle limits the aggregate rate at which the response bodies are
+// read, across all hosts. It also measures the resulting throughput.
+type byteThrottle struct {
+	mu    sync.Mutex
+	rate  float64 // bytes per second
+	next  time.Time
+	start time.Time
+	total int64
+}
+
+func newByteThrottle(bps int64) *byteThrottle {
+	return &byteThrottle{rate: float64(bps), start: time.Now()}
+}
+
+// Reserve the time needed to read n bytes, and return the time at which the
+// read is paid for.
+func (bt *byteThrottle) reserve(n int) time.Time {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	now := time.Now()
+	if bt.next.Before(now) {
+		bt.next = now
+	}
+	bt.next = bt.next.Add(time.Duration(float64(n) / bt.rate * float64(time.Second)))
+	bt.total += int64(n)
+	return bt.next
+}
+
+// Return the total number of bytes read and the throughput since the
+// creation of the throttle.
+func (bt *byteThrottle) throughput() (int64, float64) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.total, float64(bt.total) / time.Now().Sub(bt.start).Seconds()
+}
+
+// Wrap the body so that it is read through the throttle. The waits are
+// interrupted when the stop channel is closed.
+func (bt *byteThrottle) wrap(body io.ReadCloser, stop <-chan struct{}) io.ReadCloser {
+	return &throttledReader{body, bt, stop}
+}
+
+// throttledReader reads a response body through the byteThrottle.
+type throttledReader struct {
+	io.ReadCloser
+	bt   *byteThrottle
+	stop <-chan struct{}
+}
+
+// Read reads at most a tenth of a second's worth of bytes, and waits until
+// they are paid for.
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if max := int(tr.bt.rate / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	} else if max == 0 && len(p) > 1 {
+		p = p[:1]
+	}
+	n, err := tr.ReadCloser.Read(p)
+	if n > 0 {
+		if d := tr.bt.reserve(n).Sub(time.Now()); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-tr.stop:
+				t.Stop()
+			}
+		}
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read from a response body in a counter
+// shared by all workers, if any, in the counter of its host, if any, and in
+// the size of its fetch.
+type countingReader struct {
+	io.ReadCloser
+	n    *int64
+	host *int64
+	size *int64
+}
+
+// Read reads from the body and adds the number of bytes read to the counters.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	if n > 0 {
+		if cr.n != nil {
+			atomic.AddInt64(cr.n, int64(n))
+		}
+		if cr.host != nil {
+			atomic.AddInt64(cr.host, int64(n))
+		}
+		*cr.size += int64(n)
+	}
+	return n, err
+}
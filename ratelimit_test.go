@@ -0,0 +1,49 @@
+package gocrawl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestByteThrottle(t *testing.T) {
+	const rate = 10000
+
+	bt := newByteThrottle(rate)
+	stop := make(chan struct{})
+	start := time.Now()
+	var wg sync.WaitGroup
+	sizes := make([]int, 3)
+	for i := range sizes {
+		// The bodies read concurrently share the same throttle
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := bt.wrap(ioutil.NopCloser(bytes.NewReader(make([]byte, 1000))), stop)
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Error(err)
+			}
+			sizes[i] = len(b)
+		}(i)
+	}
+	wg.Wait()
+	for i, n := range sizes {
+		if n != 1000 {
+			t.Errorf("%d: expected 1000 bytes, got %d", i, n)
+		}
+	}
+
+	n, bps := bt.throughput()
+	if n != 3000 {
+		t.Errorf("expected 3000 bytes read, got %d", n)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < 300*time.Millisecond {
+		t.Errorf("expected reads to take at least 300ms, got %v", elapsed)
+	}
+	if bps > rate {
+		t.Errorf("expected throughput of at most %d bytes/s, got %.0f", rate, bps)
+	}
+}
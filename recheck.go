@@ -0,0 +1,63 @@
+package gocrawl
+
+import (
+	"net/http"
+)
+
+// RecheckFallback is the request used to recheck a visited URL when its
+// server rejects the HEAD request, with the RecheckVisited option.
+type RecheckFallback uint8
+
+// The various recheck fallbacks.
+const (
+	// RecheckNoFallback keeps the response to the HEAD request, the URL is
+	// notified with its status code error.
+	RecheckNoFallback RecheckFallback = iota
+
+	// RecheckRangeProbe requests the URL again with a range probe, as with
+	// the FetchRangeProbe fetch mode.
+	RecheckRangeProbe
+
+	// RecheckGet requests the URL again with a GET request whose body is
+	// not read, the response being aborted right after its headers.
+	RecheckGet
+)
+
+// Mark the URL approved by the Filter as a recheck, if it is visited and the
+// RecheckVisited option is set: it is requested with HEAD only.
+func (c *Crawler) markRecheck(ctx *URLContext, isVisited bool) {
+	if isVisited && c.opts().RecheckVisited {
+		ctx.recheck = true
+		ctx.HeadOnly = true
+	}
+}
+
+// IsRecheck indicates if the URL is a visited URL that is only rechecked,
+// with the RecheckVisited option.
+func (uc *URLContext) IsRecheck() bool {
+	return uc.recheck
+}
+
+// Indicates if the body of the response to the GET request of the URL is
+// never read, for a range probe or a recheck.
+func (uc *URLContext) skipsBody() bool {
+	return uc.isRangeProbe() || uc.recheck
+}
+
+// Prepare the URL to be requested again with the RecheckFallback if the
+// server rejected the HEAD request of its recheck. Returns false if it is
+// not requested again.
+func (w *worker) recheckFallback(ctx *URLContext, res *http.Response) bool {
+	if !ctx.recheck || !ctx.fetchInfo.IsHeadRequest || w.opts.RecheckFallback == RecheckNoFallback {
+		return false
+	}
+	if res.StatusCode != http.StatusMethodNotAllowed && res.StatusCode != http.StatusNotImplemented {
+		return false
+	}
+	w.logFunc(LogTrace, "HEAD rejected (%s), recheck fallback: %s", res.Status, ctx.url)
+	ctx.HeadOnly = false
+	if w.opts.RecheckFallback == RecheckRangeProbe {
+		ctx.FetchMode = FetchRangeProbe
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type recheckExtender struct {
+	DefaultExtender
+	mu       sync.Mutex
+	visits   []string
+	outcomes map[string]VisitOutcome
+	rechecks map[string]bool
+}
+
+func (x *recheckExtender) Filter(ctx *URLContext, isVisited bool) bool {
+	return true
+}
+
+func (x *recheckExtender) Visit(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visits = append(x.visits, ctx.URL().Path)
+	return nil, true
+}
+
+func (x *recheckExtender) VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.outcomes[ctx.URL().Path] = info.Outcome
+	x.rechecks[ctx.URL().Path] = ctx.IsRecheck()
+}
+
+func TestRecheckVisited(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+r.Header.Get("Range")))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/old">old</a><a href="/nohead">nohead</a><a href="/gone">gone</a><a href="/new">new</a>`)
+		case "/nohead":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		fallback RecheckFallback
+		outcome  VisitOutcome
+		nohead   []string
+	}{
+		{RecheckNoFallback, VisitStatusError, []string{"HEAD /nohead"}},
+		{RecheckRangeProbe, VisitRangeProbe, []string{"HEAD /nohead", "GET /nohead bytes=0-0"}},
+		{RecheckGet, VisitRechecked, []string{"HEAD /nohead", "GET /nohead"}},
+	}
+	for _, c := range cases {
+		requests = nil
+		ext := &recheckExtender{outcomes: make(map[string]VisitOutcome), rechecks: make(map[string]bool)}
+		opts := NewOptions(ext)
+		opts.CrawlDelay = 0
+		opts.RecheckVisited = true
+		opts.RecheckFallback = c.fallback
+		cr := NewCrawlerWithOptions(opts)
+		visited := fmt.Sprintf("%[1]s/old\n%[1]s/nohead\n%[1]s/gone\n", srv.URL)
+		if err := cr.ImportVisited(strings.NewReader(visited)); err != nil {
+			t.Fatal(err)
+		}
+		if err := cr.Run(srv.URL + "/"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the new URLs are visited
+		if got := strings.Join(ext.visits, ","); got != "/,/new" {
+			t.Errorf("%d: expected visits of / and /new, got %s", c.fallback, got)
+		}
+		want := map[string]VisitOutcome{
+			"/":       VisitDone,
+			"/new":    VisitDone,
+			"/old":    VisitHeadOnly,
+			"/gone":   VisitStatusError,
+			"/nohead": c.outcome,
+		}
+		for p, o := range want {
+			if got := ext.outcomes[p]; got != o {
+				t.Errorf("%d: %s: expected outcome %s, got %s", c.fallback, p, o, got)
+			}
+			if rechecked := p != "/" && p != "/new"; ext.rechecks[p] != rechecked {
+				t.Errorf("%d: %s: expected recheck %v", c.fallback, p, rechecked)
+			}
+		}
+		var nohead []string
+		for _, r := range requests {
+			if strings.HasSuffix(r, "/old") || strings.HasSuffix(r, "/gone") {
+				if !strings.HasPrefix(r, "HEAD ") {
+					t.Errorf("%d: expected a HEAD request only, got %s", c.fallback, r)
+				}
+			}
+			if strings.Contains(r, "/nohead") {
+				nohead = append(nohead, r)
+			}
+		}
+		if strings.Join(nohead, ",") != strings.Join(c.nohead, ",") {
+			t.Errorf("%d: expected requests %v, got %v", c.fallback, c.nohead, nohead)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectChain returns the chain of redirections that led to the URL, in
+// normalized form, from the first URL that was redirected to the URL that
+// redirected to this one. It is empty if the URL was not reached by a
+// redirection. For an URL whose redirection closes a loop (see
+// IsRedirectLoop), the chain ends with the URL and the target of the loop.
+func (uc *URLContext) RedirectChain() []*url.URL {
+	return uc.redirectChain
+}
+
+// IsRedirectLoop indicates if the redirection of the URL closes a redirect
+// loop, i.e. it redirects to itself or to an URL of its RedirectChain. The
+// redirection is not followed, and a CrawlError of kind CekRedirectLoop is
+// notified.
+func (uc *URLContext) IsRedirectLoop() bool {
+	return uc.redirectLoop
+}
+
+// Return the chain of the redirected URL context, the chain of the URL that
+// redirects to it followed by this URL.
+func (uc *URLContext) nextRedirectChain() []*url.URL {
+	chain := make([]*url.URL, 0, len(uc.redirectChain)+1)
+	return append(append(chain, uc.redirectChain...), uc.normalizedURL)
+}
+
+// Indicates if the URL is already in its redirect chain, so that its
+// redirection closes a loop.
+func (uc *URLContext) closesRedirectLoop() bool {
+	u := uc.normalizedURL.String()
+	for _, r := range uc.redirectChain {
+		if r.String() == u {
+			return true
+		}
+	}
+	return false
+}
+
+// Indicates if the redirection of the URL to dst only changes its trailing
+// slash, which the TrailingSlashPolicy normalizes to the same URL, e.g. a
+// server that redirects "/docs/" to "/docs" with SlashAdd. The form of the
+// server is then requested in place of the URL, instead of a redirect loop.
+func (w *worker) isSlashRedirect(ctx, rCtx *URLContext, dst *url.URL) bool {
+	return w.opts.TrailingSlashPolicy != SlashKeep && dst.String() != ctx.url.String() &&
+		rCtx.normalizedURL.String() == ctx.normalizedURL.String()
+}
+
+// Flag the URL whose redirection to the redirected URL context closes a loop,
+// and notify the loop.
+func (w *worker) redirectLoop(ctx, rCtx *URLContext) {
+	ctx.redirectLoop = true
+	ctx.redirectChain = append(rCtx.redirectChain, rCtx.normalizedURL)
+	e := fmt.Errorf("%w: %s", ErrRedirectLoop, formatRedirectChain(ctx.redirectChain))
+	w.notifyError(newCrawlError(ctx, e, CekRedirectLoop))
+	w.logFunc(LogError, "ERROR redirect loop for %s: %s", ctx.url, e)
+}
+
+// Return the chain of URLs separated by arrows.
+func formatRedirectChain(chain []*url.URL) string {
+	s := make([]string, len(chain))
+	for i, u := range chain {
+		s[i] = u.String()
+	}
+	return strings.Join(s, " -> ")
+}
+
+// Return the error of the redirection of the request if it closes a loop in
+// the chain of the requests followed by the client, nil otherwise.
+func checkRedirectLoop(req *http.Request, via []*http.Request) error {
+	u := req.URL.String()
+	for i, r := range via {
+		if r.URL.String() != u {
+			continue
+		}
+		chain := make([]*url.URL, 0, len(via)-i+1)
+		for _, r := range via[i:] {
+			chain = append(chain, r.URL)
+		}
+		return fmt.Errorf("%w: %s", ErrRedirectLoop, formatRedirectChain(append(chain, req.URL)))
+	}
+	return nil
+}
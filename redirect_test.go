@@ -0,0 +1,115 @@
+package gocrawl
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type redirectLoopExtender struct {
+	DefaultExtender
+	mu       sync.Mutex
+	errors   []*CrawlError
+	outcomes map[string]VisitOutcome
+}
+
+func (x *redirectLoopExtender) Error(err *CrawlError) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.errors = append(x.errors, err)
+}
+
+func (x *redirectLoopExtender) VisitedInfo(ctx *URLContext, harvested interface{}, info *VisitInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.outcomes[ctx.URL().Path] = info.Outcome
+}
+
+func TestRedirectLoops(t *testing.T) {
+	redirects := map[string]string{
+		"/a":    "/b",
+		"/b":    "/a",
+		"/c":    "/d",
+		"/d":    "/e",
+		"/e":    "/c",
+		"/self": "/self",
+		"/f":    "/g",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to, ok := redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	ext := &redirectLoopExtender{outcomes: make(map[string]VisitOutcome)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run([]string{srv.URL + "/a", srv.URL + "/c", srv.URL + "/self", srv.URL + "/f"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exactly one error per loop
+	var loops []string
+	for _, err := range ext.errors {
+		if err.Kind != CekRedirectLoop {
+			t.Errorf("unexpected error %v", err)
+			continue
+		}
+		if !errors.Is(err.Err, ErrRedirectLoop) || !err.Ctx.IsRedirectLoop() {
+			t.Errorf("expected a redirect loop error and context, got %v", err)
+		}
+		loops = append(loops, strings.Replace(err.Err.Error(), srv.URL, "", -1))
+	}
+	want := map[string]bool{
+		"redirect loop: /a -> /b -> /a":       true,
+		"redirect loop: /c -> /d -> /e -> /c": true,
+		"redirect loop: /self -> /self":       true,
+	}
+	if len(loops) != len(want) {
+		t.Errorf("expected %d loops, got %v", len(want), loops)
+	}
+	for _, l := range loops {
+		if !want[l] {
+			t.Errorf("unexpected loop %q", l)
+		}
+	}
+
+	for p, o := range map[string]VisitOutcome{
+		"/a":    VisitRedirected,
+		"/b":    VisitRedirectLoop,
+		"/e":    VisitRedirectLoop,
+		"/self": VisitRedirectLoop,
+		"/f":    VisitRedirected,
+		"/g":    VisitDone,
+	} {
+		if got := ext.outcomes[p]; got != o {
+			t.Errorf("%s: expected outcome %s, got %s", p, o, got)
+		}
+	}
+}
+
+func TestCheckRedirectLoop(t *testing.T) {
+	req := func(s string) *http.Request {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Request{URL: u}
+	}
+	via := []*http.Request{req("http://a/robots.txt"), req("https://a/robots.txt")}
+	if err := checkRedirectLoop(req("https://b/robots.txt"), via); err != nil {
+		t.Errorf("expected no loop, got %v", err)
+	}
+	err := checkRedirectLoop(req("http://a/robots.txt"), via)
+	if !errors.Is(err, ErrRedirectLoop) || err.Error() != "redirect loop: http://a/robots.txt -> https://a/robots.txt -> http://a/robots.txt" {
+		t.Errorf("expected a redirect loop, got %v", err)
+	}
+}
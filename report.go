@@ -0,0 +1,234 @@
+package gocrawl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ReportFormat is the format of the crawl report written to the Options'
+// ReportWriter.
+type ReportFormat uint8
+
+// The various crawl report formats.
+const (
+	// ReportJSONLines writes a JSON object per line for each record, the
+	// empty fields being omitted.
+	ReportJSONLines ReportFormat = iota
+
+	// ReportCSV writes a CSV header line followed by a line for each record,
+	// with all the fields.
+	ReportCSV
+)
+
+var lookupReportFormat = [...]string{
+	ReportJSONLines: "json lines",
+	ReportCSV:       "csv",
+}
+
+func (f ReportFormat) String() string {
+	return lookupReportFormat[f]
+}
+
+// The types of the records of the crawl report.
+const (
+	reportVisit      = "visit"
+	reportRobots     = "robots"
+	reportDisallowed = "disallowed"
+	reportFiltered   = "filtered"
+	reportSkipped    = "skipped"
+)
+
+// A record of the crawl report, for an URL fetched, rejected or skipped.
+type reportRecord struct {
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	Source      string `json:"source,omitempty"`
+	Depth       int    `json:"depth"`
+	Status      int    `json:"status,omitempty"`
+	Outcome     string `json:"outcome,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+	DurationMs  int64  `json:"durationMs,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Harvested   int    `json:"harvested,omitempty"`
+
+	// The tags of the URL, encoded as a query string in the CSV report
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// The header of the CSV report, in the order of the fields of the records.
+var reportCSVHeader = []string{"type", "url", "source", "depth", "status", "outcome", "reason",
+	"detail", "durationMs", "contentType", "size", "error", "harvested", "tags"}
+
+func (r *reportRecord) csv() []string {
+	return []string{r.Type, r.URL, r.Source, strconv.Itoa(r.Depth), strconv.Itoa(r.Status), r.Outcome,
+		r.Reason, r.Detail, strconv.FormatInt(r.DurationMs, 10), r.ContentType,
+		strconv.FormatInt(r.Size, 10), r.Error, strconv.Itoa(r.Harvested), encodeTags(r.Tags)}
+}
+
+// The reporter writes the records of the crawl report as the URLs are
+// processed, so that they are not kept in memory. It is shared by the
+// crawler and the workers, so it is safe for concurrent use. A nil reporter
+// writes nothing. Once a write fails, the following records are dropped and
+// the error is returned by close.
+type reporter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	csv    *csv.Writer
+	header bool
+	err    error
+}
+
+func newReporter(w io.Writer, format ReportFormat) *reporter {
+	r := new(reporter)
+	if format == ReportCSV {
+		r.csv = csv.NewWriter(w)
+	} else {
+		r.enc = json.NewEncoder(w)
+		r.enc.SetEscapeHTML(false)
+	}
+	return r
+}
+
+// Write the record, and the CSV header before the first one.
+func (r *reporter) write(rec *reportRecord) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.csv == nil {
+		r.err = r.enc.Encode(rec)
+		return
+	}
+	r.writeHeader()
+	if r.err == nil {
+		r.err = r.csv.Write(rec.csv())
+	}
+}
+
+// Write the CSV header, if not done yet. The lock must be held.
+func (r *reporter) writeHeader() {
+	if !r.header {
+		r.header = true
+		r.err = r.csv.Write(reportCSVHeader)
+	}
+}
+
+// Complete the report, writing the buffered CSV lines (and the header of an
+// empty report), and return the first write error, if any.
+func (r *reporter) close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.csv != nil && r.err == nil {
+		r.writeHeader()
+		r.csv.Flush()
+		if r.err == nil {
+			r.err = r.csv.Error()
+		}
+	}
+	return r.err
+}
+
+// Return a new record of the URL, with the fields of its context.
+func newReportRecord(typ string, ctx *URLContext) *reportRecord {
+	rec := &reportRecord{Type: typ, URL: ctx.url.String(), Depth: ctx.depth, Tags: ctx.tags}
+	if ctx.sourceURL != nil {
+		rec.Source = ctx.sourceURL.String()
+	}
+	return rec
+}
+
+// Set the fields of the response and of the fetch of the URL.
+func (rec *reportRecord) setFetch(ctx *URLContext, res *http.Response) {
+	if res != nil {
+		rec.Status = res.StatusCode
+		rec.ContentType = res.Header.Get("Content-Type")
+	}
+	if fi := ctx.fetchInfo; fi != nil {
+		rec.DurationMs = fi.Duration.Milliseconds()
+		rec.Size = fi.Size
+	}
+}
+
+// Return the kind of error of the visit outcome, if it is an error.
+func visitOutcomeError(outcome VisitOutcome) string {
+	switch outcome {
+	case VisitFetchError:
+		return CekFetch.String()
+	case VisitStatusError:
+		return CekHttpStatusCode.String()
+	case VisitPanicked:
+		return CekExtenderPanic.String()
+	case VisitRedirectLoop:
+		return CekRedirectLoop.String()
+	case VisitTimedOut:
+		return CekVisitTimeout.String()
+	}
+	return ""
+}
+
+// Report the outcome of the fetched URL.
+func (r *reporter) visit(ctx *URLContext, res *http.Response, harvested interface{}, info *VisitInfo) {
+	if r == nil {
+		return
+	}
+	rec := newReportRecord(reportVisit, ctx)
+	rec.setFetch(ctx, res)
+	rec.Outcome = info.Outcome.String()
+	rec.Error = visitOutcomeError(info.Outcome)
+	rec.Harvested = countHarvested(harvested)
+	r.write(rec)
+}
+
+// Report a request of the robots.txt URL, with the kind of its error if it
+// failed or got a server error status code, empty otherwise.
+func (r *reporter) robots(ctx *URLContext, res *http.Response, errKind string) {
+	if r == nil {
+		return
+	}
+	rec := newReportRecord(reportRobots, ctx)
+	rec.setFetch(ctx, res)
+	rec.Error = errKind
+	r.write(rec)
+}
+
+// Report the URL rejected by a policy.
+func (r *reporter) disallowed(ctx *URLContext, kind DisallowedKind, detail string) {
+	if r == nil {
+		return
+	}
+	rec := newReportRecord(reportDisallowed, ctx)
+	rec.Reason, rec.Detail = kind.String(), detail
+	r.write(rec)
+}
+
+// Report the URL rejected by the Filter extender method.
+func (r *reporter) filtered(ctx *URLContext) {
+	if r == nil {
+		return
+	}
+	r.write(newReportRecord(reportFiltered, ctx))
+}
+
+// Report the URL skipped without a request, with the kind of error notified.
+func (r *reporter) skipped(ctx *URLContext, kind CrawlErrorKind) {
+	if r == nil {
+		return
+	}
+	rec := newReportRecord(reportSkipped, ctx)
+	rec.Error = kind.String()
+	r.write(rec)
+}
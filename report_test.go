@@ -0,0 +1,79 @@
+package gocrawl
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	cases := []struct {
+		format ReportFormat
+		file   string
+	}{
+		{ReportJSONLines, "robotb.jsonl"},
+		{ReportCSV, "robotb.csv"},
+	}
+	for _, c := range cases {
+		want, err := ioutil.ReadFile(filepath.Join("testdata", "report", c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		spy := newSpy(newFileFetcher(), true)
+		opts := NewOptions(spy)
+		opts.Deterministic = true
+		opts.CrawlDelay = 0
+		opts.LogFlags = LogAll
+		opts.ReportWriter = &buf
+		opts.ReportFormat = c.format
+		// The auto clock makes the fetches take no time, so that the
+		// durations of the report are stable
+		opts.Clock = newFakeClock(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true)
+		if err := NewCrawlerWithOptions(opts).Run("http://robotb/page1.html"); err != nil {
+			t.Fatalf("%s: %v", c.format, err)
+		}
+		if got := buf.String(); got != string(want) {
+			t.Errorf("%s: want report\n%s\ngot\n%s", c.format, want, got)
+		}
+	}
+}
+
+type failWriter struct {
+	n int
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("disk full")
+}
+
+func TestReportWriteError(t *testing.T) {
+	w := new(failWriter)
+	r := newReporter(w, ReportJSONLines)
+	u, err := url.Parse("http://hosta/page1.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &URLContext{url: u, normalizedURL: u}
+	r.filtered(ctx)
+	r.filtered(ctx)
+	if err := r.close(); err == nil || err.Error() != "disk full" {
+		t.Errorf("want the write error returned by close, got %v", err)
+	}
+	if w.n != 1 {
+		t.Errorf("want the records dropped after the write error, got %d writes", w.n)
+	}
+
+	var buf bytes.Buffer
+	if err := newReporter(&buf, ReportCSV).close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "type,url,source,depth,status,outcome,reason,detail,durationMs,contentType,size,error,harvested,tags\n"; got != want {
+		t.Errorf("want the header of an empty CSV report, got %q", got)
+	}
+}
@@ -0,0 +1,486 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	robotstxt "github.com/temoto/robotstxt.go"
+)
+
+// The robots.txt that disallows all URLs, used when the robots.txt cannot be
+// fetched, based on the RobotsErrorPolicy.
+const disallowAllRobots = "User-agent: *\nDisallow: /"
+
+var (
+	// The robots.txt groups that allow and disallow all URLs.
+	allowAllGroup    = mustRobotsGroup("")
+	disallowAllGroup = mustRobotsGroup(disallowAllRobots)
+)
+
+func mustRobotsGroup(s string) *robotstxt.Group {
+	data, e := robotstxt.FromString(s)
+	if e != nil {
+		panic(e)
+	}
+	return data.FindGroup("*")
+}
+
+// robotsGroups holds the robots.txt group of each host, along with its rules
+// if they need the RFC 9309 precedence, and the hosts that do not support the
+// HEAD requests. It is shared by the workers and the crawler, so it is safe
+// for concurrent use.
+type robotsGroups struct {
+	mu     sync.RWMutex
+	groups map[string]*robotstxt.Group
+	rules  map[string][]robotsRule
+	noHead map[string]bool
+}
+
+func newRobotsGroups() *robotsGroups {
+	return &robotsGroups{
+		groups: make(map[string]*robotstxt.Group),
+		rules:  make(map[string][]robotsRule),
+		noHead: make(map[string]bool),
+	}
+}
+
+// Set the robots.txt group of the host, and its rules if they need the RFC
+// 9309 precedence (see setRobotsBody). A nil group allows all URLs.
+func (rg *robotsGroups) set(host string, g *robotstxt.Group, rules []robotsRule) {
+	if g == nil {
+		g = allowAllGroup
+	}
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.groups[host] = g
+	if rules != nil {
+		rg.rules[host] = rules
+	} else {
+		delete(rg.rules, host)
+	}
+}
+
+// Indicates if the path is allowed by the robots.txt of the host, as decided
+// by its worker, and whether the robots.txt of the host has been processed.
+func (rg *robotsGroups) allowed(host, path string) (bool, bool) {
+	rg.mu.RLock()
+	defer rg.mu.RUnlock()
+	g, ok := rg.groups[host]
+	if !ok {
+		return true, false
+	}
+	return robotsAllow(g, rg.rules[host], path), true
+}
+
+// Get the robots.txt group of the host, if any.
+func (rg *robotsGroups) get(host string) (*robotstxt.Group, bool) {
+	rg.mu.RLock()
+	defer rg.mu.RUnlock()
+	g, ok := rg.groups[host]
+	return g, ok
+}
+
+// Remember that the host rejects the HEAD requests, for the rest of the
+// crawl.
+func (rg *robotsGroups) setHeadUnsupported(host string) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.noHead[host] = true
+}
+
+// Indicates if the host rejects the HEAD requests. A nil robotsGroups knows
+// no such host.
+func (rg *robotsGroups) headUnsupported(host string) bool {
+	if rg == nil {
+		return false
+	}
+	rg.mu.RLock()
+	defer rg.mu.RUnlock()
+	return rg.noHead[host]
+}
+
+// A rule of a robots.txt group.
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// String returns the rule as written in the robots.txt.
+func (r robotsRule) String() string {
+	if r.allow {
+		return "Allow: " + r.pattern
+	}
+	return "Disallow: " + r.pattern
+}
+
+// Test if the rule's pattern matches the path, supporting the "*" and "$"
+// wildcards.
+func (r robotsRule) match(path string) bool {
+	if !strings.ContainsAny(r.pattern, "*$") {
+		return strings.HasPrefix(path, r.pattern)
+	}
+	rx := regexp.QuoteMeta(r.pattern)
+	rx = strings.Replace(rx, `\*`, `.*`, -1)
+	if strings.HasSuffix(rx, `\$`) {
+		rx = rx[:len(rx)-2] + "$"
+	}
+	ok, _ := regexp.MatchString("^"+rx, path)
+	return ok
+}
+
+// RobotsInfo is the interpretation of a robots.txt by the crawler, passed to
+// the RobotsParsed method of a RobotsInfoExtender.
+type RobotsInfo struct {
+	// Body is the content of the robots.txt.
+	Body []byte
+
+	// Agent is the user-agent of the group that applies to the
+	// RobotUserAgent, i.e. the group enforced by the crawler, in lowercase,
+	// "*" for the default group, or empty if no group applies (i.e. all URLs
+	// are allowed).
+	Agent string
+
+	// Token is the product token of the RobotUserAgent, in lowercase (e.g.
+	// "acmebot" for "AcmeBot/2.1 (+https://acme.example/bot)"), and Match
+	// tells how the group was selected with it.
+	Token string
+	Match RobotsMatch
+
+	// Rules are the rules of the group, in order (e.g.
+	// "Disallow: /private/").
+	Rules []string
+
+	// CrawlDelay is the crawl delay of the group, as enforced by the crawler,
+	// zero if it has none.
+	CrawlDelay time.Duration
+
+	// Sitemaps are the URLs of the Sitemap lines, as written.
+	Sitemaps []string
+
+	// Warnings are the anomalies found while parsing the robots.txt. They
+	// do not prevent it from being applied.
+	Warnings []RobotsWarning
+}
+
+// RobotsMatch indicates how the group of a robots.txt that applies to the
+// RobotUserAgent was selected.
+type RobotsMatch uint8
+
+// The various group selections, by order of precedence.
+const (
+	// RobotsMatchNone means no group applies, all URLs are allowed.
+	RobotsMatchNone RobotsMatch = iota
+
+	// RobotsMatchExact means the product token of the group's user-agent is
+	// the product token of the RobotUserAgent, ignoring the case, per RFC
+	// 9309.
+	RobotsMatchExact
+
+	// RobotsMatchPrefix means the group's user-agent is the longest prefix of
+	// the RobotUserAgent, ignoring the case, e.g. "acme" for "AcmeBot/2.1".
+	RobotsMatchPrefix
+
+	// RobotsMatchDefault means the default group ("User-agent: *") applies.
+	RobotsMatchDefault
+)
+
+var lookupRobotsMatch = [...]string{
+	RobotsMatchNone:    "none",
+	RobotsMatchExact:   "exact",
+	RobotsMatchPrefix:  "prefix",
+	RobotsMatchDefault: "default",
+}
+
+func (m RobotsMatch) String() string {
+	return lookupRobotsMatch[m]
+}
+
+// Return the product token of the user-agent, in lowercase: its leading
+// letters, underscores and hyphens (e.g. "acmebot" for "AcmeBot/2.1").
+func robotsProductToken(agent string) string {
+	agent = strings.ToLower(strings.TrimSpace(agent))
+	for i, r := range agent {
+		if (r < 'a' || r > 'z') && r != '_' && r != '-' {
+			return agent[:i]
+		}
+	}
+	return agent
+}
+
+// RobotsWarning is a non-fatal anomaly of a robots.txt, e.g. an unknown
+// directive or a rule before any User-agent line.
+type RobotsWarning struct {
+	// Line is the number of the line of the anomaly, starting at 1, or 0 if
+	// it relates to the whole file.
+	Line int
+
+	// Message describes the anomaly.
+	Message string
+}
+
+// String returns the warning prefixed by its line number, if any.
+func (w RobotsWarning) String() string {
+	if w.Line == 0 {
+		return w.Message
+	}
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+}
+
+// RobotsInfoExtender is an optional interface that an Extender can
+// implement to get the interpretation of each robots.txt received, whether
+// fetched or returned by the RequestRobots extender method, e.g. to report
+// the broken robots.txt files to their owners. It is observability only, the
+// robots.txt is applied the same way. RobotsParsed is called from the
+// worker's goroutine, after the FetchedRobots extender method.
+type RobotsInfoExtender interface {
+	RobotsParsed(ctx *URLContext, info *RobotsInfo)
+}
+
+// The known robots.txt directives, in lowercase.
+var knownRobotsDirectives = map[string]bool{
+	"user-agent":  true,
+	"useragent":   true,
+	"allow":       true,
+	"disallow":    true,
+	"crawl-delay": true,
+	"sitemap":     true,
+	"host":        true,
+}
+
+// The byte-order marks of the UTF-16 and UTF-32 encodings, which are not
+// supported.
+var robotsForeignBOMs = []string{"\x00\x00\xfe\xff", "\xff\xfe\x00\x00", "\xfe\xff", "\xff\xfe"}
+
+// parsedRobots holds the groups of a robots.txt, keyed by their lowercase
+// user-agents, along with its sitemaps and warnings.
+type parsedRobots struct {
+	groups   map[string][]robotsRule
+	delays   map[string]time.Duration
+	sitemaps []string
+	warnings []RobotsWarning
+}
+
+// Parse the body of a robots.txt. The robotstxt package does not expose its
+// rules, so the body is parsed again, using the same group selection and rule
+// precedence (the longest matching pattern wins), keeping track of the
+// anomalies.
+func parseRobots(body []byte) *parsedRobots {
+	pr := &parsedRobots{
+		groups: make(map[string][]robotsRule),
+		delays: make(map[string]time.Duration),
+	}
+	s := string(body)
+	if strings.HasPrefix(s, "\ufeff") {
+		pr.warn(0, "UTF-8 byte-order mark at the start of the file")
+		s = s[len("\ufeff"):]
+	} else {
+		for _, bom := range robotsForeignBOMs {
+			if strings.HasPrefix(s, bom) {
+				pr.warn(0, "UTF-16 or UTF-32 byte-order mark, the file is not UTF-8")
+				break
+			}
+		}
+	}
+
+	var agents []string
+	inAgents := false
+	for n, line := range strings.Split(s, "\n") {
+		n++
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			pr.warn(n, "line without a directive")
+			continue
+		}
+		key, val := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		if !knownRobotsDirectives[key] {
+			pr.warn(n, fmt.Sprintf("unknown directive %q", strings.TrimSpace(line[:i])))
+		}
+		switch key {
+		case "user-agent", "useragent":
+			if val == "" {
+				pr.warn(n, "empty User-agent")
+			}
+			if !inAgents {
+				agents = agents[:0]
+			}
+			a := strings.ToLower(val)
+			agents = append(agents, a)
+			if _, ok := pr.groups[a]; !ok {
+				pr.groups[a] = nil
+			}
+			inAgents = true
+			continue
+		case "allow", "disallow":
+			if len(agents) == 0 {
+				pr.warn(n, "rule before any User-agent line")
+			} else if val != "" {
+				for _, a := range agents {
+					pr.groups[a] = append(pr.groups[a], robotsRule{key == "allow", val})
+				}
+			}
+		case "crawl-delay":
+			secs, e := strconv.ParseFloat(val, 64)
+			if e != nil || secs < 0 {
+				pr.warn(n, fmt.Sprintf("invalid Crawl-delay %q", val))
+			} else if len(agents) == 0 {
+				pr.warn(n, "Crawl-delay before any User-agent line")
+			} else {
+				for _, a := range agents {
+					pr.delays[a] = time.Duration(secs * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			// The value is the rest of the line, with its own colon
+			if u, e := url.Parse(val); e != nil || !u.IsAbs() {
+				pr.warn(n, fmt.Sprintf("invalid Sitemap URL %q", val))
+			} else {
+				pr.sitemaps = append(pr.sitemaps, val)
+			}
+		}
+		inAgents = false
+	}
+	return pr
+}
+
+func (pr *parsedRobots) warn(line int, msg string) {
+	pr.warnings = append(pr.warnings, RobotsWarning{line, msg})
+}
+
+// Select the group of the agent and return its rules, see selectGroup.
+func (pr *parsedRobots) group(agent string) (string, []robotsRule) {
+	name, _ := pr.selectGroup(agent)
+	return name, pr.groups[name]
+}
+
+// Select the group of the agent: the group with the product token of the
+// agent (the one written as the token itself if several have it, else the
+// longest), else the group with the longest user-agent prefix of the agent,
+// else the default one. The returned group user-agent is empty if no group
+// applies.
+func (pr *parsedRobots) selectGroup(agent string) (string, RobotsMatch) {
+	token := robotsProductToken(agent)
+	agent = strings.ToLower(agent)
+	exact, prefix := "", ""
+	for a := range pr.groups {
+		if a == "*" || a == "" {
+			continue
+		}
+		if token != "" && robotsProductToken(a) == token {
+			if exact != token && (a == token || len(a) > len(exact)) {
+				exact = a
+			}
+		} else if strings.HasPrefix(agent, a) && len(a) > len(prefix) {
+			prefix = a
+		}
+	}
+	switch {
+	case exact != "":
+		return exact, RobotsMatchExact
+	case prefix != "":
+		return prefix, RobotsMatchPrefix
+	}
+	if _, ok := pr.groups["*"]; ok {
+		return "*", RobotsMatchDefault
+	}
+	return "", RobotsMatchNone
+}
+
+// Return the interpretation of the robots.txt for the agent. If the group
+// enforced by the crawler is set, its user-agent and crawl delay are
+// reported, rather than those of the group selected by this parser.
+func (pr *parsedRobots) info(body []byte, agent string, g *robotstxt.Group) *RobotsInfo {
+	name, match := pr.selectGroup(agent)
+	delay := pr.delays[name]
+	if g != nil {
+		if a := strings.ToLower(g.Agent); a != name {
+			name, match = a, robotsGroupMatch(a, agent)
+		}
+		delay = g.CrawlDelay
+	}
+	rules := pr.groups[name]
+	info := &RobotsInfo{
+		Body:       body,
+		Agent:      name,
+		Token:      robotsProductToken(agent),
+		Match:      match,
+		CrawlDelay: delay,
+		Sitemaps:   pr.sitemaps,
+		Warnings:   pr.warnings,
+	}
+	for _, r := range rules {
+		info.Rules = append(info.Rules, r.String())
+	}
+	return info
+}
+
+// Return how the group of the user-agent name was selected for the agent.
+func robotsGroupMatch(name, agent string) RobotsMatch {
+	switch name {
+	case "":
+		return RobotsMatchNone
+	case "*":
+		return RobotsMatchDefault
+	}
+	if token := robotsProductToken(agent); token != "" && robotsProductToken(name) == token {
+		return RobotsMatchExact
+	}
+	return RobotsMatchPrefix
+}
+
+// Find the rule of the robots.txt group that disallows the path, or return
+// an empty string if no rule disallows it.
+func findDisallowRule(rules []robotsRule, path string) string {
+	if best := winningRobotsRule(rules, path); best != nil && !best.allow {
+		return best.String()
+	}
+	return ""
+}
+
+// Indicates if the path is allowed by the robots.txt group, or by its rules
+// with the RFC 9309 precedence if they are set.
+func robotsAllow(g *robotstxt.Group, rules []robotsRule, path string) bool {
+	if rules != nil {
+		r := winningRobotsRule(rules, path)
+		return r == nil || r.allow
+	}
+	return g.Test(path)
+}
+
+// Return the rule that applies to the path per RFC 9309: the longest
+// matching pattern wins, and Allow wins the ties. Returns nil if no rule
+// matches, the path is allowed.
+func winningRobotsRule(rules []robotsRule, path string) *robotsRule {
+	var best *robotsRule
+	for i, r := range rules {
+		if !r.match(path) {
+			continue
+		}
+		if best == nil || len(r.pattern) > len(best.pattern) || (len(r.pattern) == len(best.pattern) && r.allow) {
+			best = &rules[i]
+		}
+	}
+	return best
+}
+
+// Indicates if the rules need the RFC 9309 precedence, i.e. they have an
+// Allow rule or a wildcard. The classic rules are tested by the robotstxt
+// package, as they always were.
+func needsRobotsPrecedence(rules []robotsRule) bool {
+	for _, r := range rules {
+		if r.allow || strings.ContainsAny(r.pattern, "*$") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,520 @@
+package gocrawl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	robotstxt "github.com/temoto/robotstxt.go"
+)
+
+// Return the rule of the robots.txt that disallows the path for the agent.
+func disallowRule(body []byte, agent, path string) string {
+	_, rules := parseRobots(body).group(agent)
+	return findDisallowRule(rules, path)
+}
+
+func TestFindDisallowRule(t *testing.T) {
+	const robots = `User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.pdf$
+
+# Specific group
+User-agent: gocrawl
+User-agent: other
+Disallow: /gocrawl/
+Disallow: /tmp
+`
+	cases := []struct {
+		agent string
+		path  string
+		want  string
+	}{
+		{"Googlebot", "/", ""},
+		{"Googlebot", "/private/page.html", "Disallow: /private/"},
+		{"Googlebot", "/private/public/page.html", ""},
+		{"Googlebot", "/docs/file.pdf", "Disallow: /*.pdf$"},
+		{"Googlebot", "/docs/file.pdf.html", ""},
+		{"Googlebot", "/gocrawl/page.html", ""},
+		{"gocrawl v0.4", "/gocrawl/page.html", "Disallow: /gocrawl/"},
+		{"gocrawl v0.4", "/private/page.html", ""},
+		{"Other", "/tmp/page.html", "Disallow: /tmp"},
+	}
+	for i, c := range cases {
+		if got := disallowRule([]byte(robots), c.agent, c.path); got != c.want {
+			t.Errorf("%d: want %q for %s with agent %s, got %q", i, c.want, c.path, c.agent, got)
+		}
+	}
+	if got := disallowRule([]byte(disallowAllRobots), "gocrawl", "/page.html"); got != "Disallow: /" {
+		t.Errorf("want %q for disallow all, got %q", "Disallow: /", got)
+	}
+	if got := disallowRule(nil, "gocrawl", "/page.html"); got != "" {
+		t.Errorf("want no rule without robots.txt, got %q", got)
+	}
+}
+
+func TestRobotsGroupMatch(t *testing.T) {
+	const robots = `User-agent: *
+Disallow: /private/
+
+User-agent: AcmeBot
+Disallow: /acme/
+
+User-agent: acme
+Disallow: /partial/
+
+User-agent: OtherBot/1.0
+Disallow: /other/
+`
+	const noDefault = `User-agent: acmebot-news
+Disallow: /news/
+
+User-agent: otherbot
+Disallow: /
+`
+	cases := []struct {
+		robots string
+		agent  string
+		token  string
+		group  string
+		match  RobotsMatch
+		allow  []string
+		deny   []string
+	}{
+		{robots, "AcmeBot/2.1 (+https://acme.example/bot)", "acmebot", "acmebot", RobotsMatchExact,
+			[]string{"/private/page", "/partial/page"}, []string{"/acme/page"}},
+		{robots, "ACMEBOT", "acmebot", "acmebot", RobotsMatchExact,
+			[]string{"/private/page"}, []string{"/acme/page"}},
+		{robots, "otherbot (+https://other.example)", "otherbot", "otherbot/1.0", RobotsMatchExact,
+			[]string{"/acme/page"}, []string{"/other/page"}},
+		{robots, "AcmeCrawler/1.0", "acmecrawler", "acme", RobotsMatchPrefix,
+			[]string{"/acme/page", "/private/page"}, []string{"/partial/page"}},
+		{robots, "Googlebot/2.1", "googlebot", "*", RobotsMatchDefault,
+			[]string{"/acme/page", "/other/page"}, []string{"/private/page"}},
+		{noDefault, "AcmeBot-News/1.0", "acmebot-news", "acmebot-news", RobotsMatchExact,
+			[]string{"/page"}, []string{"/news/page"}},
+		{noDefault, "AcmeBot/2.1", "acmebot", "", RobotsMatchNone,
+			[]string{"/page", "/news/page"}, nil},
+		{noDefault, "", "", "", RobotsMatchNone,
+			[]string{"/page"}, nil},
+	}
+	for i, c := range cases {
+		pr := parseRobots([]byte(c.robots))
+		if group, match := pr.selectGroup(c.agent); group != c.group || match != c.match {
+			t.Errorf("%d: want group %q (%s) for %q, got %q (%s)", i, c.group, c.match, c.agent, group, match)
+		}
+		info := pr.info([]byte(c.robots), c.agent, nil)
+		if info.Agent != c.group || info.Match != c.match || info.Token != c.token {
+			t.Errorf("%d: want info %q %s %q for %q, got %q %s %q", i, c.group, c.match, c.token, c.agent,
+				info.Agent, info.Match, info.Token)
+		}
+		for _, p := range c.allow {
+			if rule := disallowRule([]byte(c.robots), c.agent, p); rule != "" {
+				t.Errorf("%d: want %s allowed for %q, got %q", i, p, c.agent, rule)
+			}
+		}
+		for _, p := range c.deny {
+			if rule := disallowRule([]byte(c.robots), c.agent, p); rule == "" {
+				t.Errorf("%d: want %s disallowed for %q", i, p, c.agent)
+			}
+		}
+	}
+}
+
+type robotsAgentExtender struct {
+	DefaultExtender
+	mu      sync.Mutex
+	infos   map[string]*RobotsInfo
+	visited map[string]bool
+}
+
+func (x *robotsAgentExtender) RobotsParsed(ctx *URLContext, info *RobotsInfo) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.infos[ctx.url.Host] = info
+}
+
+func (x *robotsAgentExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visited[ctx.url.Host+ctx.url.Path] = true
+}
+
+func TestRobotsPerHostAgent(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /all/\n\nUser-agent: ACMEBOT\nDisallow: /acme/\n")
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/all/page">all</a><a href="/acme/page">acme</a></body></html>`)
+		default:
+			fmt.Fprint(w, "<html><body>ok</body></html>")
+		}
+	})
+	srv1, srv2 := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer srv1.Close()
+	defer srv2.Close()
+	host1, host2 := strings.TrimPrefix(srv1.URL, "http://"), strings.TrimPrefix(srv2.URL, "http://")
+
+	ext := &robotsAgentExtender{infos: make(map[string]*RobotsInfo), visited: make(map[string]bool)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogNone
+	opts.SameHostOnly = true
+	opts.RobotUserAgent = "Googlebot/2.1"
+	opts.PerHost = map[string]HostOptions{host2: {RobotUserAgent: "AcmeBot/2.1 (+https://acme.example/bot)"}}
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run([]string{srv1.URL + "/", srv2.URL + "/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if info := ext.infos[host1]; info == nil || info.Agent != "*" || info.Match != RobotsMatchDefault || info.Token != "googlebot" {
+		t.Errorf("%s: want the default group, got %+v", host1, info)
+	}
+	if info := ext.infos[host2]; info == nil || info.Agent != "acmebot" || info.Match != RobotsMatchExact || info.Token != "acmebot" {
+		t.Errorf("%s: want the acmebot group, got %+v", host2, info)
+	}
+	for u, want := range map[string]bool{
+		host1 + "/all/page":  false,
+		host1 + "/acme/page": true,
+		host2 + "/all/page":  true,
+		host2 + "/acme/page": false,
+	} {
+		if ext.visited[u] != want {
+			t.Errorf("%s: want visited %v, got %v", u, want, ext.visited[u])
+		}
+	}
+}
+
+func TestParseRobotsFixtures(t *testing.T) {
+	cases := []struct {
+		file     string
+		agent    string
+		group    string
+		rules    []string
+		delay    time.Duration
+		sitemaps []string
+		warnings []string
+	}{
+		{"bom.txt", "gocrawl (test)", "*", []string{"Disallow: /private/"}, 0, nil,
+			[]string{"UTF-8 byte-order mark at the start of the file"}},
+		{"utf16.txt", "gocrawl (test)", "", nil, 0, nil,
+			[]string{"UTF-16 or UTF-32 byte-order mark, the file is not UTF-8", "line 1: line without a directive"}},
+		{"orphan.txt", "gocrawl (test)", "*", []string{"Disallow: /tmp/"}, 0, nil,
+			[]string{"line 1: rule before any User-agent line", "line 2: Crawl-delay before any User-agent line"}},
+		{"unknown.txt", "gocrawl (test)", "*", []string{"Disallow: /real/"}, 0, nil,
+			[]string{
+				`line 3: unknown directive "Disalow"`,
+				`line 4: unknown directive "Noindex"`,
+				`line 6: invalid Crawl-delay "soon"`,
+				"line 7: line without a directive",
+				"line 8: empty User-agent",
+			}},
+		{"full.txt", "gocrawl (test)", "gocrawl", []string{"Disallow: /gocrawl/", "Allow: /gocrawl/public/"}, 2500 * time.Millisecond,
+			[]string{"https://example.com/sitemap.xml", "https://example.com/news.xml"},
+			[]string{`line 12: invalid Sitemap URL "/relative-sitemap.xml"`}},
+		{"full.txt", "Otherbot/1.0", "otherbot", []string{"Disallow: /gocrawl/", "Allow: /gocrawl/public/"}, 2500 * time.Millisecond,
+			[]string{"https://example.com/sitemap.xml", "https://example.com/news.xml"},
+			[]string{`line 12: invalid Sitemap URL "/relative-sitemap.xml"`}},
+		{"full.txt", "Googlebot", "*", []string{"Disallow: /"}, 10 * time.Second,
+			[]string{"https://example.com/sitemap.xml", "https://example.com/news.xml"},
+			[]string{`line 12: invalid Sitemap URL "/relative-sitemap.xml"`}},
+	}
+	for _, c := range cases {
+		body, err := ioutil.ReadFile(filepath.Join("testdata", "robotstxt", c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := parseRobots(body).info(body, c.agent, nil)
+		var warns []string
+		for _, w := range info.Warnings {
+			warns = append(warns, w.String())
+		}
+		if info.Agent != c.group || !reflect.DeepEqual(info.Rules, c.rules) || info.CrawlDelay != c.delay {
+			t.Errorf("%s (%s): want group %q with rules %q and delay %v, got %q with %q and %v",
+				c.file, c.agent, c.group, c.rules, c.delay, info.Agent, info.Rules, info.CrawlDelay)
+		}
+		if !reflect.DeepEqual(info.Sitemaps, c.sitemaps) {
+			t.Errorf("%s (%s): want sitemaps %q, got %q", c.file, c.agent, c.sitemaps, info.Sitemaps)
+		}
+		if !reflect.DeepEqual(warns, c.warnings) {
+			t.Errorf("%s (%s): want warnings %q, got %q", c.file, c.agent, c.warnings, warns)
+		}
+	}
+}
+
+type robotsInfoExtender struct {
+	DefaultExtender
+	calls []string
+	infos []*RobotsInfo
+}
+
+func (x *robotsInfoExtender) FetchedRobots(ctx *URLContext, res *http.Response) {
+	x.calls = append(x.calls, "FetchedRobots")
+}
+
+func (x *robotsInfoExtender) RobotsParsed(ctx *URLContext, info *RobotsInfo) {
+	x.calls = append(x.calls, "RobotsParsed")
+	x.infos = append(x.infos, info)
+}
+
+func TestRobotsInfoExtender(t *testing.T) {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "robotstxt", "full.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write(body)
+			return
+		}
+		fmt.Fprint(w, "<html><body>ok</body></html>")
+	}))
+	defer srv.Close()
+
+	ext := new(robotsInfoExtender)
+	opts := NewOptions(ext)
+	opts.CrawlDelay = time.Millisecond
+	opts.LogFlags = LogNone
+	opts.RobotUserAgent = "gocrawl (test)"
+	c := NewCrawlerWithOptions(opts)
+	if err := c.Run(srv.URL + "/page"); err != nil {
+		t.Fatalf("run failed with %v", err)
+	}
+	if calls := strings.Join(ext.calls, ","); calls != "FetchedRobots,RobotsParsed" {
+		t.Fatalf("want FetchedRobots then RobotsParsed, got %s", calls)
+	}
+	info := ext.infos[0]
+	if !bytes.Equal(info.Body, body) || info.Agent != "gocrawl" || len(info.Rules) != 2 || len(info.Sitemaps) != 2 || len(info.Warnings) != 1 {
+		t.Errorf("unexpected robots.txt info %+v", info)
+	}
+	if info.CrawlDelay != 2500*time.Millisecond {
+		t.Errorf("want the crawl delay of the group, got %v", info.CrawlDelay)
+	}
+
+	// The user-agent and the crawl delay are those of the enforcing group
+	g := &robotstxt.Group{Agent: "*", CrawlDelay: 10 * time.Second}
+	info = parseRobots(body).info(body, "gocrawl (test)", g)
+	if info.Agent != "*" || info.Match != RobotsMatchDefault || info.CrawlDelay != 10*time.Second || len(info.Rules) != 1 {
+		t.Errorf("want the info of the enforcing group, got %+v", info)
+	}
+}
+
+type robotsSchemeExtender struct {
+	DefaultExtender
+	mu         sync.Mutex
+	visited    []string
+	disallowed []string
+}
+
+func (x *robotsSchemeExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visited = append(x.visited, ctx.url.String())
+}
+
+func (x *robotsSchemeExtender) Disallowed(ctx *URLContext) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.disallowed = append(x.disallowed, ctx.url.String())
+}
+
+func TestRobotsSchemeRedirect(t *testing.T) {
+	var mu sync.Mutex
+	robots := make(map[string]int)
+	// The http server redirects everything to https
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.URL.Path == "/robots.txt" {
+			robots["http"]++
+		}
+		mu.Unlock()
+		http.Redirect(w, r, "https://example.test"+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			mu.Lock()
+			robots["https"]++
+			mu.Unlock()
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+		case "/page1.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/page2.html">2</a><a href="/private/a.html">a</a>
+				<a href="http://example.test/private/b.html">b</a>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<p>ok</p>`)
+		}
+	}))
+	defer secure.Close()
+
+	for _, perScheme := range []bool{false, true} {
+		mu.Lock()
+		robots = make(map[string]int)
+		mu.Unlock()
+		ext := new(robotsSchemeExtender)
+		opts := NewOptions(ext)
+		opts.CrawlDelay = time.Millisecond
+		opts.LogFlags = LogNone
+		opts.RobotsPerScheme = perScheme
+		opts.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		opts.HostRewrite = map[string]string{
+			"example.test:80":  plain.Listener.Addr().String(),
+			"example.test:443": secure.Listener.Addr().String(),
+		}
+		c := NewCrawlerWithOptions(opts)
+		if err := c.Run("http://example.test/page1.html"); err != nil {
+			t.Fatalf("perScheme=%v: run failed with %v", perScheme, err)
+		}
+
+		// The rules of the redirected robots.txt apply after the scheme hop
+		sort.Strings(ext.visited)
+		sort.Strings(ext.disallowed)
+		want := []string{"https://example.test/page1.html", "https://example.test/page2.html"}
+		if !reflect.DeepEqual(ext.visited, want) {
+			t.Errorf("perScheme=%v: want visited %v, got %v", perScheme, want, ext.visited)
+		}
+		want = []string{"http://example.test/private/b.html", "https://example.test/private/a.html"}
+		if !reflect.DeepEqual(ext.disallowed, want) {
+			t.Errorf("perScheme=%v: want disallowed %v, got %v", perScheme, want, ext.disallowed)
+		}
+		if _, ok := c.RobotsFor("example.test"); !ok {
+			t.Errorf("perScheme=%v: expected the robots.txt of the host", perScheme)
+		}
+		// The robots.txt of the https scheme is requested again, if requested
+		wantRobots := map[string]int{"http": 1, "https": 1}
+		if perScheme {
+			wantRobots["https"] = 2
+		}
+		if !reflect.DeepEqual(robots, wantRobots) {
+			t.Errorf("perScheme=%v: want robots.txt requests %v, got %v", perScheme, wantRobots, robots)
+		}
+	}
+}
+
+func TestWinningRobotsRule(t *testing.T) {
+	cases := []struct {
+		robots string
+		path   string
+		want   string
+	}{
+		// The examples of RFC 9309 and of the robots.txt specification
+		{"Disallow: /example/\nDisallow: /publications/\nAllow: /example/page.html", "/example/page.html", "Allow: /example/page.html"},
+		{"Disallow: /example/\nDisallow: /publications/\nAllow: /example/page.html", "/example/other.html", "Disallow: /example/"},
+		{"Allow: /p\nDisallow: /", "/page", "Allow: /p"},
+		{"Allow: /folder\nDisallow: /folder", "/folder/page", "Allow: /folder"},
+		{"Disallow: /folder\nAllow: /folder", "/folder/page", "Allow: /folder"},
+		{"Allow: /page\nDisallow: /*.htm", "/page.htm", "Disallow: /*.htm"},
+		{"Allow: /page\nDisallow: /*.ph", "/page.php5", "Allow: /page"},
+		{"Allow: /$\nDisallow: /", "/", "Allow: /$"},
+		{"Allow: /$\nDisallow: /", "/page.htm", "Disallow: /"},
+		{"Disallow: /private/\nAllow: /private/public-report.html", "/private/public-report.html", "Allow: /private/public-report.html"},
+		{"Disallow: /private/\nAllow: /private/public-report.html", "/private/report.html", "Disallow: /private/"},
+		// Wildcards
+		{"Disallow: /*/secret$", "/a/b/secret", "Disallow: /*/secret$"},
+		{"Disallow: /*/secret$", "/a/secret.html", ""},
+		{"Disallow: /*.pdf$\nAllow: /public/*.pdf$", "/public/doc.pdf", "Allow: /public/*.pdf$"},
+		{"Disallow: /*.pdf$\nAllow: /public/*.pdf$", "/private/doc.pdf", "Disallow: /*.pdf$"},
+		{"Disallow: /tmp", "/index.html", ""},
+	}
+	for i, c := range cases {
+		_, rules := parseRobots([]byte("User-agent: *\n" + c.robots)).group("gocrawl")
+		var got string
+		if r := winningRobotsRule(rules, c.path); r != nil {
+			got = r.String()
+		}
+		if got != c.want {
+			t.Errorf("%d: want %q for %s, got %q", i, c.want, c.path, got)
+		}
+	}
+
+	// The classic rules are still tested by the robotstxt package
+	for robots, want := range map[string]bool{
+		"Disallow: /tmp\nDisallow: /private/": false,
+		"Disallow: /tmp\nAllow: /tmp/public/": true,
+		"Disallow: /*.pdf":                    true,
+		"Disallow: /index.html$":              true,
+	} {
+		_, rules := parseRobots([]byte("User-agent: *\n" + robots)).group("gocrawl")
+		if got := needsRobotsPrecedence(rules); got != want {
+			t.Errorf("%q: want %v, got %v", robots, want, got)
+		}
+	}
+}
+
+type robotsPrecedenceExtender struct {
+	DefaultExtender
+	mu         sync.Mutex
+	visited    []string
+	disallowed map[string]string
+}
+
+func (x *robotsPrecedenceExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visited = append(x.visited, ctx.URL().Path)
+}
+
+func (x *robotsPrecedenceExtender) DisallowedReason(ctx *URLContext, reason DisallowedKind, detail string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.disallowed[ctx.URL().Path] = detail
+}
+
+func TestRobotsAllowPrecedence(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /folder\nAllow: /folder\nDisallow: /private/\nAllow: /private/public-report.html\nDisallow: /*.pdf$\n")
+			return
+		}
+		fmt.Fprint(w, "<html><body>ok</body></html>")
+	}))
+	defer srv.Close()
+
+	ext := &robotsPrecedenceExtender{disallowed: make(map[string]string)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogNone
+	c := NewCrawlerWithOptions(opts)
+	var seeds []string
+	for _, p := range []string{"/folder/page", "/private/public-report.html", "/private/secret.html", "/doc.pdf", "/doc.pdf.html"} {
+		seeds = append(seeds, srv.URL+p)
+	}
+	if err := c.Run(seeds); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(ext.visited)
+	if got := strings.Join(ext.visited, ","); got != "/doc.pdf.html,/folder/page,/private/public-report.html" {
+		t.Errorf("unexpected visited URLs %s", got)
+	}
+	want := map[string]string{
+		"/private/secret.html": "Disallow: /private/",
+		"/doc.pdf":             "Disallow: /*.pdf$",
+	}
+	if !reflect.DeepEqual(ext.disallowed, want) {
+		t.Errorf("want disallowed %v, got %v", want, ext.disallowed)
+	}
+
+	// The crawler's decisions are those of RobotsAllowed
+	u, _ := url.Parse(srv.URL)
+	for p, want := range map[string]bool{"/folder/page": true, "/private/public-report.html": true, "/private/secret.html": false, "/doc.pdf": false} {
+		if ok, known := c.RobotsAllowed(u.Host, p); ok != want || !known {
+			t.Errorf("%s: want allowed %v, got %v (known: %v)", p, want, ok, known)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package gocrawl
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The robots directives that take a value, so that their name is not
+// mistaken for a user-agent (i.e. "unavailable_after: 25 Jun 2010").
+var robotsValueDirectives = map[string]bool{
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// The layouts of the unavailable_after dates, in addition to the HTTP date
+// formats.
+var unavailableAfterLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2 Jan 2006 15:04:05 MST",
+	"2 January 2006 15:04:05 MST",
+	"Monday, 2 January 2006 15:04:05 MST",
+	time.RFC822,
+	time.RFC1123Z,
+}
+
+// robotsDirectives holds the robots directives that apply to the crawler for
+// a response, from its X-Robots-Tag headers and its robots meta tags.
+type robotsDirectives struct {
+	noIndex  bool
+	noFollow bool
+}
+
+// Add the directives of the X-Robots-Tag header values that apply to the
+// robot user-agent. A value may be scoped to a user-agent
+// (i.e. "googlebot: noindex"), the directives that follow it then only apply
+// if it matches the robot user-agent, otherwise they apply to all robots.
+func (rd *robotsDirectives) addHeader(values []string, agent string, now time.Time) {
+	for _, v := range values {
+		applies := true
+		parts := strings.Split(v, ",")
+		for i := 0; i < len(parts); i++ {
+			p := strings.TrimSpace(parts[i])
+			if ix := strings.Index(p, ":"); ix > 0 {
+				if name := strings.ToLower(strings.TrimSpace(p[:ix])); !robotsValueDirectives[name] && !strings.ContainsAny(name, " \t") {
+					applies = robotsAgentMatch(name, agent)
+					p = strings.TrimSpace(p[ix+1:])
+				}
+			}
+			if applies {
+				i += rd.add(p, parts[i+1:], now)
+			}
+		}
+	}
+}
+
+// Add the directives of the content of a robots meta tag.
+func (rd *robotsDirectives) addMeta(content string, now time.Time) {
+	parts := strings.Split(content, ",")
+	for i := 0; i < len(parts); i++ {
+		i += rd.add(strings.TrimSpace(parts[i]), parts[i+1:], now)
+	}
+}
+
+// Add the directive, the next parts being the rest of the comma-separated
+// list, for the dates that contain commas. Returns the number of next parts
+// consumed by the directive.
+func (rd *robotsDirectives) add(directive string, next []string, now time.Time) int {
+	name, val := directive, ""
+	if ix := strings.Index(directive, ":"); ix >= 0 {
+		name, val = directive[:ix], strings.TrimSpace(directive[ix+1:])
+	}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "noindex":
+		rd.noIndex = true
+	case "nofollow":
+		rd.noFollow = true
+	case "none":
+		rd.noIndex, rd.noFollow = true, true
+	case "unavailable_after":
+		for n := 0; n <= len(next) && n <= 2; n++ {
+			if n > 0 {
+				val += "," + next[n-1]
+			}
+			if t, ok := parseUnavailableAfter(val); ok {
+				if t.Before(now) {
+					rd.noIndex = true
+				}
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// Parse the date of an unavailable_after directive.
+func parseUnavailableAfter(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if t, e := http.ParseTime(s); e == nil {
+		return t, true
+	}
+	for _, l := range unavailableAfterLayouts {
+		if t, e := time.Parse(l, s); e == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Indicates if the user-agent of a scoped directive matches the product
+// token of the robot user-agent, case-insensitively, i.e. "googlebot" matches
+// "Googlebot (gocrawl v0.4)" and "Googlebot/2.1".
+func robotsAgentMatch(name, agent string) bool {
+	if ix := strings.IndexAny(agent, " /"); ix >= 0 {
+		agent = agent[:ix]
+	}
+	return name != "" && name == strings.ToLower(agent)
+}
+
+// Return the robots directives of the response that apply to the robot
+// user-agent, from its X-Robots-Tag headers and, if doc is not nil, from the
+// robots meta tags of the document (the "robots" meta tags and those named
+// after the robot user-agent).
+func getRobotsDirectives(res *http.Response, doc *goquery.Document, agent string) robotsDirectives {
+	var rd robotsDirectives
+	now := time.Now()
+	rd.addHeader(res.Header.Values("X-Robots-Tag"), agent, now)
+	if doc != nil {
+		doc.Find("meta[name][content]").Each(func(i int, s *goquery.Selection) {
+			name := strings.ToLower(strings.TrimSpace(s.AttrOr("name", "")))
+			if name == "robots" || robotsAgentMatch(name, agent) {
+				rd.addMeta(s.AttrOr("content", ""), now)
+			}
+		})
+	}
+	return rd
+}
@@ -0,0 +1,152 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRobotsDirectivesHeader(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		values   []string
+		noIndex  bool
+		noFollow bool
+	}{
+		{nil, false, false},
+		{[]string{"all"}, false, false},
+		{[]string{"noindex"}, true, false},
+		{[]string{"NoFollow"}, false, true},
+		{[]string{"none"}, true, true},
+		{[]string{"noindex, nofollow"}, true, true},
+		{[]string{"noindex", "nofollow"}, true, true},
+		{[]string{"otherbot: noindex"}, false, false},
+		{[]string{"gocrawl: noindex"}, true, false},
+		{[]string{"GoCrawl: nofollow", "otherbot: noindex"}, false, true},
+		{[]string{"nofollow, otherbot: noindex"}, false, true},
+		{[]string{"otherbot: noindex, gocrawl: nofollow"}, false, true},
+		{[]string{"otherbot: none", "noindex"}, true, false},
+		{[]string{"gocrawler: noindex"}, false, false},
+		{[]string{"unavailable_after: 2010-06-25"}, true, false},
+		{[]string{"unavailable_after: 2030-06-25"}, false, false},
+		{[]string{"unavailable_after: Wed, 03 Nov 2010 15:00:00 GMT, nofollow"}, true, true},
+		{[]string{"unavailable_after: Wednesday, 03-Nov-10 15:00:00 GMT"}, true, false},
+		{[]string{"unavailable_after: 25 Jun 2030 15:00:00 PST, nofollow"}, false, true},
+		{[]string{"otherbot: unavailable_after: 25 Jun 2010 15:00:00 PST"}, false, false},
+		{[]string{"gocrawl: unavailable_after: 25 Jun 2010 15:00:00 PST"}, true, false},
+		{[]string{"unavailable_after: someday"}, false, false},
+		{[]string{"max-snippet: 20, nofollow"}, false, true},
+	}
+	for i, c := range cases {
+		var rd robotsDirectives
+		rd.addHeader(c.values, "gocrawl (test)", now)
+		if rd.noIndex != c.noIndex || rd.noFollow != c.noFollow {
+			t.Errorf("%d: %q: want noindex=%v nofollow=%v, got %v %v", i, c.values, c.noIndex, c.noFollow, rd.noIndex, rd.noFollow)
+		}
+	}
+}
+
+type robotsTagExtender struct {
+	DefaultExtender
+	mu      sync.Mutex
+	visited map[string]bool
+}
+
+func (x *robotsTagExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.visited[ctx.url.Path] = ctx.RobotsNoIndex()
+}
+
+func TestRespectMetaRobots(t *testing.T) {
+	headers := map[string][]string{
+		"/noindex.pdf":  {"noindex"},
+		"/nofollow.pdf": {"nofollow"},
+		"/none.pdf":     {"none"},
+		"/scoped.pdf":   {"otherbot: nofollow", "gocrawl: noindex"},
+		"/expired.pdf":  {"all", "unavailable_after: Wed, 03 Nov 2010 15:00:00 GMT"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch p := r.URL.Path; p {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>`)
+			for u := range headers {
+				fmt.Fprintf(w, `<a href="%s">pdf</a>`, u)
+			}
+			fmt.Fprint(w, `<a href="/meta.html">meta</a><a href="/othermeta.html">other</a></body></html>`)
+		case "/meta.html", "/othermeta.html":
+			name := "robots"
+			if p == "/othermeta.html" {
+				name = "otherbot"
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><head><meta name="%s" content="noindex, nofollow"></head><body><a href="%s.link">l</a></body></html>`, name, p)
+		default:
+			if strings.HasSuffix(p, ".link") {
+				w.Header().Set("Content-Type", "text/plain")
+				fmt.Fprint(w, "ok")
+				return
+			}
+			for _, v := range headers[p] {
+				w.Header().Add("X-Robots-Tag", v)
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			// The PDF body is parsed as an HTML document by default
+			fmt.Fprintf(w, `%%PDF-1.4 <a href="%s.link">l</a>`, p)
+		}
+	}))
+	defer srv.Close()
+
+	for _, respect := range []bool{true, false} {
+		ext := &robotsTagExtender{visited: make(map[string]bool)}
+		opts := NewOptions(ext)
+		opts.CrawlDelay = time.Millisecond
+		opts.LogFlags = LogNone
+		opts.RobotUserAgent = "gocrawl (test)"
+		opts.RespectMetaRobots = respect
+		c := NewCrawlerWithOptions(opts)
+		if err := c.Run(srv.URL + "/"); err != nil {
+			t.Fatalf("run failed with %v", err)
+		}
+
+		want := map[string]bool{
+			"/":                    false,
+			"/noindex.pdf":         true,
+			"/noindex.pdf.link":    false,
+			"/nofollow.pdf":        false,
+			"/none.pdf":            true,
+			"/scoped.pdf":          true,
+			"/scoped.pdf.link":     false,
+			"/expired.pdf":         true,
+			"/expired.pdf.link":    false,
+			"/meta.html":           true,
+			"/othermeta.html":      false,
+			"/othermeta.html.link": false,
+		}
+		if !respect {
+			for u := range want {
+				want[u] = false
+			}
+			for _, u := range []string{"/nofollow.pdf.link", "/none.pdf.link", "/meta.html.link"} {
+				want[u] = false
+			}
+		}
+		if len(ext.visited) != len(want) {
+			t.Errorf("respect=%v: want %d visited URLs, got %d: %v", respect, len(want), len(ext.visited), ext.visited)
+		}
+		for u, noIndex := range want {
+			if got, ok := ext.visited[u]; !ok {
+				t.Errorf("respect=%v: expected %s to be visited", respect, u)
+			} else if got != noIndex {
+				t.Errorf("respect=%v: want noindex=%v for %s, got %v", respect, noIndex, u, got)
+			}
+		}
+	}
+}
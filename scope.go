@@ -0,0 +1,102 @@
+package gocrawl
+
+import (
+	"net/url"
+	"strings"
+)
+
+// A path prefix of the crawl scope, on a normalized host.
+type scopePrefix struct {
+	host string
+	path string
+}
+
+// The crawl scope, per the ScopePrefixes and SamePathPrefixOnly options. It
+// is only used from the crawler's goroutine.
+type crawlScope struct {
+	prefixes []scopePrefix
+	seeds    bool
+}
+
+// Return the scope of the crawl, or nil if the URLs are not limited to path
+// prefixes. The invalid ScopePrefixes are rejected by Options.Validate.
+func newCrawlScope(opts *Options) *crawlScope {
+	if !opts.SamePathPrefixOnly && len(opts.ScopePrefixes) == 0 {
+		return nil
+	}
+	s := &crawlScope{seeds: opts.SamePathPrefixOnly}
+	for _, p := range opts.ScopePrefixes {
+		u, err := url.Parse(p)
+		if err != nil {
+			continue
+		}
+		// Compare with the same normalization as the crawled URLs, which
+		// may strip the trailing slash of a directory.
+		dir := strings.HasSuffix(u.Path, "/")
+		if u, err = normalizeURL(u, opts); err != nil {
+			continue
+		}
+		s.add(u, dir)
+	}
+	return s
+}
+
+// Add the directory of the normalized URL to the scope, the URL itself if
+// dir is true.
+func (s *crawlScope) add(u *url.URL, dir bool) {
+	path := u.EscapedPath()
+	if dir && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	p := scopePrefix{u.Host, dirPrefix(path)}
+	for _, sp := range s.prefixes {
+		if sp == p {
+			return
+		}
+	}
+	s.prefixes = append(s.prefixes, p)
+}
+
+// Add the directory of the seed to the scope, with the SamePathPrefixOnly
+// option.
+func (s *crawlScope) addSeed(ctx *URLContext) {
+	if s.seeds && ctx.normalizedURL.IsAbs() {
+		s.add(ctx.normalizedURL, strings.HasSuffix(ctx.url.Path, "/"))
+	}
+}
+
+// Indicates if the normalized URL is under one of the path prefixes of its
+// host, regardless of its scheme.
+func (s *crawlScope) contains(u *url.URL) bool {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	for _, sp := range s.prefixes {
+		if u.Host != sp.host {
+			continue
+		}
+		// The directory itself is in scope, with or without its trailing slash
+		if strings.HasPrefix(p, sp.path) || p+"/" == sp.path {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the directory of the escaped path, with a trailing slash. The final
+// segment is stripped if it looks like a file (i.e. "/docs/index.html" and
+// "/docs" both give "/docs/").
+func dirPrefix(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if strings.HasSuffix(p, "/") {
+		return p
+	}
+	i := strings.LastIndex(p, "/")
+	if strings.Contains(p[i+1:], ".") {
+		return p[:i+1]
+	}
+	return p + "/"
+}
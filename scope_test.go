@@ -0,0 +1,65 @@
+package gocrawl
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDirPrefix(t *testing.T) {
+	cases := []struct {
+		path, want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/docs", "/docs/"},
+		{"/docs/", "/docs/"},
+		{"/docs/index.html", "/docs/"},
+		{"/docs/v1/guide.pdf", "/docs/v1/"},
+		{"/a%20b", "/a%20b/"},
+	}
+	for _, c := range cases {
+		if got := dirPrefix(c.path); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.path, c.want, got)
+		}
+	}
+}
+
+func TestCrawlScope(t *testing.T) {
+	opts := NewOptions(nil)
+	opts.ScopePrefixes = []string{"http://HOST/docs/index.html", "https://other/v1.2/", "http://other/a%20b"}
+	s := newCrawlScope(opts)
+
+	cases := []struct {
+		u  string
+		in bool
+	}{
+		{"http://host/docs", true},
+		{"http://host/docs/", true},
+		{"https://host/docs/guide/page.html", true},
+		{"http://host/docsearch.html", false},
+		{"http://host/", false},
+		{"http://other/docs/", false},
+		{"http://other/v1.2/page.html", true},
+		{"http://other/v1.3/page.html", false},
+		{"http://other/a%20b/c", true},
+		{"http://other/a%20bc", false},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err = normalizeURL(u, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.contains(u); got != c.in {
+			t.Errorf("%s: expected in scope %v, got %v", c.u, c.in, got)
+		}
+	}
+
+	// No scope without the options
+	if s := newCrawlScope(NewOptions(nil)); s != nil {
+		t.Errorf("expected no scope, got %v", s)
+	}
+}
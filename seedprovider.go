@@ -0,0 +1,122 @@
+package gocrawl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// SeedProvider streams the seeds of a crawl, it can be passed to Run instead
+// of a list of seeds when the list is too large to be held in memory. Next
+// returns the next seed to enqueue, or io.EOF once there are no more seeds.
+// Any other error is notified with the CekSeedProvider kind (see the
+// AbortOnSeedError option). Next is called from a dedicated goroutine, as the
+// crawler pulls new seeds, it may block until a seed is available.
+type SeedProvider interface {
+	Next() (*EnqueueItem, error)
+}
+
+// The maximum number of URLs pending in the workers for the crawler to pull
+// a new seed from the SeedProvider, so that the seeds are enqueued as the
+// workers have capacity.
+var maxPendingForSeed = 100
+
+// A seed or an error returned by a SeedProvider.
+type seedResult struct {
+	item *EnqueueItem
+	err  error
+}
+
+// Call the SeedProvider until it returns io.EOF or the crawler stops, and
+// send its results on the channel, which is closed when done.
+func runSeedProvider(sp SeedProvider, out chan<- *seedResult, stop <-chan struct{}) {
+	defer close(out)
+	for {
+		it, err := sp.Next()
+		if err == io.EOF {
+			return
+		}
+		if it == nil && err == nil {
+			continue
+		}
+		select {
+		case out <- &seedResult{it, err}:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Return the channel to pull the seeds from, or nil if the workers have no
+// capacity for more URLs, if there is no SeedProvider or during a turn of the
+// Deterministic mode.
+func (c *Crawler) seedsChan() <-chan *seedResult {
+	if c.pushPopRefCount >= maxPendingForSeed || c.turn != nil {
+		return nil
+	}
+	return c.seeds
+}
+
+// Enqueue a seed pulled from the SeedProvider. It returns the error of the
+// provider if the crawl must be aborted.
+func (c *Crawler) enqueueSeed(sr *seedResult) error {
+	if sr.err != nil {
+		c.notifyError(newCrawlError(nil, sr.err, CekSeedProvider))
+		c.logFunc(LogError, "ERROR seed provider: %s", sr.err)
+		if c.opts().AbortOnSeedError {
+			return sr.err
+		}
+		return nil
+	}
+
+	ctxs := c.toURLContexts(*sr.item, nil)
+	for _, ctx := range ctxs {
+		ctx.seed = true
+		// The hosts of the seeds are the hosts of the SameHostOnly policy
+		c.hosts[ctx.normalizedURL.Host] = struct{}{}
+		if c.scope != nil {
+			c.scope.addSeed(ctx)
+		}
+	}
+	c.enqueueUrls(ctxs, nil, nil)
+	return nil
+}
+
+// FileSeedProvider returns a SeedProvider that reads the seeds from r, one
+// URL per line. The blank lines and the lines starting with "#" are skipped.
+// A line that is not a valid URL is returned as an error, the next seeds are
+// read on the following calls.
+func FileSeedProvider(r io.Reader) SeedProvider {
+	return &fileSeedProvider{s: bufio.NewScanner(r)}
+}
+
+type fileSeedProvider struct {
+	s    *bufio.Scanner
+	line int
+	done bool
+}
+
+func (p *fileSeedProvider) Next() (*EnqueueItem, error) {
+	for !p.done && p.s.Scan() {
+		p.line++
+		l := strings.TrimSpace(p.s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		u, err := url.Parse(l)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", p.line, err)
+		}
+		return &EnqueueItem{URL: u}, nil
+	}
+	if !p.done {
+		// A read error is returned once, the provider is exhausted afterwards
+		p.done = true
+		if err := p.s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, io.EOF
+}
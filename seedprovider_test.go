@@ -0,0 +1,43 @@
+package gocrawl
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileSeedProvider(t *testing.T) {
+	p := FileSeedProvider(strings.NewReader(`
+# comment
+http://a.com/
+
+  http://b.com/x  
+	# indented comment
+http://[bad
+http://c.com`))
+
+	var got []string
+	var errs int
+	for {
+		it, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs++
+			continue
+		}
+		got = append(got, it.URL.String())
+	}
+	want := []string{"http://a.com/", "http://b.com/x", "http://c.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if errs != 1 {
+		t.Errorf("expected 1 error, got %d", errs)
+	}
+	if _, err := p.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF once exhausted, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package gocrawl
+
+import (
+	"net/url"
+	"strings"
+)
+
+// TrailingSlashPolicy controls how the trailing slash of the paths of the
+// URLs is normalized, so that the URLs that differ only by their trailing
+// slash (i.e. "/docs" and "/docs/") are visited once.
+type TrailingSlashPolicy uint8
+
+// The various trailing slash policies.
+const (
+	// SlashKeep leaves the trailing slash as is, per the
+	// URLNormalizationFlags and the URLNormalizer.
+	SlashKeep TrailingSlashPolicy = iota
+
+	// SlashAdd adds a trailing slash to the paths whose last segment has no
+	// extension (i.e. "/docs" but not "/docs/index.html").
+	SlashAdd
+
+	// SlashStrip removes the trailing slash of the paths, except for the
+	// root path.
+	SlashStrip
+)
+
+// Apply the trailing slash policy to the path of the URL, in place.
+func applyTrailingSlash(u *url.URL, policy TrailingSlashPolicy) {
+	if policy == SlashKeep || u.Opaque != "" {
+		return
+	}
+	switch policy {
+	case SlashAdd:
+		if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+			return
+		}
+		if strings.Contains(u.Path[strings.LastIndex(u.Path, "/")+1:], ".") {
+			return
+		}
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	case SlashStrip:
+		p := strings.TrimRight(u.Path, "/")
+		if p == u.Path || p == "" {
+			return
+		}
+		u.Path = p
+		if u.RawPath != "" {
+			u.RawPath = strings.TrimRight(u.RawPath, "/")
+		}
+	}
+}
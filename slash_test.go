@@ -0,0 +1,103 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestApplyTrailingSlash(t *testing.T) {
+	cases := []struct {
+		in     string
+		policy TrailingSlashPolicy
+		want   string
+	}{
+		{"http://host/docs", SlashKeep, "http://host/docs"},
+		{"http://host/docs/", SlashKeep, "http://host/docs/"},
+		{"http://host/docs", SlashAdd, "http://host/docs/"},
+		{"http://host/docs/", SlashAdd, "http://host/docs/"},
+		{"http://host/docs/index.html", SlashAdd, "http://host/docs/index.html"},
+		{"http://host/v1.2/docs", SlashAdd, "http://host/v1.2/docs/"},
+		{"http://host/a%2Fb?q=1", SlashAdd, "http://host/a%2Fb/?q=1"},
+		{"http://host", SlashAdd, "http://host"},
+		{"http://host/docs/", SlashStrip, "http://host/docs"},
+		{"http://host/docs//", SlashStrip, "http://host/docs"},
+		{"http://host/docs", SlashStrip, "http://host/docs"},
+		{"http://host/", SlashStrip, "http://host/"},
+		{"http://host/a%2Fb/?q=1", SlashStrip, "http://host/a%2Fb?q=1"},
+		{"mailto:someone@example.com", SlashAdd, "mailto:someone@example.com"},
+	}
+	for i, c := range cases {
+		u, err := url.Parse(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyTrailingSlash(u, c.policy)
+		if got := u.String(); got != c.want {
+			t.Errorf("%d: %s with policy %d: want %s, got %s", i, c.in, c.policy, c.want, got)
+		}
+	}
+}
+
+// Return the custom assert of the paths of the visited URLs, in any order.
+func assertVisitedPaths(want ...string) func(*spyExtender, *testing.T) {
+	return func(spy *spyExtender, t *testing.T) {
+		spy.m.RLock()
+		defer spy.m.RUnlock()
+		var got []string
+		for _, args := range spy.calledWith[eMKVisit] {
+			got = append(got, args[0].(*URLContext).URL().Path)
+		}
+		sort.Strings(got)
+		exp := append([]string(nil), want...)
+		sort.Strings(exp)
+		assertTrue(reflect.DeepEqual(got, exp), "expected the paths %v visited, got %v", exp, got)
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/docs">docs</a><a href="/docs/">docs</a>`)
+		case "/docs/":
+			// The server prefers the form without the trailing slash
+			http.Redirect(w, r, "/docs", http.StatusMovedPermanently)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/">home</a>`)
+		}
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), true)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.TrailingSlashPolicy = SlashAdd
+	opts.LogFlags = LogAll
+	if err := NewCrawlerWithOptions(opts).Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The self-redirect is followed in place, the page is visited once
+	want := []string{"/robots.txt", "/", "/docs/", "/docs"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("want the requests %v, got %v", want, requests)
+	}
+	assertCallCount(spy, "TrailingSlashRedirect", eMKVisit, 2, t)
+	assertCallCount(spy, "TrailingSlashRedirect", eMKError, 0, t)
+	assertIsInLog("TrailingSlashRedirect", spy.b, fmt.Sprintf("self-redirect on trailing slash policy, requesting %s/docs instead of %s/docs/", srv.URL, srv.URL), t)
+}
@@ -0,0 +1,41 @@
+package gocrawl
+
+import (
+	"net/http"
+)
+
+// SetUnchanged marks the visited URL as unchanged, e.g. by the Visit extender
+// method when the content of the page is the same as in a previous crawl,
+// so that its visit is a soft visit that does not count against the
+// MaxVisits budget, with the CountOnlyChangedVisits option.
+func (uc *URLContext) SetUnchanged() {
+	uc.unchanged = true
+}
+
+// IsUnchanged indicates if the visit of the URL produced no new content: a
+// HEAD-only visit, a range probe, a recheck, a 304 (Not Modified) response
+// with the CountOnlyChangedVisits option, or an URL marked as unchanged by
+// the Visit extender method (see SetUnchanged).
+func (uc *URLContext) IsUnchanged() bool {
+	return uc.unchanged
+}
+
+// Process the 304 (Not Modified) response of the URL as a soft visit, with
+// the CountOnlyChangedVisits option. The URL is not visited, only notified
+// as visited, and its body is closed by the caller.
+func (w *worker) notModified(ctx *URLContext, res *http.Response) {
+	if w.errBudget != nil {
+		w.errBudget.success()
+	}
+	ctx.unchanged = true
+	w.logFunc(LogTrace, "not modified: %s", ctx.url)
+	w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitNotModified})
+	w.eventFunc(LogInfo, EventVisit, urlFields(ctx))
+	w.events.emitURL(CevVisited, ctx)
+	w.sendResponse(ctx, true, nil, false)
+}
+
+// Indicates if the visit of the URL counts against the MaxVisits budget.
+func (c *Crawler) countsVisit(ctx *URLContext) bool {
+	return !c.opts().CountOnlyChangedVisits || ctx == nil || !ctx.unchanged
+}
@@ -0,0 +1,84 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestCountOnlyChangedVisits(t *testing.T) {
+	// Half of the pages linked from the root are not modified
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			for i := 1; i <= 6; i++ {
+				fmt.Fprintf(w, `<a href="/%d">%d</a>`, i, i)
+			}
+		case "/2", "/4", "/6":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		changedOnly bool
+		err         error
+		notModified int
+		summary     string
+	}{
+		// The 304 responses are errors, the budget is exhausted (/6 may be
+		// fetched meanwhile)
+		{false, ErrMaxVisits, 2, "summary: 4 visited, "},
+		// The 304 responses and the unchanged /5 are soft visits, the crawl
+		// ends once the queue is drained
+		{true, nil, 3, "summary: 7 visited (3 changed, 4 unchanged), 7 enqueued, "},
+	}
+	for _, c := range cases {
+		spy := newSpy(new(DefaultExtender), true)
+		spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+			if ctx.URL().Path == "/5" {
+				// The content hash is known
+				ctx.SetUnchanged()
+			}
+			return nil, true
+		})
+		opts := NewOptions(spy)
+		opts.CrawlDelay = 0
+		opts.MaxVisits = 4
+		opts.CountOnlyChangedVisits = c.changedOnly
+		opts.LogFlags = LogAll
+		if err := NewCrawlerWithOptions(opts).Run(srv.URL + "/"); err != c.err {
+			t.Errorf("%v: want error %v, got %v", c.changedOnly, c.err, err)
+		}
+		name := fmt.Sprintf("CountOnlyChangedVisits %v", c.changedOnly)
+		assertCallCount(spy, name, eMKVisit, 4, t)
+		// The 304 responses are notified via Visited either way
+		var visited, notModified int
+		spy.m.RLock()
+		for _, args := range spy.calledWith[eMKVisited] {
+			if args[0].(*URLContext).FetchInfo().StatusCode == http.StatusNotModified {
+				notModified++
+			} else {
+				visited++
+			}
+		}
+		spy.m.RUnlock()
+		if visited != 4 || notModified < c.notModified {
+			t.Errorf("%s: want 4 visited and %d not modified, got %d and %d", name, c.notModified, visited, notModified)
+		}
+		assertIsInLog(name, spy.b, c.summary, t)
+		if c.changedOnly {
+			assertCallCount(spy, name, eMKError, 0, t)
+			assertIsInLog(name, spy.b, fmt.Sprintf("not modified: %s/4\n", srv.URL), t)
+		}
+	}
+}
@@ -58,7 +58,9 @@ func (k extensionMethodKey) String() string {
 }
 
 // The spy extender adds counting the number of calls for each extender method,
-// and tracks the log so that it can be asserted.
+// and tracks the log so that it can be asserted. It is the SpyExtender of the
+// gocrawltest package, with access to the URLContext internals, as the tests
+// of the package cannot import gocrawltest (import cycle).
 type spyExtender struct {
 	Extender
 	useLogBuffer bool
@@ -69,6 +71,25 @@ type spyExtender struct {
 	m            sync.RWMutex       // Protects access to call count, methods and called with maps
 	logM         sync.Mutex         // Protects access to the log buffer (b)
 	EnqueueChan  chan<- interface{} // Redefine here, not accessible on DefaultExtender
+	events       []loggedEvent      // Structured log events, protected by logM
+	sequenced    bool               // Records the sequence of the calls, see setSequenced
+	sequence     []sequencedCall    // Sequence of the calls, protected by m
+}
+
+// A call recorded in the sequence of the calls of the spy extender, with its
+// sequence number, and its normalized URL and source URL, if any.
+type sequencedCall struct {
+	seq    int
+	key    extensionMethodKey
+	url    string
+	source string
+}
+
+// A structured log event received by the spy extender.
+type loggedEvent struct {
+	level  LogFlags
+	event  string
+	fields map[string]interface{}
 }
 
 func newSpy(ext Extender, useLogBuffer bool) *spyExtender {
@@ -82,7 +103,30 @@ func newSpy(ext Extender, useLogBuffer bool) *spyExtender {
 		sync.RWMutex{},
 		sync.Mutex{},
 		nil,
+		nil,
+		false,
+		nil,
+	}
+}
+
+// Record the sequence of the calls, to assert their ordering.
+func (x *spyExtender) setSequenced() {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.sequenced = true
+}
+
+// Return the recorded sequence of the calls of the extension method.
+func (x *spyExtender) getSequence(key extensionMethodKey) []sequencedCall {
+	x.m.RLock()
+	defer x.m.RUnlock()
+	var calls []sequencedCall
+	for _, c := range x.sequence {
+		if c.key == key {
+			calls = append(calls, c)
+		}
 	}
+	return calls
 }
 
 func (x *spyExtender) setExtensionMethod(key extensionMethodKey, f interface{}) {
@@ -108,6 +152,20 @@ func (x *spyExtender) registerCall(key extensionMethodKey, args ...interface{})
 	data, _ := x.calledWith[key]
 	data = append(data, args)
 	x.calledWith[key] = data
+
+	// Register the sequence number of the call
+	if x.sequenced {
+		var u, src string
+		if len(args) > 0 {
+			if ctx, ok := args[0].(*URLContext); ok {
+				u = ctx.normalizedURL.String()
+				if ctx.normalizedSourceURL != nil {
+					src = ctx.normalizedSourceURL.String()
+				}
+			}
+		}
+		x.sequence = append(x.sequence, sequencedCall{len(x.sequence), key, u, src})
+	}
 }
 
 func (x *spyExtender) getCalledWithCount(key extensionMethodKey, args ...interface{}) int {
@@ -150,6 +208,40 @@ func (x *spyExtender) Log(logFlags LogFlags, msgLevel LogFlags, msg string) {
 	}
 }
 
+func (x *spyExtender) LogEvent(level LogFlags, event string, fields map[string]interface{}) {
+	x.logM.Lock()
+	defer x.logM.Unlock()
+	x.events = append(x.events, loggedEvent{level, event, fields})
+}
+
+// Returns the number of structured log events with the specified name and
+// fields (other fields of the event are ignored, as well as the fields set
+// to ignore).
+func (x *spyExtender) getEventCount(event string, fields map[string]interface{}) int {
+	x.logM.Lock()
+	defer x.logM.Unlock()
+	cnt := 0
+	for _, e := range x.events {
+		if e.event == event && hasFields(e.fields, fields) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func hasFields(actual, compare map[string]interface{}) bool {
+	for k, v := range compare {
+		av, ok := actual[k]
+		if !ok {
+			return false
+		}
+		if v != ignore && !reflect.DeepEqual(av, v) {
+			return false
+		}
+	}
+	return true
+}
+
 func (x *spyExtender) Visit(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
 	x.registerCall(eMKVisit, ctx, res, doc)
 	if f, ok := x.methods[eMKVisit].(func(*URLContext, *http.Response, *goquery.Document) (interface{}, bool)); ok {
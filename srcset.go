@@ -0,0 +1,100 @@
+package gocrawl
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseSrcset returns the URLs of the image candidates of a srcset attribute
+// (i.e. "small.jpg 480w, large.jpg 1080w"), deduplicated, in their order of
+// appearance. The URLs are not resolved. A candidate with an invalid
+// descriptor is skipped, the other candidates are still returned.
+func ParseSrcset(srcset string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	s := srcset
+	for {
+		// Skip the whitespace and the commas before the URL
+		s = strings.TrimLeft(s, " \t\n\f\r,")
+		if s == "" {
+			return res
+		}
+		end := strings.IndexAny(s, " \t\n\f\r")
+		if end < 0 {
+			end = len(s)
+		}
+		u, rest := s[:end], s[end:]
+
+		// A URL that ends with commas has no descriptors
+		var descs string
+		if trimmed := strings.TrimRight(u, ","); trimmed != u {
+			u = trimmed
+		} else {
+			descs, rest = splitDescriptors(rest)
+		}
+		s = rest
+		if u != "" && validDescriptors(descs) && !seen[u] {
+			seen[u] = true
+			res = append(res, u)
+		}
+	}
+}
+
+// Split the descriptors of a candidate from the rest of the srcset, at the
+// first comma that is not within parentheses.
+func splitDescriptors(s string) (descs, rest string) {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// Indicates if the descriptors of a candidate are valid: at most one width
+// ("480w") or density ("2x") descriptor, and at most one height descriptor
+// along with a width.
+func validDescriptors(descs string) bool {
+	var w, h, x bool
+	for _, d := range strings.Fields(descs) {
+		n, unit := d[:len(d)-1], d[len(d)-1]
+		switch unit {
+		case 'w', 'h':
+			v, err := strconv.Atoi(n)
+			if err != nil || v <= 0 || strings.HasPrefix(n, "+") {
+				return false
+			}
+			if unit == 'w' {
+				if w || x {
+					return false
+				}
+				w = true
+			} else {
+				if h {
+					return false
+				}
+				h = true
+			}
+		case 'x':
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) || strings.HasPrefix(n, "+") || w || x || h {
+				return false
+			}
+			x = true
+		default:
+			return false
+		}
+	}
+	return !h || w
+}
@@ -0,0 +1,30 @@
+package gocrawl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSrcset(t *testing.T) {
+	cases := []struct {
+		srcset string
+		want   []string
+	}{
+		{"", nil},
+		{"a.jpg", []string{"a.jpg"}},
+		{"a.jpg 480w, b.jpg 1080w", []string{"a.jpg", "b.jpg"}},
+		{"  a.jpg   1x ,\n\tb.jpg 2.5x,c.jpg", []string{"a.jpg", "b.jpg", "c.jpg"}},
+		{"a.jpg,b.jpg 2x", []string{"a.jpg,b.jpg"}}, // The URL ends at the whitespace
+		{"a.jpg 1x, a.jpg 2x, /b.jpg 100w 50h", []string{"a.jpg", "/b.jpg"}},
+		{"http://x/a.jpg 1x, //cdn/b.jpg 2x", []string{"http://x/a.jpg", "//cdn/b.jpg"}},
+		{"a.jpg foo, b.jpg 2x, c.jpg 10w 2x, d.jpg -1w, e.jpg 50h, f.jpg 3x", []string{"b.jpg", "f.jpg"}},
+		{"a.jpg 1x (foo, bar), b.jpg", []string{"b.jpg"}},
+		{"data:image/png;base64,AAAA 1x, b.jpg 2x", []string{"data:image/png;base64,AAAA", "b.jpg"}},
+		{",,, , a.jpg,,", []string{"a.jpg"}},
+	}
+	for i, c := range cases {
+		if got := ParseSrcset(c.srcset); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d: %q: want %q, got %q", i, c.srcset, c.want, got)
+		}
+	}
+}
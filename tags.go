@@ -0,0 +1,72 @@
+package gocrawl
+
+import (
+	"net/url"
+)
+
+// Return the tags of the host, per the HostTags option.
+func (opts *Options) hostTags(host string) map[string]string {
+	if tags, ok := opts.HostTags[host]; ok {
+		return tags
+	}
+	var match string
+	for pattern := range opts.HostTags {
+		if matchesHostPattern(host, pattern) && len(pattern) > len(match) {
+			match = pattern
+		}
+	}
+	return opts.HostTags[match]
+}
+
+// Return a copy of the tags, or nil if there are none, so that the tags
+// attached to an URL are not modified afterwards by the caller.
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	cp := make(map[string]string, len(tags))
+	for k, v := range tags {
+		cp[k] = v
+	}
+	return cp
+}
+
+// Return the union of the tags, those of b taking precedence. The tags are
+// never modified once attached, so one of the maps is returned as is if the
+// other is empty.
+func mergeTags(a, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// Set the tags of the URL: those attached to the URL (or inherited from its
+// source), merged onto those of its host.
+func (uc *URLContext) setTags(urlTags map[string]string, opts *Options) {
+	uc.urlTags = urlTags
+	uc.tags = mergeTags(opts.hostTags(uc.normalizedURL.Host), urlTags)
+}
+
+// Return the tags encoded as a query string, sorted by key, or an empty
+// string if there are none.
+func encodeTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	v := make(url.Values, len(tags))
+	for k, t := range tags {
+		v.Set(k, t)
+	}
+	return v.Encode()
+}
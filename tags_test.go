@@ -0,0 +1,125 @@
+package gocrawl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	var buf bytes.Buffer
+	spy := newSpy(newFileFetcher(), true)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.SameHostOnly = true
+	opts.LogFlags = LogAll
+	opts.HostTags = map[string]map[string]string{"hosta": {"customer": "acme"}}
+	opts.ReportWriter = &buf
+	opts.EmitEvents = true
+	opts.EventBuffer = 1000
+	c := NewCrawlerWithOptions(opts)
+	events := c.Events()
+	done := make(chan []CrawlEvent)
+	go func() {
+		var evs []CrawlEvent
+		for ev := range events {
+			evs = append(evs, ev)
+		}
+		done <- evs
+	}()
+
+	ua, _ := url.Parse("http://hosta/page1.html")
+	ub, _ := url.Parse("http://hostb/page1.html")
+	if err := c.Run([]EnqueueItem{{URL: ua}, {URL: ub, Tags: map[string]string{"run": "7"}}}); err != nil {
+		t.Fatal(err)
+	}
+	evs := <-done
+
+	// The tags of hosta are on its records only, the tags of the seed of
+	// hostb are inherited by its harvested URLs, but not by its robots.txt
+	// nor its host events, which have the tags of the host
+	want := map[string]map[string]string{
+		"hosta": {"customer": "acme"},
+		"hostb": {"run": "7"},
+	}
+	assertTags := func(where, host string, hostOnly bool, got map[string]string) {
+		exp := want[host]
+		if hostOnly {
+			exp = opts.HostTags[host]
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s of %s: want the tags %v, got %v", where, host, exp, got)
+		}
+	}
+
+	spy.m.RLock()
+	visits := spy.calledWith[eMKVisit]
+	spy.m.RUnlock()
+	if len(visits) != 5 {
+		t.Errorf("want 5 visits, got %d", len(visits))
+	}
+	for _, args := range visits {
+		ctx := args[0].(*URLContext)
+		assertTags("visit "+ctx.URL().Path, ctx.URL().Host, false, ctx.Tags())
+	}
+
+	sc := bufio.NewScanner(&buf)
+	n := 0
+	for ; sc.Scan(); n++ {
+		var rec reportRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(rec.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTags("report record "+rec.URL, u.Host, rec.Type == reportRobots, rec.Tags)
+	}
+	if n == 0 {
+		t.Error("want the report records, got none")
+	}
+
+	for _, ev := range evs {
+		switch ev.Kind {
+		case CevHostStarted, CevHostFinished:
+			assertTags("event "+ev.Kind.String(), ev.Host, true, ev.Tags)
+		case CevCrawlEnd:
+		default:
+			u, err := url.Parse(ev.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTags("event "+ev.Kind.String()+" "+ev.URL, ev.Host, u.Path == robotsTxtPath, ev.Tags)
+		}
+	}
+}
+
+func TestTagsCopied(t *testing.T) {
+	opts := NewOptions(new(DefaultExtender))
+	opts.HostTags = map[string]map[string]string{"*.example.com": {"customer": "acme", "plan": "free"}}
+	c := NewCrawlerWithOptions(opts)
+
+	u, _ := url.Parse("http://www.example.com/")
+	tags := map[string]string{"plan": "pro"}
+	ctx, err := c.itemToURLContext(EnqueueItem{URL: u, Tags: tags}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags["plan"] = "team"
+	if want := map[string]string{"customer": "acme", "plan": "pro"}; !reflect.DeepEqual(ctx.Tags(), want) {
+		t.Errorf("want the tags %v, got %v", want, ctx.Tags())
+	}
+
+	// The URLs without tags have a nil map
+	u, _ = url.Parse("http://example.org/")
+	if ctx, err = c.itemToURLContext(EnqueueItem{URL: u}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Tags() != nil {
+		t.Errorf("want no tags, got %v", ctx.Tags())
+	}
+}
@@ -59,12 +59,47 @@ var (
 		},
 
 		&testCase{
-			name: "AllNotSameHost",
+			name: "DefaultPorts",
 			opts: &Options{
-				SameHostOnly: false,
+				SameHostOnly: true,
 				CrawlDelay:   DefaultTestCrawlDelay,
 				LogFlags:     LogAll,
 			},
+			seeds: []string{
+				"http://hosta:80/page1.html",
+				"http://hosta/page4.html",
+				"https://hostb:443/page1.html",
+				"https://hostb/page2.html",
+			},
+			asserts: a{
+				eMKVisit: 7,
+			},
+			customAssert: func(spy *spyExtender, t *testing.T) {
+				// A single worker, and robots.txt, per host
+				spy.m.RLock()
+				defer spy.m.RUnlock()
+				var robots []string
+				for _, args := range spy.calledWith[eMKFetch] {
+					if ctx := args[0].(*URLContext); ctx.IsRobotsURL() {
+						robots = append(robots, ctx.normalizedURL.String())
+					}
+				}
+				assertTrue(len(robots) == 2, "expected 2 robots.txt fetches, got %v", robots)
+				for _, args := range spy.calledWith[eMKVisit] {
+					u := args[0].(*URLContext).normalizedURL
+					assertTrue(u.Port() == "", "expected no default port in %s", u)
+				}
+			},
+		},
+
+		&testCase{
+			name: "AllNotSameHost",
+			opts: &Options{
+				SameHostOnly:  false,
+				Deterministic: true,
+				CrawlDelay:    DefaultTestCrawlDelay,
+				LogFlags:      LogAll,
+			},
 			seeds: []string{
 				"http://hosta/page1.html",
 				"http://hosta/page4.html",
@@ -73,6 +108,22 @@ var (
 				eMKVisit:  10,
 				eMKFilter: 24,
 			},
+			customAssert: func(spy *spyExtender, t *testing.T) {
+				// The hosts take turns in the order of their names, the robots.txt
+				// requests included
+				assertVisitURLOrder(spy, "AllNotSameHost", []string{
+					"http://hosta/page1.html",
+					"http://hosta/page4.html",
+					"http://hostb/page1.html",
+					"http://hosta/page2.html",
+					"http://hostb/page2.html",
+					"http://hostc/page3.html",
+					"http://hosta/page3.html",
+					"http://hostc/page1.html",
+					"http://hosta/page5.html",
+					"http://hostc/page2.html",
+				}, t)
+			},
 		},
 
 		&testCase{
@@ -518,8 +569,8 @@ var (
 				eMKFilter: 2,
 			},
 			logAsserts: []string{
-				"ignore on filter policy: http://test1\n",
-				"ignore on filter policy: http://test2\n",
+				"ignore on filter policy: http://test1/\n",
+				"ignore on filter policy: http://test2/\n",
 			},
 		},
 
@@ -666,6 +717,62 @@ var (
 			},
 		},
 
+		&testCase{
+			name: "RobotsTTL",
+			opts: &Options{
+				SameHostOnly:   true,
+				CrawlDelay:     DefaultTestCrawlDelay,
+				RobotsTTL:      time.Nanosecond,
+				LogFlags:       LogAll,
+				RobotUserAgent: DefaultRobotUserAgent,
+			},
+			seeds: "http://robota/page1.html",
+			funcs: f{
+				eMKRequestRobots: func() func(*URLContext, string) ([]byte, bool) {
+					// The rules change when robots.txt is refreshed before page2
+					n := 0
+					return func(ctx *URLContext, agent string) ([]byte, bool) {
+						if n++; n < 3 {
+							return []byte("User-agent: *\nDisallow:"), false
+						}
+						return []byte("User-agent: *\nDisallow:/page2.html"), false
+					}
+				}(),
+			},
+			asserts: a{
+				eMKVisit:         1,
+				eMKEnqueued:      3,
+				eMKRequestRobots: 3,
+				eMKDisallowed:    1,
+			},
+			logAsserts: []string{
+				"robots.txt expired, refreshing: http://robota/robots.txt",
+			},
+		},
+
+		&testCase{
+			name: "IgnoreRobots",
+			opts: &Options{
+				SameHostOnly:   true,
+				CrawlDelay:     DefaultTestCrawlDelay,
+				IgnoreRobots:   true,
+				LogFlags:       LogAll,
+				RobotUserAgent: DefaultRobotUserAgent,
+			},
+			seeds: "http://robota/page1.html",
+			asserts: a{
+				eMKFetch:         2,
+				eMKVisit:         2,
+				eMKEnqueued:      2,
+				eMKRequestRobots: 0,
+				eMKFetchedRobots: 0,
+				eMKDisallowed:    0,
+			},
+			logAsserts: []string{
+				"robots.txt handling is disabled, all URLs are allowed",
+			},
+		},
+
 		&testCase{
 			name: "FetchedRobot",
 			opts: &Options{
@@ -1093,6 +1200,281 @@ var (
 			},
 		},
 
+		&testCase{
+			name: "SchemePolicy",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"http://hoste/page1.html",
+			},
+			asserts: a{
+				eMKFilter:   3, // page1 (seed), page2, page1 again from page2
+				eMKEnqueued: 3, // page1, page2, robots.txt
+				eMKVisit:    2,
+			},
+			logAsserts: []string{
+				"ignore on scheme policy: mailto:someone@hoste\n",
+				"ignore on scheme policy: javascript:void(0)\n",
+				"ignore on scheme policy: tel:+15555555555\n",
+			},
+		},
+
+		&testCase{
+			name: "SchemePolicySeed",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"mailto:someone@hoste",
+				"http://hoste/page2.html",
+			},
+			asserts: a{
+				eMKFilter: 3, // page2 (seed), page1, page2 again from page1
+				eMKVisit:  2,
+			},
+			logAsserts: []string{
+				"WARNING disallowed scheme: mailto:someone@hoste\n",
+			},
+		},
+
+		&testCase{
+			name: "URLNormalizerDedupe",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+				URLNormalizer: func(u *url.URL) *url.URL {
+					q := u.Query()
+					q.Del("sid")
+					u.RawQuery = q.Encode()
+					return u
+				},
+			},
+			seeds: []string{
+				"http://hosta/page1.html?sid=1",
+				"http://hosta/page1.html?sid=2",
+			},
+			asserts: a{
+				eMKVisit: 3, // page1 only once, page2 and page3
+			},
+			logAsserts: []string{
+				"ignore on filter policy: http://hosta/page1.html\n",
+			},
+		},
+
+		&testCase{
+			name: "StripQueryParams",
+			opts: &Options{
+				SameHostOnly:             true,
+				CrawlDelay:               DefaultTestCrawlDelay,
+				LogFlags:                 LogAll,
+				StripQueryParams:         []string{"phpsessid"},
+				StripQueryParamsMatching: regexp.MustCompile(`^utm_`),
+			},
+			seeds: []string{
+				"http://hostf/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 6, // page1 (seed), 3 links to page2, 2 links to page1
+				eMKVisit:  2,
+			},
+			logAsserts: []string{
+				"enqueue: http://hostf/page2.html?utm_source=x&page=2\n",
+				"ignore on filter policy: http://hostf/page2.html?page=2\n",
+				"ignore on filter policy: http://hostf/page1.html\n",
+			},
+		},
+
+		&testCase{
+			name: "MessyHrefs",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"http://hostk/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 8, // page1 (seed), 7 parsable links to 3 pages
+				eMKVisit:  4,
+			},
+			logAsserts: []string{
+				"enqueue: http://hostk/page2.html?a=1&b=2\n",
+				"enqueue: http://hostk/page3.html?q=%7Bx%7D\n",
+				"enqueue: http://hostk/page4.html\n",
+				"ignore on unparsable policy: 2 links of http://hostk/page1.html, i.e. ",
+			},
+		},
+
+		&testCase{
+			name: "StripQueryParamsFetchNormalized",
+			opts: &Options{
+				SameHostOnly:             true,
+				CrawlDelay:               DefaultTestCrawlDelay,
+				LogFlags:                 LogAll,
+				StripQueryParams:         []string{"phpsessid"},
+				StripQueryParamsMatching: regexp.MustCompile(`^utm_`),
+				FetchNormalized:          true,
+			},
+			seeds: []string{
+				"http://hostf/page1.html",
+			},
+			asserts: a{
+				eMKVisit: 2,
+			},
+			logAsserts: []string{
+				"enqueue: http://hostf/page2.html?page=2\n",
+				"!utm_source",
+			},
+		},
+
+		&testCase{
+			name: "IDNSameHost",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"http://xn--mnchen-3ya.example/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 4, // page1 (seed), page2 twice, page1 from page2
+				eMKVisit:  2,
+			},
+			logAsserts: []string{
+				"ignore on filter policy: http://xn--mnchen-3ya.example/page2.html\n",
+				"ignore on filter policy: http://xn--mnchen-3ya.example/page1.html\n",
+				"!ignore on same host policy",
+			},
+		},
+
+		&testCase{
+			name: "IDNInvalidHost",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"http://-münchen.example/page1.html",
+			},
+			asserts: a{
+				eMKError:  1,
+				eMKFilter: 0,
+				eMKVisit:  0,
+			},
+			logAsserts: []string{
+				"ERROR parsing URL http://-münchen.example/page1.html\n",
+			},
+		},
+
+		&testCase{
+			name: "IncludeExcludePatterns",
+			opts: &Options{
+				SameHostOnly:    false,
+				CrawlDelay:      DefaultTestCrawlDelay,
+				LogFlags:        LogAll,
+				IncludePatterns: []*regexp.Regexp{regexp.MustCompile(`^http://hosta/`)},
+				ExcludePatterns: []*regexp.Regexp{regexp.MustCompile(`page3\.html$`)},
+			},
+			seeds: []string{
+				"http://hosta/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 3, // page1 (seed), page2, page1 from page2
+				eMKVisit:  2,
+			},
+			logAsserts: []string{
+				"excluded by pattern 0: http://hosta/page3.html\n",
+				"not included by any pattern: http://hostb/page1.html\n",
+				"ignore on pattern policy: http://hosta/page3.html\n",
+			},
+		},
+
+		&testCase{
+			name: "OrderingBFS",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+				Ordering:     OrderBFS,
+			},
+			seeds: []string{
+				"http://hosta/page1.html",
+			},
+			customAssert: func(spy *spyExtender, t *testing.T) {
+				assertVisitOrder(spy, "OrderingBFS", []string{"/page1.html", "/page2.html", "/page3.html"}, t)
+			},
+		},
+
+		&testCase{
+			name: "OrderingDFS",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+				Ordering:     OrderDFS,
+			},
+			seeds: []string{
+				"http://hosta/page1.html",
+			},
+			customAssert: func(spy *spyExtender, t *testing.T) {
+				assertVisitOrder(spy, "OrderingDFS", []string{"/page1.html", "/page3.html", "/page2.html"}, t)
+			},
+		},
+
+		&testCase{
+			name: "MaxConcurrentHosts",
+			opts: &Options{
+				SameHostOnly:       false,
+				MaxConcurrentHosts: 1,
+				CrawlDelay:         DefaultTestCrawlDelay,
+				LogFlags:           LogAll,
+			},
+			seeds: []string{
+				"http://hosta/page1.html",
+				"http://hosta/page4.html",
+			},
+			asserts: a{
+				eMKVisit:  10,
+				eMKFilter: 24,
+			},
+			logAsserts: []string{
+				"host hostb waiting for a worker slot\n",
+				"worker for host hosta retired to free a slot\n",
+				"!worker 2 launched",
+			},
+		},
+
+		&testCase{
+			name: "VisitWorkers",
+			opts: &Options{
+				SameHostOnly: false,
+				VisitWorkers: 2,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"http://hosta/page1.html",
+				"http://hosta/page4.html",
+			},
+			asserts: a{
+				eMKVisit:   10,
+				eMKVisited: 10,
+				eMKFilter:  24,
+			},
+			logAsserts: []string{
+				"2 visitors launched\n",
+			},
+		},
+
 		&testCase{
 			name:     "NoCrawlDelay",
 			external: testNoCrawlDelay,
@@ -1108,11 +1490,21 @@ var (
 			external: testCrawlDelay,
 		},
 
+		&testCase{
+			name:     "CrawlDelayRealTime",
+			external: testCrawlDelayRealTime,
+		},
+
 		&testCase{
 			name:     "UserAgent",
 			external: testUserAgent,
 		},
 
+		&testCase{
+			name:     "UserAgentFunc",
+			external: testUserAgentFunc,
+		},
+
 		&testCase{
 			name:     "RunTwiceSameInstance",
 			external: testRunTwiceSameInstance,
@@ -1133,9 +1525,447 @@ var (
 			external: testEnqueueNewURL,
 		},
 
+		&testCase{
+			name:     "Enqueue",
+			external: testEnqueue,
+		},
+
 		&testCase{
 			name:     "EnqueueNewUrlOnError",
 			external: testEnqueueNewURLOnError,
 		},
+
+		&testCase{
+			name:     "FilterURL",
+			external: testFilterURL,
+		},
+
+		&testCase{
+			name:     "AdaptiveDelay",
+			external: testAdaptiveDelay,
+		},
+
+		&testCase{
+			name:     "AdaptiveDelayBounds",
+			external: testAdaptiveDelayBounds,
+		},
+
+		&testCase{
+			name:     "MaxRequestsPerSecond",
+			external: testMaxRequestsPerSecond,
+		},
+
+		&testCase{
+			name:     "SharedRateLimiter",
+			external: testSharedRateLimiter,
+		},
+
+		&testCase{
+			name:     "HostFailureThreshold",
+			external: testHostFailureThreshold,
+		},
+
+		&testCase{
+			name:     "RobotsFor",
+			external: testRobotsFor,
+		},
+
+		&testCase{
+			name:     "MaxPendingDropNewest",
+			external: testMaxPendingDropNewest,
+		},
+
+		&testCase{
+			name:     "MaxPendingDropOldest",
+			external: testMaxPendingDropOldest,
+		},
+
+		&testCase{
+			name:     "MaxPendingBlockOnFull",
+			external: testMaxPendingBlockOnFull,
+		},
+
+		&testCase{
+			name:     "MaxDuration",
+			external: testMaxDuration,
+		},
+
+		&testCase{
+			name:     "MaxTotalBytes",
+			external: testMaxTotalBytes,
+		},
+
+		&testCase{
+			name:     "LogEvents",
+			external: testLogEvents,
+		},
+
+		&testCase{
+			name:     "RecoverPanics",
+			external: testRecoverPanics,
+		},
+
+		&testCase{
+			name:     "LinkInfo",
+			external: testLinkInfo,
+		},
+
+		&testCase{
+			name:     "TrackAncestry",
+			external: testTrackAncestry,
+		},
+
+		&testCase{
+			name:     "AttemptsAndFetchInfo",
+			external: testAttemptsAndFetchInfo,
+		},
+
+		&testCase{
+			name:     "RecordEdges",
+			external: testRecordEdges,
+		},
+
+		&testCase{
+			name:     "FuncExtender",
+			external: testFuncExtender,
+		},
+
+		&testCase{
+			name:     "HostLifecycle",
+			external: testHostLifecycle,
+		},
+
+		&testCase{
+			name:     "Progress",
+			external: testProgress,
+		},
+
+		&testCase{
+			name:     "StopDuringDelay",
+			external: testStopDuringDelay,
+		},
+
+		&testCase{
+			name:     "PerHost",
+			external: testPerHost,
+		},
+
+		&testCase{
+			name:     "RevisitAfter",
+			external: testRevisitAfter,
+		},
+
+		&testCase{
+			name:     "Frontier",
+			external: testFrontier,
+		},
+
+		&testCase{
+			name:     "Drain",
+			external: testDrain,
+		},
+
+		&testCase{
+			name:     "HeadOnly",
+			external: testHeadOnly,
+		},
+
+		&testCase{
+			name:     "HeadGate",
+			external: testHeadGate,
+		},
+
+		&testCase{
+			name:     "ContentEncoding",
+			external: testContentEncoding,
+		},
+
+		&testCase{
+			name:     "Transport",
+			external: testTransport,
+		},
+
+		&testCase{
+			name:     "HostRewrite",
+			external: testHostRewrite,
+		},
+
+		&testCase{
+			name:     "FollowHreflang",
+			external: testFollowHreflang,
+		},
+
+		&testCase{
+			name:     "FollowGetForms",
+			external: testFollowGetForms,
+		},
+
+		&testCase{
+			name:     "MaxPaginationDepth",
+			external: testMaxPaginationDepth,
+		},
+
+		&testCase{
+			name:     "HarvestImages",
+			external: testHarvestImages,
+		},
+
+		&testCase{
+			name: "HarvestSelectorNone",
+			opts: &Options{
+				SameHostOnly: true,
+				CrawlDelay:   DefaultTestCrawlDelay,
+				LogFlags:     LogAll,
+			},
+			seeds: []string{
+				"http://hosti/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 16, // seed, 6 links of page1, 5 of page2, 4 of page3
+				eMKVisit:  3,
+			},
+		},
+
+		&testCase{
+			name: "HarvestSelector",
+			opts: &Options{
+				SameHostOnly:    true,
+				CrawlDelay:      DefaultTestCrawlDelay,
+				LogFlags:        LogAll,
+				HarvestSelector: "main, article",
+			},
+			seeds: []string{
+				"http://hosti/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 7, // seed, main of page1, article of page2, whole page3
+				eMKVisit:  3,
+			},
+			logAsserts: []string{
+				"no element matches the harvest selector in http://hosti/page3.html",
+			},
+			customAssert: func(spy *spyExtender, t *testing.T) {
+				spy.m.RLock()
+				defer spy.m.RUnlock()
+				for _, args := range spy.calledWith[eMKVisit] {
+					ctx := args[0].(*URLContext)
+					if ctx.url.Path == "/page1.html" {
+						continue
+					}
+					info := ctx.LinkInfo()
+					assertTrue(info != nil && info.Scope == "main, article", "expected the scope of the link to %s, got %+v", ctx.url, info)
+				}
+			},
+		},
+
+		&testCase{
+			name: "HarvestSelectorNothing",
+			opts: &Options{
+				SameHostOnly:            true,
+				CrawlDelay:              DefaultTestCrawlDelay,
+				LogFlags:                LogAll,
+				HarvestSelector:         "main, article",
+				HarvestSelectorFallback: HarvestNothing,
+			},
+			seeds: []string{
+				"http://hosti/page1.html",
+			},
+			asserts: a{
+				eMKFilter: 3, // seed, main of page1, article of page2
+				eMKVisit:  3,
+			},
+		},
+
+		&testCase{
+			name:     "ProtocolRelative",
+			external: testProtocolRelative,
+		},
+
+		&testCase{
+			name:     "RegisterVisitor",
+			external: testRegisterVisitor,
+		},
+
+		&testCase{
+			name:     "DelayState",
+			external: testDelayState,
+		},
+
+		&testCase{
+			name:     "IsVisited",
+			external: testIsVisited,
+		},
+
+		&testCase{
+			name:     "ImportVisited",
+			external: testImportVisited,
+		},
+
+		&testCase{
+			name:     "SeedItems",
+			external: testSeedItems,
+		},
+
+		&testCase{
+			name:     "SeedProvider",
+			external: testSeedProvider,
+		},
+
+		&testCase{
+			name:     "MaxErrors",
+			external: testMaxErrors,
+		},
+
+		&testCase{
+			name:     "MutateOptionsDuringRun",
+			external: testMutateOptionsDuringRun,
+		},
+
+		&testCase{
+			name:     "VisitedInfo",
+			external: testVisitedInfo,
+		},
+
+		&testCase{
+			name:     "HostComplete",
+			external: testHostComplete,
+		},
+
+		&testCase{
+			name:     "RewriteURL",
+			external: testRewriteURL,
+		},
+
+		&testCase{
+			name:     "DisallowedReason",
+			external: testDisallowedReason,
+		},
+
+		&testCase{
+			name:     "CrawlerTraps",
+			external: testCrawlerTraps,
+		},
+
+		&testCase{
+			name:     "TrackDuplicates",
+			external: testTrackDuplicates,
+		},
+
+		&testCase{
+			name:     "SamePathPrefixOnly",
+			external: testSamePathPrefixOnly,
+		},
+
+		&testCase{
+			name:     "LogHostFilter",
+			external: testLogHostFilter,
+		},
+
+		&testCase{
+			name:     "DryRun",
+			external: testDryRun,
+		},
+
+		&testCase{
+			name:     "VisitErrorPages",
+			external: testVisitErrorPages,
+		},
+
+		&testCase{
+			name:     "URLValues",
+			external: testURLValues,
+		},
+
+		&testCase{
+			name: "ResponseCache",
+			opts: &Options{
+				SameHostOnly:       true,
+				CrawlDelay:         DefaultTestCrawlDelay,
+				MaxVisits:          6,
+				ResponseCacheBytes: 1 << 20,
+				LogFlags:           LogAll,
+			},
+			seeds: "http://hosta/page1.html",
+			funcs: f{
+				// Visit the pages again, served from the cache
+				eMKFilter: func(ctx *URLContext, isVisited bool) bool {
+					return true
+				},
+			},
+			asserts: a{
+				eMKFetch: 4, // Once for robots.txt, page1, page2 and page3
+			},
+			logAsserts: []string{
+				"response cache hit for http://hosta/page1.html (head: false): 200 OK\n",
+			},
+			customAssert: func(s *spyExtender, t *testing.T) {
+				s.m.RLock()
+				defer s.m.RUnlock()
+				var cached int
+				for _, args := range s.calledWith[eMKVisited] {
+					if args[0].(*URLContext).FetchInfo().Cached {
+						cached++
+					}
+				}
+				assertTrue(cached >= 3, "expected at least 3 cached visits, got %d", cached)
+			},
+		},
+
+		&testCase{
+			name:     "MaxQueryVariantsPerPath",
+			external: testMaxQueryVariantsPerPath,
+		},
+
+		&testCase{
+			name:     "CallbacksOrder",
+			external: testCallbacksOrder,
+		},
+
+		&testCase{
+			name: "TrailingSlashKeep",
+			opts: &Options{
+				SameHostOnly:          true,
+				CrawlDelay:            DefaultTestCrawlDelay,
+				LogFlags:              LogAll,
+				URLNormalizationFlags: purell.FlagsUsuallySafeNonGreedy,
+				TrailingSlashPolicy:   SlashKeep,
+			},
+			seeds: "http://hostn/page1.html",
+			asserts: a{
+				eMKVisit: 5,
+			},
+		},
+
+		&testCase{
+			name: "TrailingSlashAdd",
+			opts: &Options{
+				SameHostOnly:          true,
+				CrawlDelay:            DefaultTestCrawlDelay,
+				LogFlags:              LogAll,
+				URLNormalizationFlags: purell.FlagsUsuallySafeNonGreedy,
+				TrailingSlashPolicy:   SlashAdd,
+			},
+			seeds: "http://hostn/page1.html",
+			asserts: a{
+				eMKVisit: 3,
+			},
+			customAssert: assertVisitedPaths("/page1.html", "/docs/", "/guide/"),
+		},
+
+		&testCase{
+			name: "TrailingSlashStrip",
+			opts: &Options{
+				SameHostOnly:          true,
+				CrawlDelay:            DefaultTestCrawlDelay,
+				LogFlags:              LogAll,
+				URLNormalizationFlags: purell.FlagsUsuallySafeNonGreedy,
+				TrailingSlashPolicy:   SlashStrip,
+			},
+			seeds: "http://hostn/page1.html",
+			asserts: a{
+				eMKVisit: 3,
+			},
+			customAssert: assertVisitedPaths("/page1.html", "/docs", "/guide"),
+		},
 	}
 )
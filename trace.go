@@ -0,0 +1,154 @@
+package gocrawl
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// FetchTimings holds the durations of the phases of a fetch, measured when
+// the Options' TraceFetch is set. The DNS, Connect and TLSHandshake
+// durations are zero if the phase did not happen, i.e. when the connection
+// is reused (see ConnReused) or when the host is an IP address.
+type FetchTimings struct {
+	// DNS is the duration of the DNS lookup.
+	DNS time.Duration
+
+	// Connect is the duration of the TCP connection.
+	Connect time.Duration
+
+	// TLSHandshake is the duration of the TLS handshake.
+	TLSHandshake time.Duration
+
+	// TTFB is the time to first byte, from the start of the request to the
+	// first byte of the response, the phases above included.
+	TTFB time.Duration
+
+	// Transfer is the duration of the transfer of the response body, from
+	// the first byte of the response to the end of the body. It is zero
+	// until the body has been read (i.e. when the URL is visited).
+	Transfer time.Duration
+
+	// ConnReused indicates if the request was sent on a connection opened
+	// by a previous request.
+	ConnReused bool
+}
+
+// fetchTrace holds the times of the events of a request, set by its client
+// trace. The events of the connection may be reported from other
+// goroutines.
+type fetchTrace struct {
+	mu                           sync.Mutex
+	start                        time.Time
+	dnsStart, dnsDone            time.Time
+	connectStart, connectDone    time.Time
+	tlsStart, tlsDone            time.Time
+	gotConn, wroteReq, firstByte time.Time
+	reused                       bool
+	remoteAddr                   string
+}
+
+func newFetchTrace() *fetchTrace {
+	return &fetchTrace{start: time.Now()}
+}
+
+// Return a copy of the request that reports its events to the trace.
+func (tr *fetchTrace) withTrace(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace()))
+}
+
+func (tr *fetchTrace) clientTrace() *httptrace.ClientTrace {
+	set := func(t *time.Time) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { set(&tr.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { set(&tr.dnsDone) },
+		ConnectStart:      func(string, string) { set(&tr.connectStart) },
+		ConnectDone:       func(string, string, error) { set(&tr.connectDone) },
+		TLSHandshakeStart: func() { set(&tr.tlsStart) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			set(&tr.tlsDone)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			set(&tr.gotConn)
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.reused = info.Reused
+			if info.Conn != nil {
+				tr.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&tr.wroteReq) },
+		GotFirstResponseByte: func() { set(&tr.firstByte) },
+	}
+}
+
+// Return the timings of the fetch, once its response is received. The
+// transfer duration is set by the body returned by timeBody.
+func (tr *fetchTrace) fetchTimings() *FetchTimings {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	d := func(from, to time.Time) time.Duration {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return 0
+		}
+		return to.Sub(from)
+	}
+	return &FetchTimings{
+		DNS:          d(tr.dnsStart, tr.dnsDone),
+		Connect:      d(tr.connectStart, tr.connectDone),
+		TLSHandshake: d(tr.tlsStart, tr.tlsDone),
+		TTFB:         d(tr.start, tr.firstByte),
+		ConnReused:   tr.reused,
+	}
+}
+
+// Wrap the response body so that the transfer duration of the timings is
+// set once the body is read or closed.
+func (tr *fetchTrace) timeBody(body io.ReadCloser, t *FetchTimings) io.ReadCloser {
+	tr.mu.Lock()
+	from := tr.firstByte
+	tr.mu.Unlock()
+	if from.IsZero() {
+		from = time.Now()
+	}
+	return &timedBody{ReadCloser: body, from: from, t: t}
+}
+
+// timedBody sets the transfer duration of the timings at the end of the
+// body.
+type timedBody struct {
+	io.ReadCloser
+	from time.Time
+	t    *FetchTimings
+	done bool
+}
+
+func (tb *timedBody) Read(p []byte) (int, error) {
+	n, err := tb.ReadCloser.Read(p)
+	if err != nil {
+		tb.end()
+	}
+	return n, err
+}
+
+func (tb *timedBody) Close() error {
+	tb.end()
+	return tb.ReadCloser.Close()
+}
+
+func (tb *timedBody) end() {
+	if !tb.done {
+		tb.done = true
+		tb.t.Transfer = time.Since(tb.from)
+	}
+}
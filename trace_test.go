@@ -0,0 +1,88 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type traceExtender struct {
+	DefaultExtender
+	mu      sync.Mutex
+	fetches map[string]*FetchInfo
+}
+
+func (x *traceExtender) ComputeDelay(host string, di *DelayInfo, lastFetch *FetchInfo) time.Duration {
+	if lastFetch != nil {
+		x.mu.Lock()
+		x.fetches[lastFetch.Ctx.url.Path] = lastFetch
+		x.mu.Unlock()
+	}
+	return time.Millisecond
+}
+
+func (x *traceExtender) Visited(ctx *URLContext, harvested interface{}) {
+	x.mu.Lock()
+	x.fetches[ctx.url.Path] = ctx.FetchInfo()
+	x.mu.Unlock()
+}
+
+func TestTraceFetch(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nAllow: /\n")
+		default:
+			time.Sleep(delay)
+			fmt.Fprint(w, "<html><body>slow</body></html>")
+		}
+	}))
+	defer srv.Close()
+
+	for _, trace := range []bool{true, false} {
+		ext := &traceExtender{fetches: make(map[string]*FetchInfo)}
+		opts := NewOptions(ext)
+		opts.LogFlags = LogNone
+		opts.TraceFetch = trace
+		c := NewCrawlerWithOptions(opts)
+		if err := c.Run(srv.URL + "/slow"); err != nil {
+			t.Fatalf("trace=%v: run failed with %v", trace, err)
+		}
+
+		rob, page := ext.fetches["/robots.txt"], ext.fetches["/slow"]
+		if rob == nil || page == nil {
+			t.Fatalf("trace=%v: expected the robots.txt and page fetches, got %v", trace, ext.fetches)
+		}
+		if !trace {
+			if rob.Timings != nil || page.Timings != nil {
+				t.Errorf("expected no timings without TraceFetch")
+			}
+			continue
+		}
+
+		tm := page.Timings
+		if tm == nil {
+			t.Fatal("expected the timings of the page")
+		}
+		if tm.TTFB < delay {
+			t.Errorf("expected a TTFB of at least %v, got %v", delay, tm.TTFB)
+		}
+		if other := tm.DNS + tm.Connect + tm.TLSHandshake + tm.Transfer; tm.TTFB <= other {
+			t.Errorf("expected the TTFB to dominate, got %v and %v for the other phases", tm.TTFB, other)
+		}
+		if tm.Transfer <= 0 {
+			t.Errorf("expected the transfer time once the body is read, got %v", tm.Transfer)
+		}
+		// The robots.txt request opens the connection, reused for the page
+		if rob.Timings == nil || rob.Timings.ConnReused || rob.Timings.Connect <= 0 {
+			t.Errorf("expected a new connection for robots.txt, got %+v", rob.Timings)
+		}
+		if !tm.ConnReused || tm.Connect != 0 {
+			t.Errorf("expected the connection to be reused for the page, got %+v", tm)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Return the reason why the normalized URL is rejected by the crawler trap
+// guards (the MaxURLLength, MaxPathSegments and MaxRepeatedSegments options),
+// or an empty string if it is not.
+func trapPolicy(opts *Options, u *url.URL) string {
+	if max := opts.MaxURLLength; max > 0 {
+		if n := len(u.String()); n > max {
+			return fmt.Sprintf("URL length %d", n)
+		}
+	}
+	if opts.MaxPathSegments <= 0 && opts.MaxRepeatedSegments <= 0 {
+		return ""
+	}
+
+	var segs []string
+	for _, s := range strings.Split(u.EscapedPath(), "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	if max := opts.MaxPathSegments; max > 0 && len(segs) > max {
+		return fmt.Sprintf("%d path segments", len(segs))
+	}
+	if max := opts.MaxRepeatedSegments; max > 0 {
+		// A segment may not repeat more than max times, consecutively or not
+		counts := make(map[string]int, len(segs))
+		for _, s := range segs {
+			if counts[s]++; counts[s] > max {
+				return fmt.Sprintf("path segment %q repeated %d times", s, counts[s])
+			}
+		}
+	}
+	return ""
+}
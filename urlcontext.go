@@ -2,14 +2,25 @@ package gocrawl
 
 import (
 	"bytes"
+	"errors"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/PuerkitoBio/purell"
+	"golang.org/x/net/idna"
 )
 
 const (
 	robotsTxtPath = "/robots.txt"
+
+	// MaxLinkTextLen is the maximum length, in bytes, of the anchor text
+	// kept in a LinkInfo.
+	MaxLinkTextLen = 256
 )
 
 // U is a convenience type definition, it is a map[*url.URL]interface{}
@@ -20,9 +31,86 @@ type U map[*url.URL]interface{}
 // that can be used to enqueue URLs (the string) with state information.
 type S map[string]interface{}
 
+// EnqueueItem is an URL to enqueue via Crawler.Enqueue, along with its
+// state and settings.
+type EnqueueItem struct {
+	// URL is the URL to enqueue. It must be absolute.
+	URL *url.URL
+
+	// State is the state information of the URL, available via the
+	// URLContext's State field.
+	State interface{}
+
+	// HeadBeforeGet, if not nil, overrides the Options' HeadBeforeGet
+	// setting (and its PerHost override) for this URL.
+	HeadBeforeGet *bool
+
+	// HeadOnly requests the URL with a HEAD request only, it is never
+	// requested with GET nor visited.
+	HeadOnly bool
+
+	// FetchMode is the fetch mode of the URL (e.g. FetchRangeProbe).
+	FetchMode FetchMode
+
+	// Priority is the priority of the URL within its host's queue.
+	Priority int
+
+	// Tags are the tags of the URL, merged onto those of its host (see the
+	// HostTags option) and available via the URLContext's Tags method. They
+	// take precedence over the tags of the host, and are inherited by the
+	// URLs harvested from the URL. The map is copied when the item is
+	// enqueued.
+	Tags map[string]string
+}
+
+// LinkInfo holds the metadata of the harvested link that led to an URL.
+type LinkInfo struct {
+	// Text is the anchor text of the link, trimmed and with its whitespace
+	// collapsed, truncated to MaxLinkTextLen bytes.
+	Text string
+
+	// Rel is the list of tokens of the rel attribute of the link, in
+	// lowercase.
+	Rel []string
+
+	// NoFollow indicates if the rel attribute has the nofollow token.
+	NoFollow bool
+
+	// Tag is the name of the tag of the link (i.e. "a").
+	Tag string
+
+	// Index is the position of the link among the links of the page,
+	// starting at 0.
+	Index int
+
+	// Hreflang is the hreflang attribute of an alternate link (i.e. "en-US"
+	// or "x-default"), harvested if the FollowHreflang option is set.
+	Hreflang string
+
+	// Pagination indicates if the link is a pagination link, i.e. its rel
+	// attribute has the next or prev token.
+	Pagination bool
+
+	// Asset is the kind of asset of the link (i.e. AssetImage), or empty
+	// for a link to a page.
+	Asset string
+
+	// Scope is the HarvestSelector option if the link was harvested from
+	// the elements that match it, empty if it was harvested from the whole
+	// page.
+	Scope string
+}
+
+// The kinds of assets of the LinkInfo.
+const (
+	AssetImage = "image"
+)
+
 // URLContext contains all information related to an URL to process.
 type URLContext struct {
 	HeadBeforeGet bool
+	HeadOnly      bool
+	FetchMode     FetchMode
 	State         interface{}
 
 	// Internal fields, available through getters
@@ -30,6 +118,30 @@ type URLContext struct {
 	normalizedURL       *url.URL
 	sourceURL           *url.URL
 	normalizedSourceURL *url.URL
+	originalURL         *url.URL
+	priority            int
+	delayOverride       *time.Duration
+	robotsRule          string
+	linkInfo            *LinkInfo
+	paginationDepth     int
+	seed                bool
+	ancestry            *ancestry
+	attempts            int
+	fetchInfo           *FetchInfo
+	visitedAt           time.Time
+	client              *http.Client
+	trace               *fetchTrace
+	beforeFetch         BeforeFetchExtender
+	robots              robotsDirectives
+	authRequest         *http.Request
+	recheck             bool
+	unchanged           bool
+	redirectChain       []*url.URL
+	redirectLoop        bool
+	values              map[string]interface{}
+	tags                map[string]string
+	urlTags             map[string]string
+	depth               int
 }
 
 // URL returns the URL.
@@ -55,6 +167,151 @@ func (uc *URLContext) NormalizedSourceURL() *url.URL {
 	return uc.normalizedSourceURL
 }
 
+// OriginalURL returns the URL before it was rewritten by the
+// Options.RewriteURL function, or the URL if it was not rewritten.
+func (uc *URLContext) OriginalURL() *url.URL {
+	if uc.originalURL != nil {
+		return uc.originalURL
+	}
+	return uc.url
+}
+
+// Priority returns the priority of the URL within its host's queue.
+func (uc *URLContext) Priority() int {
+	return uc.priority
+}
+
+// PaginationDepth returns the number of consecutive rel=next links followed
+// to reach the URL from a page that was not reached by such a link. It is 0
+// for the other URLs.
+func (uc *URLContext) PaginationDepth() int {
+	return uc.paginationDepth
+}
+
+// Depth returns the number of links followed to reach the URL from a seed
+// or from an URL enqueued without source (e.g. via the EnqueueChan), for which
+// it is 0. A redirection keeps the depth of the redirected URL.
+func (uc *URLContext) Depth() int {
+	return uc.depth
+}
+
+// IsSeed indicates if the URL is one of the seeds passed to Run (as returned
+// by the Start extender method), as opposed to the URLs harvested or enqueued
+// during the crawl.
+func (uc *URLContext) IsSeed() bool {
+	return uc.seed
+}
+
+// RobotsRule returns the robots.txt rule that disallowed the URL (e.g.
+// "Disallow: /private/"), if any. It is set before the call to the Disallowed
+// extender method.
+func (uc *URLContext) RobotsRule() string {
+	return uc.robotsRule
+}
+
+// LinkInfo returns the metadata of the link that led to the URL, or nil if
+// it was not harvested from a page (e.g. a seed or an URL enqueued via the
+// EnqueueChan). When the same URL is harvested from several pages, the
+// metadata of the first occurrence sticks, as the following ones are
+// filtered as visited.
+func (uc *URLContext) LinkInfo() *LinkInfo {
+	return uc.linkInfo
+}
+
+// Values returns the scratch space of the URL, a map of values by key that
+// the extender methods may use to pass data along the processing of the URL
+// (i.e. from Filter to Visit and Visited), without touching the State. It is
+// allocated on first use. Its lifetime is the URLContext's: the values are
+// not carried to the URLs harvested from the page nor to the target of a
+// redirection, they do not survive an URL enqueued again as a new context,
+// and they are not part of the JSON encoding used by the Frontier. It is
+// not safe for concurrent use, which is fine as an URLContext is processed
+// by one goroutine at a time.
+func (uc *URLContext) Values() map[string]interface{} {
+	if uc.values == nil {
+		uc.values = make(map[string]interface{})
+	}
+	return uc.values
+}
+
+// SetValue sets the value of the key in the scratch space of the URL (see
+// Values).
+func (uc *URLContext) SetValue(key string, value interface{}) {
+	uc.Values()[key] = value
+}
+
+// Value returns the value of the key in the scratch space of the URL (see
+// Values), or nil if it is not set.
+func (uc *URLContext) Value(key string) interface{} {
+	return uc.values[key]
+}
+
+// Tags returns the tags of the URL: those attached to it via the EnqueueItem
+// (or inherited from its source URL) merged onto those of its host, per the
+// HostTags option. It is nil if the URL has no tags. The map is shared and
+// must not be modified.
+func (uc *URLContext) Tags() map[string]string {
+	return uc.tags
+}
+
+// Ancestry returns the chain of referrers of the URL in normalized form,
+// from the seed to the source URL, if the Options' TrackAncestry is set. It is
+// empty for a seed or an URL enqueued without source (e.g. via the
+// EnqueueChan), which starts a fresh chain.
+func (uc *URLContext) Ancestry() []*url.URL {
+	if uc.ancestry == nil || uc.normalizedSourceURL == nil {
+		return nil
+	}
+	res, _ := uc.ancestry.chain(uc.normalizedSourceURL)
+	return append(res, uc.normalizedSourceURL)
+}
+
+// Attempts returns the number of times the URL has been requested, including
+// the requests of previous URL contexts with the same normalized URL (e.g.
+// when it is enqueued again on error). A HEAD request followed by a GET
+// request counts as one attempt.
+func (uc *URLContext) Attempts() int {
+	return uc.attempts
+}
+
+// FetchInfo returns the information of the last fetch of the URL (e.g. its
+// duration, status code and size), or nil if it has not been fetched.
+func (uc *URLContext) FetchInfo() *FetchInfo {
+	return uc.fetchInfo
+}
+
+// VisitedAt returns the time when the URL was previously enqueued, before
+// the Options' RevisitAfter delay expired, or the zero time if it was not
+// enqueued before.
+func (uc *URLContext) VisitedAt() time.Time {
+	return uc.visitedAt
+}
+
+// RobotsNoIndex indicates if the response of the URL must not be indexed,
+// per its robots directives (noindex, none or a past unavailable_after date),
+// when the Options' RespectMetaRobots is set. It is set before the call to
+// the Visit extender method.
+func (uc *URLContext) RobotsNoIndex() bool {
+	return uc.robots.noIndex
+}
+
+// RobotsNoFollow indicates if the links of the response of the URL must not
+// be followed, per its robots directives (nofollow or none), when the
+// Options' RespectMetaRobots is set. The harvested links of the URL are then
+// ignored.
+func (uc *URLContext) RobotsNoFollow() bool {
+	return uc.robots.noFollow
+}
+
+// Return the HTTP client to use for the URL: the client of the crawler, if
+// tuned via the Options' Transport, or the HttpClient.
+func (uc *URLContext) httpClient() *http.Client {
+	if uc.client != nil {
+		return uc.client
+	}
+	return HttpClient
+}
+
 // IsRobotsURL indicates if the URL is a robots.txt URL.
 func (uc *URLContext) IsRobotsURL() bool {
 	return isRobotsURL(uc.normalizedURL)
@@ -62,7 +319,7 @@ func (uc *URLContext) IsRobotsURL() bool {
 
 // cloneForRedirect returns a new URLContext with the given
 // destination URL with the same sourceURL and normalizedSourceURL.
-func (uc *URLContext) cloneForRedirect(dst *url.URL, normFlags purell.NormalizationFlags) *URLContext {
+func (uc *URLContext) cloneForRedirect(dst *url.URL, opts *Options) (*URLContext, error) {
 	var src, normalizedSrc *url.URL
 	if uc.sourceURL != nil {
 		src = &url.URL{}
@@ -84,17 +341,155 @@ func (uc *URLContext) cloneForRedirect(dst *url.URL, normFlags purell.Normalizat
 		*normalizedSrc = *uc.normalizedURL
 	}
 
+	var orig *url.URL
+	if opts.RewriteURL != nil {
+		if orig, dst = rewriteURL(dst, uc, opts); dst == nil {
+			return nil, errRewriteDropped
+		}
+	}
+	// The hashbang fragment is translated in the fetched URL too
+	escapeHashbang(dst, opts)
 	rawDst := &url.URL{}
 	*rawDst = *dst
-	purell.NormalizeURL(dst, normFlags)
-	return &URLContext{
+	dst, err := normalizeURL(dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.FetchNormalized {
+		*rawDst = *dst
+	}
+	applyTrailingSlash(rawDst, opts.TrailingSlashPolicy)
+	ctx := &URLContext{
 		HeadBeforeGet:       uc.HeadBeforeGet,
+		HeadOnly:            uc.HeadOnly,
+		FetchMode:           uc.FetchMode,
 		State:               uc.State,
 		url:                 rawDst,
 		normalizedURL:       dst,
 		sourceURL:           src,
 		normalizedSourceURL: normalizedSrc,
+		originalURL:         orig,
+		priority:            uc.priority,
+		delayOverride:       uc.delayOverride,
+		linkInfo:            uc.linkInfo,
+		paginationDepth:     uc.paginationDepth,
+		depth:               uc.depth,
+		ancestry:            uc.ancestry,
+		redirectChain:       uc.nextRedirectChain(),
+	}
+	// The destination keeps the tags of the URL, along with those of its
+	// host
+	ctx.setTags(uc.urlTags, opts)
+	return ctx, nil
+}
+
+// Normalize the URL based on the normalization options. The URL may be
+// modified in place, the normalized URL is returned. An error is returned
+// if the host is an invalid internationalized domain name. The query
+// parameters are stripped first, so that the URLNormalizer never sees
+// them, then come the normalization flags, the URLNormalizer and the
+// trailing slash policy.
+func normalizeURL(u *url.URL, opts *Options) (*url.URL, error) {
+	if err := normalizeHost(u); err != nil {
+		return nil, err
+	}
+	if len(opts.StripQueryParams) > 0 || opts.StripQueryParamsMatching != nil {
+		stripQueryParams(u, opts)
+	}
+	escapeHashbang(u, opts)
+	if opts.URLNormalizer == nil || opts.URLNormalizerMode == NormalizerAfterPurell {
+		frag := u.Fragment
+		purell.NormalizeURL(u, opts.URLNormalizationFlags)
+		if opts.FragmentPolicy == FragmentsKeep {
+			u.Fragment = frag
+		}
+	}
+	if opts.URLNormalizer != nil {
+		// Pass a copy so that the custom normalizer cannot alter the
+		// caller's URL.
+		cp := *u
+		if nu := opts.URLNormalizer(&cp); nu != nil {
+			u = nu
+		} else {
+			u = &cp
+		}
+	}
+	// The trailing slash policy has the final word over the normalizers
+	applyTrailingSlash(u, opts.TrailingSlashPolicy)
+	canonicalizeURL(u)
+	return u, nil
+}
+
+// Apply the minimal canonicalization of the crawler to the normalized URL,
+// whatever the normalization flags: lowercase scheme and host, no default
+// port and a "/" path instead of an empty one. The normalized URLs are the
+// keys of the visited URLs, of the hosts' workers and of the robots.txt
+// data, so that the forms of the same URL (e.g. "http://host:80" and
+// "http://host/") are never split.
+func canonicalizeURL(u *url.URL) {
+	if u.Host == "" {
+		return
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if p := u.Port(); (p == "80" && u.Scheme == "http") || (p == "443" && u.Scheme == "https") {
+		host = host[:len(host)-len(p)-1]
+	}
+	u.Host = host
+	if u.Path == "" && u.Opaque == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+}
+
+// Convert an internationalized host to its ASCII (punycode) form, so that
+// the Unicode and punycode forms of the same host are considered equal.
+func normalizeHost(u *url.URL) error {
+	h := u.Hostname()
+	if !isIDNHost(h) {
+		return nil
+	}
+	ascii, err := idna.Lookup.ToASCII(h)
+	if err != nil {
+		return err
+	}
+	if p := u.Port(); p != "" {
+		ascii += ":" + p
+	}
+	u.Host = ascii
+	return nil
+}
+
+// Checks if the host is an internationalized domain name, either in Unicode
+// or in punycode form.
+func isIDNHost(h string) bool {
+	for i := 0; i < len(h); i++ {
+		if h[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(h), "xn--")
+}
+
+// Remove the query parameters that should be stripped based on the options.
+// The remaining parameters are encoded in sorted order.
+func stripQueryParams(u *url.URL, opts *Options) {
+	if u.RawQuery == "" {
+		return
+	}
+	q := u.Query()
+	for k := range q {
+		if opts.StripQueryParamsMatching != nil && opts.StripQueryParamsMatching.MatchString(k) {
+			delete(q, k)
+			continue
+		}
+		for _, p := range opts.StripQueryParams {
+			if strings.EqualFold(p, k) {
+				delete(q, k)
+				break
+			}
+		}
 	}
+	u.RawQuery = q.Encode()
 }
 
 // Implement in a private func, because called from HttpClient also (without
@@ -106,6 +501,20 @@ func isRobotsURL(u *url.URL) bool {
 	return strings.ToLower(u.Path) == robotsTxtPath
 }
 
+// Checks if the scheme is part of the allowed schemes, or of the default
+// allowed schemes if none is specified.
+func isAllowedScheme(allowed []string, scheme string) bool {
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedSchemes
+	}
+	for _, s := range allowed {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
 func toStringArrayContextURL(list []*URLContext) string {
 	var buf bytes.Buffer
 
@@ -121,31 +530,47 @@ func toStringArrayContextURL(list []*URLContext) string {
 	return buf.String()
 }
 
-func (uc *URLContext) getRobotsURLCtx() (*URLContext, error) {
+func (uc *URLContext) getRobotsURLCtx(tags map[string]string) (*URLContext, error) {
 	robURL, err := uc.normalizedURL.Parse(robotsTxtPath)
 	if err != nil {
 		return nil, err
 	}
+	// Never request HEAD before GET for robots.txt, always fetch it fully
+	// with GET, with a nil state
 	return &URLContext{
-		false, // Never request HEAD before GET for robots.txt
-		nil,   // Always nil state
-		robURL,
-		robURL,       // Normalized is same as raw
-		uc.sourceURL, // Source and normalized source is same as for current context
-		uc.normalizedSourceURL,
+		url:                 robURL,
+		normalizedURL:       robURL,       // Normalized is same as raw
+		sourceURL:           uc.sourceURL, // Source and normalized source is same as for current context
+		normalizedSourceURL: uc.normalizedSourceURL,
+		tags:                tags, // The tags of the host only
+		depth:               uc.depth,
 	}, nil
 }
 
-func (c *Crawler) toURLContexts(raw interface{}, src *url.URL) []*URLContext {
+// Notify and log an URL that cannot be converted to an URLContext.
+func (c *Crawler) urlError(u interface{}, err error) {
+	if err == errRewriteDropped {
+		c.logFunc(LogTrace, "ignore on rewrite policy: %s", u)
+		return
+	}
+	c.notifyError(newCrawlError(nil, err, CekParseURL))
+	if err == ErrProtocolRelative {
+		c.logFunc(LogError, "ERROR %s: %s", err, u)
+		return
+	}
+	c.logFunc(LogError, "ERROR parsing URL %s", u)
+}
+
+func (c *Crawler) toURLContexts(raw interface{}, src *URLContext) []*URLContext {
 	var res []*URLContext
+	urlError := c.urlError
 
 	mapString := func(v S) {
 		res = make([]*URLContext, 0, len(v))
 		for s, st := range v {
 			ctx, err := c.stringToURLContext(s, src)
 			if err != nil {
-				c.Options.Extender.Error(newCrawlError(nil, err, CekParseURL))
-				c.logFunc(LogError, "ERROR parsing URL %s", s)
+				urlError(s, err)
 			} else {
 				ctx.State = st
 				res = append(res, ctx)
@@ -156,9 +581,13 @@ func (c *Crawler) toURLContexts(raw interface{}, src *url.URL) []*URLContext {
 	mapURL := func(v U) {
 		res = make([]*URLContext, 0, len(v))
 		for u, st := range v {
-			ctx := c.urlToURLContext(u, src)
-			ctx.State = st
-			res = append(res, ctx)
+			ctx, err := c.urlToURLContext(u, src)
+			if err != nil {
+				urlError(u, err)
+			} else {
+				ctx.State = st
+				res = append(res, ctx)
+			}
 		}
 	}
 
@@ -166,12 +595,33 @@ func (c *Crawler) toURLContexts(raw interface{}, src *url.URL) []*URLContext {
 	case *URLContext:
 		res = []*URLContext{v}
 
+	case []*URLContext:
+		res = v
+
+	case EnqueueItem:
+		ctx, err := c.itemToURLContext(v, src)
+		if err != nil {
+			urlError(v.URL, err)
+		} else {
+			res = []*URLContext{ctx}
+		}
+
+	case []EnqueueItem:
+		res = make([]*URLContext, 0, len(v))
+		for _, it := range v {
+			ctx, err := c.itemToURLContext(it, src)
+			if err != nil {
+				urlError(it.URL, err)
+			} else {
+				res = append(res, ctx)
+			}
+		}
+
 	case string:
 		// Convert a single string URL to an URLContext
 		ctx, err := c.stringToURLContext(v, src)
 		if err != nil {
-			c.Options.Extender.Error(newCrawlError(nil, err, CekParseURL))
-			c.logFunc(LogError, "ERROR parsing URL %s", v)
+			urlError(v, err)
 		} else {
 			res = []*URLContext{ctx}
 		}
@@ -182,20 +632,29 @@ func (c *Crawler) toURLContexts(raw interface{}, src *url.URL) []*URLContext {
 		for _, s := range v {
 			ctx, err := c.stringToURLContext(s, src)
 			if err != nil {
-				c.Options.Extender.Error(newCrawlError(nil, err, CekParseURL))
-				c.logFunc(LogError, "ERROR parsing URL %s", s)
+				urlError(s, err)
 			} else {
 				res = append(res, ctx)
 			}
 		}
 
 	case *url.URL:
-		res = []*URLContext{c.urlToURLContext(v, src)}
+		ctx, err := c.urlToURLContext(v, src)
+		if err != nil {
+			urlError(v, err)
+		} else {
+			res = []*URLContext{ctx}
+		}
 
 	case []*url.URL:
 		res = make([]*URLContext, 0, len(v))
 		for _, u := range v {
-			res = append(res, c.urlToURLContext(u, src))
+			ctx, err := c.urlToURLContext(u, src)
+			if err != nil {
+				urlError(u, err)
+			} else {
+				res = append(res, ctx)
+			}
 		}
 
 	case map[string]interface{}:
@@ -218,31 +677,200 @@ func (c *Crawler) toURLContexts(raw interface{}, src *url.URL) []*URLContext {
 	return res
 }
 
-func (c *Crawler) stringToURLContext(str string, src *url.URL) (*URLContext, error) {
+// Convert the links harvested from a visit to URL contexts, along with the
+// metadata of their links.
+func (c *Crawler) harvestedContexts(res *workerResponse) []*URLContext {
+	ctxs := make([]*URLContext, 0, len(res.harvested))
+	for _, l := range res.harvested {
+		if l.ctx != nil {
+			ctxs = append(ctxs, l.ctx)
+			continue
+		}
+		ctx, err := c.itemToURLContext(l.item, res.ctx)
+		if err != nil {
+			c.urlError(l.item.URL, err)
+			continue
+		}
+		info := l.info
+		ctx.linkInfo = info
+		if info != nil && info.Pagination && hasToken(info.Rel, "next") {
+			ctx.paginationDepth = res.ctx.paginationDepth + 1
+		}
+		if info != nil && info.Asset != "" {
+			// The assets are only checked, unless the Filter says otherwise
+			ctx.HeadOnly = true
+		}
+		ctxs = append(ctxs, ctx)
+	}
+	return ctxs
+}
+
+// Return the metadata of the link at the specified index of the page.
+func newLinkInfo(sel *goquery.Selection, index int) *LinkInfo {
+	info := &LinkInfo{
+		Text:  strings.Join(strings.Fields(sel.Text()), " "),
+		Tag:   sel.Nodes[0].Data,
+		Index: index,
+	}
+	if len(info.Text) > MaxLinkTextLen {
+		// Truncate at a rune boundary
+		n := MaxLinkTextLen
+		for n > 0 && !utf8.RuneStart(info.Text[n]) {
+			n--
+		}
+		info.Text = info.Text[:n]
+	}
+	if rel, ok := sel.Attr("rel"); ok {
+		info.Rel = strings.Fields(strings.ToLower(rel))
+		for _, r := range info.Rel {
+			switch r {
+			case "nofollow":
+				info.NoFollow = true
+			case "next", "prev":
+				info.Pagination = true
+			}
+		}
+	}
+	return info
+}
+
+// Convert the enqueue item to an URL context, with its state and per-URL
+// settings.
+func (c *Crawler) itemToURLContext(it EnqueueItem, src *URLContext) (*URLContext, error) {
+	if it.URL == nil {
+		return nil, errors.New("nil URL to enqueue")
+	}
+	// Copy the URL, as the normalization alters it
+	u := *it.URL
+	ctx, err := c.urlToURLContext(&u, src)
+	if err != nil {
+		return nil, err
+	}
+	ctx.State = it.State
+	if it.HeadBeforeGet != nil {
+		ctx.HeadBeforeGet = *it.HeadBeforeGet
+	}
+	ctx.HeadOnly = it.HeadOnly
+	ctx.FetchMode = it.FetchMode
+	ctx.priority = it.Priority
+	if len(it.Tags) > 0 {
+		ctx.setTags(mergeTags(ctx.urlTags, copyTags(it.Tags)), c.opts())
+	}
+	return ctx, nil
+}
+
+func (c *Crawler) stringToURLContext(str string, src *URLContext) (*URLContext, error) {
 	u, err := url.Parse(str)
 	if err != nil {
 		return nil, err
 	}
-	return c.urlToURLContext(u, src), nil
+	return c.urlToURLContext(u, src)
 }
 
-func (c *Crawler) urlToURLContext(u, src *url.URL) *URLContext {
-	var rawSrc *url.URL
+func (c *Crawler) urlToURLContext(u *url.URL, src *URLContext) (*URLContext, error) {
+	var rawSrc, normSrc, orig *url.URL
+	var err error
 
+	// A protocol-relative URL takes the scheme of its source, before the
+	// normalization and the same host policy.
+	if u.Scheme == "" && u.Host != "" {
+		if src == nil {
+			return nil, ErrProtocolRelative
+		}
+		cp := *u
+		cp.Scheme = src.url.Scheme
+		u = &cp
+	}
+	if c.opts().RewriteURL != nil {
+		if orig, u = rewriteURL(u, src, c.opts()); u == nil {
+			return nil, errRewriteDropped
+		}
+	}
+	// The hashbang fragment is translated in the fetched URL too
+	escapeHashbang(u, c.opts())
 	rawU := *u
-	purell.NormalizeURL(u, c.Options.URLNormalizationFlags)
+	if u, err = normalizeURL(u, c.opts()); err != nil {
+		return nil, err
+	}
+	if c.opts().FetchNormalized {
+		rawU = *u
+	}
+	// The fetched URL has the trailing slash of the normalized one
+	applyTrailingSlash(&rawU, c.opts().TrailingSlashPolicy)
 	if src != nil {
 		rawSrc = &url.URL{}
-		*rawSrc = *src
-		purell.NormalizeURL(src, c.Options.URLNormalizationFlags)
+		*rawSrc = *src.url
+		normSrc = &url.URL{}
+		*normSrc = *src.normalizedURL
 	}
 
-	return &URLContext{
-		c.Options.HeadBeforeGet,
-		nil,
-		&rawU,
-		u,
-		rawSrc,
-		src,
+	depth := 0
+	if src != nil {
+		depth = src.depth + 1
+	}
+
+	headBeforeGet := c.opts().HeadBeforeGet
+	if hbg := c.opts().hostOptions(u.Host).HeadBeforeGet; hbg != nil {
+		headBeforeGet = *hbg
+	}
+
+	ctx := &URLContext{
+		HeadBeforeGet:       headBeforeGet,
+		url:                 &rawU,
+		normalizedURL:       u,
+		sourceURL:           rawSrc,
+		normalizedSourceURL: normSrc,
+		originalURL:         orig,
+		depth:               depth,
+	}
+	// The harvested URLs inherit the tags attached to their source
+	var urlTags map[string]string
+	if src != nil {
+		urlTags = src.urlTags
+	}
+	ctx.setTags(urlTags, c.opts())
+	return ctx, nil
+}
+
+// The error returned when the RewriteURL option drops an URL, it is not
+// notified.
+var errRewriteDropped = errors.New("URL dropped by RewriteURL")
+
+// Rewrite the URL with the RewriteURL option. It returns the original URL if
+// it was rewritten, and the URL to use, which is nil if the URL is dropped.
+func rewriteURL(u *url.URL, src *URLContext, opts *Options) (orig, res *url.URL) {
+	cp := *u
+	if res = opts.RewriteURL(&cp, src); res == nil {
+		return nil, nil
+	}
+	if res.String() != u.String() {
+		orig = &url.URL{}
+		*orig = *u
+	}
+	return orig, res
+}
+
+// fetchAttempts holds the number of fetch attempts of each URL, keyed by the
+// normalized URL. It is shared by the workers, so it is safe for concurrent
+// use.
+type fetchAttempts struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func newFetchAttempts() *fetchAttempts {
+	return &fetchAttempts{count: make(map[string]int)}
+}
+
+// Increment the number of attempts of the normalized URL, and return it. A
+// nil fetchAttempts only counts the current attempt.
+func (fa *fetchAttempts) inc(u *url.URL) int {
+	if fa == nil {
+		return 1
 	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	key := u.String()
+	fa.count[key]++
+	return fa.count[key]
 }
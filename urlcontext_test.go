@@ -1,7 +1,9 @@
 package gocrawl
 
 import (
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/PuerkitoBio/purell"
@@ -16,7 +18,10 @@ func TestCloneForRedirect(t *testing.T) {
 	ctx1.HeadBeforeGet = true
 	ctx1.State = 1
 	p2, _ := ctx1.URL().Parse("/p2")
-	ctx2 := ctx1.cloneForRedirect(p2, c.Options.URLNormalizationFlags)
+	ctx2, err := ctx1.cloneForRedirect(p2, c.Options)
+	if err != nil {
+		t.Fatalf("failed to clone for redirect: %v", err)
+	}
 
 	var got string
 	wantSrc := "http://localhost/p1"
@@ -56,7 +61,10 @@ func TestCloneForRedirect(t *testing.T) {
 
 	// test 2: redirect again from p2 to p3, should keep p1 as source
 	p3, _ := ctx2.URL().Parse("/p3")
-	ctx3 := ctx2.cloneForRedirect(p3, c.Options.URLNormalizationFlags)
+	ctx3, err := ctx2.cloneForRedirect(p3, c.Options)
+	if err != nil {
+		t.Fatalf("failed to clone for redirect: %v", err)
+	}
 	if src := ctx3.SourceURL(); src != nil {
 		got = src.String()
 	}
@@ -92,3 +100,133 @@ func TestCloneForRedirect(t *testing.T) {
 		t.Error("want HeadBeforeGet to be true")
 	}
 }
+
+func TestNormalizeURLModes(t *testing.T) {
+	lowerPath := func(u *url.URL) *url.URL {
+		u.Path = strings.ToLower(u.Path)
+		return u
+	}
+	cases := []struct {
+		mode NormalizerMode
+		in   string
+		want string
+	}{
+		{NormalizerAfterPurell, "http://localhost/A", "http://localhost/a/"},
+		{NormalizerReplacePurell, "http://localhost/A", "http://localhost/a"},
+	}
+	for i, c := range cases {
+		opts := NewOptions(&DefaultExtender{})
+		opts.URLNormalizationFlags = purell.FlagAddTrailingSlash
+		opts.URLNormalizer = lowerPath
+		opts.URLNormalizerMode = c.mode
+
+		u, err := url.Parse(c.in)
+		if err != nil {
+			t.Fatalf("%d: failed to parse URL %s", i, c.in)
+		}
+		raw := *u
+		got, err := normalizeURL(u, opts)
+		if err != nil {
+			t.Fatalf("%d: failed to normalize URL %s: %v", i, c.in, err)
+		}
+		if got.String() != c.want {
+			t.Errorf("%d: want %s, got %s", i, c.want, got)
+		}
+		if c.mode == NormalizerReplacePurell && u.String() != raw.String() {
+			t.Errorf("%d: want input URL unchanged, got %s", i, u)
+		}
+	}
+}
+
+func TestNormalizeURLCanonical(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"http://host:80/page.html", "http://host/page.html"},
+		{"HTTP://Host:80", "http://host/"},
+		{"http://host", "http://host/"},
+		{"https://host:443/", "https://host/"},
+		{"http://host:443/", "http://host:443/"},
+		{"https://host:80/", "https://host:80/"},
+		{"http://host:8080", "http://host:8080/"},
+		{"/relative", "/relative"},
+	}
+	// Whatever the normalization flags
+	for _, flags := range []purell.NormalizationFlags{0, purell.FlagsUsuallySafeGreedy} {
+		opts := NewOptions(&DefaultExtender{})
+		opts.URLNormalizationFlags = flags
+		for i, c := range cases {
+			u, err := url.Parse(c.in)
+			if err != nil {
+				t.Fatalf("%d: failed to parse URL %s", i, c.in)
+			}
+			got, err := normalizeURL(u, opts)
+			if err != nil {
+				t.Fatalf("%d: failed to normalize URL %s: %v", i, c.in, err)
+			}
+			if got.String() != c.want {
+				t.Errorf("%d: want %s, got %s", i, c.want, got)
+			}
+		}
+	}
+}
+
+func TestNormalizeURLIDNHost(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"http://münchen.example/a", "http://xn--mnchen-3ya.example/a", false},
+		{"http://MÜNCHEN.example:8080/a", "http://xn--mnchen-3ya.example:8080/a", false},
+		{"http://xn--mnchen-3ya.example/a", "http://xn--mnchen-3ya.example/a", false},
+		{"http://localhost/a", "http://localhost/a", false},
+		{"http://-münchen.example/a", "", true},
+	}
+	opts := NewOptions(&DefaultExtender{})
+	opts.URLNormalizationFlags = 0
+	for i, c := range cases {
+		u, err := url.Parse(c.in)
+		if err != nil {
+			t.Fatalf("%d: failed to parse URL %s", i, c.in)
+		}
+		got, err := normalizeURL(u, opts)
+		if (err != nil) != c.err {
+			t.Errorf("%d: want error %t, got %v", i, c.err, err)
+			continue
+		}
+		if err == nil && got.String() != c.want {
+			t.Errorf("%d: want %s, got %s", i, c.want, got)
+		}
+	}
+}
+
+func TestProtocolRelativeURL(t *testing.T) {
+	c := NewCrawler(&DefaultExtender{})
+	c.Options.URLNormalizationFlags = purell.FlagsUsuallySafeGreedy
+	for _, scheme := range []string{"http", "https"} {
+		src, _ := c.stringToURLContext(scheme+"://host/dir/page.html", nil)
+		for _, s := range []string{"//host/other.html", "//HOST/other.html"} {
+			ctx, err := c.stringToURLContext(s, src)
+			if err != nil {
+				t.Errorf("%s from %s: %s", s, src.URL(), err)
+				continue
+			}
+			if want := scheme + "://host/other.html"; ctx.NormalizedURL().String() != want {
+				t.Errorf("%s from %s: want %s, got %s", s, src.URL(), want, ctx.NormalizedURL())
+			}
+			if ctx.URL().Scheme != scheme {
+				t.Errorf("%s from %s: want the %s scheme, got %s", s, src.URL(), scheme, ctx.URL())
+			}
+			if !c.isSameHost(ctx) {
+				t.Errorf("%s from %s: want the same host", s, src.URL())
+			}
+		}
+	}
+
+	// No source to resolve it against
+	if _, err := c.stringToURLContext("//host/page.html", nil); err != ErrProtocolRelative {
+		t.Errorf("want ErrProtocolRelative, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package gocrawl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Add the normalized URL to the visited set, or remove it. It must only be
+// called by the crawler's goroutine.
+func (c *Crawler) setVisited(normalized string, visited bool) {
+	c.visitedMu.Lock()
+	defer c.visitedMu.Unlock()
+	if visited {
+		c.visited[normalized] = c.opts().getClock().Now()
+	} else {
+		delete(c.visited, normalized)
+	}
+}
+
+// Add the URL to the pending set, once accepted in the queue of its host, or
+// remove it once processed or dropped. The set counts the contexts of each
+// normalized URL, as an URL may be enqueued again explicitly while it is
+// pending. It must only be called by the crawler's goroutine.
+func (c *Crawler) setPending(ctx *URLContext, pending bool) {
+	key := ctx.normalizedURL.String()
+	if pending {
+		c.pending[key]++
+	} else if c.pending[key] > 1 {
+		c.pending[key]--
+	} else {
+		delete(c.pending, key)
+	}
+}
+
+// IsVisited indicates if the URL is in the visited set of the crawler, once
+// normalized like the harvested URLs (e.g. with the URLNormalizationFlags
+// and StripQueryParams options). An URL is added to the visited set when it
+// is enqueued, so it may not be fetched yet while the crawler is running, or
+// it may have been disallowed by the robots.txt. The set is kept once the
+// crawl is done, until the next run. It is safe to call it while the crawler
+// is running. It returns false if the URL is invalid or not absolute.
+func (c *Crawler) IsVisited(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() {
+		return false
+	}
+	if u, err = normalizeURL(u, c.opts()); err != nil {
+		return false
+	}
+	c.visitedMu.RLock()
+	defer c.visitedMu.RUnlock()
+	_, ok := c.visited[u.String()]
+	return ok
+}
+
+// VisitedURLs returns the normalized URLs of the visited set, sorted. See
+// IsVisited for the URLs of the visited set. It is safe to call it while the
+// crawler is running. EachVisited avoids the allocation of the whole set.
+func (c *Crawler) VisitedURLs() []string {
+	c.visitedMu.RLock()
+	res := make([]string, 0, len(c.visited))
+	for u := range c.visited {
+		res = append(res, u)
+	}
+	c.visitedMu.RUnlock()
+	sort.Strings(res)
+	return res
+}
+
+// EachVisited calls fn for each normalized URL of the visited set, along with
+// the time it was added to the set, in no particular order, until fn returns
+// false. It is safe to call it while the crawler is running, but the crawler
+// cannot enqueue URLs until it returns, so fn should be fast and must not
+// call the methods of the crawler.
+func (c *Crawler) EachVisited(fn func(u string, at time.Time) bool) {
+	c.visitedMu.RLock()
+	defer c.visitedMu.RUnlock()
+	for u, at := range c.visited {
+		if !fn(u, at) {
+			return
+		}
+	}
+}
+
+// ExportVisited writes the visited set to w, one normalized URL per line,
+// sorted, followed by a tab and the time it was added to the set (in the
+// RFC 3339 format, UTC). See IsVisited for the URLs of the visited set. It
+// is safe to call it while the crawler is running, typically it is called
+// once Run returns to persist the set for the next crawl.
+func (c *Crawler) ExportVisited(w io.Writer) error {
+	c.visitedMu.RLock()
+	lines := make([]string, 0, len(c.visited))
+	for u, at := range c.visited {
+		lines = append(lines, u+"\t"+at.UTC().Format(time.RFC3339Nano))
+	}
+	c.visitedMu.RUnlock()
+	sort.Strings(lines)
+
+	bw := bufio.NewWriter(w)
+	for _, l := range lines {
+		if _, err := bw.WriteString(l + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportVisited adds the URLs read from r to the visited set, in the format
+// written by ExportVisited. The time is optional, the URLs without time are
+// added as visited now. The blank lines and the lines starting with "#" are
+// skipped. The URLs are normalized with the current Options, and the
+// imported URLs are kept for the next run, even if the Options'
+// RevisitAfter is not set, so that the Filter extender method receives
+// isVisited=true for them. Without RevisitAfter, they replace the visited
+// set of the previous run, otherwise they are added to it. The URLs of
+// several imports before a run add up. Nothing is imported if the input is
+// invalid, the error tells the line number. It cannot be called while the
+// crawler is running.
+func (c *Crawler) ImportVisited(r io.Reader) error {
+	c.enqMu.Lock()
+	defer c.enqMu.Unlock()
+	if c.inRun {
+		return errors.New("cannot import the visited URLs while the crawler is running")
+	}
+
+	now := c.opts().getClock().Now()
+	imported := make(map[string]time.Time)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, at := line, now
+		if i := strings.IndexByte(line, '\t'); i >= 0 {
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(line[i+1:]))
+			if err != nil {
+				return fmt.Errorf("line %d: invalid time: %s", n, err)
+			}
+			raw, at = strings.TrimSpace(line[:i]), t
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		if !u.IsAbs() {
+			return fmt.Errorf("line %d: URL is not absolute: %s", n, raw)
+		}
+		if u, err = normalizeURL(u, c.opts()); err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		imported[u.String()] = at
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	c.visitedMu.Lock()
+	defer c.visitedMu.Unlock()
+	if c.visited == nil || (c.opts().RevisitAfter <= 0 && !c.imported) {
+		// The visited set of the previous run is not kept for the next
+		c.visited = make(map[string]time.Time, len(imported))
+	}
+	for u, at := range imported {
+		c.visited[u] = at
+	}
+	c.imported = true
+	return nil
+}
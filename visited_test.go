@@ -0,0 +1,162 @@
+package gocrawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImportVisitedErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"URL", "http://a/\n# comment\n\nhttp://a/%zz\n", "line 4: "},
+		{"NotAbsolute", "http://a/\n/b\n", "line 2: URL is not absolute: /b"},
+		{"Time", "http://a/\t2020-01-01T00:00:00Z\nhttp://b/\tyesterday\n", "line 2: invalid time: "},
+	}
+	for _, c := range cases {
+		cr := NewCrawler(new(DefaultExtender))
+		err := cr.ImportVisited(strings.NewReader(c.input))
+		if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+			t.Errorf("%s: want error %q, got %v", c.name, c.err, err)
+		}
+		// Nothing is imported on error
+		if urls := cr.VisitedURLs(); len(urls) != 0 {
+			t.Errorf("%s: want no imported URLs, got %v", c.name, urls)
+		}
+	}
+
+	// The comments, the blank lines and the missing times are accepted
+	cr := NewCrawler(new(DefaultExtender))
+	if err := cr.ImportVisited(strings.NewReader("# visited\n\nHTTP://A/b\nhttp://a/c\t2020-01-01T00:00:00Z\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !cr.IsVisited("http://a/b") || !cr.IsVisited("http://a/c") {
+		t.Errorf("want the URLs to be imported, got %v", cr.VisitedURLs())
+	}
+}
+
+func TestImportVisitedReplaces(t *testing.T) {
+	for _, revisit := range []time.Duration{0, time.Hour} {
+		cr := NewCrawler(new(DefaultExtender))
+		cr.Options.RevisitAfter = revisit
+		// The visited set of a previous run
+		cr.visited = map[string]time.Time{"http://a/old": time.Now()}
+		for _, u := range []string{"http://a/b", "http://a/c"} {
+			if err := cr.ImportVisited(strings.NewReader(u + "\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !cr.IsVisited("http://a/b") || !cr.IsVisited("http://a/c") {
+			t.Errorf("%v: want the URLs of both imports, got %v", revisit, cr.VisitedURLs())
+		}
+		if old := cr.IsVisited("http://a/old"); old != (revisit > 0) {
+			t.Errorf("%v: want the previous run kept=%v, got %v", revisit, revisit > 0, cr.VisitedURLs())
+		}
+	}
+}
+
+func TestRevisitAfterClock(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start, false)
+	ff := newFileFetcher()
+	opts := NewOptions(nil)
+	opts.SameHostOnly = true
+	opts.CrawlDelay = 0
+	opts.WorkerIdleTTL = 0
+	opts.RevisitAfter = time.Hour
+	opts.LogFlags = LogNone
+	opts.Clock = clock
+	c := NewCrawlerWithOptions(opts)
+
+	run := func(name string, visits int) {
+		spy := newSpy(ff, true)
+		spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+			return !isVisited
+		})
+		opts.Extender = spy
+		c.Run([]string{"http://hosta/page1.html", "http://hosta/page4.html"})
+		assertCallCount(spy, name, eMKVisit, visits, t)
+	}
+
+	run("first", 5)
+	// The visits are fresh until RevisitAfter has passed on the clock
+	clock.set(start.Add(opts.RevisitAfter - time.Second))
+	run("fresh", 0)
+	clock.set(start.Add(opts.RevisitAfter))
+	run("stale", 5)
+}
+
+func TestPendingDuplicates(t *testing.T) {
+	// Each page links to all the others
+	pages := []string{"/", "/a", "/b", "/c", "/d"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		for _, p := range pages {
+			fmt.Fprintf(w, `<a href="%s">%s</a>`, p, p)
+		}
+	}))
+	defer srv.Close()
+
+	// The Filter allows the URLs until they are visited, and the URL
+	// enqueued again explicitly
+	var mu sync.Mutex
+	done := make(map[string]bool)
+	spy := newSpy(new(DefaultExtender), true)
+	spy.setExtensionMethod(eMKFilter, func(ctx *URLContext, isVisited bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctx.State == "again" || !done[ctx.url.Path]
+	})
+	spy.setExtensionMethod(eMKVisited, func(ctx *URLContext, harvested interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		done[ctx.url.Path] = true
+	})
+	var c *Crawler
+	once := false
+	spy.setExtensionMethod(eMKEnqueued, func(ctx *URLContext) {
+		if ctx.url.Path == "/a" && !once {
+			once = true
+			u := *ctx.url
+			if err := c.Enqueue(EnqueueItem{URL: &u, State: "again"}); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.LogFlags = LogAll
+	c = NewCrawlerWithOptions(opts)
+	if err := c.Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The links found again while pending are not fetched twice, only the
+	// URL enqueued explicitly is
+	fetches := make(map[string]int)
+	spy.m.RLock()
+	for _, args := range spy.calledWith[eMKFetch] {
+		fetches[args[0].(*URLContext).url.Path]++
+	}
+	spy.m.RUnlock()
+	want := map[string]int{"/robots.txt": 1, "/": 1, "/a": 2, "/b": 1, "/c": 1, "/d": 1}
+	for p, n := range want {
+		if fetches[p] != n {
+			t.Errorf("%s: want %d fetches, got %d", p, n, fetches[p])
+		}
+	}
+	assertCallCount(spy, "PendingDuplicates", eMKFetch, 7, t)
+	assertCallCount(spy, "PendingDuplicates", eMKVisit, 6, t)
+	assertIsInLog("PendingDuplicates", spy.b, fmt.Sprintf("ignore on duplicate policy, already pending: %s/b", srv.URL), t)
+}
@@ -0,0 +1,85 @@
+package gocrawl
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// VisitFunc visits the response of an URL whose Content-Type matches the
+// pattern it was registered with by Crawler.RegisterVisitor. The body is the
+// content of the response, which can also be read from the response's Body.
+// The goquery document is only parsed for the HTML and XHTML content types,
+// it is nil otherwise. It returns the harvested URLs and whether the links
+// should be processed by the crawler, like the Visit extender method.
+type VisitFunc func(ctx *URLContext, res *http.Response, body []byte, doc *goquery.Document) (harvested interface{}, findLinks bool)
+
+// A visitor registered for a media type pattern.
+type visitor struct {
+	pattern string
+	fn      VisitFunc
+}
+
+// RegisterVisitor registers a visit function for the responses whose media
+// type, as parsed from the Content-Type header, matches the pattern. The
+// pattern is a media type such as "application/pdf", or a pattern such as
+// "text/*" or "application/*+json" as supported by path.Match. An exact
+// pattern has precedence over the wildcard patterns, which are tried in
+// registration order. The responses that match no pattern are visited by the
+// Extender's Visit method. It must be called before Run, it returns an error
+// if the crawler is running or if the pattern is invalid.
+func (c *Crawler) RegisterVisitor(pattern string, fn VisitFunc) error {
+	if fn == nil {
+		return errors.New("nil visit function")
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.Count(pattern, "/") != 1 {
+		return fmt.Errorf("invalid media type pattern %q", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid media type pattern %q: %s", pattern, err)
+	}
+
+	c.enqMu.Lock()
+	defer c.enqMu.Unlock()
+	if c.running {
+		return errors.New("cannot register a visitor while the crawler is running")
+	}
+	c.visitors = append(c.visitors, &visitor{pattern, fn})
+	return nil
+}
+
+// Return the visit function registered for the Content-Type of the
+// response, or nil if there is none.
+func matchVisitor(visitors []*visitor, res *http.Response) VisitFunc {
+	if len(visitors) == 0 {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	for _, v := range visitors {
+		if v.pattern == mt {
+			return v.fn
+		}
+	}
+	for _, v := range visitors {
+		if ok, _ := path.Match(v.pattern, mt); ok {
+			return v.fn
+		}
+	}
+	return nil
+}
+
+// Indicates if the goquery document should be parsed for the Content-Type
+// of a response visited by a registered visitor.
+func isHTMLResponse(res *http.Response) bool {
+	mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return mt == "text/html" || mt == "application/xhtml+xml"
+}
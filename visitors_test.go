@@ -0,0 +1,67 @@
+package gocrawl
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestMatchVisitor(t *testing.T) {
+	c := NewCrawler(new(DefaultExtender))
+	var got string
+	register := func(pattern string) {
+		if err := c.RegisterVisitor(pattern, func(*URLContext, *http.Response, []byte, *goquery.Document) (interface{}, bool) {
+			got = pattern
+			return nil, false
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("application/*+json")
+	register("text/*")
+	register("text/html")
+	register("Application/PDF")
+
+	cases := []struct {
+		ct   string
+		want string
+	}{
+		{"text/html; charset=utf-8", "text/html"},
+		{"TEXT/HTML", "text/html"},
+		{"text/plain", "text/*"},
+		{"application/ld+json; charset=utf-8", "application/*+json"},
+		{"application/json", ""},
+		{"application/pdf", "Application/PDF"},
+		{"image/png", ""},
+		{"", ""},
+		{"not a media type;;", ""},
+	}
+	for _, c2 := range cases {
+		got = ""
+		res := &http.Response{Header: http.Header{"Content-Type": {c2.ct}}}
+		if fn := matchVisitor(c.visitors, res); fn != nil {
+			fn(nil, res, nil, nil)
+		}
+		if got != c2.want {
+			t.Errorf("%q: want the %q visitor, got %q", c2.ct, c2.want, got)
+		}
+	}
+}
+
+func TestRegisterVisitorErrors(t *testing.T) {
+	c := NewCrawler(new(DefaultExtender))
+	fn := func(*URLContext, *http.Response, []byte, *goquery.Document) (interface{}, bool) { return nil, false }
+	for _, p := range []string{"", "text", "text/html/x", "text/[", "*/*/*"} {
+		if err := c.RegisterVisitor(p, fn); err == nil {
+			t.Errorf("%q: want an error", p)
+		}
+	}
+	if err := c.RegisterVisitor("text/html", nil); err == nil {
+		t.Error("want an error for a nil function")
+	}
+	c.running = true
+	if err := c.RegisterVisitor("text/html", fn); err == nil {
+		t.Error("want an error while running")
+	}
+}
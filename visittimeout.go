@@ -0,0 +1,100 @@
+package gocrawl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// The deadline of a visit, with the VisitTimeout option. The worker abandons
+// the visit on expiry, unless it completed meanwhile, and the goroutine of
+// the visit then drops its result instead of notifying it.
+type visitDeadline struct {
+	ctx       context.Context
+	mu        sync.Mutex
+	abandoned bool
+	completed bool
+}
+
+// Return the context of the visit, which is never done without deadline.
+func (dl *visitDeadline) context() context.Context {
+	if dl == nil {
+		return context.Background()
+	}
+	return dl.ctx
+}
+
+// Call f unless the visit was abandoned, in which case false is returned.
+// The worker waits for f to return before abandoning the visit, so that
+// nothing is notified for the visit once the worker moved on.
+func (dl *visitDeadline) do(f func()) bool {
+	if dl == nil {
+		f()
+		return true
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.abandoned {
+		return false
+	}
+	f()
+	return true
+}
+
+// Call f to complete the visit unless it was abandoned, in which case false
+// is returned. The visit cannot be abandoned afterwards.
+func (dl *visitDeadline) complete(f func()) bool {
+	return dl.do(func() {
+		if dl != nil {
+			dl.completed = true
+		}
+		f()
+	})
+}
+
+// Abandon the visit, unless it completed. Returns false if it completed.
+func (dl *visitDeadline) abandon() bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.completed {
+		return false
+	}
+	dl.abandoned = true
+	return true
+}
+
+// Run the visit of the URL within the VisitTimeout deadline, if set, and
+// return its harvested links. The visit returns false if it was abandoned.
+// On expiry, the visit is notified as timed out, without harvested links,
+// and it keeps running in its goroutine until it returns.
+func (w *worker) visitWithin(ctx *URLContext, res *http.Response, visit func(*visitDeadline) ([]harvestedLink, bool)) []harvestedLink {
+	if w.opts.VisitTimeout <= 0 {
+		links, _ := visit(nil)
+		return links
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), w.opts.VisitTimeout)
+	defer cancel()
+	dl := &visitDeadline{ctx: c}
+	done := make(chan []harvestedLink, 1)
+	go func() {
+		if links, ok := visit(dl); ok {
+			done <- links
+		}
+	}()
+
+	select {
+	case links := <-done:
+		return links
+	case <-c.Done():
+	}
+	if !dl.abandon() {
+		// Completed right on the deadline
+		return <-done
+	}
+	w.notifyError(newCrawlErrorMessage(ctx, fmt.Sprintf("visit not done within %v", w.opts.VisitTimeout), CekVisitTimeout))
+	w.logFunc(LogError, "ERROR visit timeout for %s after %v", ctx.url, w.opts.VisitTimeout)
+	w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitTimedOut})
+	return nil
+}
@@ -0,0 +1,127 @@
+package gocrawl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Return the server of the visit timeout tests, whose root links the slow
+// page before the others.
+func newVisitTimeoutServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/slow">slow</a><a href="/a">a</a><a href="/b">b</a>`)
+		case "/slow":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/c">c</a>`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/">home</a>`)
+		}
+	}))
+}
+
+// Assert that the visit of the slow page timed out, and that the other
+// pages were visited.
+func assertVisitTimeout(name string, spy *spyExtender, srv *httptest.Server, elapsed time.Duration, t *testing.T) {
+	if elapsed > time.Second {
+		t.Errorf("%s: want the crawl done despite the slow visit, took %v", name, elapsed)
+	}
+	assertCallCount(spy, name, eMKVisited, 3, t)
+	assertCallCount(spy, name, eMKError, 1, t)
+	spy.m.RLock()
+	defer spy.m.RUnlock()
+	for _, args := range spy.calledWith[eMKVisited] {
+		if p := args[0].(*URLContext).URL().Path; p == "/slow" {
+			t.Errorf("%s: want the slow page not notified as visited", name)
+		}
+	}
+	if errs := spy.calledWith[eMKError]; len(errs) == 1 {
+		err := errs[0][0].(*CrawlError)
+		if err.Kind != CekVisitTimeout || err.Ctx.URL().Path != "/slow" {
+			t.Errorf("%s: want the visit timeout of /slow, got %v (%s)", name, err, err.Kind)
+		}
+	}
+	// The links of the slow page are not harvested
+	assertIsInLog(name, spy.b, fmt.Sprintf("ERROR visit timeout for %s/slow after 50ms\n", srv.URL), t)
+	assertIsNotInLog(name, spy.b, srv.URL+"/c", t)
+}
+
+func TestVisitTimeout(t *testing.T) {
+	srv := newVisitTimeoutServer()
+	defer srv.Close()
+
+	// The slow visit is released once the test is done
+	release := make(chan struct{})
+	defer close(release)
+	spy := newSpy(new(DefaultExtender), true)
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		if ctx.URL().Path == "/slow" {
+			<-release
+		}
+		return nil, true
+	})
+	opts := NewOptions(spy)
+	opts.CrawlDelay = 0
+	opts.VisitTimeout = 50 * time.Millisecond
+	opts.LogFlags = LogAll
+	start := time.Now()
+	if err := NewCrawlerWithOptions(opts).Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	assertVisitTimeout("VisitTimeout", spy, srv, time.Since(start), t)
+	assertCallCount(spy, "VisitTimeout", eMKVisit, 4, t)
+}
+
+// The extender of the visit timeout test that implements VisitCtx.
+type visitCtxExtender struct {
+	*spyExtender
+	done chan error
+}
+
+func (x *visitCtxExtender) VisitCtx(c context.Context, ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+	if ctx.URL().Path == "/slow" {
+		<-c.Done()
+		x.done <- c.Err()
+		return nil, true
+	}
+	return x.spyExtender.Visit(ctx, res, doc)
+}
+
+func TestVisitCtx(t *testing.T) {
+	srv := newVisitTimeoutServer()
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), true)
+	ext := &visitCtxExtender{spy, make(chan error, 1)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.VisitTimeout = 50 * time.Millisecond
+	opts.LogFlags = LogAll
+	start := time.Now()
+	if err := NewCrawlerWithOptions(opts).Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	assertVisitTimeout("VisitCtx", spy, srv, time.Since(start), t)
+
+	// The slow visit is signalled, and VisitCtx is called instead of Visit
+	select {
+	case err := <-ext.done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("want the context of the visit done on its deadline, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("want the context of the slow visit done")
+	}
+	assertCallCount(spy, "VisitCtx", eMKVisit, 3, t)
+}
@@ -0,0 +1,184 @@
+package gocrawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const warcDateFormat = "2006-01-02T15:04:05Z"
+
+// warcWriter writes the fetched requests and responses as WARC 1.1 records,
+// optionally compressing each record as a separate gzip member. It is shared
+// by the workers, so it is safe for concurrent use.
+type warcWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	gzip bool
+}
+
+func newWARCWriter(w io.Writer, gz bool) *warcWriter {
+	return &warcWriter{w: w, gzip: gz}
+}
+
+// Wrap the response body so that the request and response records are
+// written once it is closed. The errors are reported to onError.
+func (ww *warcWriter) wrap(res *http.Response, date time.Time, onError func(error)) io.ReadCloser {
+	return &warcBody{ReadCloser: res.Body, res: res, date: date, ww: ww, onError: onError}
+}
+
+// Write the request record, if the request is available, and the response
+// record of the exchange. The truncated reason, if any, is set in the
+// WARC-Truncated header of the response record.
+func (ww *warcWriter) writeExchange(res *http.Response, body []byte, date time.Time, truncated string) error {
+	var block bytes.Buffer
+
+	major, minor := res.ProtoMajor, res.ProtoMinor
+	if major == 0 {
+		major, minor = 1, 1
+	}
+	fmt.Fprintf(&block, "HTTP/%d.%d %s\r\n", major, minor, res.Status)
+	if err := res.Header.Write(&block); err != nil {
+		return err
+	}
+	block.WriteString("\r\n")
+	block.Write(body)
+
+	uri, resID := "", newWARCRecordID()
+	if res.Request != nil && res.Request.URL != nil {
+		uri = res.Request.URL.String()
+	}
+	resHeaders := [][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", resID},
+		{"WARC-Date", date.UTC().Format(warcDateFormat)},
+		{"WARC-Target-URI", uri},
+		{"Content-Type", "application/http;msgtype=response"},
+		{"WARC-Payload-Digest", warcDigest(body)},
+	}
+	if truncated != "" {
+		resHeaders = append(resHeaders, [2]string{"WARC-Truncated", truncated})
+	}
+
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+	if res.Request != nil {
+		// The request as sent on the wire, with the headers of the transport.
+		// It is dumped with a fake round trip, out of the context of the fetch
+		// (i.e. its trace and its deadline).
+		req, err := httputil.DumpRequestOut(res.Request.WithContext(context.Background()), false)
+		if err != nil {
+			return err
+		}
+		if err := ww.writeRecord([][2]string{
+			{"WARC-Type", "request"},
+			{"WARC-Record-ID", newWARCRecordID()},
+			{"WARC-Date", date.UTC().Format(warcDateFormat)},
+			{"WARC-Target-URI", uri},
+			{"WARC-Concurrent-To", resID},
+			{"Content-Type", "application/http;msgtype=request"},
+		}, req); err != nil {
+			return err
+		}
+	}
+	return ww.writeRecord(resHeaders, block.Bytes())
+}
+
+// Write a record with the specified headers and block. The block digest and
+// the content length are added to the headers.
+func (ww *warcWriter) writeRecord(headers [][2]string, block []byte) error {
+	var rec bytes.Buffer
+
+	rec.WriteString("WARC/1.1\r\n")
+	headers = append(headers,
+		[2]string{"WARC-Block-Digest", warcDigest(block)},
+		[2]string{"Content-Length", strconv.Itoa(len(block))})
+	for _, h := range headers {
+		rec.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	rec.WriteString("\r\n")
+	rec.Write(block)
+	rec.WriteString("\r\n\r\n")
+
+	if !ww.gzip {
+		_, err := ww.w.Write(rec.Bytes())
+		return err
+	}
+	gz := gzip.NewWriter(ww.w)
+	if _, err := gz.Write(rec.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Return the SHA-1 digest of the bytes, in the WARC (base32) form.
+func warcDigest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// Return a new random (version 4) UUID record ID.
+func newWARCRecordID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// warcBody captures the response body as it is read, and writes the records
+// of the exchange when it is closed. The body is not read on close, so the
+// record only has the bytes read by the crawler: it is truncated on length
+// if they are not the whole body, and on disconnect if the read failed.
+type warcBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	res       *http.Response
+	date      time.Time
+	eof       bool
+	truncated string
+	closed    bool
+	ww        *warcWriter
+	onError   func(error)
+}
+
+// Read reads from the body and captures the bytes read.
+func (wb *warcBody) Read(p []byte) (int, error) {
+	n, err := wb.ReadCloser.Read(p)
+	wb.buf.Write(p[:n])
+	if err == io.EOF {
+		wb.eof = true
+	} else if err != nil && wb.truncated == "" {
+		wb.truncated = "disconnect"
+	}
+	return n, err
+}
+
+// Close closes the body and writes the records.
+func (wb *warcBody) Close() error {
+	if wb.closed {
+		return nil
+	}
+	wb.closed = true
+	// The body is complete if it was read to the end, or if its announced
+	// length was read (there is no body for a HEAD request)
+	complete := wb.eof || int64(wb.buf.Len()) == wb.res.ContentLength || wb.ReadCloser == http.NoBody
+	if !complete && wb.truncated == "" {
+		wb.truncated = "length"
+	}
+	err := wb.ReadCloser.Close()
+	if e := wb.ww.writeExchange(wb.res, wb.buf.Bytes(), wb.date, wb.truncated); e != nil {
+		wb.onError(e)
+	}
+	return err
+}
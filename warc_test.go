@@ -0,0 +1,196 @@
+package gocrawl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type warcRecord struct {
+	headers map[string]string
+	block   []byte
+}
+
+// Parse the WARC records of r.
+func readWARCRecords(r io.Reader, t *testing.T) []*warcRecord {
+	var recs []*warcRecord
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return recs
+		}
+		if line != "WARC/1.1\r\n" {
+			t.Fatalf("expected WARC version line, got %q", line)
+		}
+		rec := &warcRecord{headers: make(map[string]string)}
+		for {
+			line, err = br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read WARC header: %s", err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			parts := strings.SplitN(strings.TrimRight(line, "\r\n"), ": ", 2)
+			rec.headers[parts[0]] = parts[1]
+		}
+		n, _ := strconv.Atoi(rec.headers["Content-Length"])
+		rec.block = make([]byte, n)
+		if _, err := io.ReadFull(br, rec.block); err != nil {
+			t.Fatalf("failed to read WARC block: %s", err)
+		}
+		if _, err := br.Discard(4); err != nil {
+			t.Fatalf("failed to read WARC record end: %s", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestWARCWriter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			fmt.Fprint(w, "<html><body>ok</body></html>")
+		}
+	}))
+	defer srv.Close()
+
+	for _, gz := range []bool{false, true} {
+		var buf bytes.Buffer
+
+		opts := NewOptions(new(DefaultExtender))
+		opts.CrawlDelay = time.Millisecond
+		opts.LogFlags = LogNone
+		opts.WARCWriter = &buf
+		opts.WARCGzip = gz
+		c := NewCrawlerWithOptions(opts)
+		if err := c.Run(srv.URL + "/page"); err != nil {
+			t.Fatalf("gzip=%v: run failed with %v", gz, err)
+		}
+
+		var r io.Reader = &buf
+		if gz {
+			gr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("gzip=%v: %s", gz, err)
+			}
+			r = gr
+		}
+		recs := readWARCRecords(r, t)
+		if len(recs) != 4 {
+			t.Fatalf("gzip=%v: expected 4 records, got %d", gz, len(recs))
+		}
+		for i, exp := range []struct {
+			typ, uri, status string
+		}{
+			{"request", srv.URL + "/robots.txt", ""},
+			{"response", srv.URL + "/robots.txt", "HTTP/1.1 404 Not Found"},
+			{"request", srv.URL + "/page", ""},
+			{"response", srv.URL + "/page", "HTTP/1.1 200 OK"},
+		} {
+			rec := recs[i]
+			if rec.headers["WARC-Type"] != exp.typ || rec.headers["WARC-Target-URI"] != exp.uri {
+				t.Errorf("gzip=%v: record %d: expected %s of %s, got %s of %s", gz, i, exp.typ, exp.uri,
+					rec.headers["WARC-Type"], rec.headers["WARC-Target-URI"])
+			}
+			if d := warcDigest(rec.block); rec.headers["WARC-Block-Digest"] != d {
+				t.Errorf("gzip=%v: record %d: expected block digest %s, got %s", gz, i, d, rec.headers["WARC-Block-Digest"])
+			}
+			if _, err := time.Parse(warcDateFormat, rec.headers["WARC-Date"]); err != nil {
+				t.Errorf("gzip=%v: record %d: invalid WARC-Date: %s", gz, i, err)
+			}
+			if exp.typ == "request" {
+				if rec.headers["WARC-Concurrent-To"] != recs[i+1].headers["WARC-Record-ID"] {
+					t.Errorf("gzip=%v: record %d: expected request to refer to its response", gz, i)
+				}
+			} else if !bytes.HasPrefix(rec.block, []byte(exp.status+"\r\n")) {
+				t.Errorf("gzip=%v: record %d: expected status line %q", gz, i, exp.status)
+			}
+		}
+		if !bytes.HasSuffix(recs[3].block, []byte("\r\n\r\n<html><body>ok</body></html>")) {
+			t.Errorf("gzip=%v: expected response body in record, got %q", gz, recs[3].block)
+		}
+		if v, ok := recs[3].headers["WARC-Truncated"]; ok {
+			t.Errorf("gzip=%v: expected the whole response body, got WARC-Truncated %q", gz, v)
+		}
+		if !bytes.HasPrefix(recs[2].block, []byte("GET /page HTTP/1.1\r\nHost: ")) || !bytes.Contains(recs[2].block, []byte("User-Agent: "+DefaultUserAgent+"\r\n")) {
+			t.Errorf("gzip=%v: expected the request as sent, got %q", gz, recs[2].block)
+		}
+	}
+}
+
+func TestWARCTruncated(t *testing.T) {
+	var buf bytes.Buffer
+
+	res := &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       ioutil.NopCloser(strings.NewReader("body")),
+	}
+	ww := newWARCWriter(&buf, false)
+	if err := ww.writeExchange(res, []byte("bo"), time.Now(), "length"); err != nil {
+		t.Fatal(err)
+	}
+	recs := readWARCRecords(&buf, t)
+	if len(recs) != 1 {
+		t.Fatalf("expected a single response record without request, got %d", len(recs))
+	}
+	if v := recs[0].headers["WARC-Truncated"]; v != "length" {
+		t.Errorf("expected WARC-Truncated to be length, got %q", v)
+	}
+	if v, exp := recs[0].headers["WARC-Payload-Digest"], warcDigest([]byte("bo")); v != exp {
+		t.Errorf("expected payload digest %s, got %s", exp, v)
+	}
+}
+
+func TestWARCBodyNotRead(t *testing.T) {
+	var buf bytes.Buffer
+	var errs []error
+
+	res := &http.Response{
+		Status:        "404 Not Found",
+		StatusCode:    404,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/html"}},
+		ContentLength: 4,
+		Body:          ioutil.NopCloser(strings.NewReader("body")),
+	}
+	ww := newWARCWriter(&buf, false)
+	res.Body = ww.wrap(res, time.Now(), func(e error) { errs = append(errs, e) })
+	p := make([]byte, 2)
+	if _, err := io.ReadFull(res.Body, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Body.Close(); err != nil || len(errs) > 0 {
+		t.Fatalf("expected the records to be written, got %v %v", err, errs)
+	}
+
+	// Only the bytes read are recorded, the body is not drained
+	recs := readWARCRecords(&buf, t)
+	if len(recs) != 1 {
+		t.Fatalf("expected a single response record, got %d", len(recs))
+	}
+	if v := recs[0].headers["WARC-Truncated"]; v != "length" {
+		t.Errorf("expected WARC-Truncated to be length, got %q", v)
+	}
+	if !bytes.HasSuffix(recs[0].block, []byte("\r\n\r\nbo")) {
+		t.Errorf("expected the bytes read in the record, got %q", recs[0].block)
+	}
+}
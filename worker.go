@@ -2,12 +2,18 @@ package gocrawl
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"path"
@@ -26,28 +32,185 @@ type worker struct {
 
 	// Communication channels and sync
 	push    chan<- *workerResponse
-	pop     popChannel
+	pop     *hostQueue
 	stop    chan struct{}
+	retire  chan struct{}
 	enqueue chan<- interface{}
+	visits  chan<- *visitJob
 	wg      *sync.WaitGroup
 
-	// Robots validation
-	robotsGroup *robotstxt.Group
+	// Global rate limits and bytes read, shared by all workers
+	limiter   RateLimiter
+	stopCtx   context.Context
+	throttle  *byteThrottle
+	bytesRead *int64
+
+	// Number of URLs pushed to this worker and not yet processed. Managed
+	// by the crawler only.
+	queued int
+
+	// Turns of the Deterministic mode, nil otherwise: the worker processes a
+	// single URL when it gets a turn, and signals the end of the turn.
+	turn     chan struct{}
+	turnDone chan<- struct{}
+
+	// Robots validation, body, expiration time and scheme of the robots.txt
+	// data, and the groups of all hosts. With the RobotsPerScheme option, the
+	// data of the other schemes is kept aside, and the groups of all hosts
+	// get the data of the host's first scheme. The rules are set if they need
+	// the RFC 9309 precedence, see setRobotsBody, while the rules of the
+	// group are always set, to cite the rule that disallows an URL.
+	robotsGroup      *robotstxt.Group
+	robotsBody       []byte
+	robotsRules      []robotsRule
+	robotsGroupRules []robotsRule
+	robotsExpires    time.Time
+	robotsScheme     string
+	robotsHostScheme string
+	robotsSchemes    map[string]*robotsState
+	robots           *robotsGroups
+
+	// Number of fetch attempts of each URL and WARC archive, shared by all
+	// workers
+	attempts *fetchAttempts
+	warc     *warcWriter
+
+	// URLs popped by the workers and not processed yet
+	inFlight *inFlight
+
+	// Time of the last fetch of each host, shared by all workers
+	lastFetches *lastFetches
+
+	// Fetch errors of the run, if MaxErrors or MaxConsecutiveErrors is set
+	errBudget *errorBudget
+
+	// HTTP client of the run, a copy of the HTTPClient option or of the
+	// HttpClient
+	client *http.Client
+
+	// Visit functions registered by content type on the crawler
+	visitors []*visitor
+
+	// Lifecycle hooks of the host, if the extender implements them
+	hostExt HostExtender
+
+	// Outcome hook of the fetched URLs, if the extender implements it
+	visitedExt VisitedExtender
+
+	// Rejection hook of the URLs, if the extender implements it
+	disExt DisallowedExtender
+
+	// Interpretation hook of the robots.txt, if the extender implements it
+	robotsExt RobotsInfoExtender
+
+	// Authentication hook, if the extender implements it, and the URLs
+	// retried with credentials, shared by all workers
+	authExt     AuthExtender
+	authRetries *fetchAttempts
+
+	// Last-chance hook of the requests, if the extender implements it
+	beforeFetchExt BeforeFetchExtender
+
+	// Visit method with a context, if the extender implements it
+	visitCtxExt VisitCtxExtender
+
+	// Dispatcher of the notifications of the extender, if the
+	// OrderedCallbacks option is set
+	callbacks *dispatcher
+
+	// Response cache of the run, if the ResponseCacheBytes option is set,
+	// shared by all workers
+	cache *responseCache
+
+	// Crawl report of the run, if the ReportWriter option is set
+	report *reporter
+
+	// Matcher of the HarvestSelector option, nil to harvest the whole pages
+	harvestScope goquery.Matcher
+
+	// Report hook of the URLs not fetched with the DryRun option, if the
+	// extender implements it
+	dryRunExt DryRunExtender
+
+	// Statistics of the host, reported to the HostCompleteExtender once
+	// complete, i.e. if the worker stops with an empty queue and, at the end
+	// of the crawl, if drained is set because there are no more URLs.
+	stats       *hostStats
+	hostCompExt HostCompleteExtender
+	drained     *int32
+
+	// URLs blocked on full queues by host, the host is not complete while
+	// some of its URLs are blocked
+	blockedHosts *blockedHosts
+
+	// Progress of the crawl, to count the URLs rejected by the worker
+	progress *progress
+
+	// Event stream of the crawl, if the EmitEvents option is set
+	events *eventStream
+
+	// Options of the host, resolved from the global options and the PerHost
+	// overrides when the worker starts
+	userAgent      string
+	robotUserAgent string
+	crawlDelay     time.Duration
+	maxVisits      int
+	visitCount     int
+	windows        []CrawlWindow
+	tags           map[string]string
 
 	// Logging
-	logFunc func(LogFlags, string, ...interface{})
+	logFunc   func(LogFlags, string, ...interface{})
+	eventFunc func(LogFlags, string, map[string]interface{})
 
 	// Implementation fields
-	wait           <-chan time.Time
+	clock          Clock
+	delay          Timer
+	delayPending   bool
 	lastFetch      *FetchInfo
+	recentFetches  []*FetchInfo
 	lastCrawlDelay time.Duration
 	opts           *Options
+
+	// Circuit breaker, the host is considered down until downUntil when
+	// the number of consecutive failures reaches the threshold.
+	failures  int
+	downUntil time.Time
+
+	// Concurrent fetches, with the FetchersPerHost option: fetchers holds a
+	// token per fetch in flight (nil for one fetch at a time), startMu
+	// serializes the starts of the fetches (the crawl delay and the
+	// ComputeDelay state), and mu guards the fetch results (the last and
+	// recent fetches, the circuit breaker and the visits count).
+	fetchers     chan struct{}
+	fetching     sync.WaitGroup
+	startMu      sync.Mutex
+	mu           sync.Mutex
+	fetchSeq     int
+	lastFetchSeq int
 }
 
 // Start crawling the host.
 func (w *worker) run() {
+	reason := HostStopCrawlEnd
 	defer func() {
+		// Wait for the fetches in flight, they end on the stop signal
+		w.fetching.Wait()
+		if w.delay != nil {
+			w.delay.Stop()
+		}
+		pending := w.pop.len()
+		if w.hostCompExt != nil && pending == 0 && !w.blockedHosts.has(w.host) && (reason != HostStopCrawlEnd || atomic.LoadInt32(w.drained) == 1) {
+			w.hostCompExt.HostComplete(w.host, w.stats.snapshot(w.visitCount))
+		}
+		reason = w.stopReason(reason)
+		if w.hostExt != nil {
+			w.hostExt.HostStopped(w.host, reason, pending)
+		}
+		w.logFunc(LogTrace, "host %s stopped (%s), %d pending URLs", w.host, reason, pending)
 		w.logFunc(LogInfo, "worker done.")
+		w.eventFunc(LogInfo, EventWorkerStop, withTags(map[string]interface{}{"host": w.host, "reason": reason.String(), "pending": pending}, w.tags))
+		w.events.emit(CrawlEvent{Kind: CevHostFinished, Host: w.host, Tags: w.tags})
 		w.wg.Done()
 	}()
 
@@ -57,9 +220,14 @@ func (w *worker) run() {
 
 		w.logFunc(LogInfo, "waiting for pop...")
 
-		// Initialize the idle timeout channel, if required
-		if w.opts.WorkerIdleTTL > 0 {
-			idleChan = time.After(w.opts.WorkerIdleTTL)
+		// Initialize the idle timeout channel, if required. A paused worker with
+		// URLs in its queue is not idle. In Deterministic mode, the worker only
+		// wakes up on its turns and never idles.
+		popChan := w.pop.wait()
+		if w.turn != nil {
+			popChan = w.turn
+		} else if w.opts.WorkerIdleTTL > 0 && w.pop.len() == 0 && len(w.fetchers) == 0 {
+			idleChan = after(w.clock, w.opts.WorkerIdleTTL)
 		}
 
 		select {
@@ -67,30 +235,91 @@ func (w *worker) run() {
 			w.logFunc(LogInfo, "stop signal received.")
 			return
 
+		case <-w.retire:
+			w.logFunc(LogInfo, "retire signal received.")
+			reason = HostStopRetired
+			return
+
 		case <-idleChan:
 			w.logFunc(LogInfo, "idle timeout received.")
+			reason = HostStopIdle
 			w.sendResponse(nil, false, nil, true)
 			return
 
-		case batch := <-w.pop:
+		case <-popChan:
 
-			// Got a batch of urls to crawl, loop and check at each iteration if a stop
-			// is received.
-			for _, ctx := range batch {
+			// Got urls to crawl, pop them by order of priority and check at each
+			// iteration if a stop is received.
+			for {
+				if w.windows != nil && w.pop.len() > 0 && !w.waitCrawlWindow() {
+					w.logFunc(LogInfo, "stop signal received.")
+					return
+				}
+				ctx, ok, err := w.pop.pop()
+				if err != nil {
+					// Try again later, the URLs are still in the frontier
+					w.notifyError(newCrawlError(nil, err, CekFrontier))
+					w.logFunc(LogError, "ERROR popping from the frontier: %s", err)
+					go func(t Timer) {
+						<-t.C()
+						w.pop.wake()
+					}(w.clock.NewTimer(frontierRetryDelay))
+					break
+				}
+				if !ok {
+					break
+				}
 				w.logFunc(LogInfo, "popped: %s", ctx.url)
+				if w.opts.LogFlags&LogQueue != 0 {
+					w.logFunc(LogQueue, "dequeued %s for host %s, queue length %d", ctx.url, w.host, w.pop.len())
+				}
+				if !ctx.IsRobotsURL() {
+					w.inFlight.add(ctx)
+				}
 
 				if ctx.IsRobotsURL() {
+					// The robots.txt is fetched alone
+					w.fetching.Wait()
 					w.requestRobotsTxt(ctx)
-				} else if w.isAllowedPerRobotsPolicies(ctx.url) {
-					w.requestURL(ctx, ctx.HeadBeforeGet)
-				} else {
-					// Must still notify Crawler that this URL was processed, although not visited
-					w.opts.Extender.Disallowed(ctx)
+				} else if w.isHostDown() {
+					// Fast-fail the URL, the host is considered down
+					w.notifyError(newCrawlErrorMessage(ctx, "host is down", CekSkippedHostDown))
+					w.report.skipped(ctx, CekSkippedHostDown)
+					w.logFunc(LogTrace, "skipped on host down policy: %s", ctx.url)
+					w.sendResponse(ctx, false, nil, false)
+				} else if w.isMaxVisitsReached() {
+					// The maximum number of visits of the host is reached
+					w.logFunc(LogIgnored, "ignored on host max visits policy: %s", ctx.url)
+					w.disallowed(ctx, DisHostMaxVisits, strconv.Itoa(w.maxVisits))
 					w.sendResponse(ctx, false, nil, false)
+				} else {
+					// Apply the current robots.txt policies, refreshed if expired
+					if w.isRobotsStale(ctx) {
+						w.fetching.Wait()
+					}
+					w.useRobotsScheme(ctx)
+					w.refreshRobotsTxt(ctx)
+					if w.isAllowedPerRobotsPolicies(ctx.url) {
+						if w.opts.DryRun {
+							w.wouldFetch(ctx)
+						} else if !w.startFetcher(ctx) {
+							w.logFunc(LogInfo, "stop signal received.")
+							return
+						}
+					} else {
+						// Must still notify Crawler that this URL was processed, although not visited
+						ctx.robotsRule = findDisallowRule(w.robotsGroupRules, ctx.url.Path)
+						kind := DisRobots
+						if w.robotsGroup == disallowAllGroup {
+							kind = DisRobotsError
+						}
+						w.disallowed(ctx, kind, ctx.robotsRule)
+						w.sendResponse(ctx, false, nil, false)
+					}
 				}
 
 				// No need to check for idle timeout here, no idling while looping through
-				// a batch of URLs.
+				// the queued URLs.
 				select {
 				case <-w.stop:
 					w.logFunc(LogInfo, "stop signal received.")
@@ -98,16 +327,157 @@ func (w *worker) run() {
 				default:
 					// Nothing, just continue...
 				}
+				if w.turn != nil {
+					// A single URL per turn
+					break
+				}
+			}
+			if w.turn != nil {
+				w.turnDone <- struct{}{}
 			}
 		}
 	}
 }
 
+// Start the crawl delay. The same timer is reused for all delays of the
+// worker.
+func (w *worker) startDelay(d time.Duration) {
+	if w.delay == nil {
+		w.delay = w.clock.NewTimer(d)
+	} else {
+		w.delay.Reset(d)
+	}
+	w.delayPending = true
+}
+
+// Wait for the pending crawl delay, if any. Returns false if the stop signal
+// is received while waiting, in which case the delay is still pending.
+func (w *worker) waitDelay() bool {
+	if !w.delayPending {
+		return true
+	}
+	select {
+	case <-w.delay.C():
+		w.delayPending = false
+		return true
+	case <-w.stop:
+		w.logFunc(LogInfo, "stop signal received during crawl delay.")
+		return false
+	}
+}
+
+// Return the reason of the worker stop, refined for a crawl stopped by the
+// error budget and for a host considered down by the circuit breaker.
+func (w *worker) stopReason(reason HostStopReason) HostStopReason {
+	if reason == HostStopRetired {
+		return reason
+	}
+	if reason == HostStopCrawlEnd && w.errBudget != nil && w.errBudget.hasStopped() {
+		return HostStopErrors
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.HostFailureThreshold > 0 && w.failures >= w.opts.HostFailureThreshold {
+		return HostStopHostDown
+	}
+	return reason
+}
+
+// Checks if the host is considered down by the circuit breaker. Once the
+// cooldown delay is expired, the next request is allowed as a probe.
+func (w *worker) isHostDown() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.HostFailureThreshold <= 0 || w.failures < w.opts.HostFailureThreshold {
+		return false
+	}
+	if w.clock.Now().Before(w.downUntil) {
+		return true
+	}
+	w.logFunc(LogTrace, "cooldown expired, probing host %s", w.host)
+	return false
+}
+
+// Keep track of the result of a fetch for the circuit breaker.
+func (w *worker) setFetchResult(ok bool) {
+	if w.opts.HostFailureThreshold <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ok {
+		if w.failures >= w.opts.HostFailureThreshold {
+			w.logFunc(LogTrace, "host %s is up, circuit closed", w.host)
+		}
+		w.failures = 0
+		return
+	}
+	w.failures++
+	if w.failures >= w.opts.HostFailureThreshold {
+		w.downUntil = w.clock.Now().Add(w.opts.HostCooldown)
+		w.logFunc(LogTrace, "host %s is down after %d consecutive failures, circuit opened until %v", w.host, w.failures, w.downUntil)
+	}
+}
+
+// Request the URL, in its own goroutine if the FetchersPerHost option allows
+// concurrent fetches, once a fetcher is available. Returns false if the stop
+// signal is received while waiting for a fetcher.
+func (w *worker) startFetcher(ctx *URLContext) bool {
+	headRequest := (ctx.HeadBeforeGet && !ctx.isRangeProbe() && !w.robots.headUnsupported(w.host)) || ctx.HeadOnly
+	if w.fetchers == nil {
+		w.requestURL(ctx, headRequest)
+		return true
+	}
+	select {
+	case w.fetchers <- struct{}{}:
+	case <-w.stop:
+		return false
+	}
+	w.fetching.Add(1)
+	go func() {
+		defer func() {
+			<-w.fetchers
+			w.fetching.Done()
+			// Wake the worker up, it may be idle now
+			w.pop.wake()
+		}()
+		w.requestURL(ctx, headRequest)
+	}()
+	return true
+}
+
+// Indicates if the maximum number of visits of the host is reached. If the
+// fetches in flight could reach it, they are waited for first.
+func (w *worker) isMaxVisitsReached() bool {
+	if w.maxVisits <= 0 {
+		return false
+	}
+	w.mu.Lock()
+	n := w.visitCount
+	w.mu.Unlock()
+	if n+len(w.fetchers) >= w.maxVisits {
+		w.fetching.Wait()
+		w.mu.Lock()
+		n = w.visitCount
+		w.mu.Unlock()
+	}
+	return n >= w.maxVisits
+}
+
+// Indicates if the robots.txt policies are about to be fetched again or
+// switched for the URL, per the RobotsTTL and RobotsPerScheme options.
+func (w *worker) isRobotsStale(ctx *URLContext) bool {
+	if w.opts.RobotsPerScheme && w.robotsScheme != "" && ctx.normalizedURL.Scheme != w.robotsScheme {
+		return true
+	}
+	return !w.robotsExpires.IsZero() && !w.clock.Now().Before(w.robotsExpires)
+}
+
 // Checks if the given URL can be fetched based on robots.txt policies.
 func (w *worker) isAllowedPerRobotsPolicies(u *url.URL) bool {
 	if w.robotsGroup != nil {
 		// Is this URL allowed per robots.txt policy?
-		ok := w.robotsGroup.Test(u.Path)
+		ok := robotsAllow(w.robotsGroup, w.robotsRules, u.Path)
 		if !ok {
 			w.logFunc(LogIgnored, "ignored on robots.txt policy: %s", u.String())
 		}
@@ -120,22 +490,82 @@ func (w *worker) isAllowedPerRobotsPolicies(u *url.URL) bool {
 
 // Process the specified URL.
 func (w *worker) requestURL(ctx *URLContext, headRequest bool) {
-	if res, ok := w.fetchURL(ctx, w.opts.UserAgent, headRequest); ok {
-		var harvested interface{}
+	res, ok := w.fetchURL(ctx, w.requestUserAgent(ctx), headRequest)
+	if ok && w.recheckFallback(ctx, res) {
+		res.Body.Close()
+		res, ok = w.fetchURL(ctx, w.requestUserAgent(ctx), false)
+	}
+	if ok {
+		var harvested []harvestedLink
 		var visited bool
 
 		// Close the body on function end
 		defer res.Body.Close()
 
+		// The body of a range probe or a recheck is not read, closing it
+		// aborts the response of a server that ignores the Range header
+		if ctx.skipsBody() && !ctx.fetchInfo.IsHeadRequest {
+			w.probeRange(ctx, res)
+			return
+		}
+
 		// Any 2xx status code is good to go
 		if res.StatusCode >= 200 && res.StatusCode < 300 {
-			// Success, visit the URL
-			harvested = w.visitURL(ctx, res)
+			w.mu.Lock()
+			w.visitCount++
+			w.mu.Unlock()
+			if w.errBudget != nil {
+				w.errBudget.success()
+			}
+			if ctx.fetchInfo.IsHeadRequest {
+				if w.opts.RespectMetaRobots {
+					ctx.robots = getRobotsDirectives(res, nil, w.robotUserAgent)
+				}
+				// No GET request for a HEAD-only URL or a GET skipped after the HEAD,
+				// the URL is not visited, only notified as visited
+				ctx.unchanged = true
+				w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitHeadOnly})
+				w.eventFunc(LogInfo, EventVisit, urlFields(ctx))
+				w.events.emitURL(CevVisited, ctx)
+				w.sendResponse(ctx, true, nil, false)
+				return
+			}
+
+			// Success, visit the URL. The document is not parsed for the content
+			// types of the registered visitors, except for HTML.
+			visit := matchVisitor(w.visitors, res)
+			body := w.readBody(ctx, res)
+			parse := body != nil && (visit == nil || isHTMLResponse(res))
+			if w.visits != nil {
+				// Hand the visit to the visitor pool, which sends the response. Blocks
+				// until a visitor is available, so that fetching pauses meanwhile.
+				doc, _ := w.prepareVisit(ctx, res, body, parse, nil)
+				select {
+				case w.visits <- &visitJob{w, ctx, res, body, doc, visit}:
+				case <-w.stop:
+				}
+				return
+			}
+			harvested = w.visitWithin(ctx, res, func(dl *visitDeadline) ([]harvestedLink, bool) {
+				doc, ok := w.prepareVisit(ctx, res, body, parse, dl)
+				if !ok {
+					return nil, false
+				}
+				return w.visitDocument(ctx, res, body, doc, visit, dl)
+			})
 			visited = true
+		} else if res.StatusCode == http.StatusNotModified && w.opts.CountOnlyChangedVisits {
+			w.notModified(ctx, res)
+			return
 		} else {
 			// Error based on status code received
-			w.opts.Extender.Error(newCrawlErrorMessage(ctx, res.Status, CekHttpStatusCode))
+			w.notifyError(newStatusCodeError(ctx, res))
 			w.logFunc(LogError, "ERROR status code for %s: %s", ctx.url, res.Status)
+			if w.opts.VisitErrorPages && !ctx.fetchInfo.IsHeadRequest && isHTMLResponse(res) {
+				harvested = w.visitErrorPage(ctx, res)
+			} else {
+				w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitStatusError})
+			}
 		}
 		w.sendResponse(ctx, visited, harvested, false)
 	}
@@ -143,15 +573,239 @@ func (w *worker) requestURL(ctx *URLContext, headRequest bool) {
 
 // Process the robots.txt URL.
 func (w *worker) requestRobotsTxt(ctx *URLContext) {
+	var maxAge time.Duration
+
 	// Ask if it should be fetched
-	if robData, reqRob := w.opts.Extender.RequestRobots(ctx, w.opts.RobotUserAgent); !reqRob {
+	if robData, reqRob := w.opts.Extender.RequestRobots(ctx, w.robotUserAgent); !reqRob {
 		w.logFunc(LogInfo, "using robots.txt from cache")
 		w.robotsGroup = w.getRobotsTxtGroup(ctx, robData, nil)
 
-	} else if res, ok := w.fetchURL(ctx, w.opts.UserAgent, false); ok {
-		// Close the body on function end
-		defer res.Body.Close()
-		w.robotsGroup = w.getRobotsTxtGroup(ctx, nil, res)
+	} else {
+		w.robotsGroup, maxAge = w.fetchRobotsTxt(ctx)
+	}
+	w.robotsScheme = ctx.normalizedURL.Scheme
+	if w.robotsHostScheme == "" {
+		w.robotsHostScheme = w.robotsScheme
+	}
+	if w.robotsScheme == w.robotsHostScheme {
+		w.robots.set(w.host, w.robotsGroup, w.robotsRules)
+	}
+
+	// Set the expiration time of the robots.txt data, even on failure, so that
+	// it is not requested again for each URL.
+	if ttl := w.opts.RobotsTTL; ttl > 0 {
+		if maxAge > 0 && maxAge < ttl {
+			ttl = maxAge
+		}
+		w.robotsExpires = w.clock.Now().Add(ttl)
+	}
+}
+
+// Fetch the robots.txt URL and return its group, along with its max-age. If
+// it cannot be fetched (fetch error or 5xx status code), the RobotsErrorPolicy
+// is applied.
+func (w *worker) fetchRobotsTxt(ctx *URLContext) (*robotstxt.Group, time.Duration) {
+	var retries int
+
+	if w.opts.RobotsErrorPolicy == RobotsRetryThenDisallow {
+		retries = w.opts.RobotsRetries
+	}
+	delay := w.opts.RobotsRetryDelay
+	var parks int
+	for i := 0; ; i++ {
+		res, ok := w.fetchURL(ctx, w.userAgent, false)
+		if ok && (res.StatusCode < 500 || res.StatusCode >= 600) {
+			// Close the body on function end
+			defer res.Body.Close()
+			g := w.getRobotsTxtGroup(ctx, nil, res)
+			w.report.robots(ctx, res, "")
+			return g, getMaxAge(res)
+		}
+		if ok {
+			w.report.robots(ctx, res, CekFetchRobots.String())
+		} else if ctx.fetchInfo != nil {
+			w.report.robots(ctx, nil, CekFetch.String())
+		}
+		if ok && res.StatusCode == http.StatusServiceUnavailable && parks < w.opts.RobotsUnavailableRetries {
+			// Temporarily unavailable, park the host until the retry, which
+			// does not count as a retry of the RobotsErrorPolicy
+			w.opts.Extender.FetchedRobots(ctx, res)
+			res.Body.Close()
+			w.notifyError(newCrawlErrorMessage(ctx, res.Status, CekFetchRobots))
+			parks++
+			if !w.park(robotsRetryWait(res, w.opts.MaxRobotsRetryWait, w.clock.Now())) {
+				return nil, 0
+			}
+			i--
+			continue
+		}
+		status := "fetch error"
+		if ok {
+			// The robots.txt has been fetched, even if with a server error, so notify
+			status = res.Status
+			w.opts.Extender.FetchedRobots(ctx, res)
+			res.Body.Close()
+			w.notifyError(newCrawlErrorMessage(ctx, status, CekFetchRobots))
+		}
+		if i >= retries {
+			w.logFunc(LogError, "ERROR robots.txt unavailable for host %s: %s", w.host, status)
+			break
+		}
+		w.logFunc(LogInfo, "robots.txt unavailable (%s), retrying in %v", status, delay)
+		select {
+		case <-after(w.clock, delay):
+		case <-w.stop:
+			return nil, 0
+		}
+		delay *= 2
+	}
+
+	w.stats.setRobots(RobotsFailed)
+	if w.opts.RobotsErrorPolicy == RobotsAllowOnError {
+		w.logFunc(LogInfo, "robots.txt error policy: allowing all URLs of host %s", w.host)
+		w.setRobotsBody(nil)
+		return nil, 0
+	}
+	w.logFunc(LogInfo, "robots.txt error policy: disallowing all URLs of host %s", w.host)
+	w.setRobotsBody([]byte(disallowAllRobots))
+	return disallowAllGroup, 0
+}
+
+// Park the host for the delay, after a 503 response to its robots.txt
+// request: nothing is fetched and the crawler is notified, so that the
+// worker does not hold a slot of MaxConcurrentHosts meanwhile. Returns false
+// if the stop signal is received.
+func (w *worker) park(d time.Duration) bool {
+	w.logFunc(LogTrace, "robots.txt of host %s unavailable, parked for %v", w.host, d)
+	w.stats.addParked(d)
+	w.sendParked(true)
+	defer w.sendParked(false)
+
+	select {
+	case <-after(w.clock, d):
+		w.logFunc(LogTrace, "host %s resumed, requesting its robots.txt again", w.host)
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// Notify the crawler that the worker is parked or resumes, if the slots of
+// the workers are limited.
+func (w *worker) sendParked(parked bool) {
+	if w.opts.MaxConcurrentHosts <= 0 {
+		return
+	}
+	select {
+	case w.push <- &workerResponse{host: w.host, parked: parked, resumed: !parked}:
+	case <-w.stop:
+	}
+}
+
+// Return the delay before the robots.txt is requested again after the 503
+// response, per its Retry-After header (a number of seconds or a date), or
+// DefaultRobotsRetryAfter without a valid one, capped by max if it is
+// positive.
+func robotsRetryWait(res *http.Response, max time.Duration, now time.Time) time.Duration {
+	d := DefaultRobotsRetryAfter
+	if ra := strings.TrimSpace(res.Header.Get("Retry-After")); ra != "" {
+		if secs, e := strconv.Atoi(ra); e == nil && secs >= 0 {
+			d = time.Duration(secs) * time.Second
+		} else if t, e := http.ParseTime(ra); e == nil {
+			if d = t.Sub(now); d < 0 {
+				d = 0
+			}
+		}
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// Request the robots.txt URL again if its data is expired, before processing
+// the specified URL.
+func (w *worker) refreshRobotsTxt(ctx *URLContext) {
+	if w.robotsExpires.IsZero() || w.clock.Now().Before(w.robotsExpires) {
+		return
+	}
+	robCtx, e := ctx.getRobotsURLCtx(w.tags)
+	if e != nil {
+		w.notifyError(newCrawlError(ctx, e, CekParseRobots))
+		w.logFunc(LogError, "ERROR parsing robots.txt from %s: %s", ctx.normalizedURL, e)
+		w.robotsExpires = time.Time{}
+		return
+	}
+	w.logFunc(LogTrace, "robots.txt expired, refreshing: %s", robCtx.url)
+	w.requestRobotsTxt(robCtx)
+}
+
+// The robots.txt data of a scheme of the host, kept aside by the worker
+// while the URLs of another scheme are processed.
+type robotsState struct {
+	group      *robotstxt.Group
+	body       []byte
+	rules      []robotsRule
+	groupRules []robotsRule
+	expires    time.Time
+}
+
+// Switch to the robots.txt data of the scheme of the specified URL, before
+// processing it, if the RobotsPerScheme option is set. The robots.txt of the
+// scheme is requested the first time.
+func (w *worker) useRobotsScheme(ctx *URLContext) {
+	scheme := ctx.normalizedURL.Scheme
+	if !w.opts.RobotsPerScheme || w.robotsScheme == "" || scheme == w.robotsScheme {
+		return
+	}
+	if w.robotsSchemes == nil {
+		w.robotsSchemes = make(map[string]*robotsState)
+	}
+	w.robotsSchemes[w.robotsScheme] = &robotsState{w.robotsGroup, w.robotsBody, w.robotsRules, w.robotsGroupRules, w.robotsExpires}
+	if st, ok := w.robotsSchemes[scheme]; ok {
+		w.robotsGroup, w.robotsBody, w.robotsRules, w.robotsGroupRules, w.robotsExpires = st.group, st.body, st.rules, st.groupRules, st.expires
+		w.robotsScheme = scheme
+		return
+	}
+	robCtx, e := ctx.getRobotsURLCtx(w.tags)
+	if e != nil {
+		w.notifyError(newCrawlError(ctx, e, CekParseRobots))
+		w.logFunc(LogError, "ERROR parsing robots.txt from %s: %s", ctx.normalizedURL, e)
+		return
+	}
+	w.logFunc(LogTrace, "requesting robots.txt of scheme %s: %s", scheme, robCtx.url)
+	w.robotsExpires = time.Time{}
+	w.requestRobotsTxt(robCtx)
+}
+
+// Get the max-age directive of the Cache-Control header of the response, or 0
+// if there is none.
+func getMaxAge(res *http.Response) time.Duration {
+	for _, dir := range strings.Split(res.Header.Get("Cache-Control"), ",") {
+		dir = strings.TrimSpace(dir)
+		if !strings.HasPrefix(strings.ToLower(dir), "max-age=") {
+			continue
+		}
+		if secs, e := strconv.Atoi(dir[len("max-age="):]); e == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return 0
+}
+
+// Set the body of the robots.txt, and the rules of the robot user-agent if
+// they need the RFC 9309 precedence (an Allow rule or a wildcard), so that
+// the longest matching rule wins and Allow wins the ties. Otherwise the
+// URLs are tested by the robots.txt group. The rules of the group are kept
+// in any case, to cite the rule of the disallowed URLs.
+func (w *worker) setRobotsBody(b []byte) {
+	w.robotsBody, w.robotsRules, w.robotsGroupRules = b, nil, nil
+	if b != nil {
+		_, rules := parseRobots(b).group(w.robotUserAgent)
+		w.robotsGroupRules = rules
+		if needsRobotsPrecedence(rules) {
+			w.robotsRules = rules
+		}
 	}
 }
 
@@ -169,60 +823,372 @@ func (w *worker) getRobotsTxtGroup(ctx *URLContext, b []byte, res *http.Response
 		res.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
 		// Error or not, the robots.txt has been fetched, so notify
 		w.opts.Extender.FetchedRobots(ctx, res)
+		// Keep the body to explain the disallowed URLs, unless it is an error page
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			w.setRobotsBody(buf.Bytes())
+		} else {
+			w.setRobotsBody(nil)
+		}
 	} else {
 		data, e = robotstxt.FromBytes(b)
+		w.setRobotsBody(b)
 	}
 
 	// If robots data cannot be parsed, will return nil, which will allow access by default.
 	// Reasonable, since by default no robots.txt means full access, so invalid
 	// robots.txt is similar behavior.
 	if e != nil {
-		w.opts.Extender.Error(newCrawlError(nil, e, CekParseRobots))
+		w.notifyError(newCrawlError(nil, e, CekParseRobots))
 		w.logFunc(LogError, "ERROR parsing robots.txt for host %s: %s", w.host, e)
+		w.stats.setRobots(RobotsFailed)
 	} else {
-		g = data.FindGroup(w.opts.RobotUserAgent)
+		w.stats.setRobots(RobotsFetched)
+		agent := w.robotUserAgent
+		if w.robotsBody != nil {
+			// Select the group by product token, so that the decisions match
+			// the RobotsInfo and the explanations of the disallowed URLs
+			var match RobotsMatch
+			agent, match = parseRobots(w.robotsBody).selectGroup(w.robotUserAgent)
+			w.logFunc(LogTrace, "robots.txt group for host %s: %q (match: %s, token: %q)",
+				w.host, agent, match, robotsProductToken(w.robotUserAgent))
+		}
+		g = data.FindGroup(agent)
+	}
+
+	// Report the interpretation of the robots.txt content, if requested
+	if w.robotsExt != nil && w.robotsBody != nil {
+		info := parseRobots(w.robotsBody).info(w.robotsBody, w.robotUserAgent, g)
+		callExtender(w.opts, ctx, "RobotsParsed", w.notifyError, func() {
+			w.robotsExt.RobotsParsed(ctx, info)
+		})
 	}
 	return g
 }
 
 // Set the crawl delay between this request and the next.
-func (w *worker) setCrawlDelay() {
+func (w *worker) setCrawlDelay(ctx *URLContext) {
 	var robDelay time.Duration
 
 	if w.robotsGroup != nil {
 		robDelay = w.robotsGroup.CrawlDelay
+		if max := w.opts.MaxRobotsDelay; max > 0 && robDelay > max {
+			w.logFunc(LogTrace, "robots.txt crawl-delay %v capped to %v", robDelay, max)
+			robDelay = max
+		}
+	}
+	// The robots.txt delay is the floor of the delay, unless it is ignored
+	floor := robDelay
+	if w.opts.RobotsDelayPolicy == RobotsDelayUseOptions {
+		floor = 0
+	}
+	if ctx.delayOverride != nil {
+		// The override replaces the computed delay, not the floor
+		w.lastCrawlDelay = *ctx.delayOverride
+		if w.lastCrawlDelay < floor {
+			w.lastCrawlDelay = floor
+		}
+		if w.opts.DelayJitter > 0 {
+			w.lastCrawlDelay = w.jitterDelay(w.lastCrawlDelay, floor)
+		}
+		w.logFunc(LogInfo, "using crawl-delay override: %v", w.lastCrawlDelay)
+		w.logDelayEvent(ctx)
+		w.logDelay(ctx, robDelay)
+		return
+	}
+	w.mu.Lock()
+	recentFetches, lastFetch := append([]*FetchInfo(nil), w.recentFetches...), w.lastFetch
+	w.mu.Unlock()
+	di := &DelayInfo{
+		w.crawlDelay,
+		robDelay,
+		w.lastCrawlDelay,
+		recentFetches,
+		w.opts.AdaptiveDelay,
+		w.opts.RobotsDelayPolicy,
+	}
+	// A panic in the extender falls back to the default delay
+	if !callExtender(w.opts, ctx, "ComputeDelay", w.notifyError, func() {
+		w.lastCrawlDelay = w.opts.Extender.ComputeDelay(w.host, di, lastFetch)
+	}) {
+		w.lastCrawlDelay = di.Delay()
+	}
+	if w.opts.DelayJitter > 0 {
+		w.lastCrawlDelay = w.jitterDelay(w.lastCrawlDelay, floor)
 	}
-	w.lastCrawlDelay = w.opts.Extender.ComputeDelay(w.host,
-		&DelayInfo{
-			w.opts.CrawlDelay,
-			robDelay,
-			w.lastCrawlDelay,
-		},
-		w.lastFetch)
 	w.logFunc(LogInfo, "using crawl-delay: %v", w.lastCrawlDelay)
+	w.logDelayEvent(ctx)
+	w.logDelay(ctx, robDelay)
+}
+
+// Log the crawl delay applied before the URL along with its inputs, under
+// the LogDelay flag.
+func (w *worker) logDelay(ctx *URLContext, robDelay time.Duration) {
+	if w.opts.LogFlags&LogDelay == 0 {
+		return
+	}
+	age := "none"
+	if w.lastFetches != nil {
+		if t, ok := w.lastFetches.get(w.host); ok {
+			age = w.clock.Now().Sub(t).String()
+		}
+	}
+	if ctx.delayOverride != nil {
+		w.logFunc(LogDelay, "crawl delay for %s: %v (override: %v, robots.txt: %v, last fetch age: %s): %s",
+			w.host, w.lastCrawlDelay, *ctx.delayOverride, robDelay, age, ctx.url)
+		return
+	}
+	w.logFunc(LogDelay, "crawl delay for %s: %v (options: %v, robots.txt: %v, last fetch age: %s): %s",
+		w.host, w.lastCrawlDelay, w.crawlDelay, robDelay, age, ctx.url)
+}
+
+// Send the structured log event for the crawl delay applied before the URL.
+func (w *worker) logDelayEvent(ctx *URLContext) {
+	fields := urlFields(ctx)
+	fields["duration"] = w.lastCrawlDelay
+	w.eventFunc(LogInfo, EventDelay, fields)
+}
+
+// Apply the random jitter to the delay, without going below the robots.txt
+// crawl delay.
+func (w *worker) jitterDelay(d, robDelay time.Duration) time.Duration {
+	j := w.opts.DelayJitter
+	if j > 1 {
+		j = 1
+	}
+	rnd := w.opts.DelayJitterRand
+	if rnd == nil {
+		rnd = rand.Float64
+	}
+	d = time.Duration(float64(d) * (1 + j*(2*rnd()-1)))
+	if d < robDelay {
+		d = robDelay
+	}
+	return d
+}
+
+// Indicates if the bytes read exceed the MaxTotalBytes option.
+func (w *worker) isBudgetExceeded() bool {
+	max := w.opts.MaxTotalBytes
+	return max > 0 && w.bytesRead != nil && atomic.LoadInt64(w.bytesRead) > max
+}
+
+// Notify the error to the extender, and send its structured log event.
+func (w *worker) notifyError(err *CrawlError) {
+	w.eventFunc(LogError, EventError, errorFields(err))
+	w.events.emitError(err)
+	w.stats.addError(err.Kind)
+	if w.errBudget != nil {
+		w.errBudget.add(err)
+	}
+	w.callbacks.call(func() {
+		if w.opts.RecoverPanics {
+			defer dropErrorPanic(w.logFunc)
+		}
+		w.opts.Extender.Error(err)
+	})
+}
+
+// Notify the outcome of a fetched URL to the VisitedExtender, completing the
+// info with the response. Without VisitedExtender, only the visited URLs are
+// notified, via the Visited extender method.
+func (w *worker) notifyVisited(ctx *URLContext, res *http.Response, harvested interface{}, info *VisitInfo) {
+	w.report.visit(ctx, res, harvested, info)
+	if w.visitedExt == nil {
+		if w.notifiesVisited(info.Outcome) {
+			w.callbacks.call(func() {
+				callExtender(w.opts, ctx, "Visited", w.notifyError, func() {
+					w.opts.Extender.Visited(ctx, harvested)
+				})
+			})
+		}
+		return
+	}
+
+	if res != nil {
+		info.StatusCode, info.Header = res.StatusCode, res.Header.Clone()
+	}
+	if ctx.fetchInfo != nil {
+		info.Size = ctx.fetchInfo.Size
+	}
+	info.Harvested = countHarvested(harvested)
+	w.callbacks.call(func() {
+		callExtender(w.opts, ctx, "VisitedInfo", w.notifyError, func() {
+			w.visitedExt.VisitedInfo(ctx, harvested, info)
+		})
+	})
+}
+
+// Indicates if the Visited extender method is called for the outcome, when
+// the extender does not implement the VisitedExtender. The status errors
+// are notified whether or not their body is visited (see VisitErrorPages).
+func (w *worker) notifiesVisited(outcome VisitOutcome) bool {
+	switch outcome {
+	case VisitDone, VisitHeadOnly, VisitRangeProbe, VisitRechecked, VisitNotModified, VisitStatusError:
+		return true
+	}
+	return false
+}
+
+// Count and notify the URL rejected by a policy of the worker. Without the
+// DisallowedExtender, only the robots.txt rejections are notified, via the
+// Disallowed extender method.
+func (w *worker) disallowed(ctx *URLContext, kind DisallowedKind, detail string) {
+	if w.progress != nil {
+		w.progress.addDisallowed(kind)
+	}
+	w.report.disallowed(ctx, kind, detail)
+	w.callbacks.call(func() {
+		if w.disExt != nil {
+			w.disExt.DisallowedReason(ctx, kind, detail)
+		} else if kind == DisRobots || kind == DisRobotsError {
+			w.opts.Extender.Disallowed(ctx)
+		}
+	})
+}
+
+// Return the number of harvested values: the length of a slice or map, or 1
+// for a single URL.
+func countHarvested(harvested interface{}) int {
+	if harvested == nil {
+		return 0
+	}
+	v := reflect.ValueOf(harvested)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len()
+	case reflect.Ptr:
+		if v.IsNil() {
+			return 0
+		}
+	}
+	return 1
+}
+
+// Keep track of the most recent fetches, for the adaptive crawl delay.
+func (w *worker) addRecentFetch(fi *FetchInfo) {
+	n := DefaultAdaptiveWindow
+	if ad := w.opts.AdaptiveDelay; ad != nil && ad.Window > 0 {
+		n = ad.Window
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recentFetches = append(w.recentFetches, fi)
+	if len(w.recentFetches) > n {
+		w.recentFetches = w.recentFetches[len(w.recentFetches)-n:]
+	}
+}
+
+// Return the user-agent to request the URL with, from the UserAgentFunc
+// option if set.
+func (w *worker) requestUserAgent(ctx *URLContext) string {
+	if fn := w.opts.UserAgentFunc; fn != nil {
+		if agent := fn(ctx); agent != "" {
+			return agent
+		}
+	}
+	return w.userAgent
+}
+
+// Set the last fetch passed to the ComputeDelay extender method, unless a
+// fetch that started after the one of sequence seq already completed.
+func (w *worker) setLastFetch(seq int, fi *FetchInfo) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq >= w.lastFetchSeq {
+		w.lastFetch, w.lastFetchSeq = fi, seq
+	}
+}
+
+// Wait for the crawl delay and the rate limit before a fetch of the URL, and
+// compute the next crawl delay. Returns the delay and the sequence number of
+// the fetch, or false if the URL must not be fetched. With concurrent
+// fetchers, the starts of the fetches are serialized and the delay starts
+// with the fetch.
+func (w *worker) waitFetchStart(ctx *URLContext) (time.Duration, int, bool) {
+	w.startMu.Lock()
+	defer w.startMu.Unlock()
+
+	// Wait for crawl delay, if one is pending.
+	w.logFunc(LogTrace, "waiting for crawl delay")
+	if !w.waitDelay() {
+		return 0, 0, false
+	}
+
+	// Do not start a fetch once the bytes budget is exceeded, the crawler
+	// stops on this response.
+	if w.isBudgetExceeded() && !ctx.IsRobotsURL() {
+		w.logFunc(LogIgnored, "ignored on bytes budget: %s", ctx.url)
+		return 0, 0, false
+	}
+
+	// Wait for the global rate limit, if any.
+	if w.limiter != nil {
+		w.logFunc(LogTrace, "waiting for rate limit")
+		if e := w.limiter.Wait(w.stopCtx); e != nil {
+			select {
+			case <-w.stop:
+			default:
+				w.notifyError(newCrawlError(ctx, e, CekFetch))
+				w.logFunc(LogError, "ERROR waiting for rate limit for %s: %s", ctx.url, e)
+			}
+			return 0, 0, false
+		}
+	}
+
+	// Compute the next delay
+	w.setCrawlDelay(ctx)
+	if w.fetchers != nil {
+		w.startDelay(w.lastCrawlDelay)
+	}
+	w.fetchSeq++
+	return w.lastCrawlDelay, w.fetchSeq, true
 }
 
 // Request the specified URL and return the response.
 func (w *worker) fetchURL(ctx *URLContext, agent string, headRequest bool) (res *http.Response, ok bool) {
 	var e error
-	var silent bool
+	var silent, attempted, slashFollowed bool
 
 	for {
-		// Wait for crawl delay, if one is pending.
-		w.logFunc(LogTrace, "waiting for crawl delay")
-		if w.wait != nil {
-			<-w.wait
-			w.wait = nil
+		// Serve the request from the response cache, if the URL was fetched
+		// already
+		if res = w.cachedResponse(ctx, agent, headRequest); res != nil {
+			if !headRequest || ctx.HeadOnly {
+				return res, true
+			}
+			if get, ok := w.followHead(ctx, res); !get {
+				return res, ok
+			}
+			headRequest = false
+			continue
 		}
 
-		// Compute the next delay
-		w.setCrawlDelay()
+		delay, seq, start := w.waitFetchStart(ctx)
+		if !start {
+			w.sendResponse(ctx, false, nil, false)
+			return nil, false
+		}
 
 		// Compute the fetch duration
-		now := time.Now()
+		now := w.clock.Now()
 
-		// Request the URL
-		if res, e = w.opts.Extender.Fetch(ctx, agent, headRequest); e != nil {
+		// Request the URL, a HEAD request followed by a GET counts as one attempt
+		if !attempted {
+			attempted = true
+			ctx.attempts = w.attempts.inc(ctx.normalizedURL)
+		}
+		ctx.client, ctx.trace, ctx.beforeFetch = w.client, nil, w.beforeFetchExt
+		if w.opts.TraceFetch {
+			ctx.trace = newFetchTrace()
+		}
+		w.events.emitURL(CevFetchStart, ctx)
+		res, e = w.opts.Extender.Fetch(ctx, agent, headRequest)
+		var timings *FetchTimings
+		if ctx.trace != nil {
+			timings = ctx.trace.fetchTimings()
+		}
+		if e != nil {
+			var follow bool
 			// Check if this is an ErrEnqueueRedirect, in which case we will enqueue
 			// the redirect-to URL.
 			if ue, ok := e.(*url.Error); ok {
@@ -236,25 +1202,71 @@ func (w *worker) fetchURL(ctx *URLContext, agent string, headRequest bool) (res
 					// Absolute URLs that point to another host are ok too.
 					if ur, e := ctx.url.Parse(ue.URL); e != nil {
 						// Notify error
-						w.opts.Extender.Error(newCrawlError(nil, e, CekParseRedirectURL))
+						w.notifyError(newCrawlError(nil, e, CekParseRedirectURL))
 						w.logFunc(LogError, "ERROR parsing redirect URL %s: %s", ue.URL, e)
 					} else {
 						w.logFunc(LogTrace, "redirect to %s from %s, linked from %s", ur, ctx.URL(), ctx.SourceURL())
 						// Enqueue the redirect-to URL with the original source
-						rCtx := ctx.cloneForRedirect(ur, w.opts.URLNormalizationFlags)
-						w.enqueue <- rCtx
+						if rCtx, e := ctx.cloneForRedirect(ur, w.opts); e == errRewriteDropped {
+							w.logFunc(LogTrace, "ignore on rewrite policy: %s", ur)
+						} else if e != nil {
+							w.notifyError(newCrawlError(ctx, e, CekParseRedirectURL))
+							w.logFunc(LogError, "ERROR parsing redirect URL %s: %s", ur, e)
+						} else if !slashFollowed && w.isSlashRedirect(ctx, rCtx, ur) {
+							// Request the form of the server in place, once
+							w.logFunc(LogTrace, "self-redirect on trailing slash policy, requesting %s instead of %s", ur, ctx.url)
+							slashFollowed, follow = true, true
+							ctx.url = ur
+						} else if rCtx.closesRedirectLoop() {
+							w.redirectLoop(ctx, rCtx)
+						} else {
+							w.enqueue <- rCtx
+						}
 					}
 				}
 			}
 
 			// No fetch, so set to nil
-			w.lastFetch = nil
+			w.setLastFetch(seq, nil)
+			w.stats.addFetch(w.clock.Now().Sub(now))
+			w.events.emitFetch(ctx, 0, w.clock.Now().Sub(now), e)
+			if follow {
+				silent = false
+				continue
+			}
 
 			if !silent {
-				// Notify error
-				w.opts.Extender.Error(newCrawlError(ctx, e, CekFetch))
+				// Keep track of the failed fetch, with a zero status code
+				ctx.fetchInfo = &FetchInfo{ctx, w.clock.Now().Sub(now), 0, headRequest, 0, timings, agent, -1, false}
+				w.addRecentFetch(ctx.fetchInfo)
+				// Notify error, with a distinct kind for vetoed requests, redirection
+				// policy violations and robots.txt fetches. A vetoed request is not
+				// a failure of the host.
+				kind := CekFetch
+				if errors.Is(e, ErrVetoed) {
+					kind = CekVetoed
+				} else if errors.Is(e, ErrRedirectLoop) {
+					kind = CekRedirectLoop
+				} else if errors.Is(e, ErrRedirectPolicy) {
+					kind = CekRedirectPolicy
+				} else if ctx.IsRobotsURL() {
+					kind = CekFetchRobots
+				}
+				if kind != CekVetoed {
+					w.setFetchResult(false)
+				}
+				w.notifyError(newCrawlError(ctx, e, kind))
 				w.logFunc(LogError, "ERROR fetching %s: %s", ctx.url, e)
 			}
+			if !ctx.IsRobotsURL() {
+				if ctx.redirectLoop {
+					w.notifyVisited(ctx, nil, nil, &VisitInfo{Outcome: VisitRedirectLoop})
+				} else if silent {
+					w.notifyVisited(ctx, nil, nil, &VisitInfo{Outcome: VisitRedirected})
+				} else {
+					w.notifyVisited(ctx, nil, nil, &VisitInfo{Outcome: VisitFetchError, Err: e})
+				}
+			}
 
 			// Return from this URL crawl
 			w.sendResponse(ctx, false, nil, false)
@@ -262,40 +1274,150 @@ func (w *worker) fetchURL(ctx *URLContext, agent string, headRequest bool) (res
 
 		}
 		// Get the fetch duration
-		fetchDuration := time.Now().Sub(now)
-		// Crawl delay starts now.
-		w.wait = time.After(w.lastCrawlDelay)
+		fetchDuration := w.clock.Now().Sub(now)
+		w.events.emitFetch(ctx, res.StatusCode, fetchDuration, nil)
+		// Crawl delay starts now, unless it started with the fetch.
+		if w.fetchers == nil {
+			w.startDelay(delay)
+		}
+		w.lastFetches.set(w.host, w.clock.Now())
+		w.stats.addFetch(fetchDuration)
+		w.stats.addDelay(delay)
 
 		// Keep trace of this last fetch info
-		w.lastFetch = &FetchInfo{
+		fi := &FetchInfo{
 			ctx,
 			fetchDuration,
 			res.StatusCode,
 			headRequest,
+			0,
+			timings,
+			agent,
+			-1,
+			false,
+		}
+		w.setLastFetch(seq, fi)
+		ctx.fetchInfo = fi
+		w.addRecentFetch(fi)
+		w.setFetchResult(true)
+		fields := urlFields(ctx)
+		fields["status"], fields["duration"], fields["head"] = res.StatusCode, fetchDuration, headRequest
+		fields["agent"] = agent
+		if timings != nil {
+			fields["dns"], fields["connect"], fields["tls"] = timings.DNS, timings.Connect, timings.TLSHandshake
+			fields["ttfb"], fields["reused"] = timings.TTFB, timings.ConnReused
 		}
+		w.eventFunc(LogInfo, EventFetch, fields)
 
-		if headRequest {
-			// Close the HEAD request's body
-			defer res.Body.Close()
-			// Next up is GET request, maybe
+		// Request the URL again with credentials on an authentication
+		// challenge, if the AuthExtender provides them
+		if w.authenticate(ctx, res) {
+			if res.Body != nil {
+				res.Body.Close()
+			}
+			continue
+		}
+		// Request the URL again with a GET if the host rejects the HEAD
+		// request
+		if headRequest && w.headRejected(ctx, res) {
 			headRequest = false
-			// Ask caller if we should proceed with a GET
-			if !w.opts.Extender.RequestGet(ctx, res) {
-				w.logFunc(LogIgnored, "ignored on HEAD filter policy: %s", ctx.url)
-				w.sendResponse(ctx, false, nil, false)
-				ok = false
-				break
+			continue
+		}
+
+		// Read the body through the bandwidth throttle, if any, and count the
+		// bytes read.
+		if w.throttle != nil && !headRequest && res.Body != nil {
+			res.Body = w.throttle.wrap(res.Body, w.stop)
+		}
+		if !headRequest && res.Body != nil {
+			res.Body = &countingReader{res.Body, w.bytesRead, &w.stats.bytes, &fi.Size}
+			if timings != nil {
+				res.Body = ctx.trace.timeBody(res.Body, timings)
 			}
-		} else {
-			ok = true
-			break
 		}
+		// Archive the exchange once the body is closed, if requested
+		if w.warc != nil && res.Body != nil {
+			res.Body = w.warc.wrap(res, now, func(e error) {
+				w.notifyError(newCrawlError(ctx, e, CekWriteWARC))
+				w.logFunc(LogError, "ERROR writing WARC records for %s: %s", ctx.url, e)
+			})
+		}
+		// Decompress the body if the transport did not, e.g. when the
+		// Accept-Encoding header of the request was set explicitly. The body
+		// of a range probe or a recheck is not read.
+		if !headRequest && !ctx.skipsBody() {
+			if e := decodeBody(res); e != nil {
+				w.notifyError(newCrawlError(ctx, e, CekReadBody))
+				w.logFunc(LogError, "ERROR decoding body of %s: %s", ctx.url, e)
+			}
+		}
+		// Keep the response in the cache once its body is read, if requested
+		if w.cachesResponse(ctx, res, headRequest) {
+			res.Body = w.cache.wrap(ctx.normalizedURL.String(), res)
+		}
+
+		if !headRequest || ctx.HeadOnly {
+			return res, true
+		}
+		if get, ok := w.followHead(ctx, res); !get {
+			return res, ok
+		}
+		headRequest = false
 	}
-	return
 }
 
-// Send a response to the crawler.
-func (w *worker) sendResponse(ctx *URLContext, visited bool, harvested interface{}, idleDeath bool) {
+// Decide if the GET request follows the HEAD request of the URL. If it does
+// not, the URL is processed with the HEAD response if it is a success (ok is
+// true), or it is notified as skipped.
+func (w *worker) followHead(ctx *URLContext, res *http.Response) (get, ok bool) {
+	// Ask caller if we should proceed with a GET
+	if !w.requestGet(ctx, res) {
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			// Process the URL with the HEAD response
+			w.logFunc(LogIgnored, "GET skipped on HEAD filter policy: %s", ctx.url)
+			return false, true
+		}
+		res.Body.Close()
+		w.logFunc(LogIgnored, "ignored on HEAD filter policy: %s", ctx.url)
+		w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitGetSkipped})
+		w.sendResponse(ctx, false, nil, false)
+		return false, false
+	}
+	// Close the HEAD request's body, next up is GET request
+	res.Body.Close()
+	return true, false
+}
+
+// Indicates if the host rejected the HEAD request of the URL with a 405
+// (Method Not Allowed) or 501 (Not Implemented) status code, in which case
+// the GET request is made instead, without an error, and the next URLs of
+// the host skip the HEAD request for the rest of the crawl. The HeadOnly
+// URLs are not requested with a GET.
+func (w *worker) headRejected(ctx *URLContext, res *http.Response) bool {
+	if ctx.HeadOnly || (res.StatusCode != http.StatusMethodNotAllowed && res.StatusCode != http.StatusNotImplemented) {
+		return false
+	}
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	w.robots.setHeadUnsupported(w.host)
+	w.stats.addHeadFallback()
+	w.logFunc(LogTrace, "HEAD unsupported by host %s (%s), falling back to GET: %s", w.host, res.Status, ctx.url)
+	return true
+}
+
+// Indicates if the GET request should follow the HEAD request, based on the
+// MaxBodySize option and the RequestGet extender method.
+func (w *worker) requestGet(ctx *URLContext, headRes *http.Response) bool {
+	if max := w.opts.MaxBodySize; max > 0 && headRes.ContentLength > max {
+		w.logFunc(LogIgnored, "ignored on body size policy (%d bytes): %s", headRes.ContentLength, ctx.url)
+		return false
+	}
+	return w.opts.Extender.RequestGet(ctx, headRes)
+}
+
+// Send a response to the crawler, along with the harvested links, if any.
+func (w *worker) sendResponse(ctx *URLContext, visited bool, harvested []harvestedLink, idleDeath bool) {
 	// Push harvested urls back to crawler, even if empty (uses the channel communication
 	// to decrement reference count of pending URLs)
 	if ctx == nil || !isRobotsURL(ctx.url) {
@@ -304,6 +1426,13 @@ func (w *worker) sendResponse(ctx *URLContext, visited bool, harvested interface
 		select {
 		case <-w.stop:
 			w.logFunc(LogInfo, "ignoring send response, will stop.")
+			if ctx != nil && visited {
+				// Not to be drained, it is visited
+				w.inFlight.remove(ctx)
+			}
+			return
+		case <-w.retire:
+			w.logFunc(LogInfo, "ignoring send response, retired.")
 			return
 		default:
 			// Nothing, just continue...
@@ -316,47 +1445,176 @@ func (w *worker) sendResponse(ctx *URLContext, visited bool, harvested interface
 			harvested,
 			w.host,
 			idleDeath,
+			false,
+			false,
+		}
+		if ctx != nil {
+			w.inFlight.remove(ctx)
+		}
+		// The crawler may stop while the response is sent, e.g. on the fetch
+		// errors limit, so the send must not block once it has stopped.
+		select {
+		case w.push <- res:
+		case <-w.stop:
+			w.logFunc(LogInfo, "ignoring send response, will stop.")
 		}
-		w.push <- res
 	}
 }
 
-// Process the response for a URL.
-func (w *worker) visitURL(ctx *URLContext, res *http.Response) interface{} {
-	var doc *goquery.Document
-	var harvested interface{}
-	var doLinks bool
+// Load the goquery document from the response body. The body is re-assigned
+// so that it can be read again.
+func (w *worker) loadDocument(ctx *URLContext, res *http.Response) *goquery.Document {
+	if bd := w.readBody(ctx, res); bd != nil {
+		return w.parseDocument(ctx, res, bd)
+	}
+	return nil
+}
 
-	// Load a goquery document and call the visitor function
-	if bd, e := ioutil.ReadAll(res.Body); e != nil {
-		w.opts.Extender.Error(newCrawlError(ctx, e, CekReadBody))
+// Read the response body, which is re-assigned so that it can be read again.
+// Returns nil if the body cannot be read.
+func (w *worker) readBody(ctx *URLContext, res *http.Response) []byte {
+	bd, e := ioutil.ReadAll(res.Body)
+	if e != nil {
+		w.notifyError(newCrawlError(ctx, e, CekReadBody))
 		w.logFunc(LogError, "ERROR reading body %s: %s", ctx.url, e)
-	} else {
-		if node, e := html.Parse(bytes.NewBuffer(bd)); e != nil {
-			w.opts.Extender.Error(newCrawlError(ctx, e, CekParseBody))
-			w.logFunc(LogError, "ERROR parsing %s: %s", ctx.url, e)
-		} else {
-			doc = goquery.NewDocumentFromNode(node)
-			doc.Url = res.Request.URL
+		return nil
+	}
+	// Re-assign the body so it can be consumed by the visitor function
+	res.Body = ioutil.NopCloser(bytes.NewBuffer(bd))
+	return bd
+}
+
+// Parse the goquery document from the response body.
+func (w *worker) parseDocument(ctx *URLContext, res *http.Response, bd []byte) *goquery.Document {
+	doc, e := parseHTML(res, bd)
+	if e != nil {
+		w.notifyParseError(ctx, e)
+	}
+	return doc
+}
+
+// Parse the goquery document from the response body, if parse is set, and
+// get the robots directives of the response. With the VisitTimeout option,
+// the parse error and the directives are dropped if the visit is abandoned
+// meanwhile, and false is returned.
+func (w *worker) prepareVisit(ctx *URLContext, res *http.Response, bd []byte, parse bool, dl *visitDeadline) (doc *goquery.Document, ok bool) {
+	var e error
+	if parse {
+		doc, e = parseHTML(res, bd)
+	}
+	ok = dl.do(func() {
+		if e != nil {
+			w.notifyParseError(ctx, e)
+		}
+		if w.opts.RespectMetaRobots {
+			ctx.robots = getRobotsDirectives(res, doc, w.robotUserAgent)
 		}
-		// Re-assign the body so it can be consumed by the visitor function
-		res.Body = ioutil.NopCloser(bytes.NewBuffer(bd))
+	})
+	return doc, ok
+}
+
+// Notify the error of the parsing of the response body.
+func (w *worker) notifyParseError(ctx *URLContext, e error) {
+	w.notifyError(newCrawlError(ctx, e, CekParseBody))
+	w.logFunc(LogError, "ERROR parsing %s: %s", ctx.url, e)
+}
+
+// Parse the goquery document from the body of the response.
+func parseHTML(res *http.Response, bd []byte) (*goquery.Document, error) {
+	node, e := html.Parse(bytes.NewReader(bd))
+	if e != nil {
+		return nil, e
 	}
+	doc := goquery.NewDocumentFromNode(node)
+	doc.Url = res.Request.URL
+	return doc, nil
+}
 
-	// Visit the document (with nil goquery doc if failed to load)
-	if harvested, doLinks = w.opts.Extender.Visit(ctx, res, doc); doLinks {
+// Process the response for a URL, with its loaded goquery document, using
+// the registered visit function if not nil. Returns the harvested links,
+// with their metadata if they were harvested by processLinks. With the
+// VisitTimeout option, nothing is notified if the visit is abandoned
+// meanwhile, and false is returned.
+func (w *worker) visitDocument(ctx *URLContext, res *http.Response, body []byte, doc *goquery.Document, visit VisitFunc, dl *visitDeadline) (result []harvestedLink, ok bool) {
+	var harvested interface{}
+	var links map[*url.URL]*LinkInfo
+	var doLinks bool
+	var panicErr *CrawlError
+
+	// Visit the document (with nil goquery doc if failed to load). If the visit
+	// panics, the URL is considered visited, without harvested links.
+	visited := callExtender(w.opts, ctx, "Visit", func(err *CrawlError) { panicErr = err }, func() {
+		switch {
+		case visit != nil:
+			harvested, doLinks = visit(ctx, res, body, doc)
+		case w.visitCtxExt != nil:
+			harvested, doLinks = w.visitCtxExt.VisitCtx(dl.context(), ctx, res, doc)
+		default:
+			harvested, doLinks = w.opts.Extender.Visit(ctx, res, doc)
+		}
+	})
+	if dl.context().Err() != nil {
+		// The deadline expired, the visit is abandoned without its links
+		return nil, false
+	}
+	processed := visited && doLinks && doc != nil && !ctx.robots.noFollow
+	if processed {
 		// Links were not processed by the visitor, so process links
-		if doc != nil {
-			harvested = w.processLinks(doc)
-		} else {
-			w.opts.Extender.Error(newCrawlErrorMessage(ctx, "No goquery document to process links.", CekProcessLinks))
+		harvested, links = w.processLinks(doc)
+	}
+
+	ok = dl.complete(func() {
+		if !visited {
+			w.notifyError(panicErr)
+			w.notifyVisited(ctx, res, nil, &VisitInfo{Outcome: VisitPanicked})
+			return
+		}
+		if ctx.robots.noFollow {
+			// The robots directives forbid to follow the links of the URL
+			if doLinks || harvested != nil {
+				w.logFunc(LogIgnored, "links ignored on robots nofollow directive: %s", ctx.url)
+			}
+			harvested, doLinks = nil, false
+		}
+		if doLinks && !processed {
+			w.notifyError(newCrawlErrorMessage(ctx, "No goquery document to process links.", CekProcessLinks))
 			w.logFunc(LogError, "ERROR processing links %s", ctx.url)
 		}
-	}
-	// Notify that this URL has been visited
-	w.opts.Extender.Visited(ctx, harvested)
+		// Notify that this URL has been visited
+		w.notifyVisited(ctx, res, harvested, &VisitInfo{Outcome: VisitDone, FindLinks: processed})
+		w.eventFunc(LogInfo, EventVisit, urlFields(ctx))
+		w.events.emitURL(CevVisited, ctx)
+		result = w.harvestedLinks(ctx, harvested, links)
+	})
+	return result, ok
+}
 
-	return harvested
+// A visit handed by a worker to the visitor pool.
+type visitJob struct {
+	w     *worker
+	ctx   *URLContext
+	res   *http.Response
+	body  []byte
+	doc   *goquery.Document
+	visit VisitFunc
+}
+
+// Run a visitor of the visitor pool, processing the visits until the stop
+// signal is received.
+func runVisitor(visits <-chan *visitJob, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case job := <-visits:
+			harvested := job.w.visitWithin(job.ctx, job.res, func(dl *visitDeadline) ([]harvestedLink, bool) {
+				return job.w.visitDocument(job.ctx, job.res, job.body, job.doc, job.visit, dl)
+			})
+			job.w.sendResponse(job.ctx, true, harvested, false)
+		}
+	}
 }
 
 func handleBaseTag(root *url.URL, baseHref string, aHref string) string {
@@ -381,26 +1639,206 @@ func handleBaseTag(root *url.URL, baseHref string, aHref string) string {
 	return resolvedURL.String()
 }
 
-// Scrape the document's content to gather all links
-func (w *worker) processLinks(doc *goquery.Document) (result []*url.URL) {
-	baseURL, _ := doc.Find("base[href]").Attr("href")
-	urls := doc.Find("a[href]").Map(func(_ int, s *goquery.Selection) string {
-		val, _ := s.Attr("href")
-		if baseURL != "" {
-			val = handleBaseTag(doc.Url, baseURL, val)
+// Scrape the document's content to gather all links, along with the metadata
+// of each link.
+func (w *worker) processLinks(doc *goquery.Document) (result []*url.URL, links map[*url.URL]*LinkInfo) {
+	h := &harvestDoc{doc: doc}
+	if base, ok := doc.Find("base[href]").Attr("href"); ok {
+		h.baseURL = cleanHref(base)
+	}
+	links = make(map[*url.URL]*LinkInfo)
+	root, scope := w.harvestRoot(doc)
+	anchors := root.Find("a[href]")
+	anchors.Each(func(i int, sel *goquery.Selection) {
+		s, _ := sel.Attr("href")
+		if parsed := w.resolveLink(h, s); parsed != nil {
+			info := newLinkInfo(sel, i)
+			info.Scope = scope
+			result = append(result, parsed)
+			links[parsed] = info
 		}
-		return val
 	})
-	for _, s := range urls {
-		// If href starts with "#", then it points to this same exact URL, ignore (will fail to parse anyway)
-		if len(s) > 0 && !strings.HasPrefix(s, "#") {
-			if parsed, e := url.Parse(s); e == nil {
-				parsed = doc.Url.ResolveReference(parsed)
-				result = append(result, parsed)
-			} else {
-				w.logFunc(LogIgnored, "ignore on unparsable policy %s: %s", s, e.Error())
+	index := anchors.Length()
+	if w.opts.MaxPaginationDepth > 0 {
+		index = w.processPaginationLinks(h, index, &result, links)
+	}
+	if w.opts.FollowHreflang {
+		index = w.processHreflangLinks(h, index, &result, links)
+	}
+	if w.opts.FollowGetForms {
+		index = w.processGetForms(h, index, &result, links)
+	}
+	if w.opts.HarvestImages {
+		w.processImages(h, root, scope, index, &result, links)
+	}
+	if h.unparsable > 0 {
+		w.logFunc(LogIgnored, "ignore on unparsable policy: %d links of %s, i.e. %s", h.unparsable, doc.Url, h.lastErr)
+	}
+	return
+}
+
+// Resolve the link against the document's URL, with its base tag if any,
+// once cleaned. Returns nil if the link should be ignored, the links that
+// cannot be parsed are counted.
+func (w *worker) resolveLink(h *harvestDoc, s string) *url.URL {
+	s = cleanHref(s)
+	if h.baseURL != "" {
+		s = handleBaseTag(h.doc.Url, h.baseURL, s)
+	}
+	// If href starts with "#", then it points to this same exact URL, ignore
+	// unless the fragment is followed per the FragmentPolicy option
+	if len(s) == 0 || (strings.HasPrefix(s, "#") && !followFragment(s, w.opts)) {
+		return nil
+	}
+	parsed, e := url.Parse(s)
+	if e != nil {
+		h.unparsable++
+		h.lastErr = e
+		return nil
+	}
+	parsed = h.doc.Url.ResolveReference(parsed)
+	if !isAllowedScheme(w.opts.AllowedSchemes, parsed.Scheme) {
+		w.logFunc(LogIgnored, "ignore on scheme policy: %s", parsed)
+		return nil
+	}
+	return parsed
+}
+
+// Gather the pagination links of the head (i.e. <link rel="next">), indexed
+// from index. Returns the index of the next link.
+func (w *worker) processPaginationLinks(h *harvestDoc, index int, result *[]*url.URL, links map[*url.URL]*LinkInfo) int {
+	h.doc.Find("link[href][rel]").Each(func(_ int, sel *goquery.Selection) {
+		info := newLinkInfo(sel, index)
+		if !info.Pagination {
+			return
+		}
+		index++
+		s, _ := sel.Attr("href")
+		if parsed := w.resolveLink(h, s); parsed != nil {
+			*result = append(*result, parsed)
+			links[parsed] = info
+		}
+	})
+	return index
+}
+
+// Gather the alternate links with an hreflang attribute, indexed from index.
+// Returns the index of the next link.
+func (w *worker) processHreflangLinks(h *harvestDoc, index int, result *[]*url.URL, links map[*url.URL]*LinkInfo) int {
+	h.doc.Find("link[href][hreflang]").Each(func(_ int, sel *goquery.Selection) {
+		info := newLinkInfo(sel, index)
+		if !hasToken(info.Rel, "alternate") || info.Pagination {
+			return
+		}
+		index++
+		s, _ := sel.Attr("href")
+		if parsed := w.resolveLink(h, s); parsed != nil {
+			info.Hreflang, _ = sel.Attr("hreflang")
+			info.Hreflang = strings.TrimSpace(info.Hreflang)
+			*result = append(*result, parsed)
+			links[parsed] = info
+		}
+	})
+	return index
+}
+
+// Gather the actions of the GET forms, indexed from index. An empty action
+// is the URL of the document. If the GetFormDefaults option is set, the query
+// string of the action is replaced by the default values of the form.
+func (w *worker) processGetForms(h *harvestDoc, index int, result *[]*url.URL, links map[*url.URL]*LinkInfo) int {
+	h.doc.Find("form").Each(func(_ int, sel *goquery.Selection) {
+		if m, _ := sel.Attr("method"); m != "" && !strings.EqualFold(strings.TrimSpace(m), "get") {
+			return
+		}
+		info := newLinkInfo(sel, index)
+		info.Text = ""
+		index++
+		action, _ := sel.Attr("action")
+		var parsed *url.URL
+		if action = strings.TrimSpace(action); action == "" {
+			u := *h.doc.Url
+			u.Fragment = ""
+			parsed = &u
+		} else if parsed = w.resolveLink(h, action); parsed == nil {
+			return
+		}
+		if w.opts.GetFormDefaults {
+			parsed.RawQuery = formDefaults(sel)
+		}
+		*result = append(*result, parsed)
+		links[parsed] = info
+	})
+	return index
+}
+
+// Gather the images of the img tags (src and srcset attributes) and the
+// sources of the picture tags (srcset attribute) of the root selection, in
+// the given harvest scope, indexed from index. Each image URL is harvested
+// once.
+func (w *worker) processImages(h *harvestDoc, root *goquery.Selection, scope string, index int, result *[]*url.URL, links map[*url.URL]*LinkInfo) {
+	seen := make(map[string]bool)
+	add := func(sel *goquery.Selection, s string) {
+		parsed := w.resolveLink(h, s)
+		if parsed == nil || seen[parsed.String()] {
+			return
+		}
+		seen[parsed.String()] = true
+		info := newLinkInfo(sel, index)
+		info.Asset = AssetImage
+		info.Scope = scope
+		index++
+		*result = append(*result, parsed)
+		links[parsed] = info
+	}
+	root.Find("img, picture > source").Each(func(_ int, sel *goquery.Selection) {
+		if src, ok := sel.Attr("src"); ok && sel.Nodes[0].Data == "img" {
+			add(sel, strings.TrimSpace(src))
+		}
+		if srcset, ok := sel.Attr("srcset"); ok {
+			for _, s := range ParseSrcset(srcset) {
+				add(sel, s)
 			}
 		}
+	})
+}
+
+// Return the default values of the form, encoded as a query string: the
+// hidden inputs and the pre-selected options, in document order. The
+// disabled and unnamed controls are skipped.
+func formDefaults(form *goquery.Selection) string {
+	var parts []string
+	add := func(name, value string) {
+		parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
 	}
-	return
+	form.Find("input, select").Each(func(_ int, sel *goquery.Selection) {
+		name, _ := sel.Attr("name")
+		if _, disabled := sel.Attr("disabled"); name == "" || disabled {
+			return
+		}
+		if sel.Nodes[0].Data == "input" {
+			if typ, _ := sel.Attr("type"); strings.EqualFold(strings.TrimSpace(typ), "hidden") {
+				value, _ := sel.Attr("value")
+				add(name, value)
+			}
+			return
+		}
+		sel.Find("option[selected]").Each(func(_ int, opt *goquery.Selection) {
+			value, ok := opt.Attr("value")
+			if !ok {
+				value = strings.Join(strings.Fields(opt.Text()), " ")
+			}
+			add(name, value)
+		})
+	})
+	return strings.Join(parts, "&")
+}
+
+// Indicates if the token is in the list of tokens.
+func hasToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
 }
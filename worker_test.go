@@ -1,13 +1,17 @@
 package gocrawl
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/PuerkitoBio/purell"
 )
 
@@ -55,6 +59,8 @@ func TestRedirectURLContext(t *testing.T) {
 			&URLContext{
 				url:           mustParse(srv.URL + "/p1"),
 				normalizedURL: mustParse(srv.URL + "/p1/"),
+				seed:          true,
+				attempts:      1,
 			}, 1, 1, 0,
 		},
 		{
@@ -63,6 +69,7 @@ func TestRedirectURLContext(t *testing.T) {
 				normalizedURL:       mustParse(srv.URL + "/p2/"),
 				sourceURL:           mustParse(srv.URL + "/p1"),
 				normalizedSourceURL: mustParse(srv.URL + "/p1/"),
+				attempts:            1,
 			}, 1, 1, 0,
 		},
 		{
@@ -71,9 +78,30 @@ func TestRedirectURLContext(t *testing.T) {
 				normalizedURL:       mustParse(srv.URL + "/p3/"),
 				sourceURL:           mustParse(srv.URL + "/p1"),
 				normalizedSourceURL: mustParse(srv.URL + "/p1/"),
+				attempts:            1,
 			}, 1, 1, 1,
 		},
 	}
+	// The visited URL holds the info of its fetch, the redirected ones have
+	// none as their fetch did not fail
+	spy.m.RLock()
+	fi := spy.calledWith[eMKVisit][0][0].(*URLContext).FetchInfo()
+	spy.m.RUnlock()
+	if fi == nil {
+		t.Fatal("want the fetch info of the visited URL, got nil")
+	}
+	if fi.StatusCode != 200 || fi.IsHeadRequest || fi.Size != 2 || fi.UserAgent != "test" || fi.TotalSize != -1 || fi.Cached || fi.Timings != nil {
+		t.Errorf("want the fetch info of a 200 GET response of 2 bytes with agent test, got %+v", fi)
+	}
+	exp := callCounts[2].ctx
+	exp.fetchInfo = &FetchInfo{
+		Ctx:        exp,
+		Duration:   fi.Duration,
+		StatusCode: 200,
+		Size:       2,
+		UserAgent:  "test",
+		TotalSize:  -1,
+	}
 	for i, cc := range callCounts {
 		if n := spy.getCalledWithCount(eMKFilter, cc.ctx, false); n != cc.filterCnt {
 			t.Errorf("%d: want %d filter call for %s, got %d", i, cc.filterCnt, cc.ctx.url, n)
@@ -86,3 +114,523 @@ func TestRedirectURLContext(t *testing.T) {
 		}
 	}
 }
+
+func TestJitterDelay(t *testing.T) {
+	var r float64
+	w := &worker{opts: NewOptions(nil)}
+	w.opts.DelayJitter = 0.5
+	w.opts.DelayJitterRand = func() float64 { return r }
+
+	cases := []struct {
+		r        float64
+		d        time.Duration
+		robDelay time.Duration
+		want     time.Duration
+	}{
+		{0, time.Second, 0, 500 * time.Millisecond},
+		{0.5, time.Second, 0, time.Second},
+		{0.75, time.Second, 0, 1250 * time.Millisecond},
+		{0, time.Second, 800 * time.Millisecond, 800 * time.Millisecond},
+		{0.25, 2 * time.Second, time.Second, 1500 * time.Millisecond},
+	}
+	for i, c := range cases {
+		r = c.r
+		if got := w.jitterDelay(c.d, c.robDelay); got != c.want {
+			t.Errorf("%d: want %v, got %v", i, c.want, got)
+		}
+	}
+}
+
+func TestRobotsErrorPolicy(t *testing.T) {
+	cases := []struct {
+		status     int // 0 for a timeout
+		failures   int // number of failed robots.txt requests, -1 for always
+		policy     RobotsErrorPolicy
+		robots     int32
+		visits     int
+		disallowed int
+		errors     int // number of CekFetchRobots errors
+	}{
+		{500, -1, RobotsAllowOnError, 1, 1, 0, 1},
+		{500, -1, RobotsDisallowOnError, 1, 0, 1, 1},
+		{503, -1, RobotsDisallowOnError, 1, 0, 1, 1},
+		{503, -1, RobotsRetryThenDisallow, 3, 0, 1, 3},
+		{503, 1, RobotsRetryThenDisallow, 2, 1, 0, 1},
+		{0, -1, RobotsAllowOnError, 1, 1, 0, 1},
+		{0, -1, RobotsDisallowOnError, 1, 0, 1, 1},
+		{0, -1, RobotsRetryThenDisallow, 3, 0, 1, 3},
+		{404, -1, RobotsDisallowOnError, 1, 1, 0, 0},
+	}
+	for i, c := range cases {
+		var robots int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				if n := atomic.AddInt32(&robots, 1); c.failures < 0 || int(n) <= c.failures {
+					if c.status == 0 {
+						time.Sleep(100 * time.Millisecond)
+					} else {
+						w.WriteHeader(c.status)
+					}
+					return
+				}
+			}
+			fmt.Fprint(w, "ok")
+		}))
+
+		client := &http.Client{Timeout: 20 * time.Millisecond}
+		spy := newSpy(new(DefaultExtender), true)
+		spy.setExtensionMethod(eMKFetch, func(ctx *URLContext, agent string, head bool) (*http.Response, error) {
+			return client.Get(ctx.url.String())
+		})
+		var robotsErrors int
+		spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+			if err.Kind == CekFetchRobots {
+				robotsErrors++
+			}
+		})
+		opts := NewOptions(spy)
+		opts.CrawlDelay = time.Millisecond
+		opts.RobotsErrorPolicy = c.policy
+		opts.RobotsRetries = 2
+		opts.RobotsRetryDelay = time.Millisecond
+		opts.LogFlags = LogAll
+		crawler := NewCrawlerWithOptions(opts)
+		if err := crawler.Run(srv.URL + "/page"); err != nil {
+			t.Errorf("%d: run failed with %v", i, err)
+		}
+		srv.Close()
+
+		if n := atomic.LoadInt32(&robots); n != c.robots {
+			t.Errorf("%d: want %d robots.txt requests, got %d", i, c.robots, n)
+		}
+		assertCallCount(spy, fmt.Sprintf("%d", i), eMKVisit, c.visits, t)
+		assertCallCount(spy, fmt.Sprintf("%d", i), eMKDisallowed, c.disallowed, t)
+		if robotsErrors != c.errors {
+			t.Errorf("%d: want %d robots.txt errors, got %d", i, c.errors, robotsErrors)
+		}
+	}
+}
+
+func TestRobotsUnavailable(t *testing.T) {
+	const wait = 100 * time.Millisecond
+
+	var robots int32
+	parked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			if atomic.AddInt32(&robots, 1) == 1 {
+				// Capped by the MaxRobotsRetryWait option
+				w.Header().Set("Retry-After", "120")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer parked.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer other.Close()
+
+	var mu sync.Mutex
+	var visits []string
+	spy := newSpy(new(DefaultExtender), true)
+	spy.setExtensionMethod(eMKVisit, func(ctx *URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		visits = append(visits, ctx.url.Host)
+		return nil, false
+	})
+	ext := &hostCompleteExtender{spyExtender: spy, stats: make(map[string][]HostStats)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.MaxConcurrentHosts = 1
+	opts.RobotsErrorPolicy = RobotsDisallowOnError
+	opts.RobotsUnavailableRetries = 2
+	opts.MaxRobotsRetryWait = wait
+	opts.LogFlags = LogAll
+	start := time.Now()
+	if err := NewCrawlerWithOptions(opts).Run([]string{parked.URL + "/page", other.URL + "/page"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&robots); n != 2 {
+		t.Errorf("want 2 robots.txt requests, got %d", n)
+	}
+	if d := time.Since(start); d < wait {
+		t.Errorf("want the host parked for %v, the crawl took %v", wait, d)
+	}
+	// The other host gets the slot of the parked host
+	host := parked.Listener.Addr().String()
+	if len(visits) != 2 || visits[1] != host {
+		t.Errorf("want the page of the parked host visited last, got %v", visits)
+	}
+	if st := ext.stats[host]; len(st) != 1 || st[0].Parked != 1 || st[0].ParkedTime != wait || st[0].Robots != RobotsFetched {
+		t.Errorf("want the host parked once for %v, got %+v", wait, st)
+	}
+	assertIsInLog("RobotsUnavailable", spy.b, fmt.Sprintf("parked for %v", wait), t)
+}
+
+func TestRobotsRetryWait(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header string
+		max    time.Duration
+		want   time.Duration
+	}{
+		{"", 0, DefaultRobotsRetryAfter},
+		{"", time.Minute, time.Minute},
+		{"30", 0, 30 * time.Second},
+		{" 30 ", time.Second, time.Second},
+		{"Wed, 01 Jan 2020 00:02:00 GMT", 0, 2 * time.Minute},
+		{"Tue, 31 Dec 2019 23:00:00 GMT", 0, 0},
+		{"soon", 0, DefaultRobotsRetryAfter},
+		{"-1", 0, DefaultRobotsRetryAfter},
+	}
+	for _, c := range cases {
+		res := &http.Response{Header: http.Header{}}
+		if c.header != "" {
+			res.Header.Set("Retry-After", c.header)
+		}
+		if got := robotsRetryWait(res, c.max, now); got != c.want {
+			t.Errorf("%q: want %v, got %v", c.header, c.want, got)
+		}
+	}
+}
+
+func TestRobotsDelayPolicy(t *testing.T) {
+	const short, long = 40 * time.Millisecond, 200 * time.Millisecond
+
+	cases := []struct {
+		robots   string
+		opts     time.Duration
+		policy   RobotsDelayPolicy
+		maxRobot time.Duration
+		min, max time.Duration
+	}{
+		{"0.2", short, RobotsDelayUseMax, 0, long, 2 * long},
+		{"0.04", long, RobotsDelayUseMax, 0, long, 2 * long},
+		{"0.04", long, RobotsDelayUseRobots, 0, short, long},
+		{"", long, RobotsDelayUseRobots, 0, long, 2 * long},
+		{"0.2", short, RobotsDelayUseOptions, 0, short, long},
+		{"10", short, RobotsDelayUseMax, long, long, 2 * long},
+	}
+	for i, c := range cases {
+		var mu sync.Mutex
+		var fetches []time.Time
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/robots.txt":
+				fmt.Fprint(w, "User-agent: *\nDisallow:\n")
+				if c.robots != "" {
+					fmt.Fprintf(w, "Crawl-delay: %s\n", c.robots)
+				}
+			default:
+				mu.Lock()
+				fetches = append(fetches, time.Now())
+				n := len(fetches)
+				mu.Unlock()
+				fmt.Fprintf(w, `<html><body><a href="/p%d">next</a></body></html>`, n+1)
+			}
+		}))
+
+		opts := NewOptions(new(DefaultExtender))
+		opts.CrawlDelay = c.opts
+		opts.RobotsDelayPolicy = c.policy
+		opts.MaxRobotsDelay = c.maxRobot
+		opts.MaxVisits = 3
+		opts.LogFlags = LogError
+		NewCrawlerWithOptions(opts).Run(srv.URL + "/p1")
+		srv.Close()
+
+		if len(fetches) != 3 {
+			t.Errorf("%d: want 3 fetches, got %d", i, len(fetches))
+			continue
+		}
+		for j := 1; j < len(fetches); j++ {
+			if gap := fetches[j].Sub(fetches[j-1]); gap < c.min || gap >= c.max {
+				t.Errorf("%d: want gap #%d between %v and %v, got %v", i, j, c.min, c.max, gap)
+			}
+		}
+	}
+}
+
+// Extender that overrides the crawl delay of all the URLs.
+type delayOverrideExtender struct {
+	DefaultExtender
+	delay time.Duration
+}
+
+func (x *delayOverrideExtender) FilterURL(ctx *URLContext, isVisited bool) FilterResult {
+	d := x.delay
+	return FilterResult{Allow: !isVisited, DelayOverride: &d}
+}
+
+func TestDelayOverrideRobotsFloor(t *testing.T) {
+	const robots = 200 * time.Millisecond
+
+	cases := []struct {
+		override time.Duration
+		policy   RobotsDelayPolicy
+		maxRobot time.Duration
+		jitter   float64
+		want     time.Duration
+	}{
+		{10 * time.Millisecond, RobotsDelayUseMax, 0, 0, robots},
+		{10 * time.Millisecond, RobotsDelayUseRobots, 0, 0, robots},
+		{time.Second, RobotsDelayUseMax, 0, 0, time.Second},
+		{10 * time.Millisecond, RobotsDelayUseOptions, 0, 0, 10 * time.Millisecond},
+		{10 * time.Millisecond, RobotsDelayUseMax, 50 * time.Millisecond, 0, 50 * time.Millisecond},
+		{time.Second, RobotsDelayUseMax, 0, 0.5, 500 * time.Millisecond},
+		{300 * time.Millisecond, RobotsDelayUseMax, 0, 0.5, robots},
+	}
+	for i, c := range cases {
+		clock := newFakeClock(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true)
+		var mu sync.Mutex
+		var fetches []time.Time
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/robots.txt":
+				fmt.Fprintf(w, "User-agent: *\nDisallow:\nCrawl-delay: %v\n", robots.Seconds())
+			default:
+				mu.Lock()
+				fetches = append(fetches, clock.Now())
+				n := len(fetches)
+				mu.Unlock()
+				fmt.Fprintf(w, `<html><body><a href="/p%d">next</a></body></html>`, n+1)
+			}
+		}))
+
+		opts := NewOptions(&delayOverrideExtender{delay: c.override})
+		opts.CrawlDelay = 0
+		opts.RobotsDelayPolicy = c.policy
+		opts.MaxRobotsDelay = c.maxRobot
+		opts.DelayJitter = c.jitter
+		opts.DelayJitterRand = func() float64 { return 0 }
+		opts.WorkerIdleTTL = 0
+		opts.MaxVisits = 3
+		opts.LogFlags = LogError
+		opts.Clock = clock
+		NewCrawlerWithOptions(opts).Run(srv.URL + "/p1")
+		srv.Close()
+
+		if len(fetches) != 3 {
+			t.Errorf("%d: want 3 fetches, got %d", i, len(fetches))
+			continue
+		}
+		for j := 1; j < len(fetches); j++ {
+			if gap := fetches[j].Sub(fetches[j-1]); gap != c.want {
+				t.Errorf("%d: want gap #%d of %v, got %v", i, j, c.want, gap)
+			}
+		}
+	}
+}
+
+func TestCrawlErrorUnwrap(t *testing.T) {
+	cause := &url.Error{Op: "Get", URL: "http://host/robots.txt", Err: ErrRedirectPolicy}
+	var err error = newCrawlError(nil, cause, CekRedirectPolicy)
+
+	if !errors.Is(err, ErrRedirectPolicy) {
+		t.Errorf("want errors.Is to match ErrRedirectPolicy")
+	}
+	var ue *url.Error
+	if !errors.As(err, &ue) || ue != cause {
+		t.Errorf("want errors.As to find the *url.Error")
+	}
+	if ce, ok := err.(*CrawlError); !ok || ce.Kind.String() != "RedirectPolicy" {
+		t.Errorf("want a *CrawlError of kind RedirectPolicy, got %v", err)
+	}
+	if errors.Is(newCrawlErrorMessage(nil, "msg", CekFetch), ErrRedirectPolicy) {
+		t.Errorf("want no match for an error without cause")
+	}
+}
+
+func TestRobotsRedirectPolicy(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			// Endless redirection loop
+			http.Redirect(w, r, fmt.Sprintf("/robots.txt?n=%d", atomic.AddInt32(&n, 1)), http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), true)
+	var kinds []CrawlErrorKind
+	spy.setExtensionMethod(eMKError, func(err *CrawlError) {
+		kinds = append(kinds, err.Kind)
+		if !errors.Is(err, ErrRedirectPolicy) {
+			t.Errorf("want the error to wrap ErrRedirectPolicy, got %v", err)
+		}
+	})
+	opts := NewOptions(spy)
+	opts.CrawlDelay = time.Millisecond
+	opts.LogFlags = LogAll
+	NewCrawlerWithOptions(opts).Run(srv.URL + "/page")
+
+	if len(kinds) != 1 || kinds[0] != CekRedirectPolicy {
+		t.Errorf("want a single RedirectPolicy error, got %v", kinds)
+	}
+}
+
+func TestWorkerDelayStop(t *testing.T) {
+	w := &worker{
+		stop:    make(chan struct{}),
+		logFunc: func(LogFlags, string, ...interface{}) {},
+	}
+	if !w.waitDelay() {
+		t.Fatal("want no wait without a pending delay")
+	}
+
+	w.startDelay(10 * time.Second)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(w.stop)
+	}()
+	start := time.Now()
+	if w.waitDelay() {
+		t.Error("want the wait to be interrupted by the stop signal")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("want the wait to be interrupted promptly, got %v", d)
+	}
+}
+
+func TestFetchersPerHost(t *testing.T) {
+	const pages, fetchers, delay = 8, 4, 20 * time.Millisecond
+	var mu sync.Mutex
+	var active, maxActive, robotsActive int
+	var starts []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			mu.Lock()
+			robotsActive = active
+			if len(starts) > 0 {
+				robotsActive = -1
+			}
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotFound)
+		case "/":
+			for i := 0; i < pages; i++ {
+				fmt.Fprintf(w, `<a href="/p%d">p%d</a>`, i, i)
+			}
+		default:
+			mu.Lock()
+			starts = append(starts, time.Now())
+			if active++; active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	spy := newSpy(new(DefaultExtender), true)
+	opts := NewOptions(spy)
+	opts.CrawlDelay = delay
+	opts.FetchersPerHost = fetchers
+	opts.LogFlags = LogAll
+	c := NewCrawlerWithOptions(opts)
+	start := time.Now()
+	if err := c.Run(srv.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	assertCallCount(spy, "FetchersPerHost", eMKVisit, pages+1, t)
+	mu.Lock()
+	defer mu.Unlock()
+	if robotsActive != 0 {
+		t.Errorf("want the robots.txt to be fetched alone and first, got %d", robotsActive)
+	}
+	if maxActive < 2 || maxActive > fetchers {
+		t.Errorf("want between 2 and %d fetches in flight, got %d", fetchers, maxActive)
+	}
+	// The crawl delay spaces out the starts of the fetches
+	for i := 1; i < len(starts); i++ {
+		if d := starts[i].Sub(starts[i-1]); d < delay-5*time.Millisecond {
+			t.Errorf("want fetches started at least %v apart, got %v", delay, d)
+		}
+	}
+	if max := pages * 100 * time.Millisecond; elapsed >= max {
+		t.Errorf("want the fetches to overlap, crawl took %v", elapsed)
+	}
+}
+
+func BenchmarkWorkerDelay(b *testing.B) {
+	w := &worker{stop: make(chan struct{})}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.startDelay(0)
+		w.waitDelay()
+	}
+}
+
+func TestHeadFallback(t *testing.T) {
+	var mu sync.Mutex
+	heads := make(map[string]int)
+	gets := make(map[string]int)
+	newServer := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method == "HEAD" {
+				heads[r.Host]++
+				w.WriteHeader(status)
+				return
+			}
+			gets[r.Host+r.URL.Path]++
+			switch r.URL.Path {
+			case "/robots.txt":
+				w.WriteHeader(http.StatusNotFound)
+			case "/":
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<a href="/a.html">a</a><a href="/b.html">b</a><a href="/c.html">c</a>`)
+			default:
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<a href="/">home</a>`)
+			}
+		}))
+	}
+	notAllowed, notImplemented := newServer(http.StatusMethodNotAllowed), newServer(http.StatusNotImplemented)
+	defer notAllowed.Close()
+	defer notImplemented.Close()
+
+	spy := newSpy(new(DefaultExtender), true)
+	ext := &hostCompleteExtender{spyExtender: spy, stats: make(map[string][]HostStats)}
+	opts := NewOptions(ext)
+	opts.CrawlDelay = 0
+	opts.HeadBeforeGet = true
+	opts.LogFlags = LogAll
+	if err := NewCrawlerWithOptions(opts).Run([]string{notAllowed.URL + "/", notImplemented.URL + "/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, srv := range []*httptest.Server{notAllowed, notImplemented} {
+		host := srv.Listener.Addr().String()
+		// A single wasted HEAD request, all the pages are requested with a GET
+		if heads[host] != 1 {
+			t.Errorf("%s: want a single HEAD request, got %d", host, heads[host])
+		}
+		for _, path := range []string{"/robots.txt", "/", "/a.html", "/b.html", "/c.html"} {
+			if gets[host+path] != 1 {
+				t.Errorf("%s: want a single GET request of %s, got %d", host, path, gets[host+path])
+			}
+		}
+		if st := ext.stats[host]; len(st) != 1 || st[0].HeadFallbacks != 1 || st[0].Visits != 4 {
+			t.Errorf("%s: want a single HEAD fallback and 4 visits, got %+v", host, st)
+		}
+		assertIsInLog("HeadFallback", spy.b, fmt.Sprintf("HEAD unsupported by host %s", host), t)
+	}
+	assertCallCount(spy, "HeadFallback", eMKError, 0, t)
+}